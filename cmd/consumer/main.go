@@ -1,30 +1,41 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"time"
 
 	"github.com/codingconcepts/env"
+	"github.com/gorilla/mux"
 	"github.com/joho/godotenv"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	amqp "github.com/rabbitmq/amqp091-go"
-	"golang.org/x/sync/errgroup"
+	"golang.org/x/time/rate"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
 	"github.com/golden-vcr/dynamo/internal/filters"
 	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/health"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/golden-vcr/dynamo/internal/outflow"
 	"github.com/golden-vcr/dynamo/internal/processing"
+	"github.com/golden-vcr/dynamo/internal/prompts"
+	"github.com/golden-vcr/dynamo/internal/queue"
 	"github.com/golden-vcr/dynamo/internal/storage"
-	"github.com/golden-vcr/ledger"
-	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/dynamo/internal/tracing"
 	"github.com/golden-vcr/server-common/db"
 	"github.com/golden-vcr/server-common/entry"
 	"github.com/golden-vcr/server-common/rmq"
+	"github.com/google/uuid"
 )
 
 type Config struct {
@@ -32,16 +43,63 @@ type Config struct {
 	AuthSharedSecret string `env:"AUTH_SHARED_SECRET" required:"true"`
 	LedgerURL        string `env:"LEDGER_URL" default:"http://localhost:5003"`
 
-	OpenaiApiKey string `env:"OPENAI_API_KEY" required:"true"`
+	GenerationBackend            string   `env:"GENERATION_BACKEND" default:"openai"`
+	OpenaiApiKey                 string   `env:"OPENAI_API_KEY"`
+	LocalGenerationLatencyMs     int      `env:"LOCAL_GENERATION_LATENCY_MS" default:"2000"`
+	LocalGenerationRejectionRate float64  `env:"LOCAL_GENERATION_REJECTION_RATE" default:"0"`
+	LocalGenerationErrorRate     float64  `env:"LOCAL_GENERATION_ERROR_RATE" default:"0"`
+	ModerationBackend            string   `env:"MODERATION_BACKEND" default:"api"`
+	ModerationRulesFile          string   `env:"MODERATION_RULES_FILE"`
+	GenerationParamsFile         string   `env:"GENERATION_PARAMS_FILE"`
+	Automatic1111URL             string   `env:"AUTOMATIC1111_URL"`
+	ComfyUIURL                   string   `env:"COMFYUI_URL"`
+	ComfyUIWorkflowFile          string   `env:"COMFYUI_WORKFLOW_FILE"`
+	ImageProviderFailover        []string `env:"IMAGE_PROVIDER_FAILOVER"`
+	FailoverMaxFailures          int      `env:"FAILOVER_MAX_FAILURES" default:"3"`
+	FailoverCooldownSeconds      int      `env:"FAILOVER_COOLDOWN_SECONDS" default:"60"`
+
+	NumWorkers             int `env:"NUM_WORKERS" default:"4"`
+	MaxDeliveries          int `env:"MAX_DELIVERIES" default:"5"`
+	RequeueDelaySeconds    int `env:"REQUEUE_DELAY_SECONDS" default:"10"`
+	RequeueMaxDelaySeconds int `env:"REQUEUE_MAX_DELAY_SECONDS" default:"300"`
+	ImageRequestsPerMinute int `env:"IMAGE_REQUESTS_PER_MINUTE" default:"5"`
+	ImageRequestsBurst     int `env:"IMAGE_REQUESTS_BURST" default:"1"`
+	TextRequestsPerMinute  int `env:"TEXT_REQUESTS_PER_MINUTE" default:"60"`
+	TextRequestsBurst      int `env:"TEXT_REQUESTS_BURST" default:"5"`
+	RecoveryMinAgeSeconds  int `env:"RECOVERY_MIN_AGE_SECONDS" default:"900"`
+	RecoveryMaxAgeSeconds  int `env:"RECOVERY_MAX_AGE_SECONDS" default:"3600"`
+
+	ImageCandidates              int    `env:"IMAGE_CANDIDATES" default:"1"`
+	ImageSelection               string `env:"IMAGE_SELECTION" default:"auto"`
+	ImageSelectionTimeoutSeconds int    `env:"IMAGE_SELECTION_TIMEOUT_SECONDS" default:"60"`
+	ImageSelectionSweepSeconds   int    `env:"IMAGE_SELECTION_SWEEP_SECONDS" default:"10"`
+
+	PromptTemplateReloadSeconds int `env:"PROMPT_TEMPLATE_RELOAD_SECONDS" default:"30"`
+
+	MetricsBindAddr         string `env:"METRICS_BIND_ADDR"`
+	MetricsPort             uint16 `env:"METRICS_PORT" default:"5006"`
+	LivenessMaxStallSeconds int    `env:"LIVENESS_MAX_STALL_SECONDS" default:"600"`
+
+	TracingExporter string `env:"TRACING_EXPORTER" default:"none"`
+	TracingFile     string `env:"TRACING_FILE" default:"traces.jsonl"`
 
 	DiscordGhostsWebhookUrl  string `env:"DISCORD_GHOSTS_WEBHOOK_URL"`
 	DiscordFriendsWebhookUrl string `env:"DISCORD_FRIENDS_WEBHOOK_URL"`
 
-	SpacesBucketName     string `env:"SPACES_BUCKET_NAME" required:"true"`
-	SpacesRegionName     string `env:"SPACES_REGION_NAME" required:"true"`
-	SpacesEndpointOrigin string `env:"SPACES_ENDPOINT_URL" required:"true"`
-	SpacesAccessKeyId    string `env:"SPACES_ACCESS_KEY_ID" required:"true"`
-	SpacesSecretKey      string `env:"SPACES_SECRET_KEY" required:"true"`
+	StorageBackend       string `env:"STORAGE_BACKEND" default:"spaces"`
+	SpacesBucketName     string `env:"SPACES_BUCKET_NAME"`
+	SpacesRegionName     string `env:"SPACES_REGION_NAME"`
+	SpacesEndpointOrigin string `env:"SPACES_ENDPOINT_URL"`
+	SpacesAccessKeyId    string `env:"SPACES_ACCESS_KEY_ID"`
+	SpacesSecretKey      string `env:"SPACES_SECRET_KEY"`
+	SpacesEndpointScheme string `env:"SPACES_ENDPOINT_SCHEME" default:"https"`
+	SpacesForcePathStyle bool   `env:"SPACES_FORCE_PATH_STYLE" default:"false"`
+	SpacesACL            string `env:"SPACES_ACL" default:"public-read"`
+	SpacesCacheControl   string `env:"SPACES_CACHE_CONTROL"`
+	LocalStorageDir      string `env:"LOCAL_STORAGE_DIR" default:"storage"`
+	LocalStorageBindAddr string `env:"LOCAL_STORAGE_BIND_ADDR"`
+	LocalStoragePort     uint16 `env:"LOCAL_STORAGE_PORT" default:"5005"`
+	LocalStorageURL      string `env:"LOCAL_STORAGE_URL" default:"http://localhost:5005"`
 
 	RmqHost     string `env:"RMQ_HOST" required:"true"`
 	RmqPort     int    `env:"RMQ_PORT" required:"true"`
@@ -71,6 +129,23 @@ func main() {
 		app.Fail("Failed to load config", err)
 	}
 
+	// Export OpenTelemetry traces as configured, so that we can see where the time is
+	// spent in handling each request: trace context is propagated from the headers of
+	// incoming messages, and into the headers of the messages we produce
+	shutdownTracing, err := tracing.Init(ctx, tracing.Options{
+		ServiceName: "dynamo-consumer",
+		Exporter:    config.TracingExporter,
+		FilePath:    config.TracingFile,
+	})
+	if err != nil {
+		app.Fail("Failed to initialize tracing", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			app.Log().Error("Failed to flush traces", "error", err)
+		}
+	}()
+
 	// Resolve our 'imf' command-line tool from the PATH, since we need it to process
 	// some generated images (see https://github.com/golden-vcr/image-filters: for the
 	// time being we invoke the imf binary as a subprocess rather than linking the
@@ -128,11 +203,22 @@ func main() {
 	}
 	q := queries.New(db)
 
+	// Load the active version of each prompt template from the database, and keep
+	// reloading them in the background so that newly-activated versions are picked up
+	// without a restart
+	promptStore := prompts.NewStore(q)
+	if err := promptStore.Load(ctx, app.Log()); err != nil {
+		app.Fail("Failed to load prompt templates", err)
+	}
+	if config.PromptTemplateReloadSeconds > 0 {
+		go promptStore.Run(ctx, app.Log(), time.Duration(config.PromptTemplateReloadSeconds)*time.Second)
+	}
+
 	// We need an auth service client so that when we can obtain JWTs that will
 	// authorize us to debit fun points from users in exchange for alerts, which we
-	// accomplish with a ledger client
+	// accomplish with a client for the ledger's outflow API
 	authServiceClient := auth.NewServiceClient(config.AuthURL, config.AuthSharedSecret)
-	ledgerClient := ledger.NewClient(config.LedgerURL)
+	outflowClient := outflow.NewClient(config.LedgerURL)
 
 	// Initialize an AMQP client
 	amqpConn, err := amqp.Dial(rmq.FormatConnectionString(config.RmqHost, config.RmqPort, config.RmqVhost, config.RmqUser, config.RmqPassword))
@@ -144,14 +230,26 @@ func main() {
 	// Prepare a producer that we can use to send messages to the onscreen-events queue,
 	// whenenver we're finishing generating assets and are ready to move on to using
 	// them in alerts
-	onscreenEventsProducer, err := rmq.NewProducer(amqpConn, "onscreen-events")
+	onscreenEventsProducer, err := queue.NewProducer(amqpConn, "onscreen-events")
 	if err != nil {
 		app.Fail("Failed to initialize AMQP producer for onscreen-events", err)
 	}
 
+	// Prepare a producer that we can use to send messages to the generation-events
+	// queue, so that viewers can be notified when their requests fail
+	generationEventsProducer, err := queue.NewProducer(amqpConn, "generation-events")
+	if err != nil {
+		app.Fail("Failed to initialize AMQP producer for generation-events", err)
+	}
+
 	// Prepare a consumer and start receiving incoming messages from the
-	// generation-requests exchange
-	generationEventsConsumer, err := rmq.NewConsumer(amqpConn, "generation-requests")
+	// generation-requests exchange: we only prefetch as many messages as we have
+	// workers to handle them, so that any backlog remains in RabbitMQ, and messages
+	// that we requeue after a transient failure are held back with exponential backoff
+	generationEventsConsumer, err := queue.NewConsumer(amqpConn, "generation-requests", config.NumWorkers, queue.RequeueBackoff{
+		InitialDelay: time.Duration(config.RequeueDelaySeconds) * time.Second,
+		MaxDelay:     time.Duration(config.RequeueMaxDelaySeconds) * time.Second,
+	})
 	if err != nil {
 		app.Fail("Failed to initialize AMQP consumer for generation-events", err)
 	}
@@ -160,12 +258,195 @@ func main() {
 		app.Fail("Failed to init recv channel on generation-events consumer", err)
 	}
 
-	// Prepare our internal generation.Client and storage.Client interfaces, which allow
-	// us to generate assets and store them in S3, respectively
-	generationClient := generation.NewClient(config.OpenaiApiKey)
-	storageClient, err := storage.NewClient(config.SpacesAccessKeyId, config.SpacesSecretKey, config.SpacesEndpointOrigin, config.SpacesRegionName, config.SpacesBucketName)
-	if err != nil {
-		app.Fail("Failed to initialize storage client", err)
+	// By default, every style's assets are generated with the same model and settings,
+	// but we can override those params for individual styles from a JSON file
+	generationParams := generation.StyleParams{}
+	if config.GenerationParamsFile != "" {
+		generationParams, err = generation.LoadStyleParams(config.GenerationParamsFile)
+		if err != nil {
+			app.Fail("Failed to load generation params", err)
+		}
+		for style := range generationParams {
+			if _, ok := styles.Get(style); !ok {
+				app.Fail("Failed to load generation params", fmt.Errorf("unsupported image style '%s'", style))
+			}
+		}
+	}
+
+	// Select the backend that will actually generate our assets: ordinarily that's the
+	// OpenAI API, but for offline development we can use a local stand-in that draws
+	// placeholder images and can simulate latency and failures
+	var backendClient generation.Client
+	switch config.GenerationBackend {
+	case "openai":
+		if config.OpenaiApiKey == "" {
+			app.Fail("Failed to load config", fmt.Errorf("OPENAI_API_KEY is required when GENERATION_BACKEND is 'openai'"))
+		}
+		for _, style := range styles.Names() {
+			if err := generation.ValidateOpenaiParams(generationParams.Get(style)); err != nil {
+				app.Fail("Failed to load generation params", fmt.Errorf("invalid params for style '%s': %w", style, err))
+			}
+		}
+		backendClient = generation.NewClient(config.OpenaiApiKey)
+	case "local":
+		backendClient = generation.NewLocalClient(generation.LocalOptions{
+			Latency:       time.Duration(config.LocalGenerationLatencyMs) * time.Millisecond,
+			RejectionRate: config.LocalGenerationRejectionRate,
+			ErrorRate:     config.LocalGenerationErrorRate,
+		})
+		app.Log().Warn("Using local stand-in generation backend; no assets will be generated by OpenAI")
+	default:
+		app.Fail("Failed to load config", fmt.Errorf("unsupported GENERATION_BACKEND '%s'", config.GenerationBackend))
+	}
+
+	// Individual styles may instead have their images generated by a self-hosted
+	// Stable Diffusion server, via the AUTOMATIC1111 web UI's API or a ComfyUI workflow
+	providers := make(map[string]generation.ImageGenerator)
+	if config.Automatic1111URL != "" {
+		providers[generation.ProviderAutomatic1111] = generation.NewAutomatic1111Client(config.Automatic1111URL)
+	}
+	if config.ComfyUIURL != "" {
+		if config.ComfyUIWorkflowFile == "" {
+			app.Fail("Failed to load config", fmt.Errorf("COMFYUI_WORKFLOW_FILE is required when COMFYUI_URL is set"))
+		}
+		workflow, err := os.ReadFile(config.ComfyUIWorkflowFile)
+		if err != nil {
+			app.Fail("Failed to open ComfyUI workflow file", err)
+		}
+		comfyUIClient, err := generation.NewComfyUIClient(generation.ComfyUIOptions{
+			URL:      config.ComfyUIURL,
+			Workflow: workflow,
+		})
+		if err != nil {
+			app.Fail("Failed to initialize ComfyUI client", err)
+		}
+		providers[generation.ProviderComfyUI] = comfyUIClient
+	}
+	for _, style := range styles.Names() {
+		params := generationParams.Get(style)
+		if params.Image.Provider != "" {
+			if _, ok := providers[params.Image.Provider]; !ok {
+				app.Fail("Failed to load generation params", fmt.Errorf("image provider '%s' for style '%s' is not configured", params.Image.Provider, style))
+			}
+			if err := generation.ValidateStableDiffusionParams(params.Image); err != nil {
+				app.Fail("Failed to load generation params", fmt.Errorf("invalid params for style '%s': %w", style, err))
+			}
+		}
+	}
+
+	// Viewer inputs are moderated before any generation occurs: by default we use the
+	// moderation endpoint offered by our generation backend, but we can instead screen
+	// inputs against a local set of blocklisted words and regex rules
+	var moderationRules []generation.Rule
+	switch config.ModerationBackend {
+	case "api":
+	case "rules":
+		if config.ModerationRulesFile == "" {
+			app.Fail("Failed to load config", fmt.Errorf("MODERATION_RULES_FILE is required when MODERATION_BACKEND is 'rules'"))
+		}
+	default:
+		app.Fail("Failed to load config", fmt.Errorf("unsupported MODERATION_BACKEND '%s'", config.ModerationBackend))
+	}
+	if config.ModerationRulesFile != "" {
+		f, err := os.Open(config.ModerationRulesFile)
+		if err != nil {
+			app.Fail("Failed to open moderation rules file", err)
+		}
+		moderationRules, err = generation.ParseRules(f)
+		f.Close()
+		if err != nil {
+			app.Fail("Failed to parse moderation rules", err)
+		}
+	}
+
+	// If a provider fails with a transient error (e.g. during an OpenAI outage), we can
+	// fail over to the other providers listed in IMAGE_PROVIDER_FAILOVER, in order, and
+	// a provider that fails repeatedly is skipped until its cooldown elapses. Since the
+	// other providers can only generate images, moderation falls back to our local
+	// rules (if we have any), and text generation falls back to canned names.
+	for _, name := range config.ImageProviderFailover {
+		if _, ok := providers[name]; !ok && name != config.GenerationBackend {
+			app.Fail("Failed to load config", fmt.Errorf("image provider '%s' in IMAGE_PROVIDER_FAILOVER is not configured", name))
+		}
+	}
+	var fallbackClient generation.Client
+	if len(config.ImageProviderFailover) > 0 {
+		if config.ModerationRulesFile != "" {
+			fallbackClient = generation.NewFallbackClient(moderationRules)
+		} else {
+			app.Log().Warn("MODERATION_RULES_FILE is not set; alerts will fail while the generation backend is unavailable")
+		}
+	}
+	backendClient = generation.NewFailoverClient(
+		app.Log(),
+		generation.NewProviderClient(backendClient, providers),
+		generation.FailoverOptions{
+			BackendName: config.GenerationBackend,
+			Order:       config.ImageProviderFailover,
+			MaxFailures: config.FailoverMaxFailures,
+			Cooldown:    time.Duration(config.FailoverCooldownSeconds) * time.Second,
+			Fallback:    fallbackClient,
+		},
+	)
+	if config.ModerationBackend == "rules" {
+		backendClient = generation.NewRulesModeratingClient(backendClient, moderationRules)
+		app.Log().Info("Moderating inputs with local rules", "numRules", len(moderationRules))
+	}
+
+	// Prepare our internal generation.Client interface, which allows us to generate
+	// assets: generation calls are rate-limited (separately for images and text), calls
+	// that fail with transient errors are retried with backoff, and each attempt is
+	// recorded in the database
+	generationClient := generation.NewRetryingClient(
+		app.Log(),
+		generation.NewRateLimitedClient(
+			backendClient,
+			newLimiter(config.ImageRequestsPerMinute, config.ImageRequestsBurst),
+			newLimiter(config.TextRequestsPerMinute, config.TextRequestsBurst),
+		),
+		q,
+		generation.DefaultRetryPolicy,
+	)
+
+	// Generated images are ordinarily stored in a Spaces bucket, but for offline
+	// development we can instead write them to a local directory, which we serve over
+	// HTTP so that the resulting image URLs can be loaded just the same
+	var storageClient storage.Client
+	switch config.StorageBackend {
+	case "spaces":
+		if config.SpacesBucketName == "" || config.SpacesRegionName == "" || config.SpacesEndpointOrigin == "" || config.SpacesAccessKeyId == "" || config.SpacesSecretKey == "" {
+			app.Fail("Failed to load config", fmt.Errorf("SPACES_* variables are required when STORAGE_BACKEND is 'spaces'"))
+		}
+		storageClient, err = storage.NewClient(config.SpacesAccessKeyId, config.SpacesSecretKey, config.SpacesEndpointOrigin, config.SpacesRegionName, config.SpacesBucketName, storage.Options{
+			Scheme:         config.SpacesEndpointScheme,
+			ForcePathStyle: config.SpacesForcePathStyle,
+			ACL:            config.SpacesACL,
+			CacheControl:   config.SpacesCacheControl,
+		})
+		if err != nil {
+			app.Fail("Failed to initialize storage client", err)
+		}
+	case "local":
+		storageClient, err = storage.NewFilesystemClient(config.LocalStorageDir, config.LocalStorageURL)
+		if err != nil {
+			app.Fail("Failed to initialize storage client", err)
+		}
+		go entry.RunServer(ctx, app.Log(), storage.NewFileServer(config.LocalStorageDir), config.LocalStorageBindAddr, config.LocalStoragePort)
+		app.Log().Warn("Using local filesystem storage backend; generated images will not be uploaded to Spaces", "dir", config.LocalStorageDir)
+	default:
+		app.Fail("Failed to load config", fmt.Errorf("unsupported STORAGE_BACKEND '%s'", config.StorageBackend))
+	}
+
+	// Decide how many candidate images we'll generate for each request, and whether
+	// we'll wait for the broadcaster to choose which one is displayed
+	selectionMode := processing.SelectionMode(config.ImageSelection)
+	if !selectionMode.IsValid() {
+		app.Fail("Failed to load config", fmt.Errorf("unsupported IMAGE_SELECTION '%s'", config.ImageSelection))
+	}
+	candidateOptions := processing.CandidateOptions{
+		NumCandidates:    config.ImageCandidates,
+		Selection:        selectionMode,
+		SelectionTimeout: time.Duration(config.ImageSelectionTimeoutSeconds) * time.Second,
 	}
 
 	// Prepare a handler that has the state necessary to respond to incoming
@@ -174,47 +455,142 @@ func main() {
 	// the onscreen-events queue to use those assets in alerts
 	h := processing.NewHandler(
 		q,
+		promptStore,
 		generationClient,
 		filterRunner,
 		storageClient,
 		authServiceClient,
-		ledgerClient,
+		outflowClient,
 		onscreenEventsProducer,
-		config.DiscordGhostsWebhookUrl,
-		config.DiscordFriendsWebhookUrl,
+		generationEventsProducer,
+		map[string]string{
+			"ghosts":  config.DiscordGhostsWebhookUrl,
+			"friends": config.DiscordFriendsWebhookUrl,
+		},
+		candidateOptions,
+		generationParams,
 	)
 
-	// Each time we read a message from the queue, spin up a new goroutine for that
-	// message, parse it according to our generation-requests schema, then handle it
-	wg, ctx := errgroup.WithContext(ctx)
-	done := false
-	for !done {
-		select {
-		case <-ctx.Done():
-			app.Log().Info("Consumer context canceled; exiting main loop")
-			done = true
-		case d, ok := <-generationRequests:
-			if ok {
-				wg.Go(func() error {
-					var r genreq.Request
-					if err := json.Unmarshal(d.Body, &r); err != nil {
-						return err
-					}
-					logger := app.Log().With("generationRequest", r)
-					logger.Info("Consumed from generation-requests")
-					if err := h.Handle(ctx, logger, &r); err != nil {
-						logger.Info("Failed to handle event", "error", err)
-					}
-					return err
-				})
-			} else {
-				app.Log().Info("Channel is closed; exiting main loop")
-				done = true
+	// Prepare a fixed-size pool of workers, each of which will read messages from the
+	// queue, parse them according to our generation-requests schema, then handle them:
+	// messages that can never succeed are dead-lettered, and messages that fail for
+	// transient reasons are requeued after a delay (up to a limit)
+	pool := queue.NewPool(app.Log(), config.NumWorkers, generationEventsConsumer, processing.IsPermanent, config.MaxDeliveries)
+
+	// Serve Prometheus metrics over HTTP, so that we can monitor the consumer's
+	// throughput, failure rates, and latency, along with health probes: we're ready
+	// to handle requests so long as we can reach all our dependencies, and we're live
+	// so long as the worker pool is making progress whenever there's work to do
+	livenessMaxStall := time.Duration(config.LivenessMaxStallSeconds) * time.Second
+	healthServer := health.NewServer(map[string]health.Check{
+		"consumer": func(ctx context.Context) error {
+			numWaiting, err := generationEventsConsumer.NumWaiting()
+			if err != nil {
+				return err
+			}
+			return pool.CheckProgress(numWaiting, livenessMaxStall)
+		},
+	}, map[string]health.Check{
+		"postgres": db.PingContext,
+		"amqp": func(ctx context.Context) error {
+			if amqpConn.IsClosed() {
+				return fmt.Errorf("connection is closed")
 			}
+			return generationEventsConsumer.Check()
+		},
+		"imf": func(ctx context.Context) error {
+			return filters.Probe(ctx, imfBinaryPath)
+		},
+		"storage": storageClient.Ping,
+	}, health.DefaultTimeout)
+	metricsRouter := mux.NewRouter()
+	metricsRouter.Path("/metrics").Methods("GET").Handler(metrics.Handler())
+	healthServer.RegisterRoutes(metricsRouter)
+	go entry.RunServer(ctx, app.Log(), metricsRouter, config.MetricsBindAddr, config.MetricsPort)
+
+	// Before we start handling new requests, finish any requests that were left
+	// unfinished the last time the consumer stopped: we only consider requests that are
+	// old enough that no other consumer could still be working on them, and requests
+	// that are too old to be worth displaying are failed and refunded instead
+	recoveryMinAge := time.Duration(config.RecoveryMinAgeSeconds) * time.Second
+	recoveryMaxAge := time.Duration(config.RecoveryMaxAgeSeconds) * time.Second
+	if err := h.Recover(ctx, app.Log(), recoveryMinAge, recoveryMaxAge); err != nil {
+		app.Log().Error("Failed to recover unfinished image requests", "error", err)
+	}
+
+	// If we're waiting on the broadcaster to select each request's image, those
+	// requests are left unfinished (without holding up a worker) until they can
+	// proceed: we resume them as soon as we're notified that the broadcaster has made
+	// a selection, and we periodically check for requests whose selection timeout has
+	// elapsed (or whose notifications we missed)
+	if candidateOptions.Selection == processing.SelectionModeBroadcaster && config.ImageCandidates > 1 {
+		selectionListener := pq.NewListener(connectionString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+			switch ev {
+			case pq.ListenerEventConnected:
+				app.Log().Info("pq listener connected")
+			case pq.ListenerEventDisconnected:
+				app.Log().Error("pq listener disconnected", "error", err)
+			case pq.ListenerEventReconnected:
+				app.Log().Info("pq listener reconnected")
+			case pq.ListenerEventConnectionAttemptFailed:
+				app.Log().Error("pq listener connection attempt failed", "error", err)
+			}
+		})
+		defer selectionListener.Close()
+		if err := selectionListener.Listen("dynamo_image_selected"); err != nil {
+			app.Fail("Failed to issue LISTEN command for pq listener", err)
 		}
+		go func() {
+			ticker := time.NewTicker(time.Duration(config.ImageSelectionSweepSeconds) * time.Second)
+			defer ticker.Stop()
+			for {
+				// pq sends a nil notification after reconnecting, which prompts us to
+				// check for any selections we weren't notified of while disconnected
+				select {
+				case <-ctx.Done():
+					return
+				case <-selectionListener.NotificationChannel():
+				case <-ticker.C:
+				}
+				if err := h.ResumeSelections(ctx, app.Log()); err != nil {
+					app.Log().Error("Failed to resume image requests awaiting selection", "error", err)
+				}
+			}
+		}()
 	}
 
-	if err := wg.Wait(); err != nil {
-		app.Fail("Encountered an error during message handling", err)
+	// Start the worker pool, handling each message that we receive
+	err = pool.Run(ctx, generationRequests, func(ctx context.Context, d amqp.Delivery) error {
+		var m processing.Message
+		if err := json.Unmarshal(d.Body, &m); err != nil {
+			app.Log().Error("Failed to parse message from generation-requests", "error", err)
+			return processing.Permanent(fmt.Errorf("malformed message body: %w", err))
+		}
+		m.MessageId = d.MessageId
+		m.Redelivered = queue.IsRedelivery(&d)
+		m.FinalDelivery = pool.IsFinalDelivery(&d)
+		logger := app.Log().With("generationRequest", m.Request, "deliveryCount", queue.GetDeliveryCount(&d))
+		if m.Id != uuid.Nil {
+			logger = logger.With("imageRequestId", m.Id)
+		}
+		logger.Info("Consumed from generation-requests")
+		if err := h.Handle(ctx, logger, &m); err != nil {
+			logger.Error("Failed to handle event", "error", err, "errorCode", errcode.Of(err))
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		app.Fail("Failed to run worker pool", err)
+	}
+	app.Log().Info("Consumer is shutting down")
+}
+
+// newLimiter returns a token-bucket rate limiter that allows the given number of
+// requests per minute, or nil (imposing no limit) if requestsPerMinute is not positive
+func newLimiter(requestsPerMinute int, burst int) *rate.Limiter {
+	if requestsPerMinute <= 0 {
+		return nil
 	}
+	return rate.NewLimiter(rate.Every(time.Minute/time.Duration(requestsPerMinute)), burst)
 }
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/codingconcepts/env"
+	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
+	"github.com/lib/pq"
+	amqp "github.com/rabbitmq/amqp091-go"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/moderation"
+	"github.com/golden-vcr/dynamo/internal/notifications"
+	"github.com/golden-vcr/dynamo/internal/records"
+	"github.com/golden-vcr/dynamo/internal/selection"
+	"github.com/golden-vcr/dynamo/internal/storage"
+	"github.com/golden-vcr/dynamo/internal/submission"
+	"github.com/golden-vcr/server-common/db"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/golden-vcr/server-common/rmq"
+)
+
+type Config struct {
+	BindAddr   string `env:"BIND_ADDR"`
+	ListenPort uint16 `env:"LISTEN_PORT" default:"5004"`
+
+	AuthURL   string `env:"AUTH_URL" default:"http://localhost:5002"`
+	LedgerURL string `env:"LEDGER_URL" default:"http://localhost:5003"`
+
+	StorageBackend       string `env:"STORAGE_BACKEND" default:"spaces"`
+	SpacesBucketName     string `env:"SPACES_BUCKET_NAME"`
+	SpacesRegionName     string `env:"SPACES_REGION_NAME"`
+	SpacesEndpointOrigin string `env:"SPACES_ENDPOINT_URL"`
+	SpacesAccessKeyId    string `env:"SPACES_ACCESS_KEY_ID"`
+	SpacesSecretKey      string `env:"SPACES_SECRET_KEY"`
+	SpacesEndpointScheme string `env:"SPACES_ENDPOINT_SCHEME" default:"https"`
+	SpacesForcePathStyle bool   `env:"SPACES_FORCE_PATH_STYLE" default:"false"`
+	LocalStorageDir      string `env:"LOCAL_STORAGE_DIR" default:"storage"`
+	LocalStorageURL      string `env:"LOCAL_STORAGE_URL" default:"http://localhost:5005"`
+
+	RmqHost     string `env:"RMQ_HOST" required:"true"`
+	RmqPort     int    `env:"RMQ_PORT" required:"true"`
+	RmqVhost    string `env:"RMQ_VHOST" required:"true"`
+	RmqUser     string `env:"RMQ_USER" required:"true"`
+	RmqPassword string `env:"RMQ_PASSWORD" required:"true"`
+
+	DatabaseHost     string `env:"PGHOST" required:"true"`
+	DatabasePort     int    `env:"PGPORT" required:"true"`
+	DatabaseName     string `env:"PGDATABASE" required:"true"`
+	DatabaseUser     string `env:"PGUSER" required:"true"`
+	DatabasePassword string `env:"PGPASSWORD" required:"true"`
+	DatabaseSslMode  string `env:"PGSSLMODE"`
+}
+
+func main() {
+	app, ctx := entry.NewApplication("dynamo")
+	defer app.Stop()
+
+	// Parse config from environment variables
+	err := godotenv.Load()
+	if err != nil && !os.IsNotExist(err) {
+		app.Fail("Failed to load .env file", err)
+	}
+	config := Config{}
+	if err := env.Set(&config); err != nil {
+		app.Fail("Failed to load config", err)
+	}
+
+	// Configure our database connection and initialize a Queries struct, so we can
+	// read from the 'dynamo' schema in response to HTTP requests
+	connectionString := db.FormatConnectionString(
+		config.DatabaseHost,
+		config.DatabasePort,
+		config.DatabaseName,
+		config.DatabaseUser,
+		config.DatabasePassword,
+		config.DatabaseSslMode,
+	)
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		app.Fail("Failed to open sql.DB", err)
+	}
+	defer db.Close()
+	if err := db.Ping(); err != nil {
+		app.Fail("Failed to connect to database", err)
+	}
+	q := queries.New(db)
+
+	// Initialize a database listener that will notify us whenever image requests are
+	// created or finished, or whenever answers or images are recorded for them
+	pqListener := pq.NewListener(connectionString, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		switch ev {
+		case pq.ListenerEventConnected:
+			app.Log().Info("pq listener connected")
+		case pq.ListenerEventDisconnected:
+			app.Log().Error("pq listener disconnected", "error", err)
+		case pq.ListenerEventReconnected:
+			app.Log().Info("pq listener reconnected")
+		case pq.ListenerEventConnectionAttemptFailed:
+			app.Log().Error("pq listener connection attempt failed", "error", err)
+		}
+	})
+	defer pqListener.Close()
+	if err := pqListener.Listen("dynamo_image_request_event"); err != nil {
+		app.Fail("Failed to issue LISTEN command for pq listener", err)
+	}
+	pqEvents := make(chan *notifications.Notification)
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-pqListener.NotificationChannel():
+				// pq sends a nil notification after reconnecting, since any
+				// notifications sent while disconnected will have been lost
+				if notification == nil {
+					continue
+				}
+				var event notifications.Notification
+				if err := json.Unmarshal([]byte(notification.Extra), &event); err != nil {
+					app.Log().Error("Failed to unmarshal extra data from notification", "error", err)
+					continue
+				}
+				select {
+				case pqEvents <- &event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+
+	// Initialize an auth client so that we can require broadcaster access for
+	// endpoints that allow generation requests to be submitted manually
+	authClient, err := auth.NewClient(ctx, config.AuthURL)
+	if err != nil {
+		app.Fail("Failed to initialize auth client", err)
+	}
+
+	// Initialize an AMQP client
+	amqpConn, err := amqp.Dial(rmq.FormatConnectionString(config.RmqHost, config.RmqPort, config.RmqVhost, config.RmqUser, config.RmqPassword))
+	if err != nil {
+		app.Fail("Failed to connect to AMQP server", err)
+	}
+	defer amqpConn.Close()
+
+	// Prepare a producer that we can use to send messages to the generation-requests
+	// queue, so that manually-submitted requests are handled by dynamo-consumer just
+	// like any other
+	generationRequestsProducer, err := rmq.NewProducer(amqpConn, "generation-requests")
+	if err != nil {
+		app.Fail("Failed to initialize AMQP producer for generation-requests", err)
+	}
+
+	// Prepare a producer that we can use to send messages to the onscreen-events queue,
+	// so that the overlay can be told to stop displaying images that are taken down
+	onscreenEventsProducer, err := rmq.NewProducer(amqpConn, "onscreen-events")
+	if err != nil {
+		app.Fail("Failed to initialize AMQP producer for onscreen-events", err)
+	}
+
+	// Connect to the same storage backend that dynamo-consumer writes generated images
+	// to, so that we can delete or privatize images when they're taken down. When using
+	// local storage, the consumer is responsible for serving the files.
+	var storageClient storage.Client
+	switch config.StorageBackend {
+	case "spaces":
+		if config.SpacesBucketName == "" || config.SpacesRegionName == "" || config.SpacesEndpointOrigin == "" || config.SpacesAccessKeyId == "" || config.SpacesSecretKey == "" {
+			app.Fail("Failed to load config", fmt.Errorf("SPACES_* variables are required when STORAGE_BACKEND is 'spaces'"))
+		}
+		storageClient, err = storage.NewClient(config.SpacesAccessKeyId, config.SpacesSecretKey, config.SpacesEndpointOrigin, config.SpacesRegionName, config.SpacesBucketName, storage.Options{
+			Scheme:         config.SpacesEndpointScheme,
+			ForcePathStyle: config.SpacesForcePathStyle,
+		})
+		if err != nil {
+			app.Fail("Failed to initialize storage client", err)
+		}
+	case "local":
+		storageClient, err = storage.NewFilesystemClient(config.LocalStorageDir, config.LocalStorageURL)
+		if err != nil {
+			app.Fail("Failed to initialize storage client", err)
+		}
+	default:
+		app.Fail("Failed to load config", fmt.Errorf("unsupported STORAGE_BACKEND '%s'", config.StorageBackend))
+	}
+
+	// Start setting up our HTTP handlers, using gorilla/mux for routing
+	r := mux.NewRouter()
+
+	// Clients can make requests to GET /requests (optionally filtered by user,
+	// broadcast, screening, style, and status) or GET /requests/:id in order to find
+	// out about image generation requests and the assets they've produced
+	{
+		recordsServer := records.NewServer(q)
+		recordsServer.RegisterRoutes(r)
+	}
+
+	// Clients can open an SSE connection via GET /events (optionally filtered by
+	// request or user) in order to be notified in real time as image requests progress
+	{
+		notificationsServer := notifications.NewServer(ctx, app.Log(), pqEvents)
+		go notificationsServer.ReadPostgresNotifications(ctx)
+		notificationsServer.RegisterRoutes(r)
+	}
+
+	// The broadcaster can make requests to POST /requests in order to manually submit
+	// a generation request, receiving the ID that will be assigned to the resulting
+	// image request
+	{
+		submissionServer := submission.NewServer(generationRequestsProducer)
+		submissionServer.RegisterRoutes(authClient, r)
+	}
+
+	// The broadcaster can make requests to POST /requests/:id/takedown in order to
+	// remove an objectionable image, optionally refunding the viewer who requested it
+	{
+		moderationServer := moderation.NewServer(q, storageClient, moderation.NewRefundClient(config.LedgerURL), onscreenEventsProducer)
+		moderationServer.RegisterRoutes(authClient, r)
+	}
+
+	// The broadcaster can make requests to POST /requests/:id/selection in order to
+	// choose which of a request's candidate images will be displayed onscreen, if the
+	// consumer is configured to wait for the broadcaster's choice
+	{
+		selectionServer := selection.NewServer(q)
+		selectionServer.RegisterRoutes(authClient, r)
+	}
+
+	// Handle incoming HTTP connections until our top-level context is canceled, at
+	// which point shut down cleanly
+	entry.RunServer(ctx, app.Log(), r, config.BindAddr, config.ListenPort)
+}
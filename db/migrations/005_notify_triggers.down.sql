@@ -0,0 +1,13 @@
+begin;
+
+drop trigger notify_on_image_created on dynamo.image;
+drop function emit_image_created_notification;
+
+drop trigger notify_on_answer_created on dynamo.answer;
+drop function emit_answer_created_notification;
+
+drop trigger notify_on_image_request_finished on dynamo.image_request;
+drop trigger notify_on_image_request_created on dynamo.image_request;
+drop function emit_image_request_change_notification;
+
+commit;
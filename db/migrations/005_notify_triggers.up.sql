@@ -0,0 +1,83 @@
+begin;
+
+create function emit_image_request_change_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('dynamo_image_request_event', jsonb_build_object(
+        'type', case
+            when TG_OP = 'INSERT' then 'created'
+            when NEW.error_message is null then 'succeeded'
+            else 'failed'
+        end,
+        'image_request_id', NEW.id,
+        'twitch_user_id', NEW.twitch_user_id,
+        'data', jsonb_build_object(
+            'broadcast_id', NEW.broadcast_id,
+            'screening_id', NEW.screening_id,
+            'style', NEW.style,
+            'inputs', NEW.inputs,
+            'prompt', NEW.prompt,
+            'created_at', NEW.created_at,
+            'finished_at', NEW.finished_at,
+            'error_message', NEW.error_message
+        )
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger notify_on_image_request_created
+    after insert on dynamo.image_request
+    for each row execute procedure emit_image_request_change_notification();
+
+create trigger notify_on_image_request_finished
+    after update on dynamo.image_request
+    for each row
+    when (OLD.finished_at is null and NEW.finished_at is not null)
+    execute procedure emit_image_request_change_notification();
+
+create function emit_answer_created_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('dynamo_image_request_event', jsonb_build_object(
+        'type', 'answer',
+        'image_request_id', NEW.image_request_id,
+        'twitch_user_id', (
+            select image_request.twitch_user_id from dynamo.image_request
+            where image_request.id = NEW.image_request_id
+        ),
+        'data', jsonb_build_object(
+            'prompt', NEW.prompt,
+            'value', NEW.value
+        )
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger notify_on_answer_created
+    after insert on dynamo.answer
+    for each row execute procedure emit_answer_created_notification();
+
+create function emit_image_created_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('dynamo_image_request_event', jsonb_build_object(
+        'type', 'image',
+        'image_request_id', NEW.image_request_id,
+        'twitch_user_id', (
+            select image_request.twitch_user_id from dynamo.image_request
+            where image_request.id = NEW.image_request_id
+        ),
+        'data', jsonb_build_object(
+            'index', NEW.index,
+            'url', NEW.url,
+            'color', NEW.color
+        )
+    )::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger notify_on_image_created
+    after insert on dynamo.image
+    for each row execute procedure emit_image_created_notification();
+
+commit;
@@ -0,0 +1,5 @@
+begin;
+
+drop table dynamo.attempt;
+
+commit;
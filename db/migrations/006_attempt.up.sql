@@ -0,0 +1,39 @@
+begin;
+
+create table dynamo.attempt (
+    image_request_id uuid not null,
+    kind             text not null,
+    number           integer not null,
+    started_at       timestamptz not null,
+    finished_at      timestamptz not null default now(),
+    error_message    text,
+    will_retry       boolean not null default false
+);
+
+comment on table dynamo.attempt is
+    'Record of a single call made to an external generation API in the course of '
+    'fulfilling an image request. Failed calls that were classified as transient are '
+    'retried with backoff, so a single generation step may result in several attempts.';
+comment on column dynamo.attempt.image_request_id is
+    'ID of the image_request record associated with this attempt.';
+comment on column dynamo.attempt.kind is
+    'Type of generation that was attempted: either "text" or "image".';
+comment on column dynamo.attempt.number is
+    'Sequential, one-indexed position of this attempt among all attempts made for the '
+    'same generation step.';
+comment on column dynamo.attempt.started_at is
+    'Timestamp indicating when we initiated the call to the generation API.';
+comment on column dynamo.attempt.finished_at is
+    'Timestamp indicating when the call to the generation API completed, whether '
+    'successfully or not.';
+comment on column dynamo.attempt.error_message is
+    'Error message describing why the attempt failed. If NULL, the attempt succeeded.';
+comment on column dynamo.attempt.will_retry is
+    'Whether the failure was classified as transient, such that another attempt was '
+    'scheduled after this one.';
+
+alter table dynamo.attempt
+    add constraint image_request_id_fk
+    foreign key (image_request_id) references dynamo.image_request (id);
+
+commit;
@@ -0,0 +1,8 @@
+begin;
+
+alter table dynamo.image_request
+    drop column stage,
+    drop column ledger_flow_id,
+    drop column twitch_display_name;
+
+commit;
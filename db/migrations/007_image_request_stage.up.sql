@@ -0,0 +1,28 @@
+begin;
+
+alter table dynamo.image_request
+    add column twitch_display_name text,
+    add column ledger_flow_id uuid,
+    add column stage text not null default 'debited';
+
+comment on column dynamo.image_request.twitch_display_name is
+    'Display name of the Twitch user that initiated this request, as of the time the '
+    'request was submitted. May be NULL for requests recorded before this column was '
+    'introduced.';
+comment on column dynamo.image_request.ledger_flow_id is
+    'ID of the pending ledger transaction (an alert-redemption outflow) that debited '
+    'points from the user in exchange for this request. The transaction is accepted '
+    'once the resulting alert has been announced, or rejected (refunding the points) if '
+    'the request fails. May be NULL for requests recorded before this column was '
+    'introduced.';
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request: "debited" '
+    '(points were debited and the request was recorded), "stored" (the generated '
+    'image was stored), "announced" (an onscreen event was produced to display the '
+    'alert), or "accepted" (the ledger transaction was accepted). Used to recover '
+    'requests that were left unfinished when the consumer stopped unexpectedly.';
+
+update dynamo.image_request set stage = 'accepted'
+    where finished_at is not null and error_message is null;
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+drop table dynamo.intermediate_image;
+
+alter table dynamo.image_request
+    drop column accepted_at,
+    drop column announced_at,
+    drop column stored_at,
+    drop column filtered_at,
+    drop column generated_at,
+    drop column named_at,
+    drop column debited_at;
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request: "debited" '
+    '(points were debited and the request was recorded), "stored" (the generated '
+    'image was stored), "announced" (an onscreen event was produced to display the '
+    'alert), or "accepted" (the ledger transaction was accepted). Used to recover '
+    'requests that were left unfinished when the consumer stopped unexpectedly.';
+
+commit;
@@ -0,0 +1,72 @@
+begin;
+
+alter table dynamo.image_request
+    add column debited_at   timestamptz,
+    add column named_at     timestamptz,
+    add column generated_at timestamptz,
+    add column filtered_at  timestamptz,
+    add column stored_at    timestamptz,
+    add column announced_at timestamptz,
+    add column accepted_at  timestamptz;
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"debited" (points were debited and the request was recorded), "named" (any '
+    'required text was generated), "generated" (an image was generated), "filtered" '
+    '(the image was converted and post-processed), "stored" (the final image was '
+    'stored), "announced" (an onscreen event was produced to display the alert), or '
+    '"accepted" (the ledger transaction was accepted). Unfinished requests are resumed '
+    'from this stage.';
+comment on column dynamo.image_request.debited_at is
+    'Timestamp indicating when the request reached the "debited" stage.';
+comment on column dynamo.image_request.named_at is
+    'Timestamp indicating when the request reached the "named" stage.';
+comment on column dynamo.image_request.generated_at is
+    'Timestamp indicating when the request reached the "generated" stage.';
+comment on column dynamo.image_request.filtered_at is
+    'Timestamp indicating when the request reached the "filtered" stage.';
+comment on column dynamo.image_request.stored_at is
+    'Timestamp indicating when the request reached the "stored" stage.';
+comment on column dynamo.image_request.announced_at is
+    'Timestamp indicating when the request reached the "announced" stage.';
+comment on column dynamo.image_request.accepted_at is
+    'Timestamp indicating when the request reached the "accepted" stage.';
+
+update dynamo.image_request set debited_at = created_at;
+
+create table dynamo.intermediate_image (
+    image_request_id uuid not null,
+    stage            text not null,
+    content_type     text not null,
+    data             bytea not null,
+    color            text,
+    created_at       timestamptz not null default now()
+);
+
+comment on table dynamo.intermediate_image is
+    'Temporary copy of an image produced by an intermediate processing stage, kept so '
+    'that an interrupted image request can be resumed without generating its image '
+    'again. Intermediate images are deleted once the final image has been stored.';
+comment on column dynamo.intermediate_image.image_request_id is
+    'ID of the image_request record associated with this image.';
+comment on column dynamo.intermediate_image.stage is
+    'The processing stage that produced this image: either "generated" or "filtered".';
+comment on column dynamo.intermediate_image.content_type is
+    'MIME type of the image data, e.g. "image/png".';
+comment on column dynamo.intermediate_image.data is
+    'Raw image data.';
+comment on column dynamo.intermediate_image.color is
+    'Hash-prefixed hex RGB value indicating the background color that was detected '
+    'while filtering the image; NULL if the image has not been filtered.';
+comment on column dynamo.intermediate_image.created_at is
+    'Timestamp indicating when the image was recorded.';
+
+alter table dynamo.intermediate_image
+    add constraint image_request_id_fk
+    foreign key (image_request_id) references dynamo.image_request (id);
+
+alter table dynamo.intermediate_image
+    add constraint image_request_id_stage_unique
+    unique (image_request_id, stage);
+
+commit;
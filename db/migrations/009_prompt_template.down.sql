@@ -0,0 +1,13 @@
+begin;
+
+alter table dynamo.answer
+    drop column prompt_template_version,
+    drop column prompt_template_id;
+
+alter table dynamo.image_request
+    drop column prompt_template_version,
+    drop column prompt_template_id;
+
+drop table dynamo.prompt_template;
+
+commit;
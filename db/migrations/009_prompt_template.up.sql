@@ -0,0 +1,106 @@
+begin;
+
+create table dynamo.prompt_template (
+    id         serial primary key,
+    style      text not null,
+    kind       text not null,
+    version    integer not null,
+    body       text not null,
+    is_active  boolean not null default false,
+    created_at timestamptz not null default now()
+);
+
+comment on table dynamo.prompt_template is
+    'A version of the template used to build prompts for image requests of a '
+    'particular style. Templates are rendered with Go''s text/template package, using '
+    'the inputs from the generation request (genreq.ImageInputs) as data. At most one '
+    'version of each template may be active at a time; if no version is active, the '
+    'style''s built-in prompt is used.';
+comment on column dynamo.prompt_template.id is
+    'Unique identifier for this version of the template.';
+comment on column dynamo.prompt_template.style is
+    'The image style that this template applies to, from the generation-requests '
+    'schema.';
+comment on column dynamo.prompt_template.kind is
+    'The kind of prompt built from this template: "image" for the prompt submitted '
+    'for image generation, or "text" for the prompt submitted to generate text that '
+    'accompanies the image.';
+comment on column dynamo.prompt_template.version is
+    'Sequential, one-indexed version number of this template, for the same style and '
+    'kind.';
+comment on column dynamo.prompt_template.body is
+    'Template source, e.g. "a ghostly image of {{ .Ghost.Subject }}".';
+comment on column dynamo.prompt_template.is_active is
+    'Whether this version of the template should be used for new requests.';
+comment on column dynamo.prompt_template.created_at is
+    'Timestamp indicating when this version of the template was created.';
+
+alter table dynamo.prompt_template
+    add constraint style_kind_version_unique
+    unique (style, kind, version);
+
+create unique index prompt_template_active_unique
+    on dynamo.prompt_template (style, kind)
+    where is_active;
+
+insert into dynamo.prompt_template (style, kind, version, body, is_active) values
+    (
+        'ghost',
+        'image',
+        1,
+        'a ghostly image of {{ .Ghost.Subject }}, with glitchy VHS artifacts, dark '
+        'background',
+        true
+    ),
+    (
+        'friend',
+        'image',
+        1,
+        '{{ with .Friend }}{{ with article .Color .Subject }}{{ . }} {{ end }}'
+        '{{ .Color }} {{ trimArticle .Subject }}, illustrated in the style of 1990s '
+        'digital clip art images, with a limited 256-color palette and sharp black '
+        'outlines, with a solid {{ complement .Color }} background suitable for chroma '
+        'keying{{ end }}',
+        true
+    ),
+    (
+        'friend',
+        'text',
+        1,
+        'Please come up with a name for a friendly mascot character who is '
+        '{{ .Friend.Subject }}. Please answer with a single name, and no additional '
+        'text.',
+        true
+    );
+
+alter table dynamo.image_request
+    add column prompt_template_id integer,
+    add column prompt_template_version integer;
+
+comment on column dynamo.image_request.prompt_template_id is
+    'ID of the prompt_template that the prompt was rendered from, or NULL if the '
+    'style''s built-in prompt was used.';
+comment on column dynamo.image_request.prompt_template_version is
+    'Version of the prompt_template that the prompt was rendered from, or NULL if the '
+    'style''s built-in prompt was used.';
+
+alter table dynamo.image_request
+    add constraint prompt_template_id_fk
+    foreign key (prompt_template_id) references dynamo.prompt_template (id);
+
+alter table dynamo.answer
+    add column prompt_template_id integer,
+    add column prompt_template_version integer;
+
+comment on column dynamo.answer.prompt_template_id is
+    'ID of the prompt_template that the prompt was rendered from, or NULL if the '
+    'style''s built-in prompt was used.';
+comment on column dynamo.answer.prompt_template_version is
+    'Version of the prompt_template that the prompt was rendered from, or NULL if the '
+    'style''s built-in prompt was used.';
+
+alter table dynamo.answer
+    add constraint prompt_template_id_fk
+    foreign key (prompt_template_id) references dynamo.prompt_template (id);
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+drop table dynamo.takedown;
+
+alter table dynamo.image_request
+    drop column removal_reason,
+    drop column removed_at;
+
+commit;
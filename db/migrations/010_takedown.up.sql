@@ -0,0 +1,57 @@
+begin;
+
+alter table dynamo.image_request
+    add column removed_at timestamptz,
+    add column removal_reason text;
+
+comment on column dynamo.image_request.removed_at is
+    'Timestamp indicating when a moderator removed this request''s images, so that they '
+    'may no longer be displayed. If NULL, the request has not been removed.';
+comment on column dynamo.image_request.removal_reason is
+    'Moderator-supplied explanation of why this request''s images were removed; NULL if '
+    'the request has not been removed.';
+
+create table dynamo.takedown (
+    id                       serial primary key,
+    image_request_id         uuid not null,
+    moderator_twitch_user_id text not null,
+    reason                   text not null,
+    object_action            text not null,
+    num_objects              integer not null,
+    refund_requested         boolean not null,
+    refund_flow_id           uuid,
+    created_at               timestamptz not null default now()
+);
+
+comment on table dynamo.takedown is
+    'Audit record of a moderator removing an image request, taking down the images '
+    'that were generated from it.';
+comment on column dynamo.takedown.id is
+    'Unique identifier for this takedown.';
+comment on column dynamo.takedown.image_request_id is
+    'ID of the image_request that was removed.';
+comment on column dynamo.takedown.moderator_twitch_user_id is
+    'ID of the Twitch user who removed the image request.';
+comment on column dynamo.takedown.reason is
+    'Moderator-supplied explanation of why the image request was removed.';
+comment on column dynamo.takedown.object_action is
+    'What was done to the stored objects for the image request: "delete" if they were '
+    'deleted from storage, or "privatize" if they were retained but made private.';
+comment on column dynamo.takedown.num_objects is
+    'Number of stored objects that were deleted or made private.';
+comment on column dynamo.takedown.refund_requested is
+    'Whether the moderator asked for the viewer''s points to be refunded.';
+comment on column dynamo.takedown.refund_flow_id is
+    'ID of the ledger transaction that refunded the viewer''s points, if any. NULL if no '
+    'refund was requested, if the viewer was never charged, or if the refund failed.';
+comment on column dynamo.takedown.created_at is
+    'Timestamp indicating when the takedown was recorded.';
+
+alter table dynamo.takedown
+    add constraint image_request_id_fk
+    foreign key (image_request_id) references dynamo.image_request (id);
+
+create index takedown_image_request_id_index
+    on dynamo.takedown (image_request_id);
+
+commit;
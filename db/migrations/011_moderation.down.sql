@@ -0,0 +1,23 @@
+begin;
+
+comment on column dynamo.attempt.kind is
+    'Type of generation that was attempted: either "text" or "image".';
+
+update dynamo.image_request set stage = 'debited' where stage = 'moderated';
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"debited" (points were debited and the request was recorded), "named" (any '
+    'required text was generated), "generated" (an image was generated), "filtered" '
+    '(the image was converted and post-processed), "stored" (the final image was '
+    'stored), "announced" (an onscreen event was produced to display the alert), or '
+    '"accepted" (the ledger transaction was accepted). Unfinished requests are resumed '
+    'from this stage.';
+
+alter table dynamo.image_request
+    drop column moderation_scores,
+    drop column moderation_flagged,
+    drop column moderation_source,
+    drop column moderated_at;
+
+commit;
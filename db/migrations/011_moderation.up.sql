@@ -0,0 +1,35 @@
+begin;
+
+alter table dynamo.image_request
+    add column moderated_at       timestamptz,
+    add column moderation_source  text,
+    add column moderation_flagged boolean,
+    add column moderation_scores  jsonb not null default '{}';
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"debited" (points were debited and the request was recorded), "moderated" (the '
+    'viewer''s inputs passed moderation), "named" (any required text was generated), '
+    '"generated" (an image was generated), "filtered" (the image was converted and '
+    'post-processed), "stored" (the final image was stored), "announced" (an onscreen '
+    'event was produced to display the alert), or "accepted" (the ledger transaction '
+    'was accepted). Unfinished requests are resumed from this stage.';
+comment on column dynamo.image_request.moderated_at is
+    'Timestamp indicating when the request reached the "moderated" stage.';
+comment on column dynamo.image_request.moderation_source is
+    'Identifies what screened the viewer''s inputs before generation: "openai" for the '
+    'OpenAI moderations API, "rules" for our local blocklist and regex rules, or '
+    '"local" for the stand-in used in development. If NULL, the request was not '
+    'moderated.';
+comment on column dynamo.image_request.moderation_flagged is
+    'Whether the viewer''s inputs were flagged during moderation, in which case the '
+    'request was rejected before any generation occurred.';
+comment on column dynamo.image_request.moderation_scores is
+    'JSON object mapping each moderation category (e.g. "violence") to a score between '
+    '0 and 1 indicating how strongly the viewer''s inputs were judged to fall into that '
+    'category. Empty if the request was not moderated.';
+
+comment on column dynamo.attempt.kind is
+    'Type of generation that was attempted: "moderation", "text", or "image".';
+
+commit;
@@ -0,0 +1,8 @@
+begin;
+
+drop index dynamo.image_request_error_code_index;
+
+alter table dynamo.image_request
+    drop column error_code;
+
+commit;
@@ -0,0 +1,16 @@
+begin;
+
+alter table dynamo.image_request
+    add column error_code text;
+
+comment on column dynamo.image_request.error_code is
+    'Stable code identifying the category of error that caused the request to fail, '
+    'e.g. "generation.rejected", "generation.flagged", "generation.failed", '
+    '"filters.failed", "storage.failed", or "processing.database"; "unknown" if the '
+    'error was not categorized. NULL if the request has not failed, or if it failed '
+    'before this column was introduced.';
+
+create index image_request_error_code_index
+    on dynamo.image_request (error_code) where error_code is not null;
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table dynamo.image_request
+    drop constraint image_request_idempotency_key_unique;
+
+alter table dynamo.image_request
+    drop column idempotency_key;
+
+commit;
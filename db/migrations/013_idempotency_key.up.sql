@@ -0,0 +1,17 @@
+begin;
+
+alter table dynamo.image_request
+    add column idempotency_key text;
+
+comment on column dynamo.image_request.idempotency_key is
+    'Key identifying the generation-requests message that this request was created '
+    'from, so that a redelivered message is not processed (and charged for) a second '
+    'time: "id:<uuid>" if the producer preassigned an ID to the request, or '
+    '"msg:<sha256>" with the hex-encoded SHA-256 hash of the AMQP message ID. NULL if '
+    'the message carried neither, or if the request was recorded before this column '
+    'was introduced.';
+
+alter table dynamo.image_request
+    add constraint image_request_idempotency_key_unique unique (idempotency_key);
+
+commit;
@@ -0,0 +1,31 @@
+begin;
+
+update dynamo.image_request set stage = 'stored' where stage = 'selected';
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"debited" (points were debited and the request was recorded), "moderated" (the '
+    'viewer''s inputs passed moderation), "named" (any required text was generated), '
+    '"generated" (an image was generated), "filtered" (the image was converted and '
+    'post-processed), "stored" (the final image was stored), "announced" (an onscreen '
+    'event was produced to display the alert), or "accepted" (the ledger transaction '
+    'was accepted). Unfinished requests are resumed from this stage.';
+
+alter table dynamo.image_request
+    drop column selected_by,
+    drop column selected_index,
+    drop column selected_at;
+
+delete from dynamo.intermediate_image where index <> 0;
+
+alter table dynamo.intermediate_image
+    drop constraint image_request_id_stage_index_unique;
+
+alter table dynamo.intermediate_image
+    add constraint image_request_id_stage_unique
+    unique (image_request_id, stage);
+
+alter table dynamo.intermediate_image
+    drop column index;
+
+commit;
@@ -0,0 +1,48 @@
+begin;
+
+alter table dynamo.intermediate_image
+    add column index integer not null default 0;
+
+comment on column dynamo.intermediate_image.index is
+    'Index of the candidate image that this intermediate image belongs to, for requests '
+    'that generate several candidates from which a single image is selected.';
+
+alter table dynamo.intermediate_image
+    drop constraint image_request_id_stage_unique;
+
+alter table dynamo.intermediate_image
+    add constraint image_request_id_stage_index_unique
+    unique (image_request_id, stage, index);
+
+alter table dynamo.image_request
+    add column selected_at    timestamptz,
+    add column selected_index integer,
+    add column selected_by    text;
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"debited" (points were debited and the request was recorded), "moderated" (the '
+    'viewer''s inputs passed moderation), "named" (any required text was generated), '
+    '"generated" (one or more candidate images were generated), "filtered" (each '
+    'candidate was converted and post-processed), "stored" (the final candidates were '
+    'stored), "selected" (one candidate was chosen for display), "announced" (an '
+    'onscreen event was produced to display the alert), or "accepted" (the ledger '
+    'transaction was accepted). Unfinished requests are resumed from this stage.';
+comment on column dynamo.image_request.selected_at is
+    'Timestamp indicating when the request reached the "selected" stage.';
+comment on column dynamo.image_request.selected_index is
+    'Index of the image that was selected for display in the onscreen alert, out of all '
+    'candidate images recorded for this request. NULL if no image has been selected '
+    'yet.';
+comment on column dynamo.image_request.selected_by is
+    'Twitch user ID of the broadcaster who selected the displayed image; NULL if the '
+    'image was selected automatically, or if no image has been selected yet.';
+
+update dynamo.image_request set selected_index = 0
+    where exists (
+        select 1 from dynamo.image
+        where image.image_request_id = image_request.id
+            and image.index = 0
+    );
+
+commit;
@@ -0,0 +1,10 @@
+begin;
+
+alter table dynamo.image_request
+    drop column text_model,
+    drop column image_style,
+    drop column image_quality,
+    drop column image_size,
+    drop column image_model;
+
+commit;
@@ -0,0 +1,31 @@
+begin;
+
+alter table dynamo.image_request
+    add column image_model   text,
+    add column image_size    text,
+    add column image_quality text,
+    add column image_style   text,
+    add column text_model    text;
+
+comment on column dynamo.image_request.image_model is
+    'Image generation model that was used to generate the images for this request, e.g. '
+    '"dall-e-3". NULL if the request was recorded before this column was introduced.';
+comment on column dynamo.image_request.image_size is
+    'Size of each generated image, in the form "<width>x<height>", e.g. "1024x1024". '
+    'NULL if the generation API''s default size was used, or if the request was '
+    'recorded before this column was introduced.';
+comment on column dynamo.image_request.image_quality is
+    'Rendering quality that images were generated with, if supported by the model: '
+    'e.g. "standard" or "hd". NULL if the model''s default quality was used, or if the '
+    'request was recorded before this column was introduced.';
+comment on column dynamo.image_request.image_style is
+    'Rendering style that images were generated with, if supported by the model: e.g. '
+    '"vivid" or "natural". Not to be confused with the style of the request itself. '
+    'NULL if the model''s default style was used, or if the request was recorded '
+    'before this column was introduced.';
+comment on column dynamo.image_request.text_model is
+    'Chat completion model that was used to generate any text for this request, e.g. '
+    '"gpt-3.5-turbo-0125". NULL if the request''s style does not require any text, or '
+    'if the request was recorded before this column was introduced.';
+
+commit;
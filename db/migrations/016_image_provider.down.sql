@@ -0,0 +1,6 @@
+begin;
+
+alter table dynamo.image_request
+    drop column image_provider;
+
+commit;
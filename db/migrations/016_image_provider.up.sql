@@ -0,0 +1,11 @@
+begin;
+
+alter table dynamo.image_request
+    add column image_provider text;
+
+comment on column dynamo.image_request.image_provider is
+    'Name of the image provider that images were requested from, e.g. "automatic1111". '
+    'NULL if images were generated by the consumer''s configured generation backend, '
+    'or if the request was recorded before this column was introduced.';
+
+commit;
@@ -0,0 +1,9 @@
+begin;
+
+alter table dynamo.intermediate_image
+    drop column provider;
+
+alter table dynamo.image
+    drop column provider;
+
+commit;
@@ -0,0 +1,19 @@
+begin;
+
+alter table dynamo.image
+    add column provider text;
+
+comment on column dynamo.image.provider is
+    'Name of the provider that generated this image, e.g. "openai" or "automatic1111": '
+    'this may differ from the image provider requested for the image request if that '
+    'provider failed and we failed over to another. NULL if the image was recorded '
+    'before this column was introduced.';
+
+alter table dynamo.intermediate_image
+    add column provider text;
+
+comment on column dynamo.intermediate_image.provider is
+    'Name of the provider that generated this image, carried over to the final image '
+    'once stored.';
+
+commit;
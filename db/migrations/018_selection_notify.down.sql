@@ -0,0 +1,6 @@
+begin;
+
+drop trigger notify_on_image_selected on dynamo.image_request;
+drop function emit_image_selected_notification;
+
+commit;
@@ -0,0 +1,16 @@
+begin;
+
+create function emit_image_selected_notification() returns trigger as $trigger$
+begin
+    perform pg_notify('dynamo_image_selected', NEW.id::text);
+    return NEW;
+end;
+$trigger$ language plpgsql;
+
+create trigger notify_on_image_selected
+    after update on dynamo.image_request
+    for each row
+    when (OLD.selected_index is null and NEW.selected_index is not null)
+    execute procedure emit_image_selected_notification();
+
+commit;
@@ -0,0 +1,21 @@
+begin;
+
+comment on column dynamo.image_request.stage is
+    'The last processing stage that was completed for this request, in order: '
+    '"received" (the request was recorded, but points may not have been debited yet), '
+    '"debited" (points were debited via the ledger transaction in ledger_flow_id), '
+    '"moderated" (the viewer''s inputs passed moderation), "named" (any required text '
+    'was generated), "generated" (one or more candidate images were generated), '
+    '"filtered" (each candidate was converted and post-processed), "stored" (the final '
+    'candidates were stored), "selected" (one candidate was chosen for display), '
+    '"announced" (an onscreen event was produced to display the alert), or "accepted" '
+    '(the ledger transaction was accepted). Unfinished requests are resumed from this '
+    'stage.';
+comment on column dynamo.image_request.ledger_flow_id is
+    'ID of the pending ledger transaction (an alert-redemption outflow) that debited '
+    'points from the user in exchange for this request. The transaction is accepted '
+    'once the resulting alert has been announced, or rejected (refunding the points) if '
+    'the request fails. NULL if points have not been debited yet, or if the request was '
+    'recorded before this column was introduced.';
+
+commit;
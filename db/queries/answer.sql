@@ -2,9 +2,20 @@
 insert into dynamo.answer (
     image_request_id,
     prompt,
-    value
+    value,
+    prompt_template_id,
+    prompt_template_version
 ) values (
     sqlc.arg('image_request_id'),
     sqlc.arg('prompt'),
-    sqlc.arg('value')
+    sqlc.arg('value'),
+    sqlc.narg('prompt_template_id'),
+    sqlc.narg('prompt_template_version')
 );
+
+-- name: GetImageRequestAnswers :many
+select
+    answer.prompt,
+    answer.value
+from dynamo.answer
+where answer.image_request_id = sqlc.arg('image_request_id');
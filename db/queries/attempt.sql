@@ -0,0 +1,18 @@
+-- name: RecordAttempt :exec
+insert into dynamo.attempt (
+    image_request_id,
+    kind,
+    number,
+    started_at,
+    finished_at,
+    error_message,
+    will_retry
+) values (
+    sqlc.arg('image_request_id'),
+    sqlc.arg('kind'),
+    sqlc.arg('number'),
+    sqlc.arg('started_at'),
+    now(),
+    sqlc.narg('error_message'),
+    sqlc.arg('will_retry')
+);
@@ -7,6 +7,17 @@ insert into dynamo.image_request (
     style,
     inputs,
     prompt,
+    twitch_display_name,
+    prompt_template_id,
+    prompt_template_version,
+    idempotency_key,
+    image_model,
+    image_size,
+    image_quality,
+    image_style,
+    text_model,
+    image_provider,
+    stage,
     created_at
 ) values (
     sqlc.arg('image_request_id'),
@@ -16,13 +27,30 @@ insert into dynamo.image_request (
     sqlc.arg('style'),
     sqlc.arg('inputs'),
     sqlc.arg('prompt'),
+    sqlc.narg('twitch_display_name'),
+    sqlc.narg('prompt_template_id'),
+    sqlc.narg('prompt_template_version'),
+    sqlc.narg('idempotency_key'),
+    sqlc.narg('image_model'),
+    sqlc.narg('image_size'),
+    sqlc.narg('image_quality'),
+    sqlc.narg('image_style'),
+    sqlc.narg('text_model'),
+    sqlc.narg('image_provider'),
+    'received',
     now()
 );
 
+-- name: RecordImageRequestDebit :exec
+update dynamo.image_request set
+    ledger_flow_id = sqlc.arg('ledger_flow_id')::uuid
+where image_request.id = sqlc.arg('image_request_id');
+
 -- name: RecordImageRequestFailure :execresult
 update dynamo.image_request set
     finished_at = now(),
-    error_message = sqlc.arg('error_message')::text
+    error_message = sqlc.arg('error_message')::text,
+    error_code = sqlc.arg('error_code')::text
 where image_request.id = sqlc.arg('image_request_id')
     and finished_at is null;
 
@@ -32,15 +60,328 @@ update dynamo.image_request set
 where image_request.id = sqlc.arg('image_request_id')
     and finished_at is null;
 
+-- name: RecordImageRequestModeration :exec
+update dynamo.image_request set
+    moderation_source = sqlc.arg('moderation_source')::text,
+    moderation_flagged = sqlc.arg('moderation_flagged')::boolean,
+    moderation_scores = sqlc.arg('moderation_scores')::jsonb
+where image_request.id = sqlc.arg('image_request_id');
+
+-- name: SetImageRequestStage :exec
+update dynamo.image_request set
+    stage = sqlc.arg('stage')::text,
+    debited_at = case when sqlc.arg('stage')::text = 'debited' then now() else debited_at end,
+    moderated_at = case when sqlc.arg('stage')::text = 'moderated' then now() else moderated_at end,
+    named_at = case when sqlc.arg('stage')::text = 'named' then now() else named_at end,
+    generated_at = case when sqlc.arg('stage')::text = 'generated' then now() else generated_at end,
+    filtered_at = case when sqlc.arg('stage')::text = 'filtered' then now() else filtered_at end,
+    stored_at = case when sqlc.arg('stage')::text = 'stored' then now() else stored_at end,
+    selected_at = case when sqlc.arg('stage')::text = 'selected' then now() else selected_at end,
+    announced_at = case when sqlc.arg('stage')::text = 'announced' then now() else announced_at end,
+    accepted_at = case when sqlc.arg('stage')::text = 'accepted' then now() else accepted_at end
+where image_request.id = sqlc.arg('image_request_id');
+
+-- name: SelectImage :execresult
+update dynamo.image_request set
+    selected_index = sqlc.arg('selected_index')::integer,
+    selected_by = sqlc.narg('selected_by')::text
+where image_request.id = sqlc.arg('image_request_id')
+    and image_request.selected_index is null
+    and image_request.finished_at is null;
+
 -- name: RecordImage :exec
 insert into dynamo.image (
     image_request_id,
     index,
     url,
-    color
+    color,
+    provider
 ) values (
     sqlc.arg('image_request_id'),
     sqlc.arg('index'),
     sqlc.arg('url'),
-    sqlc.arg('color')
+    sqlc.arg('color'),
+    sqlc.narg('provider')
 );
+
+-- name: GetImageRequest :one
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.id = sqlc.arg('image_request_id');
+
+-- name: GetImageRequestByIdempotencyKey :one
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.idempotency_key = sqlc.arg('idempotency_key')::text;
+
+-- name: ReleaseImageRequestIdempotencyKey :exec
+update dynamo.image_request set
+    idempotency_key = null
+where image_request.id = sqlc.arg('image_request_id');
+
+-- name: GetImageRequestSelection :one
+select image_request.selected_index
+from dynamo.image_request
+where image_request.id = sqlc.arg('image_request_id');
+
+-- name: GetImageRequestImages :many
+select
+    image.index,
+    image.url,
+    image.color,
+    image.provider
+from dynamo.image
+where image.image_request_id = sqlc.arg('image_request_id')
+order by image.index;
+
+-- name: ListImageRequests :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where case when sqlc.narg('twitch_user_id')::text is null
+    then true
+    else image_request.twitch_user_id = sqlc.narg('twitch_user_id')::text
+end
+and case when sqlc.narg('broadcast_id')::integer is null
+    then true
+    else image_request.broadcast_id = sqlc.narg('broadcast_id')::integer
+end
+and case when sqlc.narg('screening_id')::uuid is null
+    then true
+    else image_request.screening_id = sqlc.narg('screening_id')::uuid
+end
+and case when sqlc.narg('style')::text is null
+    then true
+    else image_request.style = sqlc.narg('style')::text
+end
+and case when sqlc.narg('status')::text is null
+    then true
+    else sqlc.narg('status')::text = (
+        case
+            when image_request.finished_at is null then 'pending'
+            when image_request.error_message is null then 'succeeded'
+            else 'failed'
+        end
+    )
+end
+and case when sqlc.narg('start_id')::uuid is null
+    then true
+    else (image_request.created_at, image_request.id) <= (
+        select image_request.created_at, image_request.id from dynamo.image_request
+        where image_request.id = sqlc.narg('start_id')::uuid
+    )
+end
+order by image_request.created_at desc, image_request.id desc
+limit sqlc.arg('num_records');
+
+-- name: ListImageRequestsAwaitingSelection :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.finished_at is null
+    and image_request.stage = 'stored'
+    and (
+        image_request.selected_index is not null
+        or image_request.stored_at < now() - make_interval(secs => sqlc.arg('timeout_seconds')::integer)
+    )
+order by image_request.stored_at;
+
+-- name: ListStaleImageRequests :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.finished_at is null
+    and image_request.created_at < now() - make_interval(secs => sqlc.arg('min_age_seconds')::integer)
+order by image_request.created_at;
@@ -0,0 +1,40 @@
+-- name: SaveIntermediateImage :exec
+insert into dynamo.intermediate_image (
+    image_request_id,
+    stage,
+    index,
+    content_type,
+    data,
+    color,
+    provider
+) values (
+    sqlc.arg('image_request_id'),
+    sqlc.arg('stage'),
+    sqlc.arg('index'),
+    sqlc.arg('content_type'),
+    sqlc.arg('data'),
+    sqlc.narg('color'),
+    sqlc.narg('provider')
+)
+on conflict (image_request_id, stage, index) do update set
+    content_type = excluded.content_type,
+    data = excluded.data,
+    color = excluded.color,
+    provider = excluded.provider,
+    created_at = now();
+
+-- name: ListIntermediateImages :many
+select
+    intermediate_image.index,
+    intermediate_image.content_type,
+    intermediate_image.data,
+    intermediate_image.color,
+    intermediate_image.provider
+from dynamo.intermediate_image
+where intermediate_image.image_request_id = sqlc.arg('image_request_id')
+    and intermediate_image.stage = sqlc.arg('stage')
+order by intermediate_image.index;
+
+-- name: DeleteIntermediateImages :exec
+delete from dynamo.intermediate_image
+where intermediate_image.image_request_id = sqlc.arg('image_request_id');
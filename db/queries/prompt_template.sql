@@ -0,0 +1,12 @@
+-- name: ListActivePromptTemplates :many
+select
+    prompt_template.id,
+    prompt_template.style,
+    prompt_template.kind,
+    prompt_template.version,
+    prompt_template.body,
+    prompt_template.is_active,
+    prompt_template.created_at
+from dynamo.prompt_template
+where prompt_template.is_active
+order by prompt_template.style, prompt_template.kind;
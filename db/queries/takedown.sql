@@ -0,0 +1,31 @@
+-- name: RecordTakedown :one
+with removed as (
+    update dynamo.image_request set
+        removed_at = now(),
+        removal_reason = sqlc.arg('reason')::text
+    where image_request.id = sqlc.arg('image_request_id')
+        and image_request.removed_at is null
+    returning image_request.id
+)
+insert into dynamo.takedown (
+    image_request_id,
+    moderator_twitch_user_id,
+    reason,
+    object_action,
+    num_objects,
+    refund_requested
+)
+select
+    removed.id,
+    sqlc.arg('moderator_twitch_user_id'),
+    sqlc.arg('reason')::text,
+    sqlc.arg('object_action'),
+    sqlc.arg('num_objects'),
+    sqlc.arg('refund_requested')
+from removed
+returning takedown.id;
+
+-- name: SetTakedownRefundFlowId :exec
+update dynamo.takedown set
+    refund_flow_id = sqlc.arg('refund_flow_id')
+where takedown.id = sqlc.arg('takedown_id');
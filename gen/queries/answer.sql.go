@@ -7,29 +7,78 @@ package queries
 
 import (
 	"context"
+	"database/sql"
 
 	"github.com/google/uuid"
 )
 
+const getImageRequestAnswers = `-- name: GetImageRequestAnswers :many
+select
+    answer.prompt,
+    answer.value
+from dynamo.answer
+where answer.image_request_id = $1
+`
+
+type GetImageRequestAnswersRow struct {
+	Prompt string
+	Value  string
+}
+
+func (q *Queries) GetImageRequestAnswers(ctx context.Context, imageRequestID uuid.UUID) ([]GetImageRequestAnswersRow, error) {
+	rows, err := q.db.QueryContext(ctx, getImageRequestAnswers, imageRequestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetImageRequestAnswersRow
+	for rows.Next() {
+		var i GetImageRequestAnswersRow
+		if err := rows.Scan(&i.Prompt, &i.Value); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const recordAnswer = `-- name: RecordAnswer :exec
 insert into dynamo.answer (
     image_request_id,
     prompt,
-    value
+    value,
+    prompt_template_id,
+    prompt_template_version
 ) values (
     $1,
     $2,
-    $3
+    $3,
+    $4,
+    $5
 )
 `
 
 type RecordAnswerParams struct {
-	ImageRequestID uuid.UUID
-	Prompt         string
-	Value          string
+	ImageRequestID        uuid.UUID
+	Prompt                string
+	Value                 string
+	PromptTemplateID      sql.NullInt32
+	PromptTemplateVersion sql.NullInt32
 }
 
 func (q *Queries) RecordAnswer(ctx context.Context, arg RecordAnswerParams) error {
-	_, err := q.db.ExecContext(ctx, recordAnswer, arg.ImageRequestID, arg.Prompt, arg.Value)
+	_, err := q.db.ExecContext(ctx, recordAnswer,
+		arg.ImageRequestID,
+		arg.Prompt,
+		arg.Value,
+		arg.PromptTemplateID,
+		arg.PromptTemplateVersion,
+	)
 	return err
 }
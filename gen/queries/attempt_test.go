@@ -0,0 +1,68 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordAttempt(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	err := q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57"),
+		TwitchUserID:   "1005",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a lighthouse"}`),
+		Prompt:         "an image of a lighthouse, dark background",
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM dynamo.attempt")
+
+	err = q.RecordAttempt(context.Background(), queries.RecordAttemptParams{
+		ImageRequestID: uuid.MustParse("0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57"),
+		Kind:           "image",
+		Number:         1,
+		StartedAt:      time.Now().Add(-5 * time.Second),
+		ErrorMessage:   sql.NullString{Valid: true, String: "error, status code: 429, message: slow down"},
+		WillRetry:      true,
+	})
+	assert.NoError(t, err)
+
+	err = q.RecordAttempt(context.Background(), queries.RecordAttemptParams{
+		ImageRequestID: uuid.MustParse("0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57"),
+		Kind:           "image",
+		Number:         2,
+		StartedAt:      time.Now().Add(-2 * time.Second),
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 2, `
+		SELECT COUNT(*) FROM dynamo.attempt
+			WHERE image_request_id = '0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57'
+			AND kind = 'image'
+			AND finished_at >= started_at
+	`)
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.attempt
+			WHERE image_request_id = '0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57'
+			AND number = 1
+			AND error_message = 'error, status code: 429, message: slow down'
+			AND will_retry
+	`)
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.attempt
+			WHERE image_request_id = '0a4bb6bd-4b9e-4a63-8f3c-1d0b1e0e6a57'
+			AND number = 2
+			AND error_message IS NULL
+			AND NOT will_retry
+	`)
+}
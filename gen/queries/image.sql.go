@@ -13,17 +13,646 @@ import (
 	"github.com/google/uuid"
 )
 
+const getImageRequest = `-- name: GetImageRequest :one
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.id = $1
+`
+
+func (q *Queries) GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (DynamoImageRequest, error) {
+	row := q.db.QueryRowContext(ctx, getImageRequest, imageRequestID)
+	var i DynamoImageRequest
+	err := row.Scan(
+		&i.ID,
+		&i.TwitchUserID,
+		&i.BroadcastID,
+		&i.ScreeningID,
+		&i.Style,
+		&i.Inputs,
+		&i.Prompt,
+		&i.CreatedAt,
+		&i.FinishedAt,
+		&i.ErrorMessage,
+		&i.TwitchDisplayName,
+		&i.LedgerFlowID,
+		&i.Stage,
+		&i.DebitedAt,
+		&i.NamedAt,
+		&i.GeneratedAt,
+		&i.FilteredAt,
+		&i.StoredAt,
+		&i.AnnouncedAt,
+		&i.AcceptedAt,
+		&i.PromptTemplateID,
+		&i.PromptTemplateVersion,
+		&i.RemovedAt,
+		&i.RemovalReason,
+		&i.ModeratedAt,
+		&i.ModerationSource,
+		&i.ModerationFlagged,
+		&i.ModerationScores,
+		&i.ErrorCode,
+		&i.IdempotencyKey,
+		&i.SelectedAt,
+		&i.SelectedIndex,
+		&i.SelectedBy,
+		&i.ImageModel,
+		&i.ImageSize,
+		&i.ImageQuality,
+		&i.ImageStyle,
+		&i.TextModel,
+		&i.ImageProvider,
+	)
+	return i, err
+}
+
+const getImageRequestByIdempotencyKey = `-- name: GetImageRequestByIdempotencyKey :one
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.idempotency_key = $1::text
+`
+
+func (q *Queries) GetImageRequestByIdempotencyKey(ctx context.Context, idempotencyKey string) (DynamoImageRequest, error) {
+	row := q.db.QueryRowContext(ctx, getImageRequestByIdempotencyKey, idempotencyKey)
+	var i DynamoImageRequest
+	err := row.Scan(
+		&i.ID,
+		&i.TwitchUserID,
+		&i.BroadcastID,
+		&i.ScreeningID,
+		&i.Style,
+		&i.Inputs,
+		&i.Prompt,
+		&i.CreatedAt,
+		&i.FinishedAt,
+		&i.ErrorMessage,
+		&i.TwitchDisplayName,
+		&i.LedgerFlowID,
+		&i.Stage,
+		&i.DebitedAt,
+		&i.NamedAt,
+		&i.GeneratedAt,
+		&i.FilteredAt,
+		&i.StoredAt,
+		&i.AnnouncedAt,
+		&i.AcceptedAt,
+		&i.PromptTemplateID,
+		&i.PromptTemplateVersion,
+		&i.RemovedAt,
+		&i.RemovalReason,
+		&i.ModeratedAt,
+		&i.ModerationSource,
+		&i.ModerationFlagged,
+		&i.ModerationScores,
+		&i.ErrorCode,
+		&i.IdempotencyKey,
+		&i.SelectedAt,
+		&i.SelectedIndex,
+		&i.SelectedBy,
+		&i.ImageModel,
+		&i.ImageSize,
+		&i.ImageQuality,
+		&i.ImageStyle,
+		&i.TextModel,
+		&i.ImageProvider,
+	)
+	return i, err
+}
+
+const getImageRequestImages = `-- name: GetImageRequestImages :many
+select
+    image.index,
+    image.url,
+    image.color,
+    image.provider
+from dynamo.image
+where image.image_request_id = $1
+order by image.index
+`
+
+type GetImageRequestImagesRow struct {
+	Index    int32
+	Url      string
+	Color    string
+	Provider sql.NullString
+}
+
+func (q *Queries) GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]GetImageRequestImagesRow, error) {
+	rows, err := q.db.QueryContext(ctx, getImageRequestImages, imageRequestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []GetImageRequestImagesRow
+	for rows.Next() {
+		var i GetImageRequestImagesRow
+		if err := rows.Scan(
+			&i.Index,
+			&i.Url,
+			&i.Color,
+			&i.Provider,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const getImageRequestSelection = `-- name: GetImageRequestSelection :one
+select image_request.selected_index
+from dynamo.image_request
+where image_request.id = $1
+`
+
+func (q *Queries) GetImageRequestSelection(ctx context.Context, imageRequestID uuid.UUID) (sql.NullInt32, error) {
+	row := q.db.QueryRowContext(ctx, getImageRequestSelection, imageRequestID)
+	var selected_index sql.NullInt32
+	err := row.Scan(&selected_index)
+	return selected_index, err
+}
+
+const listImageRequests = `-- name: ListImageRequests :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where case when $1::text is null
+    then true
+    else image_request.twitch_user_id = $1::text
+end
+and case when $2::integer is null
+    then true
+    else image_request.broadcast_id = $2::integer
+end
+and case when $3::uuid is null
+    then true
+    else image_request.screening_id = $3::uuid
+end
+and case when $4::text is null
+    then true
+    else image_request.style = $4::text
+end
+and case when $5::text is null
+    then true
+    else $5::text = (
+        case
+            when image_request.finished_at is null then 'pending'
+            when image_request.error_message is null then 'succeeded'
+            else 'failed'
+        end
+    )
+end
+and case when $6::uuid is null
+    then true
+    else (image_request.created_at, image_request.id) <= (
+        select image_request.created_at, image_request.id from dynamo.image_request
+        where image_request.id = $6::uuid
+    )
+end
+order by image_request.created_at desc, image_request.id desc
+limit $7
+`
+
+type ListImageRequestsParams struct {
+	TwitchUserID sql.NullString
+	BroadcastID  sql.NullInt32
+	ScreeningID  uuid.NullUUID
+	Style        sql.NullString
+	Status       sql.NullString
+	StartID      uuid.NullUUID
+	NumRecords   int32
+}
+
+func (q *Queries) ListImageRequests(ctx context.Context, arg ListImageRequestsParams) ([]DynamoImageRequest, error) {
+	rows, err := q.db.QueryContext(ctx, listImageRequests,
+		arg.TwitchUserID,
+		arg.BroadcastID,
+		arg.ScreeningID,
+		arg.Style,
+		arg.Status,
+		arg.StartID,
+		arg.NumRecords,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DynamoImageRequest
+	for rows.Next() {
+		var i DynamoImageRequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.TwitchUserID,
+			&i.BroadcastID,
+			&i.ScreeningID,
+			&i.Style,
+			&i.Inputs,
+			&i.Prompt,
+			&i.CreatedAt,
+			&i.FinishedAt,
+			&i.ErrorMessage,
+			&i.TwitchDisplayName,
+			&i.LedgerFlowID,
+			&i.Stage,
+			&i.DebitedAt,
+			&i.NamedAt,
+			&i.GeneratedAt,
+			&i.FilteredAt,
+			&i.StoredAt,
+			&i.AnnouncedAt,
+			&i.AcceptedAt,
+			&i.PromptTemplateID,
+			&i.PromptTemplateVersion,
+			&i.RemovedAt,
+			&i.RemovalReason,
+			&i.ModeratedAt,
+			&i.ModerationSource,
+			&i.ModerationFlagged,
+			&i.ModerationScores,
+			&i.ErrorCode,
+			&i.IdempotencyKey,
+			&i.SelectedAt,
+			&i.SelectedIndex,
+			&i.SelectedBy,
+			&i.ImageModel,
+			&i.ImageSize,
+			&i.ImageQuality,
+			&i.ImageStyle,
+			&i.TextModel,
+			&i.ImageProvider,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listImageRequestsAwaitingSelection = `-- name: ListImageRequestsAwaitingSelection :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.finished_at is null
+    and image_request.stage = 'stored'
+    and (
+        image_request.selected_index is not null
+        or image_request.stored_at < now() - make_interval(secs => $1::integer)
+    )
+order by image_request.stored_at
+`
+
+func (q *Queries) ListImageRequestsAwaitingSelection(ctx context.Context, timeoutSeconds int32) ([]DynamoImageRequest, error) {
+	rows, err := q.db.QueryContext(ctx, listImageRequestsAwaitingSelection, timeoutSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DynamoImageRequest
+	for rows.Next() {
+		var i DynamoImageRequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.TwitchUserID,
+			&i.BroadcastID,
+			&i.ScreeningID,
+			&i.Style,
+			&i.Inputs,
+			&i.Prompt,
+			&i.CreatedAt,
+			&i.FinishedAt,
+			&i.ErrorMessage,
+			&i.TwitchDisplayName,
+			&i.LedgerFlowID,
+			&i.Stage,
+			&i.DebitedAt,
+			&i.NamedAt,
+			&i.GeneratedAt,
+			&i.FilteredAt,
+			&i.StoredAt,
+			&i.AnnouncedAt,
+			&i.AcceptedAt,
+			&i.PromptTemplateID,
+			&i.PromptTemplateVersion,
+			&i.RemovedAt,
+			&i.RemovalReason,
+			&i.ModeratedAt,
+			&i.ModerationSource,
+			&i.ModerationFlagged,
+			&i.ModerationScores,
+			&i.ErrorCode,
+			&i.IdempotencyKey,
+			&i.SelectedAt,
+			&i.SelectedIndex,
+			&i.SelectedBy,
+			&i.ImageModel,
+			&i.ImageSize,
+			&i.ImageQuality,
+			&i.ImageStyle,
+			&i.TextModel,
+			&i.ImageProvider,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+const listStaleImageRequests = `-- name: ListStaleImageRequests :many
+select
+    image_request.id,
+    image_request.twitch_user_id,
+    image_request.broadcast_id,
+    image_request.screening_id,
+    image_request.style,
+    image_request.inputs,
+    image_request.prompt,
+    image_request.created_at,
+    image_request.finished_at,
+    image_request.error_message,
+    image_request.twitch_display_name,
+    image_request.ledger_flow_id,
+    image_request.stage,
+    image_request.debited_at,
+    image_request.named_at,
+    image_request.generated_at,
+    image_request.filtered_at,
+    image_request.stored_at,
+    image_request.announced_at,
+    image_request.accepted_at,
+    image_request.prompt_template_id,
+    image_request.prompt_template_version,
+    image_request.removed_at,
+    image_request.removal_reason,
+    image_request.moderated_at,
+    image_request.moderation_source,
+    image_request.moderation_flagged,
+    image_request.moderation_scores,
+    image_request.error_code,
+    image_request.idempotency_key,
+    image_request.selected_at,
+    image_request.selected_index,
+    image_request.selected_by,
+    image_request.image_model,
+    image_request.image_size,
+    image_request.image_quality,
+    image_request.image_style,
+    image_request.text_model,
+    image_request.image_provider
+from dynamo.image_request
+where image_request.finished_at is null
+    and image_request.created_at < now() - make_interval(secs => $1::integer)
+order by image_request.created_at
+`
+
+func (q *Queries) ListStaleImageRequests(ctx context.Context, minAgeSeconds int32) ([]DynamoImageRequest, error) {
+	rows, err := q.db.QueryContext(ctx, listStaleImageRequests, minAgeSeconds)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var items []DynamoImageRequest
+	for rows.Next() {
+		var i DynamoImageRequest
+		if err := rows.Scan(
+			&i.ID,
+			&i.TwitchUserID,
+			&i.BroadcastID,
+			&i.ScreeningID,
+			&i.Style,
+			&i.Inputs,
+			&i.Prompt,
+			&i.CreatedAt,
+			&i.FinishedAt,
+			&i.ErrorMessage,
+			&i.TwitchDisplayName,
+			&i.LedgerFlowID,
+			&i.Stage,
+			&i.DebitedAt,
+			&i.NamedAt,
+			&i.GeneratedAt,
+			&i.FilteredAt,
+			&i.StoredAt,
+			&i.AnnouncedAt,
+			&i.AcceptedAt,
+			&i.PromptTemplateID,
+			&i.PromptTemplateVersion,
+			&i.RemovedAt,
+			&i.RemovalReason,
+			&i.ModeratedAt,
+			&i.ModerationSource,
+			&i.ModerationFlagged,
+			&i.ModerationScores,
+			&i.ErrorCode,
+			&i.IdempotencyKey,
+			&i.SelectedAt,
+			&i.SelectedIndex,
+			&i.SelectedBy,
+			&i.ImageModel,
+			&i.ImageSize,
+			&i.ImageQuality,
+			&i.ImageStyle,
+			&i.TextModel,
+			&i.ImageProvider,
+		); err != nil {
+			return nil, err
+		}
+		items = append(items, i)
+	}
+	if err := rows.Close(); err != nil {
+		return nil, err
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
 const recordImage = `-- name: RecordImage :exec
 insert into dynamo.image (
     image_request_id,
     index,
     url,
-    color
+    color,
+    provider
 ) values (
     $1,
     $2,
     $3,
-    $4
+    $4,
+    $5
 )
 `
 
@@ -32,6 +661,7 @@ type RecordImageParams struct {
 	Index          int32
 	Url            string
 	Color          string
+	Provider       sql.NullString
 }
 
 func (q *Queries) RecordImage(ctx context.Context, arg RecordImageParams) error {
@@ -40,6 +670,7 @@ func (q *Queries) RecordImage(ctx context.Context, arg RecordImageParams) error
 		arg.Index,
 		arg.Url,
 		arg.Color,
+		arg.Provider,
 	)
 	return err
 }
@@ -53,6 +684,17 @@ insert into dynamo.image_request (
     style,
     inputs,
     prompt,
+    twitch_display_name,
+    prompt_template_id,
+    prompt_template_version,
+    idempotency_key,
+    image_model,
+    image_size,
+    image_quality,
+    image_style,
+    text_model,
+    image_provider,
+    stage,
     created_at
 ) values (
     $1,
@@ -62,18 +704,39 @@ insert into dynamo.image_request (
     $5,
     $6,
     $7,
+    $8,
+    $9,
+    $10,
+    $11,
+    $12,
+    $13,
+    $14,
+    $15,
+    $16,
+    $17,
+    'received',
     now()
 )
 `
 
 type RecordImageRequestParams struct {
-	ImageRequestID uuid.UUID
-	TwitchUserID   string
-	BroadcastID    sql.NullInt32
-	ScreeningID    uuid.NullUUID
-	Style          string
-	Inputs         json.RawMessage
-	Prompt         string
+	ImageRequestID        uuid.UUID
+	TwitchUserID          string
+	BroadcastID           sql.NullInt32
+	ScreeningID           uuid.NullUUID
+	Style                 string
+	Inputs                json.RawMessage
+	Prompt                string
+	TwitchDisplayName     sql.NullString
+	PromptTemplateID      sql.NullInt32
+	PromptTemplateVersion sql.NullInt32
+	IdempotencyKey        sql.NullString
+	ImageModel            sql.NullString
+	ImageSize             sql.NullString
+	ImageQuality          sql.NullString
+	ImageStyle            sql.NullString
+	TextModel             sql.NullString
+	ImageProvider         sql.NullString
 }
 
 func (q *Queries) RecordImageRequest(ctx context.Context, arg RecordImageRequestParams) error {
@@ -85,25 +748,78 @@ func (q *Queries) RecordImageRequest(ctx context.Context, arg RecordImageRequest
 		arg.Style,
 		arg.Inputs,
 		arg.Prompt,
+		arg.TwitchDisplayName,
+		arg.PromptTemplateID,
+		arg.PromptTemplateVersion,
+		arg.IdempotencyKey,
+		arg.ImageModel,
+		arg.ImageSize,
+		arg.ImageQuality,
+		arg.ImageStyle,
+		arg.TextModel,
+		arg.ImageProvider,
 	)
 	return err
 }
 
+const recordImageRequestDebit = `-- name: RecordImageRequestDebit :exec
+update dynamo.image_request set
+    ledger_flow_id = $1::uuid
+where image_request.id = $2
+`
+
+type RecordImageRequestDebitParams struct {
+	LedgerFlowID   uuid.UUID
+	ImageRequestID uuid.UUID
+}
+
+func (q *Queries) RecordImageRequestDebit(ctx context.Context, arg RecordImageRequestDebitParams) error {
+	_, err := q.db.ExecContext(ctx, recordImageRequestDebit, arg.LedgerFlowID, arg.ImageRequestID)
+	return err
+}
+
 const recordImageRequestFailure = `-- name: RecordImageRequestFailure :execresult
 update dynamo.image_request set
     finished_at = now(),
-    error_message = $1::text
-where image_request.id = $2
+    error_message = $1::text,
+    error_code = $2::text
+where image_request.id = $3
     and finished_at is null
 `
 
 type RecordImageRequestFailureParams struct {
 	ErrorMessage   string
+	ErrorCode      string
 	ImageRequestID uuid.UUID
 }
 
 func (q *Queries) RecordImageRequestFailure(ctx context.Context, arg RecordImageRequestFailureParams) (sql.Result, error) {
-	return q.db.ExecContext(ctx, recordImageRequestFailure, arg.ErrorMessage, arg.ImageRequestID)
+	return q.db.ExecContext(ctx, recordImageRequestFailure, arg.ErrorMessage, arg.ErrorCode, arg.ImageRequestID)
+}
+
+const recordImageRequestModeration = `-- name: RecordImageRequestModeration :exec
+update dynamo.image_request set
+    moderation_source = $1::text,
+    moderation_flagged = $2::boolean,
+    moderation_scores = $3::jsonb
+where image_request.id = $4
+`
+
+type RecordImageRequestModerationParams struct {
+	ModerationSource  string
+	ModerationFlagged bool
+	ModerationScores  json.RawMessage
+	ImageRequestID    uuid.UUID
+}
+
+func (q *Queries) RecordImageRequestModeration(ctx context.Context, arg RecordImageRequestModerationParams) error {
+	_, err := q.db.ExecContext(ctx, recordImageRequestModeration,
+		arg.ModerationSource,
+		arg.ModerationFlagged,
+		arg.ModerationScores,
+		arg.ImageRequestID,
+	)
+	return err
 }
 
 const recordImageRequestSuccess = `-- name: RecordImageRequestSuccess :execresult
@@ -116,3 +832,58 @@ where image_request.id = $1
 func (q *Queries) RecordImageRequestSuccess(ctx context.Context, imageRequestID uuid.UUID) (sql.Result, error) {
 	return q.db.ExecContext(ctx, recordImageRequestSuccess, imageRequestID)
 }
+
+const releaseImageRequestIdempotencyKey = `-- name: ReleaseImageRequestIdempotencyKey :exec
+update dynamo.image_request set
+    idempotency_key = null
+where image_request.id = $1
+`
+
+func (q *Queries) ReleaseImageRequestIdempotencyKey(ctx context.Context, imageRequestID uuid.UUID) error {
+	_, err := q.db.ExecContext(ctx, releaseImageRequestIdempotencyKey, imageRequestID)
+	return err
+}
+
+const selectImage = `-- name: SelectImage :execresult
+update dynamo.image_request set
+    selected_index = $1::integer,
+    selected_by = $2::text
+where image_request.id = $3
+    and image_request.selected_index is null
+    and image_request.finished_at is null
+`
+
+type SelectImageParams struct {
+	SelectedIndex  int32
+	SelectedBy     sql.NullString
+	ImageRequestID uuid.UUID
+}
+
+func (q *Queries) SelectImage(ctx context.Context, arg SelectImageParams) (sql.Result, error) {
+	return q.db.ExecContext(ctx, selectImage, arg.SelectedIndex, arg.SelectedBy, arg.ImageRequestID)
+}
+
+const setImageRequestStage = `-- name: SetImageRequestStage :exec
+update dynamo.image_request set
+    stage = $1::text,
+    debited_at = case when $1::text = 'debited' then now() else debited_at end,
+    moderated_at = case when $1::text = 'moderated' then now() else moderated_at end,
+    named_at = case when $1::text = 'named' then now() else named_at end,
+    generated_at = case when $1::text = 'generated' then now() else generated_at end,
+    filtered_at = case when $1::text = 'filtered' then now() else filtered_at end,
+    stored_at = case when $1::text = 'stored' then now() else stored_at end,
+    selected_at = case when $1::text = 'selected' then now() else selected_at end,
+    announced_at = case when $1::text = 'announced' then now() else announced_at end,
+    accepted_at = case when $1::text = 'accepted' then now() else accepted_at end
+where image_request.id = $2
+`
+
+type SetImageRequestStageParams struct {
+	Stage          string
+	ImageRequestID uuid.UUID
+}
+
+func (q *Queries) SetImageRequestStage(ctx context.Context, arg SetImageRequestStageParams) error {
+	_, err := q.db.ExecContext(ctx, setImageRequestStage, arg.Stage, arg.ImageRequestID)
+	return err
+}
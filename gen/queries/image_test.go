@@ -2,6 +2,7 @@ package queries_test
 
 import (
 	"context"
+	"database/sql"
 	"testing"
 
 	"github.com/golden-vcr/dynamo/gen/queries"
@@ -22,6 +23,10 @@ func Test_RecordImageRequest(t *testing.T) {
 		Style:          "ghost",
 		Inputs:         []byte(`{"subject":"a scary clown"}`),
 		Prompt:         "an image of a scary clown, dark background",
+		ImageModel:     sql.NullString{Valid: true, String: "dall-e-3"},
+		ImageSize:      sql.NullString{Valid: true, String: "1024x1024"},
+		ImageQuality:   sql.NullString{Valid: true, String: "standard"},
+		ImageStyle:     sql.NullString{Valid: true, String: "natural"},
 	})
 	assert.NoError(t, err)
 
@@ -32,6 +37,12 @@ func Test_RecordImageRequest(t *testing.T) {
 			AND style = 'ghost'
 			AND inputs = '{"subject":"a scary clown"}'::jsonb
 			AND prompt = 'an image of a scary clown, dark background'
+			AND image_model = 'dall-e-3'
+			AND image_size = '1024x1024'
+			AND image_quality = 'standard'
+			AND image_style = 'natural'
+			AND text_model IS NULL
+			AND image_provider IS NULL
 			AND created_at IS NOT NULL
 			AND finished_at IS NULL
 			AND error_message IS NULL
@@ -56,6 +67,7 @@ func Test_RecordImageRequestFailure(t *testing.T) {
 	res, err := q.RecordImageRequestFailure(context.Background(), queries.RecordImageRequestFailureParams{
 		ImageRequestID: uuid.MustParse("8071fb37-8318-4eec-a479-5b329d2fb6a9"),
 		ErrorMessage:   "something went wrong",
+		ErrorCode:      "generation.failed",
 	})
 	assert.NoError(t, err)
 	querytest.AssertNumRowsChanged(t, res, 1)
@@ -70,6 +82,7 @@ func Test_RecordImageRequestFailure(t *testing.T) {
 			AND created_at IS NOT NULL
 			AND finished_at IS NOT NULL
 			AND error_message = 'something went wrong'
+			AND error_code = 'generation.failed'
 	`)
 
 	// Attempting to record a result for an image_request that's already finished should
@@ -77,6 +90,7 @@ func Test_RecordImageRequestFailure(t *testing.T) {
 	res, err = q.RecordImageRequestFailure(context.Background(), queries.RecordImageRequestFailureParams{
 		ImageRequestID: uuid.MustParse("8071fb37-8318-4eec-a479-5b329d2fb6a9"),
 		ErrorMessage:   "a different thing went wrong, like, again",
+		ErrorCode:      "storage.failed",
 	})
 	assert.NoError(t, err)
 	querytest.AssertNumRowsChanged(t, res, 0)
@@ -86,11 +100,51 @@ func Test_RecordImageRequestFailure(t *testing.T) {
 	res, err = q.RecordImageRequestFailure(context.Background(), queries.RecordImageRequestFailureParams{
 		ImageRequestID: uuid.MustParse("02448cd2-0663-47bd-bc5a-0296bcd27fff"),
 		ErrorMessage:   "oh no",
+		ErrorCode:      "unknown",
 	})
 	assert.NoError(t, err)
 	querytest.AssertNumRowsChanged(t, res, 0)
 }
 
+func Test_RecordImageRequestModeration(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	err := q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("c6a1d0f2-37b4-4e59-8a0c-5f2e7d9b1a43"),
+		TwitchUserID:   "3007",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"something awful"}`),
+		Prompt:         "an image of something awful, dark background",
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = 'c6a1d0f2-37b4-4e59-8a0c-5f2e7d9b1a43'
+			AND moderation_source IS NULL
+			AND moderation_flagged IS NULL
+			AND moderation_scores = '{}'::jsonb
+	`)
+
+	err = q.RecordImageRequestModeration(context.Background(), queries.RecordImageRequestModerationParams{
+		ImageRequestID:    uuid.MustParse("c6a1d0f2-37b4-4e59-8a0c-5f2e7d9b1a43"),
+		ModerationSource:  "openai",
+		ModerationFlagged: true,
+		ModerationScores:  []byte(`{"violence":0.92,"hate":0.01}`),
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = 'c6a1d0f2-37b4-4e59-8a0c-5f2e7d9b1a43'
+			AND moderation_source = 'openai'
+			AND moderation_flagged
+			AND moderation_scores = '{"violence":0.92,"hate":0.01}'::jsonb
+			AND moderated_at IS NULL
+	`)
+}
+
 func Test_RecordImageRequestSuccess(t *testing.T) {
 	tx := querytest.PrepareTx(t)
 	q := queries.New(tx)
@@ -156,6 +210,7 @@ func Test_RecordImage(t *testing.T) {
 		Index:          0,
 		Url:            "http://example.com/my-cool-image.png",
 		Color:          "#fc99ee",
+		Provider:       sql.NullString{Valid: true, String: "automatic1111"},
 	})
 	assert.NoError(t, err)
 
@@ -165,5 +220,306 @@ func Test_RecordImage(t *testing.T) {
 			AND index = 0
 			AND url = 'http://example.com/my-cool-image.png'
 			AND color = '#fc99ee'
+			AND provider = 'automatic1111'
+	`)
+}
+
+func Test_GetImageRequest(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := q.GetImageRequest(context.Background(), uuid.MustParse("0c8a2a1e-8ab5-4c59-9bd8-2f2b6d1f8f3e"))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("0c8a2a1e-8ab5-4c59-9bd8-2f2b6d1f8f3e"),
+		TwitchUserID:   "5555",
+		BroadcastID:    sql.NullInt32{Valid: true, Int32: 12},
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a haunted jukebox"}`),
+		Prompt:         "an image of a haunted jukebox, dark background",
+	})
+	assert.NoError(t, err)
+
+	row, err := q.GetImageRequest(context.Background(), uuid.MustParse("0c8a2a1e-8ab5-4c59-9bd8-2f2b6d1f8f3e"))
+	assert.NoError(t, err)
+	assert.Equal(t, "5555", row.TwitchUserID)
+	assert.Equal(t, sql.NullInt32{Valid: true, Int32: 12}, row.BroadcastID)
+	assert.False(t, row.ScreeningID.Valid)
+	assert.Equal(t, "ghost", row.Style)
+	assert.Equal(t, "an image of a haunted jukebox, dark background", row.Prompt)
+	assert.False(t, row.FinishedAt.Valid)
+	assert.False(t, row.ErrorMessage.Valid)
+
+	err = q.RecordImage(context.Background(), queries.RecordImageParams{
+		ImageRequestID: uuid.MustParse("0c8a2a1e-8ab5-4c59-9bd8-2f2b6d1f8f3e"),
+		Index:          0,
+		Url:            "http://example.com/jukebox.jpg",
+		Color:          "#000000",
+	})
+	assert.NoError(t, err)
+
+	images, err := q.GetImageRequestImages(context.Background(), uuid.MustParse("0c8a2a1e-8ab5-4c59-9bd8-2f2b6d1f8f3e"))
+	assert.NoError(t, err)
+	assert.Equal(t, []queries.GetImageRequestImagesRow{
+		{Index: 0, Url: "http://example.com/jukebox.jpg", Color: "#000000"},
+	}, images)
+}
+
+func Test_GetImageRequestByIdempotencyKey(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := q.GetImageRequestByIdempotencyKey(context.Background(), "msg:abc123")
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("7d1e6f0a-3c2b-4a59-8e7d-6c5b4a392817"),
+		TwitchUserID:   "5555",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a haunted jukebox"}`),
+		Prompt:         "an image of a haunted jukebox, dark background",
+		IdempotencyKey: sql.NullString{Valid: true, String: "msg:abc123"},
+	})
+	assert.NoError(t, err)
+
+	row, err := q.GetImageRequestByIdempotencyKey(context.Background(), "msg:abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.MustParse("7d1e6f0a-3c2b-4a59-8e7d-6c5b4a392817"), row.ID)
+	assert.Equal(t, sql.NullString{Valid: true, String: "msg:abc123"}, row.IdempotencyKey)
+
+	// Any number of requests may be recorded without a key
+	for _, id := range []string{"1f9a1d36-7a0e-4d3c-9c8b-0a1b2c3d4e5f", "2e8b2c47-8b1f-4e4d-8d9c-1b2c3d4e5f60"} {
+		err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+			ImageRequestID: uuid.MustParse(id),
+			TwitchUserID:   "5555",
+			Style:          "ghost",
+			Inputs:         []byte(`{"subject":"a haunted jukebox"}`),
+			Prompt:         "an image of a haunted jukebox, dark background",
+		})
+		assert.NoError(t, err)
+	}
+
+	// But recording a second request with the same key should fail
+	err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("3d7c3d58-9c20-4f5e-9ead-2c3d4e5f6071"),
+		TwitchUserID:   "5555",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a haunted jukebox"}`),
+		Prompt:         "an image of a haunted jukebox, dark background",
+		IdempotencyKey: sql.NullString{Valid: true, String: "msg:abc123"},
+	})
+	assert.ErrorContains(t, err, "image_request_idempotency_key_unique")
+
+	// Once the key has been released from the first request, it can be reused
+	err = q.ReleaseImageRequestIdempotencyKey(context.Background(), uuid.MustParse("7d1e6f0a-3c2b-4a59-8e7d-6c5b4a392817"))
+	assert.NoError(t, err)
+	err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("3d7c3d58-9c20-4f5e-9ead-2c3d4e5f6071"),
+		TwitchUserID:   "5555",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a haunted jukebox"}`),
+		Prompt:         "an image of a haunted jukebox, dark background",
+		IdempotencyKey: sql.NullString{Valid: true, String: "msg:abc123"},
+	})
+	assert.NoError(t, err)
+	row, err = q.GetImageRequestByIdempotencyKey(context.Background(), "msg:abc123")
+	assert.NoError(t, err)
+	assert.Equal(t, uuid.MustParse("3d7c3d58-9c20-4f5e-9ead-2c3d4e5f6071"), row.ID)
+}
+
+func Test_ListImageRequests(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	for _, params := range []queries.RecordImageRequestParams{
+		{
+			ImageRequestID: uuid.MustParse("2b7d1c3e-0f5e-4a67-8f10-3d4c2b1a0e91"),
+			TwitchUserID:   "1001",
+			Style:          "ghost",
+			Inputs:         []byte(`{"subject":"a lamp"}`),
+			Prompt:         "a lamp",
+		},
+		{
+			ImageRequestID: uuid.MustParse("7a9f3d2c-1b4e-4c8d-9e6f-5a2b3c4d5e6f"),
+			TwitchUserID:   "1001",
+			Style:          "friend",
+			Inputs:         []byte(`{"color":"red","subject":"a crab"}`),
+			Prompt:         "a red crab",
+		},
+		{
+			ImageRequestID: uuid.MustParse("c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"),
+			TwitchUserID:   "2002",
+			Style:          "ghost",
+			Inputs:         []byte(`{"subject":"a chair"}`),
+			Prompt:         "a chair",
+		},
+	} {
+		err := q.RecordImageRequest(context.Background(), params)
+		assert.NoError(t, err)
+	}
+	_, err := q.RecordImageRequestSuccess(context.Background(), uuid.MustParse("2b7d1c3e-0f5e-4a67-8f10-3d4c2b1a0e91"))
+	assert.NoError(t, err)
+
+	rows, err := q.ListImageRequests(context.Background(), queries.ListImageRequestsParams{
+		NumRecords: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 3)
+
+	rows, err = q.ListImageRequests(context.Background(), queries.ListImageRequestsParams{
+		TwitchUserID: sql.NullString{Valid: true, String: "1001"},
+		NumRecords:   10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 2)
+
+	rows, err = q.ListImageRequests(context.Background(), queries.ListImageRequestsParams{
+		Style:      sql.NullString{Valid: true, String: "ghost"},
+		Status:     sql.NullString{Valid: true, String: "pending"},
+		NumRecords: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, uuid.MustParse("c3d4e5f6-a7b8-4c9d-8e0f-1a2b3c4d5e6f"), rows[0].ID)
+
+	rows, err = q.ListImageRequests(context.Background(), queries.ListImageRequestsParams{
+		Status:     sql.NullString{Valid: true, String: "succeeded"},
+		NumRecords: 10,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, uuid.MustParse("2b7d1c3e-0f5e-4a67-8f10-3d4c2b1a0e91"), rows[0].ID)
+}
+
+func Test_SetImageRequestStage(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	err := q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID:    uuid.MustParse("a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a"),
+		TwitchUserID:      "6666",
+		Style:             "ghost",
+		Inputs:            []byte(`{"subject":"a lonely lighthouse"}`),
+		Prompt:            "an image of a lonely lighthouse, dark background",
+		TwitchDisplayName: sql.NullString{Valid: true, String: "Keeper"},
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = 'a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a'
+			AND twitch_display_name = 'Keeper'
+			AND ledger_flow_id IS NULL
+			AND stage = 'received'
+			AND debited_at IS NULL
+	`)
+
+	err = q.RecordImageRequestDebit(context.Background(), queries.RecordImageRequestDebitParams{
+		LedgerFlowID:   uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"),
+		ImageRequestID: uuid.MustParse("a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a"),
+	})
+	assert.NoError(t, err)
+	err = q.SetImageRequestStage(context.Background(), queries.SetImageRequestStageParams{
+		Stage:          "debited",
+		ImageRequestID: uuid.MustParse("a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a"),
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = 'a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a'
+			AND ledger_flow_id = '4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1'
+			AND stage = 'debited'
+			AND debited_at IS NOT NULL
+			AND stored_at IS NULL
 	`)
+
+	err = q.SetImageRequestStage(context.Background(), queries.SetImageRequestStageParams{
+		Stage:          "stored",
+		ImageRequestID: uuid.MustParse("a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a"),
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = 'a0f7b0f4-5f54-4a3f-9a2b-3b2c1d0e9f8a'
+			AND stage = 'stored'
+			AND stored_at IS NOT NULL
+			AND announced_at IS NULL
+	`)
+}
+
+func Test_SelectImage(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := q.GetImageRequestSelection(context.Background(), uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"))
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+
+	err = q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"),
+		TwitchUserID:   "8888",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"a creaky rocking chair"}`),
+		Prompt:         "an image of a creaky rocking chair, dark background",
+	})
+	assert.NoError(t, err)
+
+	res, err := q.SelectImage(context.Background(), queries.SelectImageParams{
+		SelectedIndex:  2,
+		SelectedBy:     sql.NullString{Valid: true, String: "90790024"},
+		ImageRequestID: uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"),
+	})
+	assert.NoError(t, err)
+	querytest.AssertNumRowsChanged(t, res, 1)
+
+	// Once an image has been selected, the selection is final
+	res, err = q.SelectImage(context.Background(), queries.SelectImageParams{
+		SelectedIndex:  0,
+		ImageRequestID: uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"),
+	})
+	assert.NoError(t, err)
+	querytest.AssertNumRowsChanged(t, res, 0)
+
+	selectedIndex, err := q.GetImageRequestSelection(context.Background(), uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"))
+	assert.NoError(t, err)
+	assert.Equal(t, sql.NullInt32{Valid: true, Int32: 2}, selectedIndex)
+
+	row, err := q.GetImageRequest(context.Background(), uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"))
+	assert.NoError(t, err)
+	assert.Equal(t, sql.NullInt32{Valid: true, Int32: 2}, row.SelectedIndex)
+	assert.Equal(t, sql.NullString{Valid: true, String: "90790024"}, row.SelectedBy)
+	assert.False(t, row.SelectedAt.Valid)
+
+	err = q.SetImageRequestStage(context.Background(), queries.SetImageRequestStageParams{
+		Stage:          "selected",
+		ImageRequestID: uuid.MustParse("5c4b3a29-1807-4f6e-9d5c-4b3a29180716"),
+	})
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = '5c4b3a29-1807-4f6e-9d5c-4b3a29180716'
+			AND stage = 'selected'
+			AND selected_at IS NOT NULL
+	`)
+}
+
+func Test_ListStaleImageRequests(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	_, err := tx.Exec(`
+		INSERT INTO dynamo.image_request (id, twitch_user_id, style, inputs, prompt, created_at, finished_at) VALUES
+			('e1d2c3b4-a596-4788-99aa-bbccddeeff00', '1001', 'ghost', '{"subject":"an old ghost"}', 'an old ghost', now() - interval '1 hour', NULL),
+			('f2e3d4c5-b6a7-4899-aabb-ccddeeff0011', '1001', 'ghost', '{"subject":"a finished ghost"}', 'a finished ghost', now() - interval '1 hour', now() - interval '59 minutes'),
+			('03f4e5d6-c7b8-49aa-bbcc-ddeeff001122', '1001', 'ghost', '{"subject":"a new ghost"}', 'a new ghost', now(), NULL)
+	`)
+	assert.NoError(t, err)
+
+	rows, err := q.ListStaleImageRequests(context.Background(), 600)
+	assert.NoError(t, err)
+	assert.Len(t, rows, 1)
+	assert.Equal(t, uuid.MustParse("e1d2c3b4-a596-4788-99aa-bbccddeeff00"), rows[0].ID)
+	assert.Equal(t, "debited", rows[0].Stage)
 }
@@ -0,0 +1,83 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IntermediateImages(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	err := q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		TwitchUserID:   "7777",
+		Style:          "friend",
+		Inputs:         []byte(`{"color":"green","subject":"a frog"}`),
+		Prompt:         "a green frog",
+	})
+	assert.NoError(t, err)
+
+	images, err := q.ListIntermediateImages(context.Background(), queries.ListIntermediateImagesParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		Stage:          "generated",
+	})
+	assert.NoError(t, err)
+	assert.Empty(t, images)
+
+	// Saving an image for the same stage and index twice should replace the original
+	for _, data := range []string{"first", "second"} {
+		err = q.SaveIntermediateImage(context.Background(), queries.SaveIntermediateImageParams{
+			ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+			Stage:          "generated",
+			ContentType:    "image/png",
+			Data:           []byte(data),
+		})
+		assert.NoError(t, err)
+	}
+	err = q.SaveIntermediateImage(context.Background(), queries.SaveIntermediateImageParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		Stage:          "generated",
+		Index:          1,
+		ContentType:    "image/png",
+		Data:           []byte("another"),
+		Provider:       sql.NullString{Valid: true, String: "comfyui"},
+	})
+	assert.NoError(t, err)
+	err = q.SaveIntermediateImage(context.Background(), queries.SaveIntermediateImageParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		Stage:          "filtered",
+		ContentType:    "image/webp",
+		Data:           []byte("filtered"),
+		Color:          sql.NullString{Valid: true, String: "#ff00ff"},
+	})
+	assert.NoError(t, err)
+
+	images, err = q.ListIntermediateImages(context.Background(), queries.ListIntermediateImagesParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		Stage:          "generated",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []queries.ListIntermediateImagesRow{
+		{Index: 0, ContentType: "image/png", Data: []byte("second")},
+		{Index: 1, ContentType: "image/png", Data: []byte("another"), Provider: sql.NullString{Valid: true, String: "comfyui"}},
+	}, images)
+
+	images, err = q.ListIntermediateImages(context.Background(), queries.ListIntermediateImagesParams{
+		ImageRequestID: uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"),
+		Stage:          "filtered",
+	})
+	assert.NoError(t, err)
+	assert.Len(t, images, 1)
+	assert.Equal(t, sql.NullString{Valid: true, String: "#ff00ff"}, images[0].Color)
+
+	err = q.DeleteIntermediateImages(context.Background(), uuid.MustParse("7c1e0b2a-4d3f-4a5b-8c6d-9e0f1a2b3c4d"))
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM dynamo.intermediate_image")
+}
@@ -20,6 +20,28 @@ type DynamoAnswer struct {
 	Prompt string
 	// String value obtained by prompting a language model.
 	Value string
+	// ID of the prompt_template that the prompt was rendered from, or NULL if the style's built-in prompt was used.
+	PromptTemplateID sql.NullInt32
+	// Version of the prompt_template that the prompt was rendered from, or NULL if the style's built-in prompt was used.
+	PromptTemplateVersion sql.NullInt32
+}
+
+// Record of a single call made to an external generation API in the course of fulfilling an image request. Failed calls that were classified as transient are retried with backoff, so a single generation step may result in several attempts.
+type DynamoAttempt struct {
+	// ID of the image_request record associated with this attempt.
+	ImageRequestID uuid.UUID
+	// Type of generation that was attempted: "moderation", "text", or "image".
+	Kind string
+	// Sequential, one-indexed position of this attempt among all attempts made for the same generation step.
+	Number int32
+	// Timestamp indicating when we initiated the call to the generation API.
+	StartedAt time.Time
+	// Timestamp indicating when the call to the generation API completed, whether successfully or not.
+	FinishedAt time.Time
+	// Error message describing why the attempt failed. If NULL, the attempt succeeded.
+	ErrorMessage sql.NullString
+	// Whether the failure was classified as transient, such that another attempt was scheduled after this one.
+	WillRetry bool
 }
 
 // Record of an image that was successfully generated from a user-submitted image request. An image request may result in multiple images. Images are ordered by index, matching the array in which they were returned by the image generation API.
@@ -32,6 +54,8 @@ type DynamoImage struct {
 	Url string
 	// Hash-prefixed hex RGB value, e.g. "#fcee99", indicating the dominant color in the image.
 	Color string
+	// Name of the provider that generated this image, e.g. "openai" or "automatic1111": this may differ from the image provider requested for the image request if that provider failed and we failed over to another. NULL if the image was recorded before this column was introduced.
+	Provider sql.NullString
 }
 
 // Records the fact that a user requested that images be generated, with their chosen prompt, to be overlaid on the video during the stream.
@@ -56,4 +80,122 @@ type DynamoImageRequest struct {
 	FinishedAt sql.NullTime
 	// Error message describing why the request completed unsuccessfully. If NULL and finished_at is not NULL, the request completed successfully.
 	ErrorMessage sql.NullString
+	// Display name of the Twitch user that initiated this request, as of the time the request was submitted. May be NULL for requests recorded before this column was introduced.
+	TwitchDisplayName sql.NullString
+	// ID of the pending ledger transaction (an alert-redemption outflow) that debited points from the user in exchange for this request. The transaction is accepted once the resulting alert has been announced, or rejected (refunding the points) if the request fails. NULL if points have not been debited yet, or if the request was recorded before this column was introduced.
+	LedgerFlowID uuid.NullUUID
+	// The last processing stage that was completed for this request, in order: "received" (the request was recorded, but points may not have been debited yet), "debited" (points were debited via the ledger transaction in ledger_flow_id), "moderated" (the viewer's inputs passed moderation), "named" (any required text was generated), "generated" (one or more candidate images were generated), "filtered" (each candidate was converted and post-processed), "stored" (the final candidates were stored), "selected" (one candidate was chosen for display), "announced" (an onscreen event was produced to display the alert), or "accepted" (the ledger transaction was accepted). Unfinished requests are resumed from this stage.
+	Stage string
+	// Timestamp indicating when the request reached the "debited" stage.
+	DebitedAt sql.NullTime
+	// Timestamp indicating when the request reached the "named" stage.
+	NamedAt sql.NullTime
+	// Timestamp indicating when the request reached the "generated" stage.
+	GeneratedAt sql.NullTime
+	// Timestamp indicating when the request reached the "filtered" stage.
+	FilteredAt sql.NullTime
+	// Timestamp indicating when the request reached the "stored" stage.
+	StoredAt sql.NullTime
+	// Timestamp indicating when the request reached the "announced" stage.
+	AnnouncedAt sql.NullTime
+	// Timestamp indicating when the request reached the "accepted" stage.
+	AcceptedAt sql.NullTime
+	// ID of the prompt_template that the prompt was rendered from, or NULL if the style's built-in prompt was used.
+	PromptTemplateID sql.NullInt32
+	// Version of the prompt_template that the prompt was rendered from, or NULL if the style's built-in prompt was used.
+	PromptTemplateVersion sql.NullInt32
+	// Timestamp indicating when a moderator removed this request's images, so that they may no longer be displayed. If NULL, the request has not been removed.
+	RemovedAt sql.NullTime
+	// Moderator-supplied explanation of why this request's images were removed; NULL if the request has not been removed.
+	RemovalReason sql.NullString
+	// Timestamp indicating when the request reached the "moderated" stage.
+	ModeratedAt sql.NullTime
+	// Identifies what screened the viewer's inputs before generation: "openai" for the OpenAI moderations API, "rules" for our local blocklist and regex rules, or "local" for the stand-in used in development. If NULL, the request was not moderated.
+	ModerationSource sql.NullString
+	// Whether the viewer's inputs were flagged during moderation, in which case the request was rejected before any generation occurred.
+	ModerationFlagged sql.NullBool
+	// JSON object mapping each moderation category (e.g. "violence") to a score between 0 and 1 indicating how strongly the viewer's inputs were judged to fall into that category. Empty if the request was not moderated.
+	ModerationScores json.RawMessage
+	// Stable code identifying the category of error that caused the request to fail, e.g. "generation.rejected", "generation.flagged", "generation.failed", "filters.failed", "storage.failed", or "processing.database"; "unknown" if the error was not categorized. NULL if the request has not failed, or if it failed before this column was introduced.
+	ErrorCode sql.NullString
+	// Key identifying the generation-requests message that this request was created from, so that a redelivered message is not processed (and charged for) a second time: "id:<uuid>" if the producer preassigned an ID to the request, or "msg:<sha256>" with the hex-encoded SHA-256 hash of the AMQP message ID. NULL if the message carried neither, or if the request was recorded before this column was introduced.
+	IdempotencyKey sql.NullString
+	// Timestamp indicating when the request reached the "selected" stage.
+	SelectedAt sql.NullTime
+	// Index of the image that was selected for display in the onscreen alert, out of all candidate images recorded for this request. NULL if no image has been selected yet.
+	SelectedIndex sql.NullInt32
+	// Twitch user ID of the broadcaster who selected the displayed image; NULL if the image was selected automatically, or if no image has been selected yet.
+	SelectedBy sql.NullString
+	// Image generation model that was used to generate the images for this request, e.g. "dall-e-3". NULL if the request was recorded before this column was introduced.
+	ImageModel sql.NullString
+	// Size of each generated image, in the form "<width>x<height>", e.g. "1024x1024". NULL if the generation API's default size was used, or if the request was recorded before this column was introduced.
+	ImageSize sql.NullString
+	// Rendering quality that images were generated with, if supported by the model: e.g. "standard" or "hd". NULL if the model's default quality was used, or if the request was recorded before this column was introduced.
+	ImageQuality sql.NullString
+	// Rendering style that images were generated with, if supported by the model: e.g. "vivid" or "natural". Not to be confused with the style of the request itself. NULL if the model's default style was used, or if the request was recorded before this column was introduced.
+	ImageStyle sql.NullString
+	// Chat completion model that was used to generate any text for this request, e.g. "gpt-3.5-turbo-0125". NULL if the request's style does not require any text, or if the request was recorded before this column was introduced.
+	TextModel sql.NullString
+	// Name of the image provider that images were requested from, e.g. "automatic1111". NULL if images were generated by the consumer's configured generation backend, or if the request was recorded before this column was introduced.
+	ImageProvider sql.NullString
+}
+
+// Temporary copy of an image produced by an intermediate processing stage, kept so that an interrupted image request can be resumed without generating its image again. Intermediate images are deleted once the final image has been stored.
+type DynamoIntermediateImage struct {
+	// ID of the image_request record associated with this image.
+	ImageRequestID uuid.UUID
+	// The processing stage that produced this image: either "generated" or "filtered".
+	Stage string
+	// MIME type of the image data, e.g. "image/png".
+	ContentType string
+	// Raw image data.
+	Data []byte
+	// Hash-prefixed hex RGB value indicating the background color that was detected while filtering the image; NULL if the image has not been filtered.
+	Color sql.NullString
+	// Timestamp indicating when the image was recorded.
+	CreatedAt time.Time
+	// Index of the candidate image that this intermediate image belongs to, for requests that generate several candidates from which a single image is selected.
+	Index int32
+	// Name of the provider that generated this image, carried over to the final image once stored.
+	Provider sql.NullString
+}
+
+// A version of the template used to build prompts for image requests of a particular style. Templates are rendered with Go's text/template package, using the inputs from the generation request (genreq.ImageInputs) as data. At most one version of each template may be active at a time; if no version is active, the style's built-in prompt is used.
+type DynamoPromptTemplate struct {
+	// Unique identifier for this version of the template.
+	ID int32
+	// The image style that this template applies to, from the generation-requests schema.
+	Style string
+	// The kind of prompt built from this template: "image" for the prompt submitted for image generation, or "text" for the prompt submitted to generate text that accompanies the image.
+	Kind string
+	// Sequential, one-indexed version number of this template, for the same style and kind.
+	Version int32
+	// Template source, e.g. "a ghostly image of {{ .Ghost.Subject }}".
+	Body string
+	// Whether this version of the template should be used for new requests.
+	IsActive bool
+	// Timestamp indicating when this version of the template was created.
+	CreatedAt time.Time
+}
+
+// Audit record of a moderator removing an image request, taking down the images that were generated from it.
+type DynamoTakedown struct {
+	// Unique identifier for this takedown.
+	ID int32
+	// ID of the image_request that was removed.
+	ImageRequestID uuid.UUID
+	// ID of the Twitch user who removed the image request.
+	ModeratorTwitchUserID string
+	// Moderator-supplied explanation of why the image request was removed.
+	Reason string
+	// What was done to the stored objects for the image request: "delete" if they were deleted from storage, or "privatize" if they were retained but made private.
+	ObjectAction string
+	// Number of stored objects that were deleted or made private.
+	NumObjects int32
+	// Whether the moderator asked for the viewer's points to be refunded.
+	RefundRequested bool
+	// ID of the ledger transaction that refunded the viewer's points, if any. NULL if no refund was requested, if the viewer was never charged, or if the refund failed.
+	RefundFlowID uuid.NullUUID
+	// Timestamp indicating when the takedown was recorded.
+	CreatedAt time.Time
 }
@@ -0,0 +1,49 @@
+package queries_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ListActivePromptTemplates(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	// Clear out the templates seeded by our migrations so we can start fresh
+	_, err := tx.Exec("update dynamo.prompt_template set is_active = false")
+	assert.NoError(t, err)
+
+	templates, err := q.ListActivePromptTemplates(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, templates, 0)
+
+	_, err = tx.Exec(`
+		insert into dynamo.prompt_template (style, kind, version, body, is_active) values
+			('ghost', 'image', 100, 'old ghost', false),
+			('ghost', 'image', 101, 'new ghost', true),
+			('friend', 'text', 100, 'name for {{ .Friend.Subject }}', true)
+	`)
+	assert.NoError(t, err)
+
+	templates, err = q.ListActivePromptTemplates(context.Background())
+	assert.NoError(t, err)
+	assert.Len(t, templates, 2)
+	assert.Equal(t, "friend", templates[0].Style)
+	assert.Equal(t, "text", templates[0].Kind)
+	assert.Equal(t, int32(100), templates[0].Version)
+	assert.Equal(t, "ghost", templates[1].Style)
+	assert.Equal(t, "image", templates[1].Kind)
+	assert.Equal(t, int32(101), templates[1].Version)
+	assert.Equal(t, "new ghost", templates[1].Body)
+
+	// Only one version of each template may be active at a time
+	_, err = tx.Exec(`
+		update dynamo.prompt_template set is_active = true
+		where style = 'ghost' and kind = 'image' and version = 100
+	`)
+	assert.Error(t, err)
+}
@@ -0,0 +1,75 @@
+package queries_test
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/server-common/querytest"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RecordTakedown(t *testing.T) {
+	tx := querytest.PrepareTx(t)
+	q := queries.New(tx)
+
+	err := q.RecordImageRequest(context.Background(), queries.RecordImageRequestParams{
+		ImageRequestID: uuid.MustParse("0b8e4f6a-3c2d-4e1f-9a8b-7c6d5e4f3a2b"),
+		TwitchUserID:   "3003",
+		Style:          "ghost",
+		Inputs:         []byte(`{"subject":"something objectionable"}`),
+		Prompt:         "a ghostly image of something objectionable",
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 0, "SELECT COUNT(*) FROM dynamo.takedown")
+
+	takedownId, err := q.RecordTakedown(context.Background(), queries.RecordTakedownParams{
+		Reason:                "not suitable for stream",
+		ImageRequestID:        uuid.MustParse("0b8e4f6a-3c2d-4e1f-9a8b-7c6d5e4f3a2b"),
+		ModeratorTwitchUserID: "1001",
+		ObjectAction:          "delete",
+		NumObjects:            1,
+		RefundRequested:       true,
+	})
+	assert.NoError(t, err)
+
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.image_request
+			WHERE id = '0b8e4f6a-3c2d-4e1f-9a8b-7c6d5e4f3a2b'
+			AND removed_at IS NOT NULL
+			AND removal_reason = 'not suitable for stream'
+	`)
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.takedown
+			WHERE image_request_id = '0b8e4f6a-3c2d-4e1f-9a8b-7c6d5e4f3a2b'
+			AND moderator_twitch_user_id = '1001'
+			AND reason = 'not suitable for stream'
+			AND object_action = 'delete'
+			AND num_objects = 1
+			AND refund_requested
+			AND refund_flow_id IS NULL
+	`)
+
+	err = q.SetTakedownRefundFlowId(context.Background(), queries.SetTakedownRefundFlowIdParams{
+		RefundFlowID: uuid.NullUUID{Valid: true, UUID: uuid.MustParse("a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d")},
+		TakedownID:   takedownId,
+	})
+	assert.NoError(t, err)
+	querytest.AssertCount(t, tx, 1, `
+		SELECT COUNT(*) FROM dynamo.takedown
+			WHERE refund_flow_id = 'a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d'
+	`)
+
+	// A request that's already been removed can't be removed again
+	_, err = q.RecordTakedown(context.Background(), queries.RecordTakedownParams{
+		Reason:                "still not suitable",
+		ImageRequestID:        uuid.MustParse("0b8e4f6a-3c2d-4e1f-9a8b-7c6d5e4f3a2b"),
+		ModeratorTwitchUserID: "1001",
+		ObjectAction:          "delete",
+	})
+	assert.ErrorIs(t, err, sql.ErrNoRows)
+	querytest.AssertCount(t, tx, 1, "SELECT COUNT(*) FROM dynamo.takedown")
+}
@@ -0,0 +1,6 @@
+// Package errcode associates errors with stable codes identifying their category (e.g.
+// "generation.rejected" or "storage.failed"), so that failures can be recorded,
+// counted, and handled according to what went wrong rather than by matching against
+// free-form error messages. Each package that produces errors defines the codes for
+// its own categories.
+package errcode
@@ -0,0 +1,67 @@
+package errcode
+
+import "errors"
+
+// Code is a stable, machine-readable identifier for a category of error, namespaced by
+// the package that defines it: codes are persisted, so they must never be changed once
+// introduced
+type Code string
+
+// Unknown is the code of any error that hasn't been assigned a more specific category
+const Unknown Code = "unknown"
+
+// coded is implemented by any error that identifies its own category
+type coded interface {
+	ErrorCode() Code
+}
+
+// Error associates an underlying error with a Code
+type Error struct {
+	code Code
+	err  error
+}
+
+// Error returns the message of the underlying error, unchanged
+func (e *Error) Error() string {
+	return e.err.Error()
+}
+
+// Unwrap returns the underlying error
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// ErrorCode returns the code that was assigned to the underlying error
+func (e *Error) ErrorCode() Code {
+	return e.code
+}
+
+// New returns a new error with the given message, assigned the given code: this is
+// useful for declaring sentinel errors that belong to a category
+func New(code Code, message string) error {
+	return &Error{code: code, err: errors.New(message)}
+}
+
+// Wrap assigns the given code to err, if it hasn't already been assigned one: the
+// category identified by the code that's closest to the source of the error wins, so
+// that callers can assign a broad category to any errors that an underlying package
+// hasn't already categorized more specifically
+func Wrap(code Code, err error) error {
+	if err == nil {
+		return nil
+	}
+	if Of(err) != Unknown {
+		return err
+	}
+	return &Error{code: code, err: err}
+}
+
+// Of returns the code that identifies the category of err, or Unknown if it hasn't
+// been categorized
+func Of(err error) Code {
+	var c coded
+	if errors.As(err, &c) {
+		return c.ErrorCode()
+	}
+	return Unknown
+}
@@ -0,0 +1,62 @@
+package errcode
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Of(t *testing.T) {
+	errSentinel := New("test.sentinel", "sentinel error")
+	tests := []struct {
+		name string
+		err  error
+		want Code
+	}{
+		{
+			"nil error is unknown",
+			nil,
+			Unknown,
+		},
+		{
+			"uncategorized error is unknown",
+			fmt.Errorf("something went wrong"),
+			Unknown,
+		},
+		{
+			"sentinel error has its own code",
+			errSentinel,
+			"test.sentinel",
+		},
+		{
+			"code is found through wrapped errors",
+			fmt.Errorf("failed to do a thing: %w", errSentinel),
+			"test.sentinel",
+		},
+		{
+			"wrapped error is assigned a code",
+			Wrap("test.broad", fmt.Errorf("something went wrong")),
+			"test.broad",
+		},
+		{
+			"wrapping does not override a more specific code",
+			Wrap("test.broad", fmt.Errorf("failed to do a thing: %w", errSentinel)),
+			"test.sentinel",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, Of(tt.err))
+		})
+	}
+}
+
+func Test_Wrap(t *testing.T) {
+	assert.NoError(t, Wrap("test.broad", nil))
+
+	inner := fmt.Errorf("inner error")
+	err := Wrap("test.broad", inner)
+	assert.EqualError(t, err, "inner error")
+	assert.ErrorIs(t, err, inner)
+}
@@ -3,14 +3,23 @@ package filters
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os/exec"
 	"regexp"
+	"time"
 
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/golden-vcr/dynamo/internal/tracing"
 	"golang.org/x/exp/slog"
 )
 
+// CodeFailed identifies errors indicating that an image could not be post-processed,
+// e.g. because the imf binary crashed or produced unexpected output
+const CodeFailed errcode.Code = "filters.failed"
+
 type Runner interface {
 	RemoveBackground(ctx context.Context, infile string, outfile string) (string, error)
 }
@@ -30,13 +39,17 @@ type cliRunner struct {
 }
 
 func (r *cliRunner) RemoveBackground(ctx context.Context, infile string, outfile string) (string, error) {
+	ctx, span := tracing.Start(ctx, "imf.remove_background")
 	var stdout bytes.Buffer
 	var stderr bytes.Buffer
 	c := exec.CommandContext(ctx, r.imfBinaryPath, "remove-background", "-i", infile, "-o", outfile)
 	r.logger.Info("Running external command", "path", c.Path, "args", c.Args)
 	c.Stdout = &stdout
 	c.Stderr = &stderr
+	startedAt := time.Now()
 	err := c.Run()
+	metrics.ObserveDuration(metrics.OperationImf, startedAt)
+	tracing.End(span, err)
 
 	stdoutStr := ""
 	if stdoutBytes, err := io.ReadAll(&stdout); err == nil {
@@ -49,16 +62,31 @@ func (r *cliRunner) RemoveBackground(ctx context.Context, infile string, outfile
 
 	if err != nil {
 		r.logger.Error("remove-background command failed", "error", err, "stdout", stdoutStr, "stderr", stderrStr)
-		return "", err
+		return "", errcode.Wrap(CodeFailed, fmt.Errorf("remove-background command failed: %w", err))
 	}
 	color, err := parseColor(stdoutStr)
 	if err != nil {
 		r.logger.Error("Failed to parse command output", "error", err, "stdout", stdoutStr, "stderr", stderrStr)
-		return "", err
+		return "", errcode.Wrap(CodeFailed, fmt.Errorf("failed to parse remove-background output: %w", err))
 	}
 	return color, nil
 }
 
+// Probe verifies that the imf binary at the given path exists and can be executed. A
+// non-zero exit status is tolerated, since we only care that the binary runs.
+func Probe(ctx context.Context, imfBinaryPath string) error {
+	c := exec.CommandContext(ctx, imfBinaryPath, "--help")
+	err := c.Run()
+	var exitErr *exec.ExitError
+	if err != nil && !errors.As(err, &exitErr) {
+		return fmt.Errorf("failed to run imf: %w", err)
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("failed to run imf: %w", ctx.Err())
+	}
+	return nil
+}
+
 func parseColor(s string) (string, error) {
 	m := regexHexColor.FindStringSubmatch(s)
 	if m == nil {
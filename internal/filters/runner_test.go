@@ -1,6 +1,10 @@
 package filters
 
 import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +19,26 @@ func Test_parseColor(t *testing.T) {
 	assert.Error(t, err)
 	assert.Equal(t, "", got)
 }
+
+func Test_Probe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("requires a POSIX shell")
+	}
+	dir := t.TempDir()
+
+	// A binary that runs is fine, even if it exits with a non-zero status
+	failingPath := filepath.Join(dir, "imf-failing")
+	err := os.WriteFile(failingPath, []byte("#!/bin/sh\nexit 2\n"), 0755)
+	assert.NoError(t, err)
+	err = Probe(context.Background(), failingPath)
+	assert.NoError(t, err)
+
+	// A binary that can't be run is not
+	err = Probe(context.Background(), filepath.Join(dir, "imf-missing"))
+	assert.Error(t, err)
+	notExecutablePath := filepath.Join(dir, "imf-not-executable")
+	err = os.WriteFile(notExecutablePath, []byte("not a binary"), 0644)
+	assert.NoError(t, err)
+	err = Probe(context.Background(), notExecutablePath)
+	assert.Error(t, err)
+}
@@ -0,0 +1,132 @@
+package generation
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// NewAutomatic1111Client returns an ImageGenerator that generates images via the
+// txt2img API exposed by a Stable Diffusion web UI (AUTOMATIC1111) running at the given
+// URL, which must have been started with the --api flag
+func NewAutomatic1111Client(url string) ImageGenerator {
+	return &automatic1111Client{
+		url:        strings.TrimSuffix(url, "/"),
+		httpClient: &http.Client{},
+	}
+}
+
+type automatic1111Client struct {
+	url        string
+	httpClient *http.Client
+}
+
+// automatic1111Txt2ImgRequest is the body of a POST /sdapi/v1/txt2img request: fields
+// that are omitted fall back to the server's defaults
+type automatic1111Txt2ImgRequest struct {
+	Prompt           string                         `json:"prompt"`
+	NegativePrompt   string                         `json:"negative_prompt,omitempty"`
+	Seed             int64                          `json:"seed"`
+	Steps            int                            `json:"steps,omitempty"`
+	CfgScale         float64                        `json:"cfg_scale,omitempty"`
+	SamplerName      string                         `json:"sampler_name,omitempty"`
+	Width            int                            `json:"width,omitempty"`
+	Height           int                            `json:"height,omitempty"`
+	BatchSize        int                            `json:"batch_size"`
+	NumIterations    int                            `json:"n_iter"`
+	OverrideSettings *automatic1111OverrideSettings `json:"override_settings,omitempty"`
+}
+
+// automatic1111OverrideSettings overrides the server's settings for a single request
+type automatic1111OverrideSettings struct {
+	Checkpoint string `json:"sd_model_checkpoint"`
+}
+
+// automatic1111Txt2ImgResponse is the body of a successful txt2img response, carrying
+// each generated image as base64-encoded PNG data
+type automatic1111Txt2ImgResponse struct {
+	Images []string `json:"images"`
+}
+
+func (c *automatic1111Client) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (_ *Image, err error) {
+	ctx, span := tracing.Start(ctx, "automatic1111.txt2img",
+		attribute.String(tracing.AttributeModel, params.Model),
+		attribute.String(tracing.AttributeImageSize, params.Size),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Build a txt2img request from our prompt and params, using a random seed (-1)
+	// unless one has been fixed
+	body := automatic1111Txt2ImgRequest{
+		Prompt:         prompt,
+		NegativePrompt: params.NegativePrompt,
+		Seed:           -1,
+		Steps:          params.Steps,
+		CfgScale:       params.CfgScale,
+		SamplerName:    params.Sampler,
+		BatchSize:      1,
+		NumIterations:  1,
+	}
+	if params.Seed != 0 {
+		body.Seed = params.Seed
+	}
+	if params.Size != "" {
+		body.Width, body.Height, err = parseSize(params.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if params.Model != "" {
+		body.OverrideSettings = &automatic1111OverrideSettings{Checkpoint: params.Model}
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+
+	// Send the request: it will block until the image is ready
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/sdapi/v1/txt2img", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("content-type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{
+			StatusCode: res.StatusCode,
+			Message:    "request to Automatic1111 txt2img API failed",
+		}
+	}
+	var result automatic1111Txt2ImgResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Automatic1111 txt2img response: %w", err)
+	}
+
+	// If we didn't get exactly one image, abort
+	if len(result.Images) != 1 {
+		return nil, fmt.Errorf("expected 1 result image from Automatic1111; got %d", len(result.Images))
+	}
+	pngData, err := base64.StdEncoding.DecodeString(result.Images[0])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode base64 image data from Automatic1111: %w", err)
+	}
+	contentType := http.DetectContentType(pngData)
+	if contentType != "image/png" {
+		return nil, fmt.Errorf("got unexpected content-type '%s' for Automatic1111 image", contentType)
+	}
+	return &Image{
+		ContentType: contentType,
+		Data:        pngData,
+	}, nil
+}
@@ -0,0 +1,115 @@
+package generation
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakePngData is recognized as PNG data by http.DetectContentType
+var fakePngData = []byte("\x89PNG\r\n\x1a\nfake image data")
+
+func Test_automatic1111Client_GenerateImage(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   ImageParams
+		status   int
+		body     string
+		wantBody string
+		wantErr  string
+	}{
+		{
+			"params are passed through to txt2img",
+			ImageParams{
+				Provider:       ProviderAutomatic1111,
+				Model:          "sd_xl_base_1.0.safetensors",
+				Size:           "1024x768",
+				NegativePrompt: "blurry, text",
+				Seed:           1234,
+				Steps:          30,
+				CfgScale:       6.5,
+				Sampler:        "DPM++ 2M Karras",
+			},
+			http.StatusOK,
+			`{"images":["` + base64.StdEncoding.EncodeToString(fakePngData) + `"],"info":"{}"}`,
+			`{"prompt":"a ghostly image of a seal","negative_prompt":"blurry, text","seed":1234,"steps":30,"cfg_scale":6.5,"sampler_name":"DPM++ 2M Karras","width":1024,"height":768,"batch_size":1,"n_iter":1,"override_settings":{"sd_model_checkpoint":"sd_xl_base_1.0.safetensors"}}`,
+			"",
+		},
+		{
+			"unset params are left up to the server, with a random seed",
+			ImageParams{Provider: ProviderAutomatic1111},
+			http.StatusOK,
+			`{"images":["` + base64.StdEncoding.EncodeToString(fakePngData) + `"]}`,
+			`{"prompt":"a ghostly image of a seal","seed":-1,"batch_size":1,"n_iter":1}`,
+			"",
+		},
+		{
+			"server error is a StatusError",
+			ImageParams{Provider: ProviderAutomatic1111},
+			http.StatusInternalServerError,
+			`{"error":"OutOfMemoryError"}`,
+			`{"prompt":"a ghostly image of a seal","seed":-1,"batch_size":1,"n_iter":1}`,
+			"request to Automatic1111 txt2img API failed: got status 500",
+		},
+		{
+			"missing image is an error",
+			ImageParams{Provider: ProviderAutomatic1111},
+			http.StatusOK,
+			`{"images":[]}`,
+			`{"prompt":"a ghostly image of a seal","seed":-1,"batch_size":1,"n_iter":1}`,
+			"expected 1 result image from Automatic1111; got 0",
+		},
+		{
+			"non-PNG image is an error",
+			ImageParams{Provider: ProviderAutomatic1111},
+			http.StatusOK,
+			`{"images":["` + base64.StdEncoding.EncodeToString([]byte("not an image")) + `"]}`,
+			`{"prompt":"a ghostly image of a seal","seed":-1,"batch_size":1,"n_iter":1}`,
+			"got unexpected content-type 'text/plain; charset=utf-8' for Automatic1111 image",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, http.MethodPost, req.Method)
+				assert.Equal(t, "/sdapi/v1/txt2img", req.URL.Path)
+				b, err := io.ReadAll(req.Body)
+				assert.NoError(t, err)
+				assert.JSONEq(t, tt.wantBody, string(b))
+
+				res.Header().Set("content-type", "application/json")
+				res.WriteHeader(tt.status)
+				res.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := NewAutomatic1111Client(srv.URL + "/")
+			image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", tt.params)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Nil(t, image)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &Image{ContentType: "image/png", Data: fakePngData}, image)
+		})
+	}
+}
+
+func Test_automatic1111Client_serverErrorIsRetryable(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(res).Encode(map[string]string{"error": "busy"})
+	}))
+	defer srv.Close()
+
+	c := NewAutomatic1111Client(srv.URL)
+	_, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", ImageParams{})
+	assert.True(t, isRetryable(err))
+}
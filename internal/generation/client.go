@@ -7,13 +7,28 @@ import (
 	"io"
 	"net/http"
 
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/tracing"
 	openai "github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// Codes identifying the categories of error that may occur during generation
+const (
+	// CodeRejected indicates that the generation API refused to generate anything
+	// from our prompt
+	CodeRejected errcode.Code = "generation.rejected"
+	// CodeFlagged indicates that the viewer's inputs were flagged during moderation
+	CodeFlagged errcode.Code = "generation.flagged"
+	// CodeFailed indicates that a generation call failed for any other reason (e.g. an
+	// outage or a malformed response), even after retrying
+	CodeFailed errcode.Code = "generation.failed"
 )
 
 // ErrRejected is returned when the image generation API rejected the request to
 // generate one or more images, typically because the prompt contained text that was
 // classified as objectionable
-var ErrRejected = errors.New("image generation request rejected")
+var ErrRejected = errcode.New(CodeRejected, "image generation request rejected")
 
 // rejectionError unwraps to ErrRejected and carries the original client-facing message
 // returned as a 400 response from the image generation API
@@ -35,26 +50,62 @@ func (e *rejectionError) Unwrap() error {
 type Image struct {
 	ContentType string
 	Data        []byte
+	// Provider names the image provider that generated the image, if known
+	Provider string
 }
 
 type Client interface {
-	GenerateText(ctx context.Context, prompt string, opaqueUserId string) (string, error)
-	GenerateImage(ctx context.Context, prompt string, opaqueUserId string) (*Image, error)
+	// Moderate screens user-supplied text before any generation occurs, returning a
+	// Moderation that indicates whether the input was flagged as objectionable
+	Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error)
+	GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error)
+	GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error)
 }
 
 type client struct {
-	c *openai.Client
+	c          *openai.Client
+	httpClient *http.Client
 }
 
 func NewClient(openaiToken string) Client {
+	// Route all requests through a transport that captures Retry-After headers, so
+	// that callers can honor them when retrying
+	httpClient := &http.Client{
+		Transport: &retryAfterTransport{next: http.DefaultTransport},
+	}
+	config := openai.DefaultConfig(openaiToken)
+	config.HTTPClient = httpClient
 	return &client{
-		c: openai.NewClient(openaiToken),
+		c:          openai.NewClientWithConfig(config),
+		httpClient: httpClient,
 	}
 }
 
-func (c *client) GenerateText(ctx context.Context, prompt string, opaqueUserId string) (string, error) {
+func (c *client) Moderate(ctx context.Context, input string, opaqueUserId string) (_ *Moderation, err error) {
+	ctx, span := tracing.Start(ctx, "openai.moderations")
+	defer func() { tracing.End(span, err) }()
+
+	ctx, hint := withRetryAfterHint(ctx)
+	res, err := c.c.Moderations(ctx, openai.ModerationRequest{
+		Input: input,
+		Model: openai.ModerationTextLatest,
+	})
+	if err != nil {
+		return nil, wrapRetryAfter(err, hint)
+	}
+	if len(res.Results) != 1 {
+		return nil, fmt.Errorf("expected 1 moderation result from OpenAI; got %d", len(res.Results))
+	}
+	return parseOpenaiModerationResult(&res.Results[0]), nil
+}
+
+func (c *client) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "openai.chat_completion", attribute.String(tracing.AttributeModel, params.Model))
+	defer func() { tracing.End(span, err) }()
+
+	ctx, hint := withRetryAfterHint(ctx)
 	res, err := c.c.CreateChatCompletion(ctx, openai.ChatCompletionRequest{
-		Model: "gpt-3.5-turbo-0125",
+		Model: params.Model,
 		Messages: []openai.ChatCompletionMessage{
 			{
 				Role:    openai.ChatMessageRoleUser,
@@ -71,7 +122,7 @@ func (c *client) GenerateText(ctx context.Context, prompt string, opaqueUserId s
 		if errors.As(err, &apiError) && apiError.HTTPStatusCode == http.StatusBadRequest && apiError.Type == "invalid_request_error" {
 			return "", &rejectionError{apiError.Message}
 		}
-		return "", err
+		return "", wrapRetryAfter(err, hint)
 	}
 
 	// If we didn't get exactly one image, abort
@@ -86,19 +137,25 @@ func (c *client) GenerateText(ctx context.Context, prompt string, opaqueUserId s
 	return result, nil
 }
 
-func (c *client) GenerateImage(ctx context.Context, prompt string, opaqueUserId string) (*Image, error) {
+func (c *client) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
 	// Send a request to the OpenAI API to generate an image from our prompt: this
 	// request will block until the image is ready
-	res, err := c.c.CreateImage(ctx, openai.ImageRequest{
+	ctx, hint := withRetryAfterHint(ctx)
+	imageCtx, span := tracing.Start(ctx, "openai.create_image",
+		attribute.String(tracing.AttributeModel, params.Model),
+		attribute.String(tracing.AttributeImageSize, params.Size),
+	)
+	res, err := c.c.CreateImage(imageCtx, openai.ImageRequest{
 		Prompt:         prompt,
-		Model:          openai.CreateImageModelDallE3,
+		Model:          params.Model,
 		N:              1,
-		Quality:        openai.CreateImageQualityStandard,
-		Size:           openai.CreateImageSize1024x1024,
-		Style:          openai.CreateImageStyleVivid,
+		Quality:        params.Quality,
+		Size:           params.Size,
+		Style:          params.Style,
 		ResponseFormat: openai.CreateImageResponseFormatURL,
 		User:           opaqueUserId,
 	})
+	tracing.End(span, err)
 	if err != nil {
 		// If our request was rejected with a 400 error, return ErrRejected so the
 		// caller can propagate it as a client-level error
@@ -106,7 +163,7 @@ func (c *client) GenerateImage(ctx context.Context, prompt string, opaqueUserId
 		if errors.As(err, &apiError) && apiError.HTTPStatusCode == http.StatusBadRequest && apiError.Type == "invalid_request_error" {
 			return nil, &rejectionError{apiError.Message}
 		}
-		return nil, err
+		return nil, wrapRetryAfter(err, hint)
 	}
 
 	// If we didn't get exactly one image, abort
@@ -117,16 +174,28 @@ func (c *client) GenerateImage(ctx context.Context, prompt string, opaqueUserId
 	result := res.Data[0]
 
 	// Download the OpenAI-hosted PNG image so we can store it permanently
-	pngReq, err := http.NewRequestWithContext(ctx, http.MethodGet, result.URL, nil)
+	return c.downloadImage(ctx, result.URL, hint)
+}
+
+// downloadImage fetches the PNG image that OpenAI is hosting at the given URL
+func (c *client) downloadImage(ctx context.Context, url string, hint *retryAfterHint) (_ *Image, err error) {
+	ctx, span := tracing.Start(ctx, "openai.download_image")
+	defer func() { tracing.End(span, err) }()
+
+	pngReq, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 	if err != nil {
 		return nil, err
 	}
-	pngRes, err := http.DefaultClient.Do(pngReq)
+	pngRes, err := c.httpClient.Do(pngReq)
 	if err != nil {
 		return nil, err
 	}
+	defer pngRes.Body.Close()
 	if pngRes.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("got status %d from request for OpenAI-hosted image", pngRes.StatusCode)
+		return nil, wrapRetryAfter(&StatusError{
+			StatusCode: pngRes.StatusCode,
+			Message:    "request for OpenAI-hosted image failed",
+		}, hint)
 	}
 
 	// Verify that OpenAI has linked us to a .png
@@ -0,0 +1,350 @@
+package generation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// DefaultComfyUIPollInterval is how often we check whether ComfyUI has finished
+// running our workflow, if ComfyUIOptions doesn't specify otherwise
+const DefaultComfyUIPollInterval = time.Second
+
+// ComfyUIOptions configures a ComfyUI image provider
+type ComfyUIOptions struct {
+	// URL is the base URL of the ComfyUI server
+	URL string
+	// Workflow is the workflow that's queued to generate each image, in ComfyUI's API
+	// format (as exported via "Save (API Format)"). Any string input whose entire value
+	// is one of the following placeholders is replaced with the corresponding value:
+	// "$prompt", "$negative_prompt", "$model", "$seed", "$steps", "$cfg_scale",
+	// "$sampler", "$width", and "$height". Params that aren't set are replaced with
+	// reasonable defaults, except for "$model", which requires a model to be set.
+	Workflow json.RawMessage
+	// PollInterval is how often we check for the workflow's outputs; if zero,
+	// DefaultComfyUIPollInterval is used
+	PollInterval time.Duration
+}
+
+// Default values substituted into a ComfyUI workflow for any params that aren't set
+const (
+	comfyUIDefaultSteps    = 20
+	comfyUIDefaultCfgScale = 7.0
+	comfyUIDefaultSampler  = "euler"
+	comfyUIDefaultSize     = 1024
+)
+
+// NewComfyUIClient returns an ImageGenerator that generates images by queueing the
+// configured workflow on a ComfyUI server, waiting for it to finish, and downloading
+// the single image that it outputs
+func NewComfyUIClient(opts ComfyUIOptions) (ImageGenerator, error) {
+	var workflow map[string]interface{}
+	if err := json.Unmarshal(opts.Workflow, &workflow); err != nil {
+		return nil, fmt.Errorf("failed to parse ComfyUI workflow: %w", err)
+	}
+	pollInterval := opts.PollInterval
+	if pollInterval <= 0 {
+		pollInterval = DefaultComfyUIPollInterval
+	}
+	return &comfyUIClient{
+		url:          strings.TrimSuffix(opts.URL, "/"),
+		workflow:     opts.Workflow,
+		pollInterval: pollInterval,
+		httpClient:   &http.Client{},
+		sleep:        sleep,
+	}, nil
+}
+
+type comfyUIClient struct {
+	url          string
+	workflow     json.RawMessage
+	pollInterval time.Duration
+	httpClient   *http.Client
+	sleep        func(ctx context.Context, d time.Duration) error
+}
+
+// comfyUIPromptResponse is the body of a successful POST /prompt response
+type comfyUIPromptResponse struct {
+	PromptId string `json:"prompt_id"`
+}
+
+// comfyUIHistoryEntry describes a queued prompt once ComfyUI has finished running it
+type comfyUIHistoryEntry struct {
+	Status struct {
+		StatusStr string `json:"status_str"`
+		Completed bool   `json:"completed"`
+	} `json:"status"`
+	Outputs map[string]struct {
+		Images []comfyUIImage `json:"images"`
+	} `json:"outputs"`
+}
+
+// comfyUIImage identifies an image file produced by a workflow
+type comfyUIImage struct {
+	Filename  string `json:"filename"`
+	Subfolder string `json:"subfolder"`
+	Type      string `json:"type"`
+}
+
+func (c *comfyUIClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (_ *Image, err error) {
+	ctx, span := tracing.Start(ctx, "comfyui.generate",
+		attribute.String(tracing.AttributeModel, params.Model),
+		attribute.String(tracing.AttributeImageSize, params.Size),
+	)
+	defer func() { tracing.End(span, err) }()
+
+	// Fill in our workflow with the prompt and params, then queue it
+	workflow, err := c.renderWorkflow(prompt, params)
+	if err != nil {
+		return nil, err
+	}
+	promptId, err := c.queuePrompt(ctx, workflow, opaqueUserId)
+	if err != nil {
+		return nil, err
+	}
+
+	// Wait for the workflow to finish, then download the image it produced
+	image, err := c.awaitImage(ctx, promptId)
+	if err != nil {
+		return nil, err
+	}
+	return c.downloadImage(ctx, image)
+}
+
+// renderWorkflow returns a copy of our workflow in which each placeholder has been
+// replaced with the corresponding value
+func (c *comfyUIClient) renderWorkflow(prompt string, params ImageParams) (map[string]interface{}, error) {
+	width, height := comfyUIDefaultSize, comfyUIDefaultSize
+	if params.Size != "" {
+		var err error
+		width, height, err = parseSize(params.Size)
+		if err != nil {
+			return nil, err
+		}
+	}
+	seed := params.Seed
+	if seed == 0 {
+		seed = rand.Int63()
+	}
+	steps := params.Steps
+	if steps == 0 {
+		steps = comfyUIDefaultSteps
+	}
+	cfgScale := params.CfgScale
+	if cfgScale == 0 {
+		cfgScale = comfyUIDefaultCfgScale
+	}
+	sampler := params.Sampler
+	if sampler == "" {
+		sampler = comfyUIDefaultSampler
+	}
+	values := map[string]interface{}{
+		"$prompt":          prompt,
+		"$negative_prompt": params.NegativePrompt,
+		"$seed":            seed,
+		"$steps":           steps,
+		"$cfg_scale":       cfgScale,
+		"$sampler":         sampler,
+		"$width":           width,
+		"$height":          height,
+	}
+	if params.Model != "" {
+		values["$model"] = params.Model
+	}
+
+	var workflow map[string]interface{}
+	if err := json.Unmarshal(c.workflow, &workflow); err != nil {
+		return nil, fmt.Errorf("failed to parse ComfyUI workflow: %w", err)
+	}
+	var replace func(v interface{}) (interface{}, error)
+	replace = func(v interface{}) (interface{}, error) {
+		switch v := v.(type) {
+		case string:
+			if value, ok := values[v]; ok {
+				return value, nil
+			}
+			if v == "$model" {
+				return nil, fmt.Errorf("ComfyUI workflow requires a model, but none is configured")
+			}
+			return v, nil
+		case map[string]interface{}:
+			for k := range v {
+				replaced, err := replace(v[k])
+				if err != nil {
+					return nil, err
+				}
+				v[k] = replaced
+			}
+		case []interface{}:
+			for i := range v {
+				replaced, err := replace(v[i])
+				if err != nil {
+					return nil, err
+				}
+				v[i] = replaced
+			}
+		}
+		return v, nil
+	}
+	if _, err := replace(workflow); err != nil {
+		return nil, err
+	}
+	return workflow, nil
+}
+
+// queuePrompt submits a workflow to be run, returning the ID that identifies it
+func (c *comfyUIClient) queuePrompt(ctx context.Context, workflow map[string]interface{}, clientId string) (string, error) {
+	data, err := json.Marshal(map[string]interface{}{
+		"prompt":    workflow,
+		"client_id": clientId,
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url+"/prompt", bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("content-type", "application/json")
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return "", &StatusError{
+			StatusCode: res.StatusCode,
+			Message:    "request to queue ComfyUI workflow failed",
+		}
+	}
+	var result comfyUIPromptResponse
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("failed to decode ComfyUI prompt response: %w", err)
+	}
+	if result.PromptId == "" {
+		return "", fmt.Errorf("got no prompt_id from ComfyUI")
+	}
+	return result.PromptId, nil
+}
+
+// awaitImage polls the history of the given prompt until the workflow has finished,
+// then returns the single image that it output
+func (c *comfyUIClient) awaitImage(ctx context.Context, promptId string) (*comfyUIImage, error) {
+	for {
+		entry, err := c.getHistory(ctx, promptId)
+		if err != nil {
+			return nil, err
+		}
+		if entry != nil {
+			if entry.Status.StatusStr == "error" {
+				return nil, fmt.Errorf("ComfyUI workflow failed")
+			}
+			if entry.Status.Completed {
+				return getComfyUIOutputImage(entry)
+			}
+		}
+		if err := c.sleep(ctx, c.pollInterval); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// getHistory returns the history entry for the given prompt, or nil if the workflow
+// hasn't finished yet
+func (c *comfyUIClient) getHistory(ctx context.Context, promptId string) (*comfyUIHistoryEntry, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/history/"+url.PathEscape(promptId), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{
+			StatusCode: res.StatusCode,
+			Message:    "request for ComfyUI history failed",
+		}
+	}
+	var history map[string]comfyUIHistoryEntry
+	if err := json.NewDecoder(res.Body).Decode(&history); err != nil {
+		return nil, fmt.Errorf("failed to decode ComfyUI history response: %w", err)
+	}
+	entry, ok := history[promptId]
+	if !ok {
+		return nil, nil
+	}
+	return &entry, nil
+}
+
+// getComfyUIOutputImage returns the single output image produced by a finished
+// workflow, ignoring any temporary images (e.g. from preview nodes)
+func getComfyUIOutputImage(entry *comfyUIHistoryEntry) (*comfyUIImage, error) {
+	nodeIds := make([]string, 0, len(entry.Outputs))
+	for nodeId := range entry.Outputs {
+		nodeIds = append(nodeIds, nodeId)
+	}
+	sort.Strings(nodeIds)
+
+	var images []comfyUIImage
+	for _, nodeId := range nodeIds {
+		for _, image := range entry.Outputs[nodeId].Images {
+			if image.Type == "output" {
+				images = append(images, image)
+			}
+		}
+	}
+	if len(images) != 1 {
+		return nil, fmt.Errorf("expected 1 result image from ComfyUI; got %d", len(images))
+	}
+	return &images[0], nil
+}
+
+// downloadImage fetches an image that ComfyUI has output
+func (c *comfyUIClient) downloadImage(ctx context.Context, image *comfyUIImage) (*Image, error) {
+	query := url.Values{}
+	query.Set("filename", image.Filename)
+	query.Set("subfolder", image.Subfolder)
+	query.Set("type", image.Type)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url+"/view?"+query.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, &StatusError{
+			StatusCode: res.StatusCode,
+			Message:    "request for ComfyUI image failed",
+		}
+	}
+
+	// Verify that ComfyUI has given us a .png
+	contentType := res.Header.Get("content-type")
+	if contentType != "image/png" {
+		return nil, fmt.Errorf("got unexpected content-type '%s' for ComfyUI image", contentType)
+	}
+	pngData, err := io.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PNG image data from ComfyUI response body: %w", err)
+	}
+	return &Image{
+		ContentType: contentType,
+		Data:        pngData,
+	}, nil
+}
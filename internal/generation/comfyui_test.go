@@ -0,0 +1,184 @@
+package generation
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const comfyUITestWorkflow = `{
+	"3": {"class_type": "KSampler", "inputs": {"seed": "$seed", "steps": "$steps", "cfg": "$cfg_scale", "sampler_name": "$sampler", "model": ["4", 0]}},
+	"4": {"class_type": "CheckpointLoaderSimple", "inputs": {"ckpt_name": "$model"}},
+	"5": {"class_type": "EmptyLatentImage", "inputs": {"width": "$width", "height": "$height", "batch_size": 1}},
+	"6": {"class_type": "CLIPTextEncode", "inputs": {"text": "$prompt"}},
+	"7": {"class_type": "CLIPTextEncode", "inputs": {"text": "$negative_prompt"}},
+	"9": {"class_type": "SaveImage", "inputs": {"filename_prefix": "dynamo"}}
+}`
+
+func Test_comfyUIClient_GenerateImage(t *testing.T) {
+	tests := []struct {
+		name          string
+		params        ImageParams
+		queueStatus   int
+		historyStatus string
+		wantInputs    map[string]interface{}
+		wantErr       string
+	}{
+		{
+			"params are substituted into the workflow",
+			ImageParams{
+				Provider:       ProviderComfyUI,
+				Model:          "sd_xl_base_1.0.safetensors",
+				Size:           "768x512",
+				NegativePrompt: "blurry, text",
+				Seed:           1234,
+				Steps:          30,
+				CfgScale:       6.5,
+				Sampler:        "dpmpp_2m",
+			},
+			http.StatusOK,
+			"success",
+			map[string]interface{}{
+				"seed":         float64(1234),
+				"steps":        float64(30),
+				"cfg":          6.5,
+				"sampler_name": "dpmpp_2m",
+				"ckpt_name":    "sd_xl_base_1.0.safetensors",
+				"width":        float64(768),
+				"height":       float64(512),
+				"prompt":       "a ghostly image of a seal",
+				"negative":     "blurry, text",
+			},
+			"",
+		},
+		{
+			"unset params are replaced with defaults",
+			ImageParams{Provider: ProviderComfyUI, Model: "sd_xl_base_1.0.safetensors"},
+			http.StatusOK,
+			"success",
+			map[string]interface{}{
+				"steps":        float64(20),
+				"cfg":          7.0,
+				"sampler_name": "euler",
+				"ckpt_name":    "sd_xl_base_1.0.safetensors",
+				"width":        float64(1024),
+				"height":       float64(1024),
+				"prompt":       "a ghostly image of a seal",
+				"negative":     "",
+			},
+			"",
+		},
+		{
+			"workflow that requires a model fails without one",
+			ImageParams{Provider: ProviderComfyUI},
+			http.StatusOK,
+			"success",
+			nil,
+			"ComfyUI workflow requires a model, but none is configured",
+		},
+		{
+			"invalid workflow is a StatusError",
+			ImageParams{Provider: ProviderComfyUI, Model: "missing.safetensors"},
+			http.StatusBadRequest,
+			"",
+			nil,
+			"request to queue ComfyUI workflow failed: got status 400",
+		},
+		{
+			"failed workflow is an error",
+			ImageParams{Provider: ProviderComfyUI, Model: "sd_xl_base_1.0.safetensors"},
+			http.StatusOK,
+			"error",
+			nil,
+			"ComfyUI workflow failed",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var queued map[string]map[string]interface{}
+			numHistoryRequests := 0
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				switch {
+				case req.Method == http.MethodPost && req.URL.Path == "/prompt":
+					var payload struct {
+						Prompt   map[string]map[string]interface{} `json:"prompt"`
+						ClientId string                            `json:"client_id"`
+					}
+					assert.NoError(t, json.NewDecoder(req.Body).Decode(&payload))
+					assert.Equal(t, "1001", payload.ClientId)
+					queued = payload.Prompt
+					res.WriteHeader(tt.queueStatus)
+					res.Write([]byte(`{"prompt_id":"p-1234","number":1,"node_errors":{}}`))
+				case req.Method == http.MethodGet && req.URL.Path == "/history/p-1234":
+					// The workflow is still running the first time we check
+					numHistoryRequests++
+					if numHistoryRequests == 1 {
+						res.Write([]byte(`{}`))
+						return
+					}
+					res.Write([]byte(`{"p-1234":{"status":{"status_str":"` + tt.historyStatus + `","completed":` + boolString(tt.historyStatus == "success") + `},"outputs":{"8":{"images":[{"filename":"preview.png","subfolder":"","type":"temp"}]},"9":{"images":[{"filename":"dynamo_00001_.png","subfolder":"","type":"output"}]}}}}`))
+				case req.Method == http.MethodGet && req.URL.Path == "/view":
+					assert.Equal(t, "dynamo_00001_.png", req.URL.Query().Get("filename"))
+					assert.Equal(t, "output", req.URL.Query().Get("type"))
+					res.Header().Set("content-type", "image/png")
+					res.Write(fakePngData)
+				default:
+					t.Errorf("unexpected request: %s %s", req.Method, req.URL.Path)
+					res.WriteHeader(http.StatusNotFound)
+				}
+			}))
+			defer srv.Close()
+
+			c, err := NewComfyUIClient(ComfyUIOptions{
+				URL:          srv.URL,
+				Workflow:     json.RawMessage(comfyUITestWorkflow),
+				PollInterval: time.Millisecond,
+			})
+			assert.NoError(t, err)
+			image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", tt.params)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Nil(t, image)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, &Image{ContentType: "image/png", Data: fakePngData}, image)
+			assert.Equal(t, 2, numHistoryRequests)
+
+			gotInputs := map[string]interface{}{
+				"steps":        queued["3"]["inputs"].(map[string]interface{})["steps"],
+				"cfg":          queued["3"]["inputs"].(map[string]interface{})["cfg"],
+				"sampler_name": queued["3"]["inputs"].(map[string]interface{})["sampler_name"],
+				"ckpt_name":    queued["4"]["inputs"].(map[string]interface{})["ckpt_name"],
+				"width":        queued["5"]["inputs"].(map[string]interface{})["width"],
+				"height":       queued["5"]["inputs"].(map[string]interface{})["height"],
+				"prompt":       queued["6"]["inputs"].(map[string]interface{})["text"],
+				"negative":     queued["7"]["inputs"].(map[string]interface{})["text"],
+			}
+			seed := queued["3"]["inputs"].(map[string]interface{})["seed"]
+			assert.IsType(t, float64(0), seed)
+			if _, ok := tt.wantInputs["seed"]; ok {
+				gotInputs["seed"] = seed
+			}
+			assert.Equal(t, tt.wantInputs, gotInputs)
+			assert.Equal(t, []interface{}{"4", float64(0)}, queued["3"]["inputs"].(map[string]interface{})["model"])
+		})
+	}
+}
+
+func Test_NewComfyUIClient_invalidWorkflow(t *testing.T) {
+	_, err := NewComfyUIClient(ComfyUIOptions{Workflow: json.RawMessage(`["not", "a", "workflow"]`)})
+	assert.ErrorContains(t, err, "failed to parse ComfyUI workflow")
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
@@ -0,0 +1,25 @@
+package generation
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+)
+
+type contextKey string
+
+const imageRequestIdKey contextKey = "imageRequestId"
+
+// WithImageRequestId returns a copy of ctx that identifies the image request on whose
+// behalf any generation calls are being made, so that clients which record details
+// about each call (e.g. the retrying client) can associate them with that request
+func WithImageRequestId(ctx context.Context, imageRequestId uuid.UUID) context.Context {
+	return context.WithValue(ctx, imageRequestIdKey, imageRequestId)
+}
+
+// GetImageRequestId returns the image request ID that was associated with ctx via
+// WithImageRequestId, if any
+func GetImageRequestId(ctx context.Context) (uuid.UUID, bool) {
+	imageRequestId, ok := ctx.Value(imageRequestIdKey).(uuid.UUID)
+	return imageRequestId, ok && imageRequestId != uuid.Nil
+}
@@ -0,0 +1,292 @@
+package generation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"golang.org/x/exp/slog"
+)
+
+// ErrNoProviderAvailable is returned when every image provider that could serve a call
+// has been skipped because its circuit breaker is open: it's treated as a transient
+// error, since the breakers will close again once their cooldown elapses
+var ErrNoProviderAvailable = errors.New("no image provider is available")
+
+// FailoverOptions configures a Client that fails over between image providers
+type FailoverOptions struct {
+	// BackendName identifies our generation backend in Order, and is recorded as the
+	// provider of the images it generates, e.g. "openai"
+	BackendName string
+	// Order lists the names of the providers to try, in turn, if the provider selected
+	// by a call's ImageParams fails with a transient error
+	Order []string
+	// MaxFailures is the number of consecutive transient failures after which a
+	// provider's circuit breaker trips; if less than 1, breakers never trip
+	MaxFailures int
+	// Cooldown is how long a tripped breaker stays open before we make a single trial
+	// call to its provider: if that call fails, the breaker trips again immediately
+	Cooldown time.Duration
+	// Fallback, if set, moderates inputs and generates text in place of our generation
+	// backend while the backend's circuit breaker is open, or if a call to the backend
+	// fails with a transient error; if nil, those calls are always made to the backend
+	Fallback Client
+}
+
+// NewFailoverClient returns a Client that wraps c, which must route each image
+// generation call to the provider named by its ImageParams (see NewProviderClient).
+// Each call is made first to the provider that its params select, or to our
+// generation backend if none is selected. If that fails with a transient error, the
+// call is made to each other provider listed in opts.Order in turn, until one
+// succeeds. Rejections and other errors that aren't transient are returned
+// immediately. Each provider has a circuit breaker that trips after repeated
+// failures, so that the provider is skipped until it's had time to recover. The
+// name of the provider that generated each image is recorded as its Provider.
+// Moderation and text generation can only fail over to opts.Fallback, since the other
+// providers only generate images.
+func NewFailoverClient(logger *slog.Logger, c Client, opts FailoverOptions) Client {
+	return &failoverClient{
+		Client:   c,
+		logger:   logger,
+		opts:     opts,
+		breakers: make(map[string]*circuitBreaker),
+		now:      time.Now,
+	}
+}
+
+type failoverClient struct {
+	Client
+	logger *slog.Logger
+	opts   FailoverOptions
+
+	mu       sync.Mutex
+	breakers map[string]*circuitBreaker
+	now      func() time.Time
+}
+
+// circuitBreaker tracks the consecutive transient failures of a single provider
+type circuitBreaker struct {
+	numFailures int
+	openUntil   time.Time
+	// isTrialInFlight is true while we're making a trial call to the provider after
+	// its cooldown has elapsed: until that call completes, other calls still skip it
+	isTrialInFlight bool
+}
+
+func (c *failoverClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	preferred := params.Provider
+	if preferred == "" {
+		preferred = c.opts.BackendName
+	}
+	var lastErr error
+	for _, name := range c.getChain(preferred) {
+		if !c.allow(name) {
+			c.logger.Warn("Skipping image provider with open circuit breaker", "provider", name)
+			continue
+		}
+
+		// Adapt our params if we've failed over from the provider they were configured
+		// for, then make the call
+		attemptParams := params
+		if name != preferred {
+			attemptParams = c.getFailoverParams(params, name)
+		}
+		image, err := c.Client.GenerateImage(ctx, prompt, opaqueUserId, attemptParams)
+		if err == nil {
+			c.recordSuccess(name)
+			image.Provider = name
+			return image, nil
+		}
+
+		// Only transient failures indicate that the provider is unhealthy: anything
+		// else (e.g. a rejected prompt) would fail the same way with any provider
+		if !c.recordOutcome(name, err) {
+			return nil, err
+		}
+		c.logger.Warn("Image provider failed; failing over", "provider", name, "error", err)
+		metrics.GenerationFailovers.WithLabelValues(name).Inc()
+		lastErr = err
+	}
+	if lastErr == nil {
+		return nil, ErrNoProviderAvailable
+	}
+	return nil, lastErr
+}
+
+func (c *failoverClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	var result *Moderation
+	err := c.callBackend("moderation", func(client Client) error {
+		var err error
+		result, err = client.Moderate(ctx, input, opaqueUserId)
+		return err
+	})
+	return result, err
+}
+
+func (c *failoverClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	var result string
+	err := c.callBackend("text generation", func(client Client) error {
+		var err error
+		result, err = client.GenerateText(ctx, prompt, opaqueUserId, params)
+		return err
+	})
+	return result, err
+}
+
+// callBackend makes a call that only our generation backend or the fallback client can
+// serve, passing the client that should handle it to call: the backend is skipped in
+// favor of the fallback while its circuit breaker is open, and if it fails with a
+// transient error, the call is made again with the fallback
+func (c *failoverClient) callBackend(operation string, call func(client Client) error) error {
+	if c.opts.Fallback == nil {
+		return call(c.Client)
+	}
+	name := c.opts.BackendName
+	if !c.allow(name) {
+		c.logger.Warn("Skipping generation backend with open circuit breaker", "provider", name, "operation", operation)
+		return call(c.opts.Fallback)
+	}
+	err := call(c.Client)
+	if err == nil {
+		c.recordSuccess(name)
+		return nil
+	}
+	if !c.recordOutcome(name, err) {
+		return err
+	}
+	c.logger.Warn("Generation backend failed; falling back", "provider", name, "operation", operation, "error", err)
+	metrics.GenerationFailovers.WithLabelValues(name).Inc()
+	return call(c.opts.Fallback)
+}
+
+// recordOutcome updates the named provider's circuit breaker after a call to it failed
+// with err, returning true if the failure was transient. Any other error shows that
+// the provider is responding, unless we gave up on the call.
+func (c *failoverClient) recordOutcome(name string, err error) bool {
+	if isRetryable(err) {
+		c.recordFailure(name)
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		c.abandonTrial(name)
+	} else {
+		c.recordSuccess(name)
+	}
+	return false
+}
+
+// getChain returns the names of the providers that should be tried, in order, for a
+// call whose params select the given provider
+func (c *failoverClient) getChain(preferred string) []string {
+	chain := []string{preferred}
+	for _, name := range c.opts.Order {
+		if name != preferred {
+			chain = append(chain, name)
+		}
+	}
+	return chain
+}
+
+// getFailoverParams adapts params for a provider other than the one they were
+// configured for: our generation backend uses the backend params that were configured
+// alongside them (or its default params, if none were), while Stable Diffusion
+// providers keep the configured size and provider-specific settings
+func (c *failoverClient) getFailoverParams(params ImageParams, name string) ImageParams {
+	if name == c.opts.BackendName {
+		if params.Backend != nil {
+			return *params.Backend
+		}
+		return DefaultParams.Image
+	}
+	return ImageParams{
+		Provider:       name,
+		Size:           params.Size,
+		NegativePrompt: params.NegativePrompt,
+		Seed:           params.Seed,
+		Steps:          params.Steps,
+		CfgScale:       params.CfgScale,
+		Sampler:        params.Sampler,
+	}
+}
+
+// allow returns false if the named provider's circuit breaker is open. Once a tripped
+// breaker's cooldown has elapsed, allow returns true for a single trial call, then
+// false again until the result of that call is recorded.
+func (c *failoverClient) allow(name string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[name]
+	if !ok || c.opts.MaxFailures < 1 || b.numFailures < c.opts.MaxFailures {
+		return true
+	}
+	if b.isTrialInFlight || c.now().Before(b.openUntil) {
+		return false
+	}
+	b.isTrialInFlight = true
+	return true
+}
+
+// recordSuccess closes the named provider's circuit breaker
+func (c *failoverClient) recordSuccess(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.breakers, name)
+}
+
+// recordFailure counts a transient failure of the named provider, tripping its
+// circuit breaker if it's failed too many times in a row
+func (c *failoverClient) recordFailure(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.breakers[name]
+	if !ok {
+		b = &circuitBreaker{}
+		c.breakers[name] = b
+	}
+	b.numFailures++
+	b.isTrialInFlight = false
+	if c.opts.MaxFailures > 0 && b.numFailures >= c.opts.MaxFailures {
+		b.openUntil = c.now().Add(c.opts.Cooldown)
+		c.logger.Error("Image provider failed repeatedly; tripping circuit breaker",
+			"provider", name,
+			"numFailures", b.numFailures,
+			"cooldown", c.opts.Cooldown,
+		)
+		metrics.GenerationCircuitBreakerTrips.WithLabelValues(name).Inc()
+	}
+}
+
+// abandonTrial notes that a call to the named provider ended without telling us
+// whether it's healthy (e.g. because the call was canceled), so that if it was a trial
+// call, another one can be made
+func (c *failoverClient) abandonTrial(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if b, ok := c.breakers[name]; ok {
+		b.isTrialInFlight = false
+	}
+}
+
+// NewFallbackClient returns a Client that can stand in for our generation backend as
+// FailoverOptions.Fallback without calling any external APIs: it moderates inputs
+// against the given rules, and generates text by choosing from the same canned names
+// as a local Client. It can't generate images.
+func NewFallbackClient(rules []Rule) Client {
+	return NewRulesModeratingClient(&fallbackClient{}, rules)
+}
+
+type fallbackClient struct{}
+
+func (c *fallbackClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	return nil, ErrNoProviderAvailable
+}
+
+func (c *fallbackClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	return localNames[hashPrompt(prompt)%uint64(len(localNames))], nil
+}
+
+func (c *fallbackClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	return nil, ErrNoProviderAvailable
+}
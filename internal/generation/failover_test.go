@@ -0,0 +1,348 @@
+package generation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_failoverClient_GenerateImage(t *testing.T) {
+	tests := []struct {
+		name         string
+		params       ImageParams
+		errs         map[string]error
+		wantProvider string
+		wantErr      string
+		wantCalls    []ImageParams
+	}{
+		{
+			"image is generated by backend if no provider is selected",
+			DefaultParams.Image,
+			nil,
+			"openai",
+			"",
+			[]ImageParams{DefaultParams.Image},
+		},
+		{
+			"transient failure fails over to next provider",
+			DefaultParams.Image,
+			map[string]error{
+				"openai": &StatusError{StatusCode: 503, Message: "outage"},
+			},
+			"automatic1111",
+			"",
+			[]ImageParams{
+				DefaultParams.Image,
+				{Provider: "automatic1111", Size: "1024x1024"},
+			},
+		},
+		{
+			"provider selected by params is tried first",
+			ImageParams{Provider: "comfyui", Model: "sd_xl_base_1.0.safetensors", Size: "768x768", Steps: 30},
+			map[string]error{
+				"comfyui": &StatusError{StatusCode: 502, Message: "bad gateway"},
+			},
+			"openai",
+			"",
+			[]ImageParams{
+				{Provider: "comfyui", Model: "sd_xl_base_1.0.safetensors", Size: "768x768", Steps: 30},
+				DefaultParams.Image,
+			},
+		},
+		{
+			"failover to backend uses backend params configured for the style",
+			ImageParams{Provider: "comfyui", Size: "768x768", Backend: &ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "hd", Style: "natural"}},
+			map[string]error{
+				"comfyui": &StatusError{StatusCode: 502, Message: "bad gateway"},
+			},
+			"openai",
+			"",
+			[]ImageParams{
+				{Provider: "comfyui", Size: "768x768", Backend: &ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "hd", Style: "natural"}},
+				{Model: "dall-e-3", Size: "1024x1024", Quality: "hd", Style: "natural"},
+			},
+		},
+		{
+			"rejection does not fail over",
+			DefaultParams.Image,
+			map[string]error{
+				"openai": &rejectionError{"nope"},
+			},
+			"",
+			"image generation request rejected: nope",
+			[]ImageParams{DefaultParams.Image},
+		},
+		{
+			"permanent error does not fail over",
+			DefaultParams.Image,
+			map[string]error{
+				"openai": fmt.Errorf("expected 1 result image from OpenAI; got 0"),
+			},
+			"",
+			"expected 1 result image from OpenAI; got 0",
+			[]ImageParams{DefaultParams.Image},
+		},
+		{
+			"last error is returned if every provider fails",
+			DefaultParams.Image,
+			map[string]error{
+				"openai":        &StatusError{StatusCode: 503, Message: "outage"},
+				"automatic1111": &StatusError{StatusCode: 500, Message: "out of memory"},
+				"comfyui":       &StatusError{StatusCode: 502, Message: "bad gateway"},
+			},
+			"",
+			"bad gateway: got status 502",
+			[]ImageParams{
+				DefaultParams.Image,
+				{Provider: "automatic1111", Size: "1024x1024"},
+				{Provider: "comfyui", Size: "1024x1024"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := &mockRoutingClient{errs: tt.errs}
+			c := NewFailoverClient(slog.Default(), router, FailoverOptions{
+				BackendName: "openai",
+				Order:       []string{"openai", "automatic1111", "comfyui"},
+				MaxFailures: 3,
+				Cooldown:    time.Minute,
+			})
+			image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", tt.params)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Nil(t, image)
+			} else {
+				assert.NoError(t, err)
+				if assert.NotNil(t, image) {
+					assert.Equal(t, tt.wantProvider, image.Provider)
+				}
+			}
+			assert.Equal(t, tt.wantCalls, router.calls)
+		})
+	}
+}
+
+func Test_failoverClient_circuitBreaker(t *testing.T) {
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	router := &mockRoutingClient{errs: map[string]error{
+		"openai": &StatusError{StatusCode: 503, Message: "outage"},
+	}}
+	c := &failoverClient{
+		Client: router,
+		logger: slog.Default(),
+		opts: FailoverOptions{
+			BackendName: "openai",
+			Order:       []string{"openai", "automatic1111"},
+			MaxFailures: 2,
+			Cooldown:    time.Minute,
+		},
+		breakers: make(map[string]*circuitBreaker),
+		now:      func() time.Time { return now },
+	}
+	generate := func() []string {
+		router.calls = nil
+		image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", DefaultParams.Image)
+		assert.NoError(t, err)
+		assert.Equal(t, "automatic1111", image.Provider)
+		providers := make([]string, 0, len(router.calls))
+		for _, call := range router.calls {
+			providers = append(providers, router.getName(call))
+		}
+		return providers
+	}
+
+	// The backend is tried on every call until it's failed twice in a row, at which
+	// point its breaker trips and it's skipped
+	assert.Equal(t, []string{"openai", "automatic1111"}, generate())
+	assert.Equal(t, []string{"openai", "automatic1111"}, generate())
+	assert.Equal(t, []string{"automatic1111"}, generate())
+
+	// Once the cooldown has elapsed, the backend is tried again: if it fails, the
+	// breaker trips again immediately
+	now = now.Add(time.Minute)
+	assert.Equal(t, []string{"openai", "automatic1111"}, generate())
+	assert.Equal(t, []string{"automatic1111"}, generate())
+
+	// Once the backend has recovered, its breaker closes
+	now = now.Add(time.Minute)
+	router.errs = nil
+	router.calls = nil
+	image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", DefaultParams.Image)
+	assert.NoError(t, err)
+	assert.Equal(t, "openai", image.Provider)
+	assert.True(t, c.allow("openai"))
+}
+
+func Test_failoverClient_allow(t *testing.T) {
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	c := &failoverClient{
+		logger: slog.Default(),
+		opts: FailoverOptions{
+			BackendName: "openai",
+			MaxFailures: 2,
+			Cooldown:    time.Minute,
+		},
+		breakers: make(map[string]*circuitBreaker),
+		now:      func() time.Time { return now },
+	}
+
+	// Once tripped, the breaker stays open until its cooldown elapses
+	c.recordFailure("openai")
+	assert.True(t, c.allow("openai"))
+	c.recordFailure("openai")
+	assert.False(t, c.allow("openai"))
+
+	// After the cooldown, only a single trial call is allowed through at once
+	now = now.Add(time.Minute)
+	assert.True(t, c.allow("openai"))
+	assert.False(t, c.allow("openai"))
+	assert.False(t, c.allow("openai"))
+
+	// If the trial call fails, the breaker stays open for another cooldown
+	c.recordFailure("openai")
+	assert.False(t, c.allow("openai"))
+	now = now.Add(30 * time.Second)
+	assert.False(t, c.allow("openai"))
+
+	// If the trial call is abandoned, another trial call can be made
+	now = now.Add(30 * time.Second)
+	assert.True(t, c.allow("openai"))
+	c.abandonTrial("openai")
+	assert.True(t, c.allow("openai"))
+
+	// If the trial call succeeds, the breaker closes
+	c.recordSuccess("openai")
+	assert.True(t, c.allow("openai"))
+	assert.True(t, c.allow("openai"))
+}
+
+func Test_failoverClient_noProviderAvailable(t *testing.T) {
+	router := &mockRoutingClient{errs: map[string]error{
+		"openai": &StatusError{StatusCode: 503, Message: "outage"},
+	}}
+	c := NewFailoverClient(slog.Default(), router, FailoverOptions{
+		BackendName: "openai",
+		MaxFailures: 1,
+		Cooldown:    time.Minute,
+	})
+	_, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", DefaultParams.Image)
+	assert.Equal(t, &StatusError{StatusCode: 503, Message: "outage"}, err)
+
+	_, err = c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", DefaultParams.Image)
+	assert.ErrorIs(t, err, ErrNoProviderAvailable)
+	assert.True(t, isRetryable(err))
+	assert.Len(t, router.calls, 1)
+}
+
+func Test_failoverClient_fallback(t *testing.T) {
+	rules := []Rule{{Category: "violence", Pattern: regexp.MustCompile(`(?i)\bgore\b`)}}
+	tests := []struct {
+		name             string
+		backendErrs      []error
+		fallback         Client
+		wantSource       string
+		wantErr          string
+		wantBackendCalls int
+	}{
+		{
+			"healthy backend is used",
+			nil,
+			NewFallbackClient(rules),
+			"mock",
+			"",
+			2,
+		},
+		{
+			"transient failure falls back, and backend is skipped once its breaker trips",
+			[]error{&StatusError{StatusCode: 503, Message: "outage"}},
+			NewFallbackClient(rules),
+			"rules",
+			"",
+			1,
+		},
+		{
+			"rejection does not fall back",
+			[]error{&rejectionError{"nope"}},
+			NewFallbackClient(rules),
+			"",
+			"image generation request rejected: nope",
+			1,
+		},
+		{
+			"transient failure is returned without a fallback",
+			[]error{&StatusError{StatusCode: 503, Message: "outage"}},
+			nil,
+			"",
+			"outage: got status 503",
+			1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backend := &mockClient{errs: tt.backendErrs}
+			c := NewFailoverClient(slog.Default(), backend, FailoverOptions{
+				BackendName: "openai",
+				MaxFailures: 1,
+				Cooldown:    time.Minute,
+				Fallback:    tt.fallback,
+			})
+			m, err := c.Moderate(context.Background(), "a seal", "1001")
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantBackendCalls, backend.numCalls)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantSource, m.Source)
+
+			text, err := c.GenerateText(context.Background(), "name this seal", "1001", DefaultParams.Text)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, text)
+			assert.Equal(t, tt.wantBackendCalls, backend.numCalls)
+		})
+	}
+}
+
+func Test_NewFallbackClient(t *testing.T) {
+	c := NewFallbackClient([]Rule{{Category: "violence", Pattern: regexp.MustCompile(`(?i)\bgore\b`)}})
+
+	m, err := c.Moderate(context.Background(), "a seal covered in gore", "1001")
+	assert.NoError(t, err)
+	assert.Equal(t, &Moderation{Source: "rules", Flagged: true, Categories: []string{"violence"}, Scores: map[string]float64{"violence": 1}}, m)
+
+	text, err := c.GenerateText(context.Background(), "name this seal", "1001", DefaultParams.Text)
+	assert.NoError(t, err)
+	assert.Contains(t, localNames, text)
+
+	_, err = c.GenerateImage(context.Background(), "a seal", "1001", DefaultParams.Image)
+	assert.ErrorIs(t, err, ErrNoProviderAvailable)
+}
+
+// mockRoutingClient stands in for a providerClient, failing each image generation
+// call with the error configured for the provider it's routed to
+type mockRoutingClient struct {
+	mockClient
+	errs  map[string]error
+	calls []ImageParams
+}
+
+func (m *mockRoutingClient) getName(params ImageParams) string {
+	if params.Provider == "" {
+		return "openai"
+	}
+	return params.Provider
+}
+
+func (m *mockRoutingClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	m.calls = append(m.calls, params)
+	if err := m.errs[m.getName(params)]; err != nil {
+		return nil, err
+	}
+	return &Image{ContentType: "image/png", Data: fakePngData}, nil
+}
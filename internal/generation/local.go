@@ -0,0 +1,270 @@
+package generation
+
+import (
+	"bytes"
+	"context"
+	"hash/fnv"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// LocalRejectMarker may be included in a prompt (e.g. in the subject of an image
+// request) to make a local Client reject the request, as if it had been refused by the
+// generation API
+const LocalRejectMarker = "[reject]"
+
+// LocalErrorMarker may be included in a prompt to make a local Client fail with a
+// transient error, as if the generation API were unavailable
+const LocalErrorMarker = "[error]"
+
+// LocalFlagMarker may be included in a viewer's input to make a local Client flag it
+// during moderation, as if it had been judged objectionable
+const LocalFlagMarker = "[flag]"
+
+// LocalOptions configures the behavior of a local Client
+type LocalOptions struct {
+	// Latency is the amount of time that each call blocks before returning
+	Latency time.Duration
+	// RejectionRate is the probability (between 0 and 1) that any call will be rejected
+	RejectionRate float64
+	// ErrorRate is the probability (between 0 and 1) that any call will fail with a
+	// transient error
+	ErrorRate float64
+}
+
+// NewLocalClient returns a stand-in Client that generates images and text locally,
+// without calling any external APIs, so that the consumer can be run end-to-end during
+// development. Images are drawn procedurally, and text is chosen from a list of canned
+// names: both are deterministic for any given prompt. Latency, rejections, and
+// transient errors are simulated according to opts, and prompts that contain
+// LocalRejectMarker or LocalErrorMarker always fail in the corresponding way. Inputs
+// pass moderation unless they contain LocalFlagMarker.
+func NewLocalClient(opts LocalOptions) Client {
+	return &localClient{
+		opts:  opts,
+		rng:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		sleep: sleep,
+	}
+}
+
+type localClient struct {
+	opts  LocalOptions
+	mu    sync.Mutex
+	rng   *rand.Rand
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func (c *localClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	m := &Moderation{
+		Source: "local",
+		Scores: map[string]float64{"simulated": 0},
+	}
+	if strings.Contains(input, LocalFlagMarker) {
+		m.Flagged = true
+		m.Categories = []string{"simulated"}
+		m.Scores["simulated"] = 1
+	}
+	return m, nil
+}
+
+func (c *localClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	if err := c.simulate(ctx, prompt); err != nil {
+		return "", err
+	}
+	return localNames[hashPrompt(prompt)%uint64(len(localNames))], nil
+}
+
+func (c *localClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	if err := c.simulate(ctx, prompt); err != nil {
+		return nil, err
+	}
+	data, err := renderLocalImage(prompt)
+	if err != nil {
+		return nil, err
+	}
+	return &Image{
+		ContentType: "image/png",
+		Data:        data,
+	}, nil
+}
+
+// simulate blocks for the configured latency, then returns the error (if any) that
+// the call should fail with
+func (c *localClient) simulate(ctx context.Context, prompt string) error {
+	if c.opts.Latency > 0 {
+		if err := c.sleep(ctx, c.opts.Latency); err != nil {
+			return err
+		}
+	}
+
+	c.mu.Lock()
+	rejectRoll, errorRoll := c.rng.Float64(), c.rng.Float64()
+	c.mu.Unlock()
+
+	if strings.Contains(prompt, LocalRejectMarker) || rejectRoll < c.opts.RejectionRate {
+		return &rejectionError{"simulated rejection from local generation backend"}
+	}
+	if strings.Contains(prompt, LocalErrorMarker) || errorRoll < c.opts.ErrorRate {
+		return &StatusError{
+			StatusCode: http.StatusServiceUnavailable,
+			Message:    "simulated transient error from local generation backend",
+		}
+	}
+	return nil
+}
+
+// localNames are the canned responses returned by a local Client's GenerateText
+var localNames = []string{
+	"Bartholomew",
+	"Captain Wiggles",
+	"Dot",
+	"Gus",
+	"Marzipan",
+	"Noodle",
+	"Pickles",
+	"Professor Sprocket",
+	"Rewind",
+	"Tracking",
+}
+
+const localImageSize = 512
+const localBorderWidth = 48
+const localTextMargin = 16
+
+// chromaKeyRegex identifies prompts that call for an image with a solid, chroma-keyed
+// background (as with the friend style), capturing the desired background color
+var chromaKeyRegex = regexp.MustCompile(`solid (\S+) background suitable for chroma keying`)
+
+// localColors maps each of the colors that may be named in a prompt to an RGB value
+var localColors = map[genreq.Color]color.RGBA{
+	genreq.ColorRed:          {0xff, 0x00, 0x00, 0xff},
+	genreq.ColorRedOrange:    {0xff, 0x53, 0x00, 0xff},
+	genreq.ColorOrange:       {0xff, 0x80, 0x00, 0xff},
+	genreq.ColorYellowOrange: {0xff, 0xc0, 0x00, 0xff},
+	genreq.ColorYellow:       {0xff, 0xff, 0x00, 0xff},
+	genreq.ColorChartreuse:   {0x80, 0xff, 0x00, 0xff},
+	genreq.ColorGreen:        {0x00, 0xff, 0x00, 0xff},
+	genreq.ColorCyan:         {0x00, 0xff, 0xff, 0xff},
+	genreq.ColorSkyBlue:      {0x00, 0x80, 0xff, 0xff},
+	genreq.ColorBlue:         {0x00, 0x00, 0xff, 0xff},
+	genreq.ColorIndigo:       {0x4b, 0x00, 0x82, 0xff},
+	genreq.ColorPurple:       {0x80, 0x00, 0x80, 0xff},
+	genreq.ColorMagenta:      {0xff, 0x00, 0xff, 0xff},
+}
+
+// renderLocalImage draws a PNG image for the given prompt: the prompt text is rendered
+// onto a gradient whose colors are seeded from the prompt, and if the prompt calls for
+// a chroma-keyed background, the image is surrounded by a solid border of that color
+func renderLocalImage(prompt string) ([]byte, error) {
+	rng := rand.New(rand.NewSource(int64(hashPrompt(prompt))))
+	from := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+	to := color.RGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), 0xff}
+
+	img := image.NewRGBA(image.Rect(0, 0, localImageSize, localImageSize))
+	for y := 0; y < localImageSize; y++ {
+		t := float64(y) / float64(localImageSize-1)
+		c := color.RGBA{
+			lerp(from.R, to.R, t),
+			lerp(from.G, to.G, t),
+			lerp(from.B, to.B, t),
+			0xff,
+		}
+		draw.Draw(img, image.Rect(0, y, localImageSize, y+1), image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	inset := 0
+	if m := chromaKeyRegex.FindStringSubmatch(prompt); m != nil {
+		borderColor, ok := localColors[genreq.Color(m[1])]
+		if !ok {
+			borderColor = localColors[genreq.ColorGreen]
+		}
+		inner := image.Rect(localBorderWidth, localBorderWidth, localImageSize-localBorderWidth, localImageSize-localBorderWidth)
+		for _, r := range []image.Rectangle{
+			image.Rect(0, 0, localImageSize, inner.Min.Y),
+			image.Rect(0, inner.Max.Y, localImageSize, localImageSize),
+			image.Rect(0, inner.Min.Y, inner.Min.X, inner.Max.Y),
+			image.Rect(inner.Max.X, inner.Min.Y, localImageSize, inner.Max.Y),
+		} {
+			draw.Draw(img, r, image.NewUniform(borderColor), image.Point{}, draw.Src)
+		}
+		inset = localBorderWidth
+	}
+
+	drawLocalText(img, prompt, inset+localTextMargin)
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// drawLocalText renders text onto img, wrapped to fit within the given margin on all
+// sides, in white with a dark drop shadow so that it's legible on any background
+func drawLocalText(img *image.RGBA, text string, margin int) {
+	face := basicfont.Face7x13
+	maxWidth := localImageSize - 2*margin
+	lineHeight := face.Metrics().Height.Ceil()
+
+	var lines []string
+	line := ""
+	for _, word := range strings.Fields(text) {
+		candidate := word
+		if line != "" {
+			candidate = line + " " + word
+		}
+		if line != "" && font.MeasureString(face, candidate).Ceil() > maxWidth {
+			lines = append(lines, line)
+			candidate = word
+		}
+		line = candidate
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+
+	for i, line := range lines {
+		y := margin + (i+1)*lineHeight
+		if y > localImageSize-margin {
+			break
+		}
+		for _, pass := range []struct {
+			offset int
+			color  color.Color
+		}{
+			{1, color.Black},
+			{0, color.White},
+		} {
+			d := &font.Drawer{
+				Dst:  img,
+				Src:  image.NewUniform(pass.color),
+				Face: face,
+				Dot:  fixed.P(margin+pass.offset, y+pass.offset),
+			}
+			d.DrawString(line)
+		}
+	}
+}
+
+func lerp(a, b uint8, t float64) uint8 {
+	return uint8(float64(a) + (float64(b)-float64(a))*t)
+}
+
+func hashPrompt(prompt string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(prompt))
+	return h.Sum64()
+}
@@ -0,0 +1,143 @@
+package generation
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image/color"
+	"image/png"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_localClient_GenerateImage(t *testing.T) {
+	c := NewLocalClient(LocalOptions{})
+
+	// Images should be deterministic for any given prompt
+	a, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "user-1", DefaultParams.Image)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/png", a.ContentType)
+	b, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "user-2", DefaultParams.Image)
+	assert.NoError(t, err)
+	assert.Equal(t, a.Data, b.Data)
+	other, err := c.GenerateImage(context.Background(), "a ghostly image of a walrus", "user-1", DefaultParams.Image)
+	assert.NoError(t, err)
+	assert.NotEqual(t, a.Data, other.Data)
+
+	img, err := png.Decode(bytes.NewReader(a.Data))
+	assert.NoError(t, err)
+	assert.Equal(t, localImageSize, img.Bounds().Dx())
+	assert.Equal(t, localImageSize, img.Bounds().Dy())
+
+	// Prompts that call for a chroma-keyed background should get a solid border
+	friend, err := c.GenerateImage(context.Background(), "a green frog, illustrated in the style of 1990s digital clip art images, with a solid magenta background suitable for chroma keying", "user-1", DefaultParams.Image)
+	assert.NoError(t, err)
+	img, err = png.Decode(bytes.NewReader(friend.Data))
+	assert.NoError(t, err)
+	wantBorder := color.RGBA{0xff, 0x00, 0xff, 0xff}
+	for _, p := range [][2]int{{0, 0}, {localImageSize - 1, localImageSize - 1}, {localBorderWidth - 1, localImageSize / 2}} {
+		assert.Equal(t, wantBorder, color.RGBAModel.Convert(img.At(p[0], p[1])))
+	}
+}
+
+func Test_localClient_GenerateText(t *testing.T) {
+	c := NewLocalClient(LocalOptions{})
+	a, err := c.GenerateText(context.Background(), "name a frog", "user-1", DefaultParams.Text)
+	assert.NoError(t, err)
+	assert.Contains(t, localNames, a)
+	b, err := c.GenerateText(context.Background(), "name a frog", "user-1", DefaultParams.Text)
+	assert.NoError(t, err)
+	assert.Equal(t, a, b)
+}
+
+func Test_localClient_simulate(t *testing.T) {
+	tests := []struct {
+		name          string
+		opts          LocalOptions
+		prompt        string
+		wantRejected  bool
+		wantRetryable bool
+	}{
+		{
+			"ordinary prompt succeeds",
+			LocalOptions{},
+			"a ghostly image of a seal",
+			false,
+			false,
+		},
+		{
+			"reject marker is rejected",
+			LocalOptions{},
+			"a ghostly image of a seal [reject]",
+			true,
+			false,
+		},
+		{
+			"error marker fails with a transient error",
+			LocalOptions{},
+			"a ghostly image of a seal [error]",
+			false,
+			true,
+		},
+		{
+			"rejection rate of 1 rejects everything",
+			LocalOptions{RejectionRate: 1},
+			"a ghostly image of a seal",
+			true,
+			false,
+		},
+		{
+			"error rate of 1 fails everything",
+			LocalOptions{ErrorRate: 1},
+			"a ghostly image of a seal",
+			false,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var delays []time.Duration
+			c := NewLocalClient(tt.opts).(*localClient)
+			c.sleep = func(ctx context.Context, d time.Duration) error {
+				delays = append(delays, d)
+				return nil
+			}
+			c.opts.Latency = 3 * time.Second
+
+			_, err := c.GenerateImage(context.Background(), tt.prompt, "user-1", DefaultParams.Image)
+			assert.Equal(t, []time.Duration{3 * time.Second}, delays)
+			if !tt.wantRejected && !tt.wantRetryable {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Equal(t, tt.wantRejected, errors.Is(err, ErrRejected))
+				assert.Equal(t, tt.wantRetryable, isRetryable(err))
+			}
+		})
+	}
+}
+
+func Test_localClient_canceled(t *testing.T) {
+	c := NewLocalClient(LocalOptions{Latency: time.Hour})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GenerateText(ctx, "name a frog", "user-1", DefaultParams.Text)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func Test_localClient_Moderate(t *testing.T) {
+	c := NewLocalClient(LocalOptions{Latency: time.Hour, RejectionRate: 1})
+
+	m, err := c.Moderate(context.Background(), "a frog", "user-1")
+	assert.NoError(t, err)
+	assert.False(t, m.Flagged)
+	assert.NoError(t, m.Err())
+
+	m, err = c.Moderate(context.Background(), "a frog [flag]", "user-1")
+	assert.NoError(t, err)
+	assert.True(t, m.Flagged)
+	assert.Equal(t, []string{"simulated"}, m.Categories)
+	assert.ErrorIs(t, m.Err(), ErrRejected)
+}
@@ -0,0 +1,89 @@
+package generation
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// Moderation describes the result of screening user-supplied text before generation
+type Moderation struct {
+	// Source identifies what performed the moderation: "openai", "rules", or "local"
+	Source string
+	// Flagged is true if the input should be rejected
+	Flagged bool
+	// Categories lists the categories (e.g. "violence") in which the input was
+	// flagged, in alphabetical order
+	Categories []string
+	// Scores maps each category that was evaluated to a score between 0 and 1,
+	// indicating how strongly the input was judged to fall into that category
+	Scores map[string]float64
+}
+
+// Err returns a *ModerationError if the input was flagged, or nil otherwise
+func (m *Moderation) Err() error {
+	if !m.Flagged {
+		return nil
+	}
+	return &ModerationError{Categories: m.Categories}
+}
+
+// ModerationError indicates that a request was rejected because its inputs were
+// flagged during moderation. It unwraps to ErrRejected, and its message is suitable for
+// display to the viewer who made the request.
+type ModerationError struct {
+	Categories []string
+}
+
+// Error formats a moderation error, prefixed with the ErrRejected message and listing
+// the categories in which the input was flagged
+func (e *ModerationError) Error() string {
+	if len(e.Categories) == 0 {
+		return fmt.Sprintf("%v: prompt was flagged by moderation", ErrRejected)
+	}
+	return fmt.Sprintf("%v: prompt was flagged by moderation for %s", ErrRejected, strings.Join(e.Categories, ", "))
+}
+
+// Unwrap identifies a value of this type as synonymous with ErrRejected
+func (e *ModerationError) Unwrap() error {
+	return ErrRejected
+}
+
+// ErrorCode distinguishes requests that were flagged during moderation from those that
+// were rejected by the generation API itself
+func (e *ModerationError) ErrorCode() errcode.Code {
+	return CodeFlagged
+}
+
+// parseOpenaiModerationResult converts a result from the OpenAI moderations API to a
+// Moderation
+func parseOpenaiModerationResult(result *openai.Result) *Moderation {
+	m := &Moderation{
+		Source:  "openai",
+		Flagged: result.Flagged,
+		Scores:  make(map[string]float64),
+	}
+	for _, c := range []struct {
+		name    string
+		flagged bool
+		score   float32
+	}{
+		{"hate", result.Categories.Hate, result.CategoryScores.Hate},
+		{"hate/threatening", result.Categories.HateThreatening, result.CategoryScores.HateThreatening},
+		{"self-harm", result.Categories.SelfHarm, result.CategoryScores.SelfHarm},
+		{"sexual", result.Categories.Sexual, result.CategoryScores.Sexual},
+		{"sexual/minors", result.Categories.SexualMinors, result.CategoryScores.SexualMinors},
+		{"violence", result.Categories.Violence, result.CategoryScores.Violence},
+		{"violence/graphic", result.Categories.ViolenceGraphic, result.CategoryScores.ViolenceGraphic},
+	} {
+		m.Scores[c.name] = float64(c.score)
+		if c.flagged {
+			m.Categories = append(m.Categories, c.name)
+		}
+	}
+	sort.Strings(m.Categories)
+	return m
+}
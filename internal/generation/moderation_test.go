@@ -0,0 +1,47 @@
+package generation
+
+import (
+	"errors"
+	"testing"
+
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Moderation_Err(t *testing.T) {
+	m := &Moderation{Flagged: false}
+	assert.NoError(t, m.Err())
+
+	m = &Moderation{Flagged: true, Categories: []string{"hate", "violence"}}
+	err := m.Err()
+	assert.ErrorIs(t, err, ErrRejected)
+	assert.False(t, isRetryable(err))
+	assert.Equal(t, "image generation request rejected: prompt was flagged by moderation for hate, violence", err.Error())
+
+	var moderationErr *ModerationError
+	assert.True(t, errors.As(err, &moderationErr))
+	assert.Equal(t, []string{"hate", "violence"}, moderationErr.Categories)
+}
+
+func Test_parseOpenaiModerationResult(t *testing.T) {
+	m := parseOpenaiModerationResult(&openai.Result{
+		Flagged: true,
+		Categories: openai.ResultCategories{
+			ViolenceGraphic: true,
+			Violence:        true,
+		},
+		CategoryScores: openai.ResultCategoryScores{
+			Violence:        0.5,
+			ViolenceGraphic: 0.25,
+			Hate:            0.125,
+		},
+	})
+	assert.Equal(t, "openai", m.Source)
+	assert.True(t, m.Flagged)
+	assert.Equal(t, []string{"violence", "violence/graphic"}, m.Categories)
+	assert.Equal(t, 0.5, m.Scores["violence"])
+	assert.Equal(t, 0.25, m.Scores["violence/graphic"])
+	assert.Equal(t, 0.125, m.Scores["hate"])
+	assert.Equal(t, 0.0, m.Scores["sexual"])
+	assert.Len(t, m.Scores, 7)
+}
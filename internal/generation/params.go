@@ -0,0 +1,242 @@
+package generation
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	openai "github.com/sashabaranov/go-openai"
+)
+
+// ImageParams selects the provider and model used to generate an image, along with the
+// settings that the model is invoked with. Empty fields are omitted from the request,
+// leaving them up to the generation API.
+type ImageParams struct {
+	// Provider names the image provider that should generate the image, e.g.
+	// "automatic1111": if empty, the image is generated by our generation backend
+	Provider string `json:"provider,omitempty"`
+	// Model identifies the image generation model, e.g. "dall-e-3"
+	Model string `json:"model,omitempty"`
+	// Size is the size of the generated image, in the form "<width>x<height>"
+	Size string `json:"size,omitempty"`
+	// Quality is the rendering quality supported by some models, e.g. "standard" or
+	// "hd"
+	Quality string `json:"quality,omitempty"`
+	// Style is the rendering style supported by some models, e.g. "vivid" or
+	// "natural": it's unrelated to the style of the image request
+	Style string `json:"style,omitempty"`
+	// NegativePrompt describes anything that should be kept out of the image, for
+	// providers that support it (i.e. Stable Diffusion)
+	NegativePrompt string `json:"negativePrompt,omitempty"`
+	// Seed fixes the random seed used to generate the image, for providers that
+	// support it; if zero, a random seed is used
+	Seed int64 `json:"seed,omitempty"`
+	// Steps is the number of sampling steps, for providers that support it
+	Steps int `json:"steps,omitempty"`
+	// CfgScale determines how strictly the image adheres to the prompt, for providers
+	// that support it
+	CfgScale float64 `json:"cfgScale,omitempty"`
+	// Sampler names the sampling method, for providers that support it
+	Sampler string `json:"sampler,omitempty"`
+	// Backend holds the params that our generation backend should use if we fail over
+	// to it from the provider selected by Provider. In config, it only needs to set the
+	// fields that override DefaultParams.Image; StyleParams.Get resolves it to a full
+	// set of params.
+	Backend *ImageParams `json:"backend,omitempty"`
+}
+
+// TextParams selects the model used to generate text
+type TextParams struct {
+	// Model identifies the chat completion model, e.g. "gpt-3.5-turbo-0125"
+	Model string `json:"model,omitempty"`
+}
+
+// Params configures how the assets for an image request are generated
+type Params struct {
+	Image ImageParams `json:"image"`
+	Text  TextParams  `json:"text"`
+}
+
+// DefaultParams are used to generate assets for any style that doesn't override them
+var DefaultParams = Params{
+	Image: ImageParams{
+		Model:   openai.CreateImageModelDallE3,
+		Size:    openai.CreateImageSize1024x1024,
+		Quality: openai.CreateImageQualityStandard,
+		Style:   openai.CreateImageStyleVivid,
+	},
+	Text: TextParams{
+		Model: "gpt-3.5-turbo-0125",
+	},
+}
+
+// Override returns a copy of p in which each field that's set in other replaces the
+// corresponding value from p. If other selects a different image provider or model,
+// only the size is carried over from p, since the other settings may not be supported.
+func (p Params) Override(other Params) Params {
+	result := p
+	if other.Image.Provider != "" && other.Image.Provider != p.Image.Provider {
+		result.Image = ImageParams{Provider: other.Image.Provider, Size: p.Image.Size}
+	}
+	if other.Image.Model != "" && other.Image.Model != result.Image.Model {
+		result.Image = ImageParams{Provider: result.Image.Provider, Model: other.Image.Model, Size: result.Image.Size}
+	}
+	if other.Image.Size != "" {
+		result.Image.Size = other.Image.Size
+	}
+	if other.Image.Quality != "" {
+		result.Image.Quality = other.Image.Quality
+	}
+	if other.Image.Style != "" {
+		result.Image.Style = other.Image.Style
+	}
+	if other.Image.NegativePrompt != "" {
+		result.Image.NegativePrompt = other.Image.NegativePrompt
+	}
+	if other.Image.Seed != 0 {
+		result.Image.Seed = other.Image.Seed
+	}
+	if other.Image.Steps != 0 {
+		result.Image.Steps = other.Image.Steps
+	}
+	if other.Image.CfgScale != 0 {
+		result.Image.CfgScale = other.Image.CfgScale
+	}
+	if other.Image.Sampler != "" {
+		result.Image.Sampler = other.Image.Sampler
+	}
+	if other.Text.Model != "" {
+		result.Text.Model = other.Text.Model
+	}
+	return result
+}
+
+// StyleParams maps the name of each image style to the params that override
+// DefaultParams for requests of that style
+type StyleParams map[genreq.ImageStyle]Params
+
+// Get returns the params that should be used to generate assets for requests of the
+// given style. If the style selects an image provider other than our generation
+// backend, the params that the backend should use in its place are resolved as well.
+func (s StyleParams) Get(style genreq.ImageStyle) Params {
+	params := DefaultParams.Override(s[style])
+	if params.Image.Provider != "" {
+		backend := DefaultParams.Image
+		if override := s[style].Image.Backend; override != nil {
+			backend = DefaultParams.Override(Params{Image: *override}).Image
+		}
+		params.Image.Backend = &backend
+	}
+	return params
+}
+
+// LoadStyleParams reads per-style overrides from a JSON file that maps each style name
+// to a Params object, e.g. {"ghost":{"image":{"style":"natural"}}}
+func LoadStyleParams(path string) (StyleParams, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var params StyleParams
+	if err := json.Unmarshal(data, &params); err != nil {
+		return nil, fmt.Errorf("failed to parse generation params from %s: %w", path, err)
+	}
+	return params, nil
+}
+
+// ValidateOpenaiParams returns an error if the given params can't be used with the
+// OpenAI API, e.g. because the chosen image model doesn't support the requested size.
+// Image params are only validated if they don't select another image provider.
+func ValidateOpenaiParams(p Params) error {
+	if p.Text.Model == "" {
+		return fmt.Errorf("text model must be set")
+	}
+	if p.Image.Provider != "" {
+		if p.Image.Backend == nil {
+			return nil
+		}
+		if p.Image.Backend.Provider != "" || p.Image.Backend.Backend != nil {
+			return fmt.Errorf("backend params must not select another image provider")
+		}
+		if err := ValidateOpenaiParams(Params{Image: *p.Image.Backend, Text: p.Text}); err != nil {
+			return fmt.Errorf("invalid backend params: %w", err)
+		}
+		return nil
+	}
+
+	var sizes, qualities, styles []string
+	switch p.Image.Model {
+	case openai.CreateImageModelDallE3:
+		sizes = []string{openai.CreateImageSize1024x1024, openai.CreateImageSize1792x1024, openai.CreateImageSize1024x1792}
+		qualities = []string{openai.CreateImageQualityStandard, openai.CreateImageQualityHD}
+		styles = []string{openai.CreateImageStyleVivid, openai.CreateImageStyleNatural}
+	case openai.CreateImageModelDallE2:
+		sizes = []string{openai.CreateImageSize256x256, openai.CreateImageSize512x512, openai.CreateImageSize1024x1024}
+	default:
+		return fmt.Errorf("unsupported image model '%s'", p.Image.Model)
+	}
+	if err := validateOption("size", p.Image.Size, sizes); err != nil {
+		return err
+	}
+	if err := validateOption("quality", p.Image.Quality, qualities); err != nil {
+		return err
+	}
+	if err := validateOption("style", p.Image.Style, styles); err != nil {
+		return err
+	}
+	if p.Image.NegativePrompt != "" || p.Image.Seed != 0 || p.Image.Steps != 0 || p.Image.CfgScale != 0 || p.Image.Sampler != "" {
+		return fmt.Errorf("negativePrompt, seed, steps, cfgScale, and sampler require a Stable Diffusion image provider")
+	}
+	return nil
+}
+
+// ValidateStableDiffusionParams returns an error if the given image params can't be
+// used with a Stable Diffusion image provider
+func ValidateStableDiffusionParams(p ImageParams) error {
+	if p.Size != "" {
+		if _, _, err := parseSize(p.Size); err != nil {
+			return err
+		}
+	}
+	if err := validateOption("quality", p.Quality, nil); err != nil {
+		return err
+	}
+	if err := validateOption("style", p.Style, nil); err != nil {
+		return err
+	}
+	if p.Steps < 0 {
+		return fmt.Errorf("steps must not be negative")
+	}
+	if p.CfgScale < 0 {
+		return fmt.Errorf("cfgScale must not be negative")
+	}
+	return nil
+}
+
+// parseSize parses an image size in the form "<width>x<height>"
+func parseSize(size string) (int, int, error) {
+	var width, height int
+	_, err := fmt.Sscanf(size, "%dx%d", &width, &height)
+	if err != nil || width <= 0 || height <= 0 || fmt.Sprintf("%dx%d", width, height) != size {
+		return 0, 0, fmt.Errorf("invalid image size '%s' (expected '<width>x<height>')", size)
+	}
+	return width, height, nil
+}
+
+// validateOption returns an error if value is set and is not one of the values that
+// the image model supports for the named option
+func validateOption(name string, value string, supported []string) error {
+	if value == "" {
+		return nil
+	}
+	for _, s := range supported {
+		if value == s {
+			return nil
+		}
+	}
+	if len(supported) == 0 {
+		return fmt.Errorf("image model does not support %s", name)
+	}
+	return fmt.Errorf("unsupported image %s '%s' (expected one of %v)", name, value, supported)
+}
@@ -0,0 +1,234 @@
+package generation
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Params_Override(t *testing.T) {
+	tests := []struct {
+		name  string
+		other Params
+		want  Params
+	}{
+		{
+			"empty params change nothing",
+			Params{},
+			DefaultParams,
+		},
+		{
+			"individual settings are overridden",
+			Params{Image: ImageParams{Quality: "hd", Style: "natural"}},
+			Params{
+				Image: ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "hd", Style: "natural"},
+				Text:  TextParams{Model: "gpt-3.5-turbo-0125"},
+			},
+		},
+		{
+			"different image model discards quality and style",
+			Params{Image: ImageParams{Model: "dall-e-2", Size: "512x512"}, Text: TextParams{Model: "gpt-4o"}},
+			Params{
+				Image: ImageParams{Model: "dall-e-2", Size: "512x512"},
+				Text:  TextParams{Model: "gpt-4o"},
+			},
+		},
+		{
+			"different image provider discards model, quality, and style",
+			Params{Image: ImageParams{Provider: "automatic1111", NegativePrompt: "blurry", Steps: 30}},
+			Params{
+				Image: ImageParams{Provider: "automatic1111", Size: "1024x1024", NegativePrompt: "blurry", Steps: 30},
+				Text:  TextParams{Model: "gpt-3.5-turbo-0125"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := DefaultParams.Override(tt.other)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_LoadStyleParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    StyleParams
+		wantErr string
+	}{
+		{
+			"params are loaded for each style",
+			`{"ghost":{"image":{"style":"natural"}},"friend":{"text":{"model":"gpt-4o"}}}`,
+			StyleParams{
+				genreq.ImageStyleGhost:  {Image: ImageParams{Style: "natural"}},
+				genreq.ImageStyleFriend: {Text: TextParams{Model: "gpt-4o"}},
+			},
+			"",
+		},
+		{
+			"invalid JSON is an error",
+			`{"ghost":`,
+			nil,
+			"failed to parse generation params",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "params.json")
+			if err := os.WriteFile(path, []byte(tt.data), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			got, err := LoadStyleParams(path)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, "natural", got.Get(genreq.ImageStyleGhost).Image.Style)
+		})
+	}
+}
+
+func Test_StyleParams_Get(t *testing.T) {
+	params := StyleParams{
+		genreq.ImageStyleGhost:  {Image: ImageParams{Style: "natural"}},
+		genreq.ImageStyleFriend: {Image: ImageParams{Provider: "automatic1111", Steps: 30, Backend: &ImageParams{Style: "natural"}}},
+	}
+
+	// Styles that use our generation backend have no separate backend params
+	assert.Equal(t, ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"}, params.Get(genreq.ImageStyleGhost).Image)
+
+	// Styles that use another provider have their backend params resolved against the
+	// defaults, or use the defaults as-is if none are configured
+	assert.Equal(t, ImageParams{
+		Provider: "automatic1111",
+		Size:     "1024x1024",
+		Steps:    30,
+		Backend:  &ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"},
+	}, params.Get(genreq.ImageStyleFriend).Image)
+	params[genreq.ImageStyleFriend] = Params{Image: ImageParams{Provider: "automatic1111"}}
+	assert.Equal(t, &DefaultParams.Image, params.Get(genreq.ImageStyleFriend).Image.Backend)
+}
+
+func Test_ValidateOpenaiParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  Params
+		wantErr string
+	}{
+		{
+			"default params are valid",
+			DefaultParams,
+			"",
+		},
+		{
+			"dall-e-3 supports hd quality and natural style",
+			DefaultParams.Override(Params{Image: ImageParams{Size: "1792x1024", Quality: "hd", Style: "natural"}}),
+			"",
+		},
+		{
+			"dall-e-2 supports smaller sizes",
+			DefaultParams.Override(Params{Image: ImageParams{Model: "dall-e-2", Size: "256x256"}}),
+			"",
+		},
+		{
+			"dall-e-3 does not support smaller sizes",
+			DefaultParams.Override(Params{Image: ImageParams{Size: "512x512"}}),
+			"unsupported image size '512x512'",
+		},
+		{
+			"dall-e-2 does not support quality",
+			DefaultParams.Override(Params{Image: ImageParams{Model: "dall-e-2", Quality: "hd"}}),
+			"image model does not support quality",
+		},
+		{
+			"unknown image model is an error",
+			DefaultParams.Override(Params{Image: ImageParams{Model: "dall-e-9"}}),
+			"unsupported image model 'dall-e-9'",
+		},
+		{
+			"Stable Diffusion params are not supported",
+			DefaultParams.Override(Params{Image: ImageParams{NegativePrompt: "blurry"}}),
+			"negativePrompt, seed, steps, cfgScale, and sampler require a Stable Diffusion image provider",
+		},
+		{
+			"image params are not validated for other providers",
+			DefaultParams.Override(Params{Image: ImageParams{Provider: "comfyui", Size: "768x512"}}),
+			"",
+		},
+		{
+			"backend params are validated for other providers",
+			StyleParams{"ghost": {Image: ImageParams{Provider: "comfyui", Backend: &ImageParams{Style: "natural"}}}}.Get("ghost"),
+			"",
+		},
+		{
+			"invalid backend params are an error",
+			StyleParams{"ghost": {Image: ImageParams{Provider: "comfyui", Backend: &ImageParams{Size: "768x512"}}}}.Get("ghost"),
+			"invalid backend params: unsupported image size '768x512'",
+		},
+		{
+			"backend params must not select another provider",
+			Params{Image: ImageParams{Provider: "comfyui", Backend: &ImageParams{Provider: "automatic1111"}}, Text: DefaultParams.Text},
+			"backend params must not select another image provider",
+		},
+		{
+			"text model is required",
+			Params{Image: DefaultParams.Image},
+			"text model must be set",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateOpenaiParams(tt.params)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_ValidateStableDiffusionParams(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  ImageParams
+		wantErr string
+	}{
+		{
+			"arbitrary sizes are valid",
+			ImageParams{Provider: "automatic1111", Size: "768x512", Steps: 30, CfgScale: 6.5},
+			"",
+		},
+		{
+			"malformed size is an error",
+			ImageParams{Provider: "automatic1111", Size: "768x512px"},
+			"invalid image size '768x512px' (expected '<width>x<height>')",
+		},
+		{
+			"quality is not supported",
+			ImageParams{Provider: "comfyui", Quality: "hd"},
+			"image model does not support quality",
+		},
+		{
+			"negative steps are an error",
+			ImageParams{Provider: "comfyui", Steps: -1},
+			"steps must not be negative",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateStableDiffusionParams(tt.params)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
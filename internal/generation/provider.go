@@ -0,0 +1,50 @@
+package generation
+
+import (
+	"context"
+	"fmt"
+)
+
+// Names of the image providers that may be selected via ImageParams.Provider, in
+// addition to our generation backend
+const (
+	// ProviderAutomatic1111 generates images via the txt2img endpoint of a self-hosted
+	// Stable Diffusion web UI (AUTOMATIC1111)
+	ProviderAutomatic1111 = "automatic1111"
+	// ProviderComfyUI generates images by queueing a workflow on a self-hosted ComfyUI
+	// server
+	ProviderComfyUI = "comfyui"
+)
+
+// ImageGenerator is implemented by anything that can generate images, including every
+// Client as well as the standalone image providers that don't support moderation or
+// text generation
+type ImageGenerator interface {
+	GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error)
+}
+
+// NewProviderClient returns a Client that wraps c, generating images with the provider
+// named by each call's ImageParams.Provider. Calls that don't name a provider, along
+// with all moderation and text generation calls, are handled by c.
+func NewProviderClient(c Client, providers map[string]ImageGenerator) Client {
+	return &providerClient{
+		Client:    c,
+		providers: providers,
+	}
+}
+
+type providerClient struct {
+	Client
+	providers map[string]ImageGenerator
+}
+
+func (c *providerClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	if params.Provider == "" {
+		return c.Client.GenerateImage(ctx, prompt, opaqueUserId, params)
+	}
+	provider, ok := c.providers[params.Provider]
+	if !ok {
+		return nil, fmt.Errorf("image provider '%s' is not configured", params.Provider)
+	}
+	return provider.GenerateImage(ctx, prompt, opaqueUserId, params)
+}
@@ -0,0 +1,70 @@
+package generation
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_providerClient_GenerateImage(t *testing.T) {
+	tests := []struct {
+		name                 string
+		provider             string
+		wantErr              string
+		wantNumDefaultCalls  int
+		wantNumProviderCalls int
+	}{
+		{
+			"image is generated by default client if no provider is named",
+			"",
+			"",
+			1,
+			0,
+		},
+		{
+			"image is generated by named provider",
+			ProviderAutomatic1111,
+			"",
+			0,
+			1,
+		},
+		{
+			"unconfigured provider is an error",
+			ProviderComfyUI,
+			"image provider 'comfyui' is not configured",
+			0,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaultClient := &mockClient{}
+			provider := &mockClient{}
+			c := NewProviderClient(defaultClient, map[string]ImageGenerator{
+				ProviderAutomatic1111: provider,
+			})
+			image, err := c.GenerateImage(context.Background(), "a ghostly image of a seal", "1001", ImageParams{Provider: tt.provider})
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Nil(t, image)
+			} else {
+				assert.NoError(t, err)
+				assert.NotNil(t, image)
+			}
+			assert.Equal(t, tt.wantNumDefaultCalls, defaultClient.numCalls)
+			assert.Equal(t, tt.wantNumProviderCalls, provider.numCalls)
+		})
+	}
+}
+
+func Test_providerClient_GenerateText(t *testing.T) {
+	defaultClient := &mockClient{}
+	c := NewProviderClient(defaultClient, map[string]ImageGenerator{
+		ProviderAutomatic1111: &mockClient{},
+	})
+	text, err := c.GenerateText(context.Background(), "name a crab", "1001", DefaultParams.Text)
+	assert.NoError(t, err)
+	assert.Equal(t, "baby don't hurt me", text)
+	assert.Equal(t, 1, defaultClient.numCalls)
+}
@@ -0,0 +1,58 @@
+package generation
+
+import (
+	"context"
+
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"golang.org/x/time/rate"
+)
+
+// NewRateLimitedClient returns a Client that wraps c, waiting for a token from the
+// appropriate limiter before each call: image and text generation are limited
+// separately, since the generation API enforces separate rate limits for each. A nil
+// limiter imposes no limit.
+func NewRateLimitedClient(c Client, imageLimiter *rate.Limiter, textLimiter *rate.Limiter) Client {
+	return &rateLimitedClient{
+		c:            c,
+		imageLimiter: imageLimiter,
+		textLimiter:  textLimiter,
+	}
+}
+
+type rateLimitedClient struct {
+	c            Client
+	imageLimiter *rate.Limiter
+	textLimiter  *rate.Limiter
+}
+
+// Moderate is not rate-limited, since moderation calls are not subject to the same
+// limits as generation calls
+func (c *rateLimitedClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	return c.c.Moderate(ctx, input, opaqueUserId)
+}
+
+func (c *rateLimitedClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	if err := wait(ctx, c.textLimiter, "text"); err != nil {
+		return "", err
+	}
+	return c.c.GenerateText(ctx, prompt, opaqueUserId, params)
+}
+
+func (c *rateLimitedClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	if err := wait(ctx, c.imageLimiter, "image"); err != nil {
+		return nil, err
+	}
+	return c.c.GenerateImage(ctx, prompt, opaqueUserId, params)
+}
+
+// wait blocks until the given limiter (if any) permits another call of the given kind,
+// counting the call as rate-limited if no token is immediately available
+func wait(ctx context.Context, limiter *rate.Limiter, kind string) error {
+	if limiter == nil {
+		return nil
+	}
+	if limiter.Tokens() < 1 {
+		metrics.GenerationRateLimited.WithLabelValues(kind, "limiter").Inc()
+	}
+	return limiter.Wait(ctx)
+}
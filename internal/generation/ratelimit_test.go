@@ -0,0 +1,47 @@
+package generation
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/time/rate"
+)
+
+func Test_rateLimitedClient(t *testing.T) {
+	inner := &mockClient{}
+	imageLimiter := rate.NewLimiter(rate.Every(time.Hour), 1)
+	textLimiter := rate.NewLimiter(rate.Every(time.Hour), 2)
+	c := NewRateLimitedClient(inner, imageLimiter, textLimiter)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	// Our first image call should consume the only token in the image bucket, and the
+	// next call should fail since we'd have to wait far longer than our deadline
+	_, err := c.GenerateImage(ctx, "a ghost", "user-1", DefaultParams.Image)
+	assert.NoError(t, err)
+	_, err = c.GenerateImage(ctx, "another ghost", "user-1", DefaultParams.Image)
+	assert.Error(t, err)
+	assert.Equal(t, 1, inner.numCalls)
+
+	// Text calls are limited independently
+	_, err = c.GenerateText(ctx, "what is love?", "user-1", DefaultParams.Text)
+	assert.NoError(t, err)
+	_, err = c.GenerateText(ctx, "baby don't hurt me", "user-1", DefaultParams.Text)
+	assert.NoError(t, err)
+	_, err = c.GenerateText(ctx, "no more", "user-1", DefaultParams.Text)
+	assert.Error(t, err)
+	assert.Equal(t, 3, inner.numCalls)
+}
+
+func Test_rateLimitedClient_unlimited(t *testing.T) {
+	inner := &mockClient{}
+	c := NewRateLimitedClient(inner, nil, nil)
+	for i := 0; i < 10; i++ {
+		_, err := c.GenerateImage(context.Background(), "a ghost", "user-1", DefaultParams.Image)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 10, inner.numCalls)
+}
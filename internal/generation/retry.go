@@ -0,0 +1,248 @@
+package generation
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	openai "github.com/sashabaranov/go-openai"
+	"golang.org/x/exp/slog"
+)
+
+// AttemptRecorder records the outcome of each individual call made to a generation
+// API, for later auditing
+type AttemptRecorder interface {
+	RecordAttempt(ctx context.Context, arg queries.RecordAttemptParams) error
+}
+
+// RetryPolicy describes how many times (and how patiently) a generation call should
+// be retried when it fails with a transient error
+type RetryPolicy struct {
+	// MaxAttempts is the total number of calls that may be made, including the first
+	MaxAttempts int
+	// InitialDelay is the base delay before the first retry; it doubles with each
+	// subsequent retry, and the actual delay is randomly jittered
+	InitialDelay time.Duration
+	// MaxDelay caps the delay between attempts: if the API asks us to wait longer
+	// than this via Retry-After, we give up instead of retrying
+	MaxDelay time.Duration
+}
+
+// DefaultRetryPolicy is a reasonable RetryPolicy for OpenAI API calls
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:  5,
+	InitialDelay: 2 * time.Second,
+	MaxDelay:     time.Minute,
+}
+
+// NewRetryingClient returns a Client that wraps c, retrying failed calls with jittered
+// exponential backoff when they fail due to rate limiting, server errors, or network
+// errors. ErrRejected is never retried, and any other error that's returned once we
+// give up is categorized as CodeFailed. If the context supplied to each call carries
+// an image request ID (see WithImageRequestId), each attempt is recorded via recorder.
+func NewRetryingClient(logger *slog.Logger, c Client, recorder AttemptRecorder, policy RetryPolicy) Client {
+	return &retryingClient{
+		logger:   logger,
+		c:        c,
+		recorder: recorder,
+		policy:   policy,
+		sleep:    sleep,
+		jitter: func(d time.Duration) time.Duration {
+			return time.Duration(rand.Int63n(int64(d) + 1))
+		},
+	}
+}
+
+type retryingClient struct {
+	logger   *slog.Logger
+	c        Client
+	recorder AttemptRecorder
+	policy   RetryPolicy
+	sleep    func(ctx context.Context, d time.Duration) error
+	jitter   func(d time.Duration) time.Duration
+}
+
+func (c *retryingClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	var result *Moderation
+	err := c.retry(ctx, "moderation", func(ctx context.Context) error {
+		var err error
+		result, err = c.c.Moderate(ctx, input, opaqueUserId)
+		return err
+	})
+	return result, err
+}
+
+func (c *retryingClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	var result string
+	err := c.retry(ctx, "text", func(ctx context.Context) error {
+		var err error
+		result, err = c.c.GenerateText(ctx, prompt, opaqueUserId, params)
+		return err
+	})
+	return result, err
+}
+
+func (c *retryingClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	var result *Image
+	err := c.retry(ctx, "image", func(ctx context.Context) error {
+		var err error
+		result, err = c.c.GenerateImage(ctx, prompt, opaqueUserId, params)
+		return err
+	})
+	return result, err
+}
+
+func (c *retryingClient) retry(ctx context.Context, kind string, f func(ctx context.Context) error) error {
+	for attempt := 1; ; attempt++ {
+		// Make the call, then decide whether we should try again if it failed
+		startedAt := time.Now()
+		err := f(ctx)
+		willRetry := false
+		delay := time.Duration(0)
+		if err != nil && attempt < c.policy.MaxAttempts && isRetryable(err) {
+			delay = c.getDelay(attempt, err)
+			willRetry = delay <= c.policy.MaxDelay
+		}
+		c.recordAttempt(ctx, kind, attempt, startedAt, err, willRetry)
+		recordAttemptMetrics(kind, err)
+		if !willRetry {
+			return errcode.Wrap(CodeFailed, err)
+		}
+
+		// Wait before retrying, giving up if our context is canceled in the meantime
+		c.logger.Warn("Generation call failed; retrying after delay",
+			"kind", kind,
+			"attempt", attempt,
+			"delay", delay,
+			"error", err,
+		)
+		if err := c.sleep(ctx, delay); err != nil {
+			return errcode.Wrap(CodeFailed, err)
+		}
+	}
+}
+
+// getDelay returns the duration we should wait before the next attempt, given the
+// one-indexed number of the attempt that just failed with the given error
+func (c *retryingClient) getDelay(attempt int, err error) time.Duration {
+	// Double our delay with each successive attempt, up to the max
+	backoff := c.policy.InitialDelay
+	for i := 1; i < attempt && backoff < c.policy.MaxDelay; i++ {
+		backoff *= 2
+	}
+	if backoff > c.policy.MaxDelay {
+		backoff = c.policy.MaxDelay
+	}
+
+	// Jitter the delay so that concurrent requests don't all retry in lockstep
+	delay := backoff/2 + c.jitter(backoff/2)
+
+	// If the server explicitly told us how long to wait, wait at least that long
+	var retryAfterErr *RetryAfterError
+	if errors.As(err, &retryAfterErr) && retryAfterErr.RetryAfter > delay {
+		delay = retryAfterErr.RetryAfter
+	}
+	return delay
+}
+
+func (c *retryingClient) recordAttempt(ctx context.Context, kind string, attempt int, startedAt time.Time, err error, willRetry bool) {
+	imageRequestId, ok := GetImageRequestId(ctx)
+	if !ok {
+		return
+	}
+	errorMessage := sql.NullString{}
+	if err != nil {
+		errorMessage.Valid = true
+		errorMessage.String = err.Error()
+	}
+
+	// Record the attempt even if the generation call was aborted due to cancellation
+	if recordErr := c.recorder.RecordAttempt(context.WithoutCancel(ctx), queries.RecordAttemptParams{
+		ImageRequestID: imageRequestId,
+		Kind:           kind,
+		Number:         int32(attempt),
+		StartedAt:      startedAt,
+		ErrorMessage:   errorMessage,
+		WillRetry:      willRetry,
+	}); recordErr != nil {
+		c.logger.Error("Failed to record generation attempt", "imageRequestId", imageRequestId, "error", recordErr)
+	}
+}
+
+// isRetryable returns true if err indicates a transient failure (rate limiting, a
+// server error, or a network error) that may succeed if retried
+func isRetryable(err error) bool {
+	if errors.Is(err, ErrRejected) {
+		return false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	if errors.Is(err, ErrNoProviderAvailable) {
+		return true
+	}
+
+	if statusCode := getStatusCode(err); statusCode != 0 {
+		return isRetryableStatus(statusCode)
+	}
+
+	var netError net.Error
+	if errors.As(err, &netError) {
+		return true
+	}
+	return errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// recordAttemptMetrics counts a single call to the generation API, along with any rate
+// limiting that the API imposed on it
+func recordAttemptMetrics(kind string, err error) {
+	outcome := metrics.Outcome(err)
+	if errors.Is(err, ErrRejected) {
+		outcome = metrics.OutcomeRejected
+	}
+	metrics.GenerationCalls.WithLabelValues(kind, outcome).Inc()
+	if getStatusCode(err) == http.StatusTooManyRequests {
+		metrics.GenerationRateLimited.WithLabelValues(kind, "api").Inc()
+	}
+}
+
+// getStatusCode returns the HTTP status code carried by an error returned from the
+// generation API, or 0 if there is none
+func getStatusCode(err error) int {
+	apiError := &openai.APIError{}
+	if errors.As(err, &apiError) {
+		return apiError.HTTPStatusCode
+	}
+	requestError := &openai.RequestError{}
+	if errors.As(err, &requestError) {
+		return requestError.HTTPStatusCode
+	}
+	statusError := &StatusError{}
+	if errors.As(err, &statusError) {
+		return statusError.StatusCode
+	}
+	return 0
+}
+
+func isRetryableStatus(statusCode int) bool {
+	return statusCode == http.StatusTooManyRequests || statusCode == http.StatusRequestTimeout || statusCode >= 500
+}
+
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
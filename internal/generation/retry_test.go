@@ -0,0 +1,333 @@
+package generation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	openai "github.com/sashabaranov/go-openai"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_retryingClient(t *testing.T) {
+	imageRequestId := uuid.MustParse("3f6fd5c4-8f4f-4a7d-9e3c-2b8d1b6e5a10")
+	tests := []struct {
+		name       string
+		errs       []error
+		wantErr    error
+		wantDelays []time.Duration
+		wantCalls  int
+		wantRetry  []bool
+	}{
+		{
+			"successful call is made once",
+			nil,
+			nil,
+			nil,
+			1,
+			[]bool{false},
+		},
+		{
+			"rate limiting and server errors are retried with backoff",
+			[]error{
+				&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"},
+				&openai.RequestError{HTTPStatusCode: http.StatusBadGateway},
+				&StatusError{StatusCode: http.StatusServiceUnavailable},
+			},
+			nil,
+			[]time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+			4,
+			[]bool{true, true, true, false},
+		},
+		{
+			"Retry-After is honored when longer than backoff",
+			[]error{
+				&RetryAfterError{
+					Err:        &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"},
+					RetryAfter: 7 * time.Second,
+				},
+			},
+			nil,
+			[]time.Duration{7 * time.Second},
+			2,
+			[]bool{true, false},
+		},
+		{
+			"Retry-After longer than max delay is not retried",
+			[]error{
+				&RetryAfterError{
+					Err:        &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"},
+					RetryAfter: time.Hour,
+				},
+			},
+			&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"},
+			nil,
+			1,
+			[]bool{false},
+		},
+		{
+			"rejections are never retried",
+			[]error{
+				&rejectionError{"your prompt is bad"},
+			},
+			ErrRejected,
+			nil,
+			1,
+			[]bool{false},
+		},
+		{
+			"other client errors are not retried",
+			[]error{
+				&openai.APIError{HTTPStatusCode: http.StatusUnauthorized, Message: "bad key"},
+			},
+			&openai.APIError{HTTPStatusCode: http.StatusUnauthorized, Message: "bad key"},
+			nil,
+			1,
+			[]bool{false},
+		},
+		{
+			"gives up after max attempts",
+			[]error{
+				&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 1"},
+				&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 2"},
+				&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 3"},
+				&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 4"},
+				&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 5"},
+			},
+			&openai.APIError{HTTPStatusCode: http.StatusInternalServerError, Message: "oops 4"},
+			[]time.Duration{1 * time.Second, 2 * time.Second, 4 * time.Second},
+			4,
+			[]bool{true, true, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inner := &mockClient{errs: tt.errs}
+			recorder := &mockAttemptRecorder{}
+			var delays []time.Duration
+			c := &retryingClient{
+				logger:   slog.Default(),
+				c:        inner,
+				recorder: recorder,
+				policy: RetryPolicy{
+					MaxAttempts:  4,
+					InitialDelay: 2 * time.Second,
+					MaxDelay:     30 * time.Second,
+				},
+				sleep: func(ctx context.Context, d time.Duration) error {
+					delays = append(delays, d)
+					return nil
+				},
+				jitter: func(d time.Duration) time.Duration {
+					return 0
+				},
+			}
+
+			ctx := WithImageRequestId(context.Background(), imageRequestId)
+			result, err := c.GenerateText(ctx, "what is love?", "user-1", DefaultParams.Text)
+			if tt.wantErr == nil {
+				assert.NoError(t, err)
+				assert.Equal(t, "baby don't hurt me", result)
+			} else if errors.Is(tt.wantErr, ErrRejected) {
+				assert.ErrorIs(t, err, ErrRejected)
+				assert.Equal(t, CodeRejected, errcode.Of(err))
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr.Error())
+				assert.Equal(t, CodeFailed, errcode.Of(err))
+			}
+			assert.Equal(t, tt.wantCalls, inner.numCalls)
+			assert.Equal(t, tt.wantDelays, delays)
+
+			assert.Len(t, recorder.attempts, len(tt.wantRetry))
+			for i, attempt := range recorder.attempts {
+				assert.Equal(t, imageRequestId, attempt.ImageRequestID)
+				assert.Equal(t, "text", attempt.Kind)
+				assert.Equal(t, int32(i+1), attempt.Number)
+				assert.Equal(t, tt.wantRetry[i], attempt.WillRetry)
+				isLast := i == len(recorder.attempts)-1
+				assert.Equal(t, !isLast || tt.wantErr != nil, attempt.ErrorMessage.Valid)
+			}
+		})
+	}
+}
+
+func Test_retryingClient_stopsWhenCanceled(t *testing.T) {
+	inner := &mockClient{errs: []error{
+		&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests, Message: "slow down"},
+	}}
+	c := &retryingClient{
+		logger:   slog.Default(),
+		c:        inner,
+		recorder: &mockAttemptRecorder{},
+		policy:   DefaultRetryPolicy,
+		sleep:    sleep,
+		jitter: func(d time.Duration) time.Duration {
+			return 0
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := c.GenerateImage(ctx, "a ghost", "user-1", DefaultParams.Image)
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, inner.numCalls)
+}
+
+func Test_retryingClient_withoutImageRequestId(t *testing.T) {
+	recorder := &mockAttemptRecorder{}
+	c := NewRetryingClient(slog.Default(), &mockClient{}, recorder, DefaultRetryPolicy)
+	_, err := c.GenerateImage(context.Background(), "a ghost", "user-1", DefaultParams.Image)
+	assert.NoError(t, err)
+	assert.Empty(t, recorder.attempts)
+}
+
+func Test_isRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{&rejectionError{"nope"}, false},
+		{fmt.Errorf("wrapped: %w", &rejectionError{"nope"}), false},
+		{context.Canceled, false},
+		{&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusInternalServerError}, true},
+		{&openai.APIError{HTTPStatusCode: http.StatusBadRequest}, false},
+		{&openai.RequestError{HTTPStatusCode: http.StatusServiceUnavailable}, true},
+		{&StatusError{StatusCode: http.StatusNotFound}, false},
+		{&RetryAfterError{Err: &openai.APIError{HTTPStatusCode: http.StatusTooManyRequests}, RetryAfter: time.Second}, true},
+		{&mockNetError{}, true},
+		{fmt.Errorf("expected 1 result image from OpenAI; got 0"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.want, isRetryable(tt.err))
+		})
+	}
+}
+
+func Test_parseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"20", 20 * time.Second},
+		{"-5", 0},
+		{"Thu, 01 Feb 2024 12:00:30 GMT", 30 * time.Second},
+		{"Thu, 01 Feb 2024 11:59:00 GMT", 0},
+		{"soon", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			assert.Equal(t, tt.want, parseRetryAfter(tt.value, now))
+		})
+	}
+}
+
+type mockClient struct {
+	errs     []error
+	numCalls int
+}
+
+func (m *mockClient) next() error {
+	m.numCalls++
+	if m.numCalls <= len(m.errs) {
+		return m.errs[m.numCalls-1]
+	}
+	return nil
+}
+
+func (m *mockClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	if err := m.next(); err != nil {
+		return nil, err
+	}
+	return &Moderation{Source: "mock"}, nil
+}
+
+func (m *mockClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	if err := m.next(); err != nil {
+		return "", err
+	}
+	return "baby don't hurt me", nil
+}
+
+func (m *mockClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	if err := m.next(); err != nil {
+		return nil, err
+	}
+	return &Image{ContentType: "image/png", Data: []byte("png")}, nil
+}
+
+type mockAttemptRecorder struct {
+	attempts []queries.RecordAttemptParams
+}
+
+func (m *mockAttemptRecorder) RecordAttempt(ctx context.Context, arg queries.RecordAttemptParams) error {
+	m.attempts = append(m.attempts, arg)
+	return nil
+}
+
+type mockNetError struct{}
+
+func (e *mockNetError) Error() string   { return "connection reset by peer" }
+func (e *mockNetError) Timeout() bool   { return false }
+func (e *mockNetError) Temporary() bool { return true }
+
+func Test_recordAttemptMetrics(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		wantOutcome     string
+		wantRateLimited bool
+	}{
+		{
+			"successful call",
+			nil,
+			"succeeded",
+			false,
+		},
+		{
+			"rejected call",
+			&rejectionError{"your prompt is bad"},
+			"rejected",
+			false,
+		},
+		{
+			"rate-limited call",
+			&openai.APIError{HTTPStatusCode: http.StatusTooManyRequests},
+			"failed",
+			true,
+		},
+		{
+			"failed call",
+			&StatusError{StatusCode: http.StatusInternalServerError},
+			"failed",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			calls := metrics.GenerationCalls.WithLabelValues("image", tt.wantOutcome)
+			rateLimited := metrics.GenerationRateLimited.WithLabelValues("image", "api")
+			callsBefore := testutil.ToFloat64(calls)
+			rateLimitedBefore := testutil.ToFloat64(rateLimited)
+
+			recordAttemptMetrics("image", tt.err)
+			assert.Equal(t, callsBefore+1, testutil.ToFloat64(calls))
+			wantRateLimited := rateLimitedBefore
+			if tt.wantRateLimited {
+				wantRateLimited++
+			}
+			assert.Equal(t, wantRateLimited, testutil.ToFloat64(rateLimited))
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package generation
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusError is returned when an HTTP request made in the course of generation
+// (other than a call to the generation API itself) yields an unexpected status code
+type StatusError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s: got status %d", e.Message, e.StatusCode)
+}
+
+// RetryAfterError wraps an error that resulted from an HTTP response carrying a
+// Retry-After header, indicating how long the server asked us to wait before trying
+// again
+type RetryAfterError struct {
+	Err        error
+	RetryAfter time.Duration
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// retryAfterHint is populated by retryAfterTransport with the Retry-After value from
+// the most recent response received in the context of a single generation call
+type retryAfterHint struct {
+	value time.Duration
+}
+
+type retryAfterHintKey struct{}
+
+// withRetryAfterHint returns a copy of ctx that will collect any Retry-After value
+// from HTTP responses, provided that requests are sent via retryAfterTransport
+func withRetryAfterHint(ctx context.Context) (context.Context, *retryAfterHint) {
+	hint := &retryAfterHint{}
+	return context.WithValue(ctx, retryAfterHintKey{}, hint), hint
+}
+
+// wrapRetryAfter annotates err with the Retry-After value collected in hint, if any
+func wrapRetryAfter(err error, hint *retryAfterHint) error {
+	if err == nil || hint.value <= 0 {
+		return err
+	}
+	return &RetryAfterError{Err: err, RetryAfter: hint.value}
+}
+
+// retryAfterTransport is an http.RoundTripper that records the Retry-After header
+// from each response in the retryAfterHint carried by the request context: the OpenAI
+// client library doesn't expose response headers on errors, so this is how we find
+// out how long we've been asked to back off
+type retryAfterTransport struct {
+	next http.RoundTripper
+}
+
+func (t *retryAfterTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	res, err := t.next.RoundTrip(req)
+	if err == nil {
+		if hint, ok := req.Context().Value(retryAfterHintKey{}).(*retryAfterHint); ok {
+			hint.value = parseRetryAfter(res.Header.Get("retry-after"), time.Now())
+		}
+	}
+	return res, err
+}
+
+// parseRetryAfter interprets the value of a Retry-After header, which may be either a
+// number of seconds or an HTTP date, returning 0 if the value is absent or invalid
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+	if t, err := http.ParseTime(value); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
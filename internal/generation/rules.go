@@ -0,0 +1,99 @@
+package generation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Rule flags any input that matches Pattern as belonging to Category
+type Rule struct {
+	Category string
+	Pattern  *regexp.Regexp
+}
+
+// ParseRules reads moderation rules from r, one per line, in the form
+// "category: pattern". A pattern enclosed in slashes (e.g. "/\bgor(e|y)\b/") is a
+// regular expression; any other pattern is a blocklisted word or phrase, which only
+// matches whole words. All patterns are case-insensitive. Blank lines and lines
+// beginning with '#' are ignored.
+func ParseRules(r io.Reader) ([]Rule, error) {
+	var rules []Rule
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		category, pattern, ok := strings.Cut(line, ":")
+		category = strings.TrimSpace(category)
+		pattern = strings.TrimSpace(pattern)
+		if !ok || category == "" || pattern == "" {
+			return nil, fmt.Errorf("line %d: expected 'category: pattern'", lineNumber)
+		}
+
+		expr := `\b` + regexp.QuoteMeta(pattern) + `\b`
+		if len(pattern) >= 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			expr = pattern[1 : len(pattern)-1]
+		}
+		re, err := regexp.Compile("(?i)" + expr)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNumber, err)
+		}
+		rules = append(rules, Rule{Category: category, Pattern: re})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return rules, nil
+}
+
+// NewRulesModeratingClient returns a Client that wraps c, moderating inputs against the
+// given rules instead of calling c's Moderate. This allows prompts to be screened
+// without an external moderation API. Each category that has any rules is given a
+// score of 1 if any of its rules match, or 0 otherwise.
+func NewRulesModeratingClient(c Client, rules []Rule) Client {
+	return &rulesModeratingClient{
+		c:     c,
+		rules: rules,
+	}
+}
+
+type rulesModeratingClient struct {
+	c     Client
+	rules []Rule
+}
+
+func (c *rulesModeratingClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*Moderation, error) {
+	m := &Moderation{
+		Source: "rules",
+		Scores: make(map[string]float64),
+	}
+	for _, rule := range c.rules {
+		if m.Scores[rule.Category] > 0 {
+			continue
+		}
+		m.Scores[rule.Category] = 0
+		if rule.Pattern.MatchString(input) {
+			m.Scores[rule.Category] = 1
+			m.Flagged = true
+			m.Categories = append(m.Categories, rule.Category)
+		}
+	}
+	sort.Strings(m.Categories)
+	return m, nil
+}
+
+func (c *rulesModeratingClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params TextParams) (string, error) {
+	return c.c.GenerateText(ctx, prompt, opaqueUserId, params)
+}
+
+func (c *rulesModeratingClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params ImageParams) (*Image, error) {
+	return c.c.GenerateImage(ctx, prompt, opaqueUserId, params)
+}
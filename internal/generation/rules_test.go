@@ -0,0 +1,115 @@
+package generation
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ParseRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		want    map[string]string
+		wantErr string
+	}{
+		{
+			"words and regexes are parsed",
+			"# comment\n\nviolence: stab\ngore: /\\bgor(e|y)\\b/\n",
+			map[string]string{
+				"violence": `(?i)\bstab\b`,
+				"gore":     `(?i)\bgor(e|y)\b`,
+			},
+			"",
+		},
+		{
+			"words are escaped",
+			"spam: buy now!",
+			map[string]string{
+				"spam": `(?i)\bbuy now!\b`,
+			},
+			"",
+		},
+		{
+			"missing pattern is an error",
+			"violence:",
+			nil,
+			"line 1: expected 'category: pattern'",
+		},
+		{
+			"invalid regex is an error",
+			"# comment\nviolence: /(/",
+			nil,
+			"line 2: error parsing regexp",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rules, err := ParseRules(strings.NewReader(tt.input))
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+				return
+			}
+			assert.NoError(t, err)
+			got := make(map[string]string)
+			for _, rule := range rules {
+				got[rule.Category] = rule.Pattern.String()
+			}
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_rulesModeratingClient_Moderate(t *testing.T) {
+	rules, err := ParseRules(strings.NewReader(`
+violence: stab
+violence: /\bshoot(ing)?\b/
+gore: gore
+`))
+	assert.NoError(t, err)
+	c := NewRulesModeratingClient(&mockClient{}, rules)
+
+	tests := []struct {
+		input          string
+		wantFlagged    bool
+		wantCategories []string
+		wantScores     map[string]float64
+	}{
+		{
+			"a friendly seal",
+			false,
+			nil,
+			map[string]float64{"violence": 0, "gore": 0},
+		},
+		{
+			"a seal about to STAB someone",
+			true,
+			[]string{"violence"},
+			map[string]float64{"violence": 1, "gore": 0},
+		},
+		{
+			"a shooting star, covered in gore",
+			true,
+			[]string{"gore", "violence"},
+			map[string]float64{"violence": 1, "gore": 1},
+		},
+		{
+			"a stable full of horses",
+			false,
+			nil,
+			map[string]float64{"violence": 0, "gore": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			m, err := c.Moderate(context.Background(), tt.input, "user-1")
+			assert.NoError(t, err)
+			assert.Equal(t, "rules", m.Source)
+			assert.Equal(t, tt.wantFlagged, m.Flagged)
+			assert.Equal(t, tt.wantCategories, m.Categories)
+			assert.Equal(t, tt.wantScores, m.Scores)
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Package health implements liveness and readiness probes for the consumer process, so
+// that an orchestrator such as Kubernetes can tell when the consumer is unable to do
+// its job: a consumer that fails its readiness checks has lost access to one of its
+// dependencies, and a consumer that fails its liveness checks has stopped making
+// progress and should be restarted
+package health
@@ -0,0 +1,101 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// DefaultTimeout is the maximum amount of time that any single check may take before
+// it's considered to have failed
+const DefaultTimeout = 5 * time.Second
+
+type Server struct {
+	liveness  map[string]Check
+	readiness map[string]Check
+	timeout   time.Duration
+}
+
+// NewServer prepares a Server that will run the given liveness and readiness checks,
+// keyed by name, in response to health probes
+func NewServer(liveness map[string]Check, readiness map[string]Check, timeout time.Duration) *Server {
+	return &Server{
+		liveness:  liveness,
+		readiness: readiness,
+		timeout:   timeout,
+	}
+}
+
+func (s *Server) RegisterRoutes(r *mux.Router) {
+	r.Path("/healthz").Methods("GET").HandlerFunc(s.handleLiveness)
+	r.Path("/readyz").Methods("GET").HandlerFunc(s.handleReadiness)
+}
+
+func (s *Server) handleLiveness(res http.ResponseWriter, req *http.Request) {
+	s.respond(res, run(req.Context(), s.liveness, s.timeout))
+}
+
+func (s *Server) handleReadiness(res http.ResponseWriter, req *http.Request) {
+	s.respond(res, run(req.Context(), s.readiness, s.timeout))
+}
+
+func (s *Server) respond(res http.ResponseWriter, report *Report) {
+	res.Header().Set("content-type", "application/json")
+	if report.Status != StatusOk {
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(res).Encode(report); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+// run executes all checks concurrently, allowing each to take no longer than timeout,
+// and reports on the results: if any check fails, the overall status is failing
+func run(ctx context.Context, checks map[string]Check, timeout time.Duration) *Report {
+	report := &Report{
+		Status: StatusOk,
+		Checks: make(map[string]string, len(checks)),
+	}
+
+	mu := &sync.Mutex{}
+	wg := &sync.WaitGroup{}
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, timeout)
+			defer cancel()
+			err := runCheck(checkCtx, check)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				report.Status = StatusFailing
+				report.Checks[name] = err.Error()
+			} else {
+				report.Checks[name] = string(StatusOk)
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+// runCheck runs a single check, giving up once ctx is done even if the check ignores
+// its context
+func runCheck(ctx context.Context, check Check) error {
+	result := make(chan error, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
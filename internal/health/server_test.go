@@ -0,0 +1,90 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server(t *testing.T) {
+	ok := func(ctx context.Context) error { return nil }
+	failing := func(ctx context.Context) error { return fmt.Errorf("connection refused") }
+	hanging := func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		liveness   map[string]Check
+		readiness  map[string]Check
+		wantStatus int
+		wantReport Report
+	}{
+		{
+			"live",
+			"/healthz",
+			map[string]Check{"consumer": ok},
+			map[string]Check{"postgres": failing},
+			http.StatusOK,
+			Report{Status: StatusOk, Checks: map[string]string{"consumer": "ok"}},
+		},
+		{
+			"not live",
+			"/healthz",
+			map[string]Check{"consumer": failing},
+			nil,
+			http.StatusServiceUnavailable,
+			Report{Status: StatusFailing, Checks: map[string]string{"consumer": "connection refused"}},
+		},
+		{
+			"ready",
+			"/readyz",
+			nil,
+			map[string]Check{"postgres": ok, "amqp": ok},
+			http.StatusOK,
+			Report{Status: StatusOk, Checks: map[string]string{"postgres": "ok", "amqp": "ok"}},
+		},
+		{
+			"not ready if any check fails",
+			"/readyz",
+			nil,
+			map[string]Check{"postgres": ok, "amqp": failing},
+			http.StatusServiceUnavailable,
+			Report{Status: StatusFailing, Checks: map[string]string{"postgres": "ok", "amqp": "connection refused"}},
+		},
+		{
+			"checks that take too long fail",
+			"/readyz",
+			nil,
+			map[string]Check{"storage": hanging},
+			http.StatusServiceUnavailable,
+			Report{Status: StatusFailing, Checks: map[string]string{"storage": "context deadline exceeded"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.liveness, tt.readiness, 50*time.Millisecond)
+			r := mux.NewRouter()
+			s.RegisterRoutes(r)
+
+			req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+			assert.Equal(t, tt.wantStatus, res.Code)
+
+			var report Report
+			err := json.Unmarshal(res.Body.Bytes(), &report)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantReport, report)
+		})
+	}
+}
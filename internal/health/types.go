@@ -0,0 +1,22 @@
+package health
+
+import "context"
+
+// Check verifies a single aspect of the process's health, returning an error that
+// describes the problem if it's unhealthy
+type Check func(ctx context.Context) error
+
+// Status summarizes the result of a set of checks
+type Status string
+
+const (
+	StatusOk      Status = "ok"
+	StatusFailing Status = "failing"
+)
+
+// Report is returned in response to a health probe: Checks maps the name of each check
+// to "ok" or to the error that it failed with
+type Report struct {
+	Status Status            `json:"status"`
+	Checks map[string]string `json:"checks"`
+}
@@ -0,0 +1,5 @@
+// Package metrics defines the Prometheus metrics reported by the consumer, so that we
+// can monitor (and alert on) its throughput, failure rates, and latency: the Handler
+// exposes these metrics, along with the Go runtime's own metrics, in the Prometheus
+// text format
+package metrics
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "dynamo"
+
+// Operation identifies a call to an external dependency whose latency we track
+type Operation string
+
+const (
+	OperationAuthToken       Operation = "auth_token"
+	OperationLedger          Operation = "ledger"
+	OperationModeration      Operation = "moderation"
+	OperationTextGeneration  Operation = "text_generation"
+	OperationImageGeneration Operation = "image_generation"
+	OperationImf             Operation = "imf"
+	OperationUpload          Operation = "upload"
+	OperationProduce         Operation = "produce"
+)
+
+// Outcome values used to label counters
+const (
+	OutcomeSucceeded = "succeeded"
+	OutcomeFailed    = "failed"
+	OutcomeRejected  = "rejected"
+)
+
+var (
+	// RequestsConsumed counts the generation requests we've consumed, by style: each
+	// redelivery of the same message is counted again
+	RequestsConsumed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_consumed_total",
+		Help:      "Number of generation requests consumed, by image style.",
+	}, []string{"style"})
+
+	// RequestsFinished counts the image requests that have finished processing, by
+	// style and outcome: error_code is the errcode.Code of the error that caused a
+	// failed request to fail, and is empty for successful requests
+	RequestsFinished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_finished_total",
+		Help:      "Number of image requests that finished processing, by image style, outcome, and error code.",
+	}, []string{"style", "outcome", "error_code"})
+
+	// RequestsInFlight reports the number of generation requests currently being
+	// handled, i.e. the number of busy workers
+	RequestsInFlight = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "requests_in_flight",
+		Help:      "Number of generation requests currently being handled.",
+	})
+
+	// OperationDuration records the latency of each call we make to an external
+	// dependency in the course of processing a request
+	OperationDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Latency of calls to external dependencies, by operation.",
+		Buckets:   []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120},
+	}, []string{"operation"})
+
+	// GenerationCalls counts each individual call made to the generation API (including
+	// retries), by kind ("moderation", "text", or "image") and outcome
+	GenerationCalls = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generation_calls_total",
+		Help:      "Number of calls made to the generation API, by kind and outcome.",
+	}, []string{"kind", "outcome"})
+
+	// GenerationRateLimited counts the times a generation call was held up by rate
+	// limiting, by kind and source: "limiter" if our own token bucket was exhausted,
+	// or "api" if the generation API responded with a 429
+	GenerationRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generation_rate_limited_total",
+		Help:      "Number of generation calls that were rate-limited, by kind and source.",
+	}, []string{"kind", "source"})
+
+	// GenerationFailovers counts the generation calls that failed with a transient
+	// error and were retried with the next provider in the failover chain (or, for
+	// moderation and text generation, with the fallback client), by the provider that
+	// failed
+	GenerationFailovers = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generation_failovers_total",
+		Help:      "Number of generation calls that failed over to another provider, by the provider that failed.",
+	}, []string{"provider"})
+
+	// GenerationCircuitBreakerTrips counts the times an image provider's circuit
+	// breaker has tripped after repeated failures, by provider
+	GenerationCircuitBreakerTrips = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "generation_circuit_breaker_trips_total",
+		Help:      "Number of times an image provider's circuit breaker tripped, by provider.",
+	}, []string{"provider"})
+
+	// DiscordPosts counts the alerts we've posted to Discord webhooks, by channel
+	// ("ghosts" or "friends") and outcome
+	DiscordPosts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "discord_posts_total",
+		Help:      "Number of alerts posted to Discord webhooks, by channel and outcome.",
+	}, []string{"channel", "outcome"})
+)
+
+// ObserveDuration records the time elapsed since startedAt as the latency of the given
+// operation: it's intended to be deferred, i.e.
+// defer metrics.ObserveDuration(metrics.OperationUpload, time.Now())
+func ObserveDuration(op Operation, startedAt time.Time) {
+	OperationDuration.WithLabelValues(string(op)).Observe(time.Since(startedAt).Seconds())
+}
+
+// Outcome returns OutcomeSucceeded if err is nil, or OutcomeFailed otherwise
+func Outcome(err error) string {
+	if err != nil {
+		return OutcomeFailed
+	}
+	return OutcomeSucceeded
+}
+
+// Handler returns an HTTP handler that serves all registered metrics in the Prometheus
+// text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,41 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Outcome(t *testing.T) {
+	assert.Equal(t, OutcomeSucceeded, Outcome(nil))
+	assert.Equal(t, OutcomeFailed, Outcome(fmt.Errorf("oh no")))
+}
+
+func Test_Handler(t *testing.T) {
+	RequestsConsumed.WithLabelValues("ghost").Inc()
+	RequestsFinished.WithLabelValues("ghost", OutcomeFailed, "generation.rejected").Inc()
+	DiscordPosts.WithLabelValues("ghosts", OutcomeSucceeded).Inc()
+	ObserveDuration(OperationUpload, time.Now().Add(-time.Second))
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	res := httptest.NewRecorder()
+	Handler().ServeHTTP(res, req)
+	assert.Equal(t, http.StatusOK, res.Code)
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	for _, want := range []string{
+		`dynamo_requests_consumed_total{style="ghost"}`,
+		`dynamo_requests_finished_total{error_code="generation.rejected",outcome="failed",style="ghost"}`,
+		`dynamo_discord_posts_total{channel="ghosts",outcome="succeeded"}`,
+		`dynamo_operation_duration_seconds_count{operation="upload"} 1`,
+		`dynamo_requests_in_flight`,
+		`go_goroutines`,
+	} {
+		assert.Contains(t, string(body), want)
+	}
+}
@@ -0,0 +1,5 @@
+// Package moderation implements API routes that allow the broadcaster to take down an
+// image that was generated in response to a viewer's request: the request is marked as
+// removed, its stored images are deleted or made private, the overlay is told to stop
+// displaying the alert, and the viewer is optionally refunded the points they spent
+package moderation
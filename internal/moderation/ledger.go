@@ -0,0 +1,87 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/google/uuid"
+)
+
+// RefundClient credits points back to a viewer whose image was taken down
+type RefundClient interface {
+	// CreditPoints grants the given number of points to the Twitch user identified by
+	// twitchUserId, returning the ID of the resulting ledger transaction. accessToken
+	// must belong to the broadcaster.
+	CreditPoints(ctx context.Context, accessToken string, twitchUserId string, numPointsToCredit int, note string) (uuid.UUID, error)
+}
+
+// NewRefundClient initializes an HTTP client that issues refunds as manual credits via
+// the golden-vcr/ledger server running at the given URL
+func NewRefundClient(ledgerUrl string) RefundClient {
+	return &refundClient{
+		ledgerUrl: ledgerUrl,
+	}
+}
+
+type refundClient struct {
+	http.Client
+	ledgerUrl string
+}
+
+// manualCreditRequest is the payload accepted by the ledger's POST /inflow/manual-credit
+// endpoint
+type manualCreditRequest struct {
+	TwitchUserId      string `json:"twitchUserId"`
+	NumPointsToCredit int    `json:"numPointsToCredit"`
+	Note              string `json:"note"`
+}
+
+func (c *refundClient) CreditPoints(ctx context.Context, accessToken string, twitchUserId string, numPointsToCredit int, note string) (uuid.UUID, error) {
+	// Build a request payload for POST /inflow/manual-credit
+	payloadBytes, err := json.Marshal(manualCreditRequest{
+		TwitchUserId:      twitchUserId,
+		NumPointsToCredit: numPointsToCredit,
+		Note:              note,
+	})
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Prepare a request that will credit the points to the target user, authorized by
+	// the broadcaster's access token
+	url := c.ledgerUrl + "/inflow/manual-credit"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("content-type", "application/json")
+
+	// Initiate the request and make sure it completes successfully
+	res, err := c.Do(req)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return uuid.Nil, fmt.Errorf("got response %d from POST %s", res.StatusCode, url)
+	}
+
+	// Parse the response body to get the ID of the resulting transaction
+	contentType := res.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		return uuid.Nil, fmt.Errorf("got unexpected content-type '%s' from POST %s", contentType, url)
+	}
+	var result ledger.TransactionResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return uuid.Nil, fmt.Errorf("error decoding response body: %w", err)
+	}
+	return result.FlowId, nil
+}
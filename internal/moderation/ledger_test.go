@@ -0,0 +1,73 @@
+package moderation
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_refundClient_CreditPoints(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantFlowId uuid.UUID
+		wantErr    string
+	}{
+		{
+			"returns the ID of the new transaction",
+			http.StatusOK,
+			`{"flowId":"4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"}`,
+			uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"),
+			"",
+		},
+		{
+			"non-OK response is an error",
+			http.StatusForbidden,
+			"insufficient access",
+			uuid.Nil,
+			"got response 403 from POST",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, http.MethodPost, req.Method)
+				assert.Equal(t, "/inflow/manual-credit", req.URL.Path)
+				assert.Equal(t, "Bearer my-token", req.Header.Get("authorization"))
+
+				var payload manualCreditRequest
+				b, err := io.ReadAll(req.Body)
+				assert.NoError(t, err)
+				assert.NoError(t, json.Unmarshal(b, &payload))
+				assert.Equal(t, manualCreditRequest{
+					TwitchUserId:      "1234",
+					NumPointsToCredit: 200,
+					Note:              "Refund",
+				}, payload)
+
+				if tt.status == http.StatusOK {
+					res.Header().Set("content-type", "application/json")
+				}
+				res.WriteHeader(tt.status)
+				res.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := NewRefundClient(srv.URL)
+			flowId, err := c.CreditPoints(context.Background(), "my-token", "1234", 200, "Refund")
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantFlowId, flowId)
+		})
+	}
+}
@@ -0,0 +1,207 @@
+package moderation
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/processing"
+	"github.com/golden-vcr/dynamo/internal/storage"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/golden-vcr/server-common/rmq"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	q                      Queries
+	storageClient          storage.Client
+	refundClient           RefundClient
+	onscreenEventsProducer rmq.Producer
+}
+
+func NewServer(q Queries, storageClient storage.Client, refundClient RefundClient, onscreenEventsProducer rmq.Producer) *Server {
+	return &Server{
+		q:                      q,
+		storageClient:          storageClient,
+		refundClient:           refundClient,
+		onscreenEventsProducer: onscreenEventsProducer,
+	}
+}
+
+func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
+	r.Path("/requests/{id}/takedown").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostTakedown),
+		),
+	)
+}
+
+func (s *Server) handlePostTakedown(res http.ResponseWriter, req *http.Request) {
+	// Identify the moderator who's requesting the takedown
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the ID of the image request from the URL
+	imageRequestId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		http.Error(res, "invalid image request ID", http.StatusBadRequest)
+		return
+	}
+
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the takedown parameters from the request body
+	var payload TakedownRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if payload.Reason == "" {
+		http.Error(res, "invalid request payload: 'reason' must be set to a non-empty string", http.StatusBadRequest)
+		return
+	}
+	if payload.Objects == "" {
+		payload.Objects = ObjectActionDelete
+	}
+	if payload.Objects != ObjectActionDelete && payload.Objects != ObjectActionPrivatize {
+		http.Error(res, fmt.Sprintf("invalid request payload: 'objects' must be '%s' or '%s'", ObjectActionDelete, ObjectActionPrivatize), http.StatusBadRequest)
+		return
+	}
+
+	// Look up the image request, and make sure it's in a state where it can be taken
+	// down: we won't interfere with a request that the consumer is still processing
+	row, err := s.q.GetImageRequest(req.Context(), imageRequestId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(res, "no such image request", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if row.RemovedAt.Valid {
+		http.Error(res, "image request has already been taken down", http.StatusConflict)
+		return
+	}
+	if !row.FinishedAt.Valid {
+		http.Error(res, "image request is still being processed", http.StatusConflict)
+		return
+	}
+
+	// Points are only spent once the request's ledger transaction has been accepted; if
+	// that never happened, there's nothing to refund
+	if payload.Refund && !row.AcceptedAt.Valid {
+		http.Error(res, "image request did not cost the viewer any points; nothing to refund", http.StatusConflict)
+		return
+	}
+
+	// Find the URLs of any images that may be displayed onscreen, before we remove them
+	imageRows, err := s.q.GetImageRequestImages(req.Context(), imageRequestId)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Delete or privatize all objects that were stored for this request. We do this
+	// before recording the takedown, so that if anything fails, the takedown can simply
+	// be retried.
+	objects, err := s.storageClient.List(req.Context(), imageRequestId.String()+"/")
+	if err != nil {
+		http.Error(res, fmt.Sprintf("failed to list stored objects: %v", err), http.StatusInternalServerError)
+		return
+	}
+	for _, obj := range objects {
+		if payload.Objects == ObjectActionPrivatize {
+			err = s.storageClient.Privatize(req.Context(), obj.Key)
+		} else {
+			err = s.storageClient.Delete(req.Context(), obj.Key)
+		}
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			http.Error(res, fmt.Sprintf("failed to %s stored object '%s': %v", payload.Objects, obj.Key, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Mark the request as removed and record the takedown in our audit table: if some
+	// other takedown got there first, we'll get no rows
+	takedownId, err := s.q.RecordTakedown(req.Context(), queries.RecordTakedownParams{
+		Reason:                payload.Reason,
+		ImageRequestID:        imageRequestId,
+		ModeratorTwitchUserID: claims.User.Id,
+		ObjectAction:          string(payload.Objects),
+		NumObjects:            int32(len(objects)),
+		RefundRequested:       payload.Refund,
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(res, "image request has already been taken down", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	logger := entry.Log(req).With("imageRequestId", imageRequestId, "takedownId", takedownId)
+	logger.Info("Took down image request", "reason", payload.Reason, "objectAction", payload.Objects, "numObjects", len(objects))
+
+	// Let the overlay know that it should no longer display the alert. The takedown has
+	// already taken effect, so a failure here isn't fatal.
+	imageUrls := make([]string, 0, len(imageRows))
+	for _, imageRow := range imageRows {
+		imageUrls = append(imageUrls, imageRow.Url)
+	}
+	ev := TakedownEvent{
+		Type: OnscreenEventTypeTakedown,
+		Payload: TakedownPayload{
+			ImageRequestId: imageRequestId,
+			ImageUrls:      imageUrls,
+		},
+	}
+	if data, err := json.Marshal(ev); err != nil {
+		logger.Error("Failed to marshal takedown event", "error", err)
+	} else if err := s.onscreenEventsProducer.Send(req.Context(), data); err != nil {
+		logger.Error("Failed to produce to onscreen-events", "error", err)
+	} else {
+		logger.Info("Produced to onscreen-events", "onscreenEvent", ev)
+	}
+
+	// If requested, give the viewer back the points they spent on the request
+	result := &TakedownResult{
+		TakedownId: int(takedownId),
+		NumObjects: len(objects),
+	}
+	if payload.Refund {
+		note := fmt.Sprintf("Refund for image request %s: %s", imageRequestId, payload.Reason)
+		flowId, err := s.refundClient.CreditPoints(req.Context(), auth.GetToken(req), row.TwitchUserID, processing.ImageAlertPointsCost, note)
+		if err != nil {
+			http.Error(res, fmt.Sprintf("image request was taken down, but refund failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		if err := s.q.SetTakedownRefundFlowId(req.Context(), queries.SetTakedownRefundFlowIdParams{
+			RefundFlowID: uuid.NullUUID{Valid: true, UUID: flowId},
+			TakedownID:   takedownId,
+		}); err != nil {
+			logger.Error("Failed to record refund flow ID", "error", err, "flowId", flowId)
+		}
+		logger.Info("Refunded viewer", "twitchUserId", row.TwitchUserID, "flowId", flowId)
+		result.RefundFlowId = &flowId
+	}
+
+	// Return a JSON-serialized TakedownResult struct to the user
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
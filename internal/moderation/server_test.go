@@ -0,0 +1,409 @@
+package moderation
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/storage"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostTakedown(t *testing.T) {
+	requestId := uuid.MustParse("9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c")
+	finishedAt := sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)}
+	succeeded := queries.DynamoImageRequest{
+		ID:           requestId,
+		TwitchUserID: "1234",
+		FinishedAt:   finishedAt,
+		AcceptedAt:   finishedAt,
+	}
+	failed := queries.DynamoImageRequest{
+		ID:           requestId,
+		TwitchUserID: "1234",
+		FinishedAt:   finishedAt,
+		ErrorMessage: sql.NullString{Valid: true, String: "rejected"},
+	}
+	pending := queries.DynamoImageRequest{
+		ID:           requestId,
+		TwitchUserID: "1234",
+	}
+	removed := succeeded
+	removed.RemovedAt = finishedAt
+	keys := []string{
+		"9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c-0.png",
+		"9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c-0-raw.png",
+	}
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+
+	tests := []struct {
+		name          string
+		row           *queries.DynamoImageRequest
+		recordErr     error
+		refundErr     error
+		authorization string
+		url           string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantDeleted   []string
+		wantPrivate   []string
+		wantTakedowns []queries.RecordTakedownParams
+		wantEvents    []string
+		wantRefunds   []string
+	}{
+		{
+			"broadcaster can take down an image, deleting its objects",
+			&succeeded,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusOK,
+			`{"takedownId":1,"numObjects":2}`,
+			keys,
+			nil,
+			[]queries.RecordTakedownParams{
+				{
+					Reason:                "offensive",
+					ImageRequestID:        requestId,
+					ModeratorTwitchUserID: "1000",
+					ObjectAction:          "delete",
+					NumObjects:            2,
+				},
+			},
+			[]string{
+				`{"type":"takedown","payload":{"imageRequestId":"9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c","imageUrls":["https://example.com/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c-0.png"]}}`,
+			},
+			nil,
+		},
+		{
+			"objects may be privatized and the viewer refunded",
+			&succeeded,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"misinterpreted prompt","objects":"privatize","refund":true}`,
+			http.StatusOK,
+			`{"takedownId":1,"numObjects":2,"refundFlowId":"4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"}`,
+			nil,
+			keys,
+			[]queries.RecordTakedownParams{
+				{
+					Reason:                "misinterpreted prompt",
+					ImageRequestID:        requestId,
+					ModeratorTwitchUserID: "1000",
+					ObjectAction:          "privatize",
+					NumObjects:            2,
+					RefundRequested:       true,
+				},
+			},
+			[]string{
+				`{"type":"takedown","payload":{"imageRequestId":"9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c","imageUrls":["https://example.com/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c-0.png"]}}`,
+			},
+			[]string{"broadcaster-token:1234:200:Refund for image request 9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c: misinterpreted prompt"},
+		},
+		{
+			"viewer may not take down images",
+			&succeeded,
+			nil,
+			nil,
+			"viewer-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusForbidden,
+			"insufficient access: requires broadcaster; you are viewer",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"reason is required",
+			&succeeded,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"objects":"delete"}`,
+			http.StatusBadRequest,
+			"invalid request payload: 'reason' must be set to a non-empty string",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"invalid object action is a 400",
+			&succeeded,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive","objects":"shred"}`,
+			http.StatusBadRequest,
+			"invalid request payload: 'objects' must be 'delete' or 'privatize'",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"nonexistent request is a 404",
+			nil,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusNotFound,
+			"no such image request",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"pending request is a 409",
+			&pending,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusConflict,
+			"image request is still being processed",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"removed request is a 409",
+			&removed,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusConflict,
+			"image request has already been taken down",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"failed request can't be refunded",
+			&failed,
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive","refund":true}`,
+			http.StatusConflict,
+			"image request did not cost the viewer any points; nothing to refund",
+			nil,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"concurrent takedown is a 409",
+			&succeeded,
+			sql.ErrNoRows,
+			nil,
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive"}`,
+			http.StatusConflict,
+			"image request has already been taken down",
+			keys,
+			nil,
+			nil,
+			nil,
+			nil,
+		},
+		{
+			"failure to refund is a 500",
+			&succeeded,
+			nil,
+			fmt.Errorf("mock error"),
+			"broadcaster-token",
+			"/requests/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/takedown",
+			`{"reason":"offensive","refund":true}`,
+			http.StatusInternalServerError,
+			"image request was taken down, but refund failed: mock error",
+			keys,
+			nil,
+			[]queries.RecordTakedownParams{
+				{
+					Reason:                "offensive",
+					ImageRequestID:        requestId,
+					ModeratorTwitchUserID: "1000",
+					ObjectAction:          "delete",
+					NumObjects:            2,
+					RefundRequested:       true,
+				},
+			},
+			[]string{
+				`{"type":"takedown","payload":{"imageRequestId":"9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c","imageUrls":["https://example.com/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c/9b2a1c64-7d3e-4f5a-8b6c-0d1e2f3a4b5c-0.png"]}}`,
+			},
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authClient := authmock.NewClient().AllowTwitchUserAccessToken("broadcaster-token", auth.RoleBroadcaster, auth.UserDetails{
+				Id:          "1000",
+				Login:       "broadcaster",
+				DisplayName: "Broadcaster",
+			}).AllowTwitchUserAccessToken("viewer-token", auth.RoleViewer, auth.UserDetails{
+				Id:          "1234",
+				Login:       "someviewer",
+				DisplayName: "SomeViewer",
+			})
+			q := &mockQueries{
+				row:       tt.row,
+				recordErr: tt.recordErr,
+				images: []queries.GetImageRequestImagesRow{
+					{Url: "https://example.com/" + keys[0]},
+				},
+			}
+			storageClient := &mockStorageClient{keys: keys}
+			refundClient := &mockRefundClient{flowId: flowId, err: tt.refundErr}
+			producer := &mockProducer{}
+			s := NewServer(q, storageClient, refundClient, producer)
+			r := mux.NewRouter()
+			s.RegisterRoutes(authClient, r)
+
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			req.Header.Set("authorization", tt.authorization)
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantDeleted, storageClient.deleted)
+			assert.Equal(t, tt.wantPrivate, storageClient.privatized)
+			assert.Equal(t, tt.wantTakedowns, q.takedowns)
+			assert.Equal(t, tt.wantEvents, producer.messages)
+			assert.Equal(t, tt.wantRefunds, refundClient.calls)
+			if tt.wantRefunds != nil && tt.refundErr == nil {
+				assert.Equal(t, []queries.SetTakedownRefundFlowIdParams{
+					{
+						RefundFlowID: uuid.NullUUID{Valid: true, UUID: flowId},
+						TakedownID:   1,
+					},
+				}, q.refundFlowIds)
+			} else {
+				assert.Empty(t, q.refundFlowIds)
+			}
+		})
+	}
+}
+
+type mockQueries struct {
+	row           *queries.DynamoImageRequest
+	images        []queries.GetImageRequestImagesRow
+	recordErr     error
+	takedowns     []queries.RecordTakedownParams
+	refundFlowIds []queries.SetTakedownRefundFlowIdParams
+}
+
+func (m *mockQueries) GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error) {
+	if m.row == nil || m.row.ID != imageRequestID {
+		return queries.DynamoImageRequest{}, sql.ErrNoRows
+	}
+	return *m.row, nil
+}
+
+func (m *mockQueries) GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error) {
+	return m.images, nil
+}
+
+func (m *mockQueries) RecordTakedown(ctx context.Context, arg queries.RecordTakedownParams) (int32, error) {
+	if m.recordErr != nil {
+		return 0, m.recordErr
+	}
+	m.takedowns = append(m.takedowns, arg)
+	return int32(len(m.takedowns)), nil
+}
+
+func (m *mockQueries) SetTakedownRefundFlowId(ctx context.Context, arg queries.SetTakedownRefundFlowIdParams) error {
+	m.refundFlowIds = append(m.refundFlowIds, arg)
+	return nil
+}
+
+type mockStorageClient struct {
+	storage.Client
+	keys       []string
+	deleted    []string
+	privatized []string
+}
+
+func (m *mockStorageClient) List(ctx context.Context, prefix string) ([]storage.ObjectInfo, error) {
+	var objects []storage.ObjectInfo
+	for _, key := range m.keys {
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, storage.ObjectInfo{Key: key})
+		}
+	}
+	return objects, nil
+}
+
+func (m *mockStorageClient) Delete(ctx context.Context, key string) error {
+	m.deleted = append(m.deleted, key)
+	return nil
+}
+
+func (m *mockStorageClient) Privatize(ctx context.Context, key string) error {
+	m.privatized = append(m.privatized, key)
+	return nil
+}
+
+type mockRefundClient struct {
+	flowId uuid.UUID
+	err    error
+	calls  []string
+}
+
+func (m *mockRefundClient) CreditPoints(ctx context.Context, accessToken string, twitchUserId string, numPointsToCredit int, note string) (uuid.UUID, error) {
+	if m.err != nil {
+		return uuid.Nil, m.err
+	}
+	m.calls = append(m.calls, fmt.Sprintf("%s:%s:%d:%s", accessToken, twitchUserId, numPointsToCredit, note))
+	return m.flowId, nil
+}
+
+type mockProducer struct {
+	messages []string
+}
+
+func (m *mockProducer) Send(ctx context.Context, jsonData []byte) error {
+	m.messages = append(m.messages, string(jsonData))
+	return nil
+}
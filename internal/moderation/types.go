@@ -0,0 +1,64 @@
+package moderation
+
+import (
+	"context"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error)
+	GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error)
+	RecordTakedown(ctx context.Context, arg queries.RecordTakedownParams) (int32, error)
+	SetTakedownRefundFlowId(ctx context.Context, arg queries.SetTakedownRefundFlowIdParams) error
+}
+
+// ObjectAction indicates what should be done with the stored objects (i.e. image files)
+// that belong to an image request that's being taken down
+type ObjectAction string
+
+const (
+	// ObjectActionDelete permanently deletes all stored objects
+	ObjectActionDelete ObjectAction = "delete"
+	// ObjectActionPrivatize retains stored objects but makes them inaccessible to the
+	// public, e.g. so they can be preserved as evidence
+	ObjectActionPrivatize ObjectAction = "privatize"
+)
+
+// TakedownRequest is the payload accepted by POST /requests/:id/takedown
+type TakedownRequest struct {
+	// Reason is a human-readable explanation of why the image is being taken down
+	Reason string `json:"reason"`
+	// Objects indicates what to do with stored objects: defaults to "delete"
+	Objects ObjectAction `json:"objects,omitempty"`
+	// Refund, if true, credits the viewer with the points they spent on the request
+	Refund bool `json:"refund"`
+}
+
+// TakedownResult is returned in response to a successful POST /requests/:id/takedown
+type TakedownResult struct {
+	TakedownId   int        `json:"takedownId"`
+	NumObjects   int        `json:"numObjects"`
+	RefundFlowId *uuid.UUID `json:"refundFlowId,omitempty"`
+}
+
+// OnscreenEventTypeTakedown is the type of the event that we produce to the
+// onscreen-events exchange when an image is taken down: it's not (yet) part of the
+// onscreen-events schema, so we define it here
+const OnscreenEventTypeTakedown = "takedown"
+
+// TakedownEvent is produced to onscreen-events to notify the overlay that an image
+// alert should no longer be displayed, if it's still queued up or onscreen
+type TakedownEvent struct {
+	Type    string          `json:"type"`
+	Payload TakedownPayload `json:"payload"`
+}
+
+// TakedownPayload identifies the alert that was taken down: since image alerts are not
+// identified by image request ID, the URLs of the request's images are included so that
+// the overlay can find the corresponding alert
+type TakedownPayload struct {
+	ImageRequestId uuid.UUID `json:"imageRequestId"`
+	ImageUrls      []string  `json:"imageUrls"`
+}
@@ -0,0 +1,5 @@
+// Package notifications contains code that facilitates real-time notifications:
+// whenever an image request is created or finished, or whenever an answer or image is
+// recorded for an image request, we respond by sending an event to all connected
+// clients that are interested in that request
+package notifications
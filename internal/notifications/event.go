@@ -0,0 +1,72 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// buildEvent converts a Notification received from Postgres into the Event that
+// should be sent to SSE clients
+func buildEvent(n *Notification) (*Event, error) {
+	payload, err := buildPayload(n.Type, n.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse data for %s notification: %w", n.Type, err)
+	}
+	return &Event{
+		Type:           n.Type,
+		ImageRequestId: n.ImageRequestId,
+		TwitchUserId:   n.TwitchUserId,
+		Payload:        payload,
+	}, nil
+}
+
+func buildPayload(eventType EventType, data json.RawMessage) (interface{}, error) {
+	switch eventType {
+	case EventTypeCreated:
+		var d imageRequestData
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, err
+		}
+		return &PayloadCreated{
+			BroadcastId: d.BroadcastId,
+			ScreeningId: d.ScreeningId,
+			Style:       d.Style,
+			Inputs:      d.Inputs,
+			Prompt:      d.Prompt,
+			CreatedAt:   d.CreatedAt,
+		}, nil
+	case EventTypeAnswer:
+		var d answerData
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, err
+		}
+		return &PayloadAnswer{
+			Prompt: d.Prompt,
+			Value:  d.Value,
+		}, nil
+	case EventTypeImage:
+		var d imageData
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, err
+		}
+		return &PayloadImage{
+			Index: d.Index,
+			Url:   d.Url,
+			Color: d.Color,
+		}, nil
+	case EventTypeSucceeded, EventTypeFailed:
+		var d imageRequestData
+		if err := json.Unmarshal(data, &d); err != nil {
+			return nil, err
+		}
+		payload := &PayloadFinished{}
+		if d.FinishedAt != nil {
+			payload.FinishedAt = *d.FinishedAt
+		}
+		if d.ErrorMessage != nil {
+			payload.ErrorMessage = *d.ErrorMessage
+		}
+		return payload, nil
+	}
+	return nil, fmt.Errorf("unsupported event type '%s'", eventType)
+}
@@ -0,0 +1,115 @@
+package notifications
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"golang.org/x/exp/slog"
+)
+
+type Server struct {
+	ctx         context.Context
+	logger      *slog.Logger
+	eventsChan  <-chan *Notification
+	subscribers subscriberChannels
+}
+
+func NewServer(ctx context.Context, logger *slog.Logger, eventsChan <-chan *Notification) *Server {
+	return &Server{
+		ctx:        ctx,
+		logger:     logger,
+		eventsChan: eventsChan,
+		subscribers: subscriberChannels{
+			chans: make(map[chan *Event]subscriberFilter),
+		},
+	}
+}
+
+func (s *Server) RegisterRoutes(r *mux.Router) {
+	r.Path("/events").Methods("GET").HandlerFunc(s.handleGetEvents)
+}
+
+func (s *Server) ReadPostgresNotifications(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case notification := <-s.eventsChan:
+			event, err := buildEvent(notification)
+			if err != nil {
+				s.logger.Error("Failed to build event from notification", "error", err)
+				continue
+			}
+			s.subscribers.broadcast(event)
+		}
+	}
+}
+
+func (s *Server) handleGetEvents(res http.ResponseWriter, req *http.Request) {
+	// If a content-type is explicitly requested, require that it's text/event-stream
+	accept := req.Header.Get("accept")
+	if accept != "" && accept != "*/*" && !strings.HasPrefix(accept, "text/event-stream") {
+		message := fmt.Sprintf("content-type %s is not supported", accept)
+		http.Error(res, message, http.StatusBadRequest)
+		return
+	}
+
+	// Clients may optionally narrow the stream down to a single request or user
+	var filter subscriberFilter
+	if requestStr := req.URL.Query().Get("request"); requestStr != "" {
+		imageRequestId, err := uuid.Parse(requestStr)
+		if err != nil {
+			http.Error(res, "invalid value for 'request' URL parameter", http.StatusBadRequest)
+			return
+		}
+		filter.imageRequestId = imageRequestId
+	}
+	filter.twitchUserId = req.URL.Query().Get("user")
+
+	eventsChan := s.subscribers.register(filter)
+	defer s.subscribers.unregister(eventsChan)
+
+	// Keep the connection alive and open a text/event-stream response body
+	res.Header().Set("content-type", "text/event-stream")
+	res.Header().Set("cache-control", "no-cache")
+	res.Header().Set("connection", "keep-alive")
+	res.WriteHeader(http.StatusOK)
+	res.(http.Flusher).Flush()
+
+	// Send an initial empty value to flush the connection and ensure that any
+	// intermediaries (Cloudflare etc) will send the initial HTTP response promptly
+	res.Write([]byte(":\n\n"))
+	res.(http.Flusher).Flush()
+
+	// Send all incoming events to the client for as long as the connection is open
+	logger := entry.Log(req)
+	logger.Info("Opened SSE connection")
+	for {
+		select {
+		case <-time.After(30 * time.Second):
+			res.Write([]byte(":\n\n"))
+			res.(http.Flusher).Flush()
+		case event := <-eventsChan:
+			data, err := json.Marshal(event)
+			if err != nil {
+				logger.Error("Failed to serialize event as JSON", "error", err)
+				continue
+			}
+			fmt.Fprintf(res, "data: %s\n\n", data)
+			res.(http.Flusher).Flush()
+		case <-s.ctx.Done():
+			logger.Info("Server is shutting down; abandoning SSE connection")
+			return
+		case <-req.Context().Done():
+			logger.Info("SSE connection has been closed")
+			return
+		}
+	}
+}
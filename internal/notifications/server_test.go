@@ -0,0 +1,157 @@
+package notifications
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_Server_handleGetEvents(t *testing.T) {
+	tests := []struct {
+		name                     string
+		url                      string
+		generateNotificationFunc func(ch chan *Notification)
+		wantStatus               int
+		wantBody                 string
+	}{
+		{
+			"returns 400 if request ID is invalid",
+			"/events?request=not-a-uuid",
+			func(ch chan *Notification) {},
+			http.StatusBadRequest,
+			"invalid value for 'request' URL parameter",
+		},
+		{
+			"sends all events to unfiltered clients",
+			"/events",
+			func(ch chan *Notification) {
+				ch <- &Notification{
+					Type:           EventTypeCreated,
+					ImageRequestId: uuid.MustParse("d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25"),
+					TwitchUserId:   "1001",
+					Data:           []byte(`{"broadcast_id":42,"screening_id":null,"style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","created_at":"1997-09-01T12:00:00Z","finished_at":null,"error_message":null}`),
+				}
+				ch <- &Notification{
+					Type:           EventTypeImage,
+					ImageRequestId: uuid.MustParse("d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25"),
+					TwitchUserId:   "1001",
+					Data:           []byte(`{"index":0,"url":"https://example.com/0.png","color":"#fcee99"}`),
+				}
+			},
+			http.StatusOK,
+			":\n\n" +
+				"data: {\"type\":\"created\",\"imageRequestId\":\"d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25\",\"twitchUserId\":\"1001\",\"payload\":{\"broadcastId\":42,\"style\":\"ghost\",\"inputs\":{\"subject\":\"a seal\"},\"prompt\":\"a ghostly image of a seal\",\"createdAt\":\"1997-09-01T12:00:00Z\"}}\n\n" +
+				"data: {\"type\":\"image\",\"imageRequestId\":\"d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25\",\"twitchUserId\":\"1001\",\"payload\":{\"index\":0,\"url\":\"https://example.com/0.png\",\"color\":\"#fcee99\"}}\n\n",
+		},
+		{
+			"sends only matching events to clients filtered by request",
+			"/events?request=d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25",
+			func(ch chan *Notification) {
+				ch <- &Notification{
+					Type:           EventTypeAnswer,
+					ImageRequestId: uuid.MustParse("5f0f0a33-3f31-4cd6-b0b4-1b0b6f1a3e0e"),
+					TwitchUserId:   "1001",
+					Data:           []byte(`{"prompt":"what is its name?","value":"Fred"}`),
+				}
+				ch <- &Notification{
+					Type:           EventTypeFailed,
+					ImageRequestId: uuid.MustParse("d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25"),
+					TwitchUserId:   "1001",
+					Data:           []byte(`{"broadcast_id":null,"screening_id":null,"style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","created_at":"1997-09-01T12:00:00Z","finished_at":"1997-09-01T12:00:30Z","error_message":"prompt was rejected"}`),
+				}
+			},
+			http.StatusOK,
+			":\n\n" +
+				"data: {\"type\":\"failed\",\"imageRequestId\":\"d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25\",\"twitchUserId\":\"1001\",\"payload\":{\"finishedAt\":\"1997-09-01T12:00:30Z\",\"errorMessage\":\"prompt was rejected\"}}\n\n",
+		},
+		{
+			"sends only matching events to clients filtered by user",
+			"/events?user=2002",
+			func(ch chan *Notification) {
+				ch <- &Notification{
+					Type:           EventTypeSucceeded,
+					ImageRequestId: uuid.MustParse("d0d4ee4a-66b1-4ba6-9c0b-7e9d73b8ff25"),
+					TwitchUserId:   "1001",
+					Data:           []byte(`{"broadcast_id":null,"screening_id":null,"style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","created_at":"1997-09-01T12:00:00Z","finished_at":"1997-09-01T12:00:30Z","error_message":null}`),
+				}
+				ch <- &Notification{
+					Type:           EventTypeSucceeded,
+					ImageRequestId: uuid.MustParse("5f0f0a33-3f31-4cd6-b0b4-1b0b6f1a3e0e"),
+					TwitchUserId:   "2002",
+					Data:           []byte(`{"broadcast_id":null,"screening_id":null,"style":"friend","inputs":{"color":"blue","subject":"a crab"},"prompt":"a crab","created_at":"1997-09-01T12:00:00Z","finished_at":"1997-09-01T12:00:45Z","error_message":null}`),
+				}
+			},
+			http.StatusOK,
+			":\n\n" +
+				"data: {\"type\":\"succeeded\",\"imageRequestId\":\"5f0f0a33-3f31-4cd6-b0b4-1b0b6f1a3e0e\",\"twitchUserId\":\"2002\",\"payload\":{\"finishedAt\":\"1997-09-01T12:00:45Z\"}}\n\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			eventsChan := make(chan *Notification)
+			s := NewServer(context.Background(), slog.Default(), eventsChan)
+
+			// Prepare a context that we can cancel in order to shut down all server
+			// processing
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			// Read from eventsChan and fan out to all connected SSE clients for as long
+			// as that context is alive
+			go s.ReadPostgresNotifications(ctx)
+
+			// Preemptively clear our status code, then run our SSE request handler in
+			// another goroutine until our context is canceled
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil).WithContext(ctx)
+			res := httptest.NewRecorder()
+			res.Code = 0
+			done := make(chan struct{})
+			go func() {
+				s.handleGetEvents(res, req)
+				done <- struct{}{}
+			}()
+
+			// Wait until we get an initial response from the server
+			for res.Code == 0 {
+				time.Sleep(10 * time.Nanosecond)
+			}
+
+			// If this test expects an error response, validate it and go no further
+			if tt.wantStatus != http.StatusOK {
+				cancel()
+				<-done
+				assert.Equal(t, tt.wantStatus, res.Code)
+				b, err := io.ReadAll(res.Body)
+				assert.NoError(t, err)
+				body := strings.TrimSuffix(string(b), "\n")
+				assert.Equal(t, tt.wantBody, body)
+				return
+			}
+			if res.Code != http.StatusOK {
+				t.Fatalf("did not get 200 response")
+			}
+
+			// We got a 200 response as expected; validate that we're receiving SSE data
+			contentType := res.Header().Get("content-type")
+			assert.Equal(t, "text/event-stream", contentType)
+
+			// Simulate postgres notifications, then wait for the response to propagate
+			// over HTTP and verify that we got the expected message(s)
+			tt.generateNotificationFunc(eventsChan)
+			time.Sleep(10 * time.Millisecond)
+			cancel()
+			<-done
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantBody, string(b))
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package notifications
+
+import (
+	"sync"
+
+	"github.com/google/uuid"
+)
+
+// subscriberFilter restricts the set of events that an SSE client receives: if either
+// value is set, only events pertaining to the matching request/user are delivered
+type subscriberFilter struct {
+	imageRequestId uuid.UUID
+	twitchUserId   string
+}
+
+func (f *subscriberFilter) matches(event *Event) bool {
+	if f.imageRequestId != uuid.Nil && event.ImageRequestId != f.imageRequestId {
+		return false
+	}
+	if f.twitchUserId != "" && event.TwitchUserId != f.twitchUserId {
+		return false
+	}
+	return true
+}
+
+type subscriberChannels struct {
+	chans map[chan *Event]subscriberFilter
+	mu    sync.RWMutex
+}
+
+func (s *subscriberChannels) register(filter subscriberFilter) chan *Event {
+	ch := make(chan *Event, 32)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.chans[ch] = filter
+	return ch
+}
+
+func (s *subscriberChannels) unregister(ch chan *Event) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.chans, ch)
+}
+
+func (s *subscriberChannels) broadcast(event *Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for ch, filter := range s.chans {
+		if !filter.matches(event) {
+			continue
+		}
+
+		// If a client isn't keeping up, drop the event for that client rather than
+		// blocking delivery to everyone else
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
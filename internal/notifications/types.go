@@ -0,0 +1,97 @@
+package notifications
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType identifies the stage of an image request's lifecycle that an Event
+// describes
+type EventType string
+
+const (
+	EventTypeCreated   EventType = "created"
+	EventTypeAnswer    EventType = "answer"
+	EventTypeImage     EventType = "image"
+	EventTypeSucceeded EventType = "succeeded"
+	EventTypeFailed    EventType = "failed"
+)
+
+// Notification is the payload of a NOTIFY message sent to the
+// 'dynamo_image_request_event' channel by the triggers on the tables in our 'dynamo'
+// schema: the format of Data depends on Type
+type Notification struct {
+	Type           EventType       `json:"type"`
+	ImageRequestId uuid.UUID       `json:"image_request_id"`
+	TwitchUserId   string          `json:"twitch_user_id"`
+	Data           json.RawMessage `json:"data"`
+}
+
+// imageRequestData is the format of Notification.Data for created, succeeded, and
+// failed events
+type imageRequestData struct {
+	BroadcastId  *int            `json:"broadcast_id"`
+	ScreeningId  *uuid.UUID      `json:"screening_id"`
+	Style        string          `json:"style"`
+	Inputs       json.RawMessage `json:"inputs"`
+	Prompt       string          `json:"prompt"`
+	CreatedAt    time.Time       `json:"created_at"`
+	FinishedAt   *time.Time      `json:"finished_at"`
+	ErrorMessage *string         `json:"error_message"`
+}
+
+// answerData is the format of Notification.Data for answer events
+type answerData struct {
+	Prompt string `json:"prompt"`
+	Value  string `json:"value"`
+}
+
+// imageData is the format of Notification.Data for image events
+type imageData struct {
+	Index int    `json:"index"`
+	Url   string `json:"url"`
+	Color string `json:"color"`
+}
+
+// Event is the JSON representation of a change to an image request, as sent to SSE
+// clients: the type of Payload depends on Type
+type Event struct {
+	Type           EventType   `json:"type"`
+	ImageRequestId uuid.UUID   `json:"imageRequestId"`
+	TwitchUserId   string      `json:"twitchUserId"`
+	Payload        interface{} `json:"payload"`
+}
+
+// PayloadCreated is the payload of a created event, describing the new image request
+type PayloadCreated struct {
+	BroadcastId *int            `json:"broadcastId,omitempty"`
+	ScreeningId *uuid.UUID      `json:"screeningId,omitempty"`
+	Style       string          `json:"style"`
+	Inputs      json.RawMessage `json:"inputs"`
+	Prompt      string          `json:"prompt"`
+	CreatedAt   time.Time       `json:"createdAt"`
+}
+
+// PayloadAnswer is the payload of an answer event, describing a value that was obtained
+// from a text generation API in the course of fulfilling the request
+type PayloadAnswer struct {
+	Prompt string `json:"prompt"`
+	Value  string `json:"value"`
+}
+
+// PayloadImage is the payload of an image event, describing an image that was
+// generated and stored in response to the request
+type PayloadImage struct {
+	Index int    `json:"index"`
+	Url   string `json:"url"`
+	Color string `json:"color"`
+}
+
+// PayloadFinished is the payload of a succeeded or failed event; ErrorMessage is only
+// set for failures
+type PayloadFinished struct {
+	FinishedAt   time.Time `json:"finishedAt"`
+	ErrorMessage string    `json:"errorMessage,omitempty"`
+}
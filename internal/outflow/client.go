@@ -0,0 +1,137 @@
+package outflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/google/uuid"
+)
+
+// ErrNotPending is returned when attempting to finalize a transaction that has already
+// been accepted or rejected
+var ErrNotPending = errors.New("transaction is not pending")
+
+type Client interface {
+	// RequestAlertRedemption creates a pending transaction that debits the given number
+	// of points from the user identified by accessToken, returning the ID of that
+	// transaction. If the user does not have enough points, returns
+	// ledger.ErrNotEnoughPoints.
+	RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (uuid.UUID, error)
+
+	// Accept finalizes a pending transaction, permanently deducting the debited points
+	Accept(ctx context.Context, accessToken string, flowId uuid.UUID) error
+
+	// Reject finalizes a pending transaction, refunding the debited points
+	Reject(ctx context.Context, accessToken string, flowId uuid.UUID) error
+}
+
+// NewClient initializes an HTTP client configured to make requests against the
+// golden-vcr/ledger server running at the given URL
+func NewClient(ledgerUrl string) Client {
+	return &client{
+		ledgerUrl: ledgerUrl,
+	}
+}
+
+type client struct {
+	http.Client
+	ledgerUrl string
+}
+
+func (c *client) RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (uuid.UUID, error) {
+	// Build a request payload for POST /outflow
+	payload := ledger.AlertRedemptionRequest{
+		Type:             ledger.TransactionTypeAlertRedemption,
+		NumPointsToDebit: numPointsToDebit,
+		AlertType:        alertType,
+		AlertMetadata:    alertMetadata,
+	}
+	payloadBytes, err := json.Marshal(payload)
+	if err != nil {
+		return uuid.Nil, err
+	}
+
+	// Prepare a request to POST /outflow that creates a pending outflow with the
+	// requested parameters
+	url := c.ledgerUrl + "/outflow"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return uuid.Nil, err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+	req.Header.Set("content-type", "application/json")
+
+	// Initiate the request and make sure it completes successfully
+	res, err := c.Do(req)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	defer res.Body.Close()
+
+	// A 409 response indicates that the user identified by the auth token does not have
+	// enough points available; propagate that error as ErrNotEnoughPoints
+	if res.StatusCode == http.StatusConflict {
+		return uuid.Nil, ledger.ErrNotEnoughPoints
+	}
+
+	// For any unexpected or non-OK response, propagate an error and halt
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusCreated {
+		return uuid.Nil, fmt.Errorf("got response %d from POST %s", res.StatusCode, url)
+	}
+
+	// We have an OK response; parse the response body to get our transaction ID
+	contentType := res.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		return uuid.Nil, fmt.Errorf("got unexpected content-type '%s' from POST %s", contentType, url)
+	}
+	var result ledger.TransactionResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return uuid.Nil, fmt.Errorf("error decoding response body: %w", err)
+	}
+	return result.FlowId, nil
+}
+
+func (c *client) Accept(ctx context.Context, accessToken string, flowId uuid.UUID) error {
+	return c.finalize(ctx, accessToken, flowId, true)
+}
+
+func (c *client) Reject(ctx context.Context, accessToken string, flowId uuid.UUID) error {
+	return c.finalize(ctx, accessToken, flowId, false)
+}
+
+func (c *client) finalize(ctx context.Context, accessToken string, flowId uuid.UUID, accept bool) error {
+	// Prepare a PATCH or DELETE request to accept or reject the transaction
+	method := http.MethodDelete
+	if accept {
+		method = http.MethodPatch
+	}
+	url := fmt.Sprintf("%s/outflow/%s", c.ledgerUrl, flowId)
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return err
+	}
+	req = entry.ConveyRequestId(ctx, req)
+	req.Header.Set("authorization", fmt.Sprintf("Bearer %s", accessToken))
+
+	// Initiate the request and make sure it completes successfully with a 204 status
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode == http.StatusConflict {
+		return ErrNotPending
+	}
+	if res.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("got response %d from %s %s", res.StatusCode, method, url)
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package outflow
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golden-vcr/ledger"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_client_RequestAlertRedemption(t *testing.T) {
+	tests := []struct {
+		name       string
+		status     int
+		body       string
+		wantFlowId uuid.UUID
+		wantErr    error
+	}{
+		{
+			"returns the ID of the new transaction",
+			http.StatusOK,
+			`{"flowId":"4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"}`,
+			uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1"),
+			nil,
+		},
+		{
+			"409 is ErrNotEnoughPoints",
+			http.StatusConflict,
+			"not enough points",
+			uuid.Nil,
+			ledger.ErrNotEnoughPoints,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, http.MethodPost, req.Method)
+				assert.Equal(t, "/outflow", req.URL.Path)
+				assert.Equal(t, "Bearer my-token", req.Header.Get("authorization"))
+
+				var payload ledger.AlertRedemptionRequest
+				b, err := io.ReadAll(req.Body)
+				assert.NoError(t, err)
+				assert.NoError(t, json.Unmarshal(b, &payload))
+				assert.Equal(t, ledger.TransactionTypeAlertRedemption, payload.Type)
+				assert.Equal(t, 500, payload.NumPointsToDebit)
+				assert.Equal(t, "ghost", payload.AlertType)
+
+				if tt.status == http.StatusOK {
+					res.Header().Set("content-type", "application/json")
+				}
+				res.WriteHeader(tt.status)
+				res.Write([]byte(tt.body))
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL)
+			flowId, err := c.RequestAlertRedemption(context.Background(), "my-token", 500, "ghost", nil)
+			if tt.wantErr != nil {
+				assert.ErrorIs(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tt.wantFlowId, flowId)
+		})
+	}
+}
+
+func Test_client_finalize(t *testing.T) {
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name       string
+		accept     bool
+		status     int
+		wantMethod string
+		wantErr    string
+	}{
+		{
+			"accept issues PATCH",
+			true,
+			http.StatusNoContent,
+			http.MethodPatch,
+			"",
+		},
+		{
+			"reject issues DELETE",
+			false,
+			http.StatusNoContent,
+			http.MethodDelete,
+			"",
+		},
+		{
+			"409 is ErrNotPending",
+			true,
+			http.StatusConflict,
+			http.MethodPatch,
+			ErrNotPending.Error(),
+		},
+		{
+			"other errors are propagated",
+			false,
+			http.StatusNotFound,
+			http.MethodDelete,
+			"got response 404",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				assert.Equal(t, tt.wantMethod, req.Method)
+				assert.Equal(t, "/outflow/"+flowId.String(), req.URL.Path)
+				assert.Equal(t, "Bearer my-token", req.Header.Get("authorization"))
+				res.WriteHeader(tt.status)
+			}))
+			defer srv.Close()
+
+			c := NewClient(srv.URL)
+			var err error
+			if tt.accept {
+				err = c.Accept(context.Background(), "my-token", flowId)
+			} else {
+				err = c.Reject(context.Background(), "my-token", flowId)
+			}
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,6 @@
+// Package outflow implements a client for the ledger service's outflow API, which we
+// use to debit points from users in exchange for alerts. Unlike the client provided by
+// the ledger package, this client exposes the ID of each pending transaction, so that
+// we can persist it alongside the image request and finalize the transaction later,
+// even if the consumer is restarted in the meantime.
+package outflow
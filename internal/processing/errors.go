@@ -0,0 +1,67 @@
+package processing
+
+import (
+	"errors"
+
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/ledger"
+	"github.com/lib/pq"
+)
+
+// Codes identifying the categories of error that may cause an image request to fail
+// during processing, aside from those defined by the packages we call into (e.g.
+// generation.CodeRejected or storage.CodeFailed)
+const (
+	// CodeDatabase indicates that we failed to read or write our own database
+	CodeDatabase errcode.Code = "processing.database"
+	// CodeAnnounceFailed indicates that we failed to produce the onscreen event that
+	// would display the alert
+	CodeAnnounceFailed errcode.Code = "processing.announce-failed"
+	// CodeUnresumable indicates that an interrupted request was abandoned because we
+	// lacked the details required to resume it
+	CodeUnresumable errcode.Code = "processing.unresumable"
+	// CodeExpired indicates that an interrupted request was abandoned because it was
+	// too old to be worth displaying by the time we could resume it
+	CodeExpired errcode.Code = "processing.expired"
+)
+
+// ErrPermanent is matched by any error indicating that a generation request can never
+// be handled successfully, such that the message should not be retried
+var ErrPermanent = errors.New("permanent failure")
+
+// permanentError unwraps to both ErrPermanent and the original error
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() []error {
+	return []error{ErrPermanent, e.err}
+}
+
+// Permanent wraps err to indicate that it's a permanent failure
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err}
+}
+
+// IsPermanent returns true if err indicates that the message being handled can never
+// be handled successfully: either because it's been explicitly marked as permanent,
+// because the prompt was rejected by the generation API, or because the viewer can't
+// afford the request. Any other error is presumed to be transient.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrPermanent) || errors.Is(err, generation.ErrRejected) || errors.Is(err, ledger.ErrNotEnoughPoints)
+}
+
+// isDuplicateIdempotencyKey returns true if err indicates that we tried to record an
+// image request with an idempotency key that's already been recorded
+func isDuplicateIdempotencyKey(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && pqErr.Code == "23505" && pqErr.Constraint == "image_request_idempotency_key_unique"
+}
@@ -0,0 +1,38 @@
+package processing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_IsPermanent(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{Permanent(fmt.Errorf("malformed message body")), true},
+		{fmt.Errorf("wrapped: %w", Permanent(fmt.Errorf("unsupported request type"))), true},
+		{fmt.Errorf("error in text generation: %w", generation.ErrRejected), true},
+		{ledger.ErrNotEnoughPoints, true},
+		{fmt.Errorf("connection refused"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.err.Error(), func(t *testing.T) {
+			assert.Equal(t, tt.want, IsPermanent(tt.err))
+		})
+	}
+}
+
+func Test_Permanent(t *testing.T) {
+	assert.NoError(t, Permanent(nil))
+
+	inner := fmt.Errorf("inner error")
+	err := Permanent(inner)
+	assert.EqualError(t, err, "inner error")
+	assert.ErrorIs(t, err, ErrPermanent)
+	assert.ErrorIs(t, err, inner)
+}
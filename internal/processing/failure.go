@@ -0,0 +1,114 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+	"golang.org/x/exp/slog"
+)
+
+// GenerationEventType identifies the kind of event we produce to the
+// generation-events exchange
+type GenerationEventType string
+
+const (
+	// GenerationEventTypeFailed indicates that a viewer's generation request could not
+	// be fulfilled, and that they were not charged for it
+	GenerationEventTypeFailed GenerationEventType = "failed"
+)
+
+// FailureReason classifies the error that caused a generation request to fail, so that
+// consumers (e.g. the chatbot) can explain it to the viewer
+type FailureReason string
+
+const (
+	// FailureReasonFlagged indicates that the viewer's inputs were flagged by moderation
+	FailureReasonFlagged FailureReason = "flagged"
+	// FailureReasonRejected indicates that the generation API refused the prompt
+	FailureReasonRejected FailureReason = "rejected"
+	// FailureReasonNotEnoughPoints indicates that the viewer couldn't afford the request
+	FailureReasonNotEnoughPoints FailureReason = "not-enough-points"
+	// FailureReasonExpired indicates that the request was interrupted, and was too old
+	// to be displayed by the time we could resume it
+	FailureReasonExpired FailureReason = "expired"
+	// FailureReasonError indicates that the request failed due to an error on our end
+	FailureReasonError FailureReason = "error"
+)
+
+// GenerationEvent is produced to the generation-events exchange to report the outcome
+// of a generation request
+type GenerationEvent struct {
+	Type    GenerationEventType `json:"type"`
+	Payload GenerationFailure   `json:"payload"`
+}
+
+// GenerationFailure describes a generation request that failed, including a short,
+// user-friendly explanation that can be relayed to the viewer as-is
+type GenerationFailure struct {
+	ImageRequestId uuid.UUID         `json:"imageRequestId"`
+	Viewer         core.Viewer       `json:"viewer"`
+	Style          genreq.ImageStyle `json:"style"`
+	Reason         FailureReason     `json:"reason"`
+	Categories     []string          `json:"categories,omitempty"`
+	Message        string            `json:"message"`
+	PointsRefunded bool              `json:"pointsRefunded"`
+}
+
+// describeFailure classifies the error that caused a generation request to fail,
+// returning a message that's suitable for display to the viewer, along with any
+// moderation categories that were flagged
+func describeFailure(err error) (FailureReason, string, []string) {
+	var moderationErr *generation.ModerationError
+	if errors.As(err, &moderationErr) {
+		message := "Your request was flagged by moderation, so no image was generated."
+		if len(moderationErr.Categories) > 0 {
+			message = fmt.Sprintf("Your request was flagged by moderation (%s), so no image was generated.", strings.Join(moderationErr.Categories, ", "))
+		}
+		return FailureReasonFlagged, message, moderationErr.Categories
+	}
+	if errors.Is(err, generation.ErrRejected) {
+		return FailureReasonRejected, "Your request was rejected by the image generator's content policy, so no image was generated.", nil
+	}
+	if errors.Is(err, ledger.ErrNotEnoughPoints) {
+		return FailureReasonNotEnoughPoints, "You don't have enough points for that request.", nil
+	}
+	if errcode.Of(err) == CodeExpired {
+		return FailureReasonExpired, "Your request was interrupted, and it was too late to display it by the time we could pick it back up.", nil
+	}
+	return FailureReasonError, "Something went wrong while generating your image.", nil
+}
+
+// notifyFailure lets other services know that a viewer's generation request failed,
+// and why, so that the viewer can be told whether their points were spent: debited
+// indicates whether points were held for the request, and refunded indicates whether
+// they've since been released. The request has already failed by the time we get
+// here, so a failure to produce the event is merely logged.
+func (h *handler) notifyFailure(ctx context.Context, logger *slog.Logger, imageRequestId uuid.UUID, viewer core.Viewer, style genreq.ImageStyle, debited bool, refunded bool, err error) {
+	reason, message, categories := describeFailure(err)
+	if !debited || refunded {
+		message += " Your points were not spent."
+	} else {
+		message += " Your points could not be refunded automatically."
+	}
+	ev := GenerationEvent{
+		Type: GenerationEventTypeFailed,
+		Payload: GenerationFailure{
+			ImageRequestId: imageRequestId,
+			Viewer:         viewer,
+			Style:          style,
+			Reason:         reason,
+			Categories:     categories,
+			Message:        message,
+			PointsRefunded: refunded,
+		},
+	}
+	h.produceGenerationEvent(ctx, logger, ev)
+}
@@ -0,0 +1,72 @@
+package processing
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/ledger"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_describeFailure(t *testing.T) {
+	tests := []struct {
+		name           string
+		err            error
+		wantReason     FailureReason
+		wantMessage    string
+		wantCategories []string
+	}{
+		{
+			"flagged inputs list their categories",
+			fmt.Errorf("wrapped: %w", &generation.ModerationError{Categories: []string{"harassment", "violence"}}),
+			FailureReasonFlagged,
+			"Your request was flagged by moderation (harassment, violence), so no image was generated.",
+			[]string{"harassment", "violence"},
+		},
+		{
+			"flagged inputs without categories",
+			&generation.ModerationError{},
+			FailureReasonFlagged,
+			"Your request was flagged by moderation, so no image was generated.",
+			nil,
+		},
+		{
+			"prompt rejected by generation API",
+			fmt.Errorf("error in text generation: %w", generation.ErrRejected),
+			FailureReasonRejected,
+			"Your request was rejected by the image generator's content policy, so no image was generated.",
+			nil,
+		},
+		{
+			"viewer can't afford request",
+			ledger.ErrNotEnoughPoints,
+			FailureReasonNotEnoughPoints,
+			"You don't have enough points for that request.",
+			nil,
+		},
+		{
+			"request expired before it could be resumed",
+			Permanent(errcode.Wrap(CodeExpired, fmt.Errorf("request is older than the maximum recovery age of 1h0m0s"))),
+			FailureReasonExpired,
+			"Your request was interrupted, and it was too late to display it by the time we could pick it back up.",
+			nil,
+		},
+		{
+			"any other error",
+			fmt.Errorf("failed to upload generated image to storage: connection refused"),
+			FailureReasonError,
+			"Something went wrong while generating your image.",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			reason, message, categories := describeFailure(tt.err)
+			assert.Equal(t, tt.wantReason, reason)
+			assert.Equal(t, tt.wantMessage, message)
+			assert.Equal(t, tt.wantCategories, categories)
+		})
+	}
+}
@@ -5,25 +5,27 @@ import (
 	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"image/jpeg"
-	"image/png"
-	"os"
-	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/golden-vcr/auth"
 	"github.com/golden-vcr/dynamo/gen/queries"
-	"github.com/golden-vcr/dynamo/internal/discord"
+	"github.com/golden-vcr/dynamo/internal/errcode"
 	"github.com/golden-vcr/dynamo/internal/filters"
 	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/golden-vcr/dynamo/internal/outflow"
+	"github.com/golden-vcr/dynamo/internal/prompts"
 	"github.com/golden-vcr/dynamo/internal/storage"
-	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/dynamo/internal/tracing"
 	"github.com/golden-vcr/schemas/core"
 	genreq "github.com/golden-vcr/schemas/generation-requests"
-	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
 	"github.com/golden-vcr/server-common/rmq"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/exp/slog"
 )
 
@@ -31,70 +33,143 @@ const ImageAlertType = "image-generation"
 const ImageAlertPointsCost = 200
 
 type Handler interface {
-	Handle(ctx context.Context, logger *slog.Logger, r *genreq.Request) error
+	Handle(ctx context.Context, logger *slog.Logger, m *Message) error
+	Recover(ctx context.Context, logger *slog.Logger, minAge time.Duration, maxAge time.Duration) error
+	ResumeSelections(ctx context.Context, logger *slog.Logger) error
 }
 
-func NewHandler(q *queries.Queries, generationClient generation.Client, filterRunner filters.Runner, storageClient storage.Client, authServiceClient auth.ServiceClient, ledgerClient ledger.Client, onscreenEventsProducer rmq.Producer, discordGhostsWebhookUrl, discordFriendsWebhookUrl string) Handler {
+func NewHandler(q *queries.Queries, promptSource PromptSource, generationClient generation.Client, filterRunner filters.Runner, storageClient storage.Client, authServiceClient auth.ServiceClient, outflowClient outflow.Client, onscreenEventsProducer rmq.Producer, generationEventsProducer rmq.Producer, discordWebhookUrls map[string]string, candidates CandidateOptions, generationParams generation.StyleParams) Handler {
 	return &handler{
 		q:                        q,
+		promptSource:             promptSource,
 		generationClient:         generationClient,
 		filterRunner:             filterRunner,
 		storageClient:            storageClient,
 		authServiceClient:        authServiceClient,
-		ledgerClient:             ledgerClient,
+		outflowClient:            outflowClient,
 		onscreenEventsProducer:   onscreenEventsProducer,
-		discordGhostsWebhookUrl:  discordGhostsWebhookUrl,
-		discordFriendsWebhookUrl: discordFriendsWebhookUrl,
+		generationEventsProducer: generationEventsProducer,
+		discordWebhookUrls:       discordWebhookUrls,
+		candidates:               candidates,
+		generationParams:         generationParams,
 	}
 }
 
 type handler struct {
 	q                        Queries
+	promptSource             PromptSource
 	generationClient         generation.Client
 	filterRunner             filters.Runner
 	storageClient            storage.Client
 	authServiceClient        auth.ServiceClient
-	ledgerClient             ledger.Client
+	outflowClient            outflow.Client
 	onscreenEventsProducer   rmq.Producer
-	discordGhostsWebhookUrl  string
-	discordFriendsWebhookUrl string
+	generationEventsProducer rmq.Producer
+	discordWebhookUrls       map[string]string
+	candidates               CandidateOptions
+	generationParams         generation.StyleParams
 }
 
-func (h *handler) Handle(ctx context.Context, logger *slog.Logger, r *genreq.Request) error {
+func (h *handler) Handle(ctx context.Context, logger *slog.Logger, m *Message) (err error) {
+	ctx, span := tracing.Start(ctx, "processing.handle")
+	defer func() { tracing.End(span, err) }()
+
+	metrics.RequestsInFlight.Inc()
+	defer metrics.RequestsInFlight.Dec()
+
+	// Requests that we don't know how to handle will never succeed, no matter how many
+	// times we try
+	if err := ValidateRequest(&m.Request); err != nil {
+		return Permanent(fmt.Errorf("invalid generation request: %w", err))
+	}
+	metrics.RequestsConsumed.WithLabelValues(string(m.Request.Payload.Image.Style)).Inc()
+	span.SetAttributes(attribute.String(tracing.AttributeStyle, string(m.Request.Payload.Image.Style)))
+
+	// If we've already recorded a request from this message (e.g. because RabbitMQ
+	// redelivered it), don't charge the viewer for it again
+	idempotencyKey := m.IdempotencyKey()
+	if idempotencyKey == "" {
+		logger.Warn("Message has no idempotency key; redeliveries will not be recognized")
+	} else {
+		row, err := h.q.GetImageRequestByIdempotencyKey(ctx, idempotencyKey)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+		if err == nil {
+			if m.Redelivered || !m.hasBodyKey() {
+				span.SetAttributes(attribute.String(tracing.AttributeImageRequestId, row.ID.String()))
+				return h.handleDuplicate(ctx, logger, &row, m.FinalDelivery)
+			}
+
+			// A message that we've never received before is a new request that just
+			// happens to be identical to an earlier one (e.g. the same viewer asking for
+			// the same thing twice during a screening), so the key now identifies the
+			// new request, and any later redelivery will be matched to it
+			logger.Info("Message is identical to an earlier request; reassigning idempotency key", "previousImageRequestId", row.ID)
+			if err := h.q.ReleaseImageRequestIdempotencyKey(ctx, row.ID); err != nil {
+				return err
+			}
+		}
+	}
+
+	// If the producer didn't preassign an ID to this request, generate a new one;
+	// otherwise make sure we haven't already handled a request with the same ID
+	requestId := m.Id
+	if requestId == uuid.Nil {
+		requestId = uuid.New()
+		span.SetAttributes(attribute.String(tracing.AttributeImageRequestId, requestId.String()))
+	} else {
+		span.SetAttributes(attribute.String(tracing.AttributeImageRequestId, requestId.String()))
+		row, err := h.q.GetImageRequest(ctx, requestId)
+		if err == nil {
+			return h.handleDuplicate(ctx, logger, &row, m.FinalDelivery)
+		}
+		if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+	}
+
+	r := &m.Request
 	switch r.Type {
 	case genreq.RequestTypeImage:
-		return h.handleImageRequest(ctx, logger, &r.Viewer, &r.State, r.Payload.Image)
+		return h.handleImageRequest(ctx, logger, requestId, idempotencyKey, m.FinalDelivery, &r.Viewer, &r.State, r.Payload.Image)
 	}
-	return nil
+	return Permanent(fmt.Errorf("unsupported request type '%s'", r.Type))
 }
 
-func (h *handler) handleImageRequest(ctx context.Context, logger *slog.Logger, viewer *core.Viewer, state *core.State, payload *genreq.PayloadImage) error {
-	// Get an access token from the auth service that'll allow us to deduct points from
-	// the target viewer's balance
-	accessToken, err := h.authServiceClient.RequestServiceToken(ctx, auth.ServiceTokenRequest{
-		Service: "dynamo",
-		User: auth.UserDetails{
-			Id:          viewer.TwitchUserId,
-			Login:       strings.ToLower(viewer.TwitchDisplayName),
-			DisplayName: viewer.TwitchDisplayName,
-		},
-	})
-	if err != nil {
-		return err
+// handleDuplicate handles a message for which we've already recorded an image request:
+// if we started handling the request but were interrupted (or failed transiently), we
+// resume from where we left off; otherwise we report the outcome that the request
+// already reached, without charging the viewer or generating anything a second time
+func (h *handler) handleDuplicate(ctx context.Context, logger *slog.Logger, row *queries.DynamoImageRequest, finalDelivery bool) error {
+	if !row.FinishedAt.Valid {
+		return h.resumeImageRequest(ctx, logger, row, finalDelivery, false)
+	}
+	if row.ErrorMessage.Valid {
+		code := errcode.Unknown
+		if row.ErrorCode.Valid {
+			code = errcode.Code(row.ErrorCode.String)
+		}
+		return Permanent(errcode.Wrap(code, fmt.Errorf("image request %s has already failed: %s", row.ID, row.ErrorMessage.String)))
 	}
+	logger.Info("Image request has already succeeded; ignoring duplicate message", "imageRequestId", row.ID)
+	return nil
+}
 
-	// Contact the ledger service to create a pending transaction, ensuring that we can
-	// deduct the requisite number of points for this generation request
-	imageRequestId := uuid.New()
-	alertMetadata := json.RawMessage([]byte(fmt.Sprintf(`{"imageRequestId":"%s","style":"%s"}`, imageRequestId, payload.Style)))
-	transaction, err := h.ledgerClient.RequestAlertRedemption(ctx, accessToken, ImageAlertPointsCost, string(ImageAlertType), &alertMetadata)
+func (h *handler) handleImageRequest(ctx context.Context, logger *slog.Logger, imageRequestId uuid.UUID, idempotencyKey string, finalDelivery bool, viewer *core.Viewer, state *core.State, payload *genreq.PayloadImage) error {
+	// Associate all generation calls made from here on with this image request, so
+	// that each attempt can be recorded against it
+	ctx = generation.WithImageRequestId(ctx, imageRequestId)
+
+	// Prepare the details of our request before we debit any points
+	style, ok := styles.Get(payload.Style)
+	if !ok {
+		return Permanent(fmt.Errorf("unsupported image style '%s'", payload.Style))
+	}
+	inputs, err := json.Marshal(payload.Inputs)
 	if err != nil {
 		return err
 	}
-	defer transaction.Finalize(ctx)
-
-	// Record our image generation request in the database, and prepare a function that
-	// we can use to record its failure (prior to returning) in the event of any error
 	broadcastId := sql.NullInt32{}
 	if state.BroadcastId != 0 {
 		broadcastId.Valid = true
@@ -105,12 +180,35 @@ func (h *handler) handleImageRequest(ctx context.Context, logger *slog.Logger, v
 		screeningId.Valid = true
 		screeningId.UUID = state.ScreeningId
 	}
-	inputs, err := json.Marshal(payload.Inputs)
+
+	// Get an access token from the auth service that'll allow us to deduct points from
+	// the target viewer's balance
+	accessToken, err := h.requestServiceToken(ctx, viewer)
 	if err != nil {
 		return err
 	}
-	description := formatDescription(payload.Style, payload.Inputs)
-	prompt := formatPrompt(payload.Style, payload.Inputs)
+
+	// Record our image generation request in the database, in the 'received' stage,
+	// along with the version of the prompt template that we used (if any) and the
+	// params that we'll generate its assets with: we do so before debiting any points,
+	// so that any ledger transaction we create belongs to a request that can be
+	// resumed (or failed and refunded) if we're interrupted
+	prompt, promptTemplateId, promptTemplateVersion := h.renderPrompt(logger, style, prompts.KindImage, payload.Inputs)
+	j := &imageJob{
+		id:           imageRequestId,
+		viewer:       *viewer,
+		payload:      *payload,
+		style:        style,
+		prompt:       prompt,
+		accessToken:  accessToken,
+		params:       h.generationParams.Get(payload.Style),
+		finalAttempt: finalDelivery,
+		resumable:    idempotencyKey != "",
+	}
+	textModel := ""
+	if style.TextPrompt(payload.Inputs) != "" {
+		textModel = j.params.Text.Model
+	}
 	if err := h.q.RecordImageRequest(ctx, queries.RecordImageRequestParams{
 		ImageRequestID: imageRequestId,
 		TwitchUserID:   viewer.TwitchUserId,
@@ -118,295 +216,101 @@ func (h *handler) handleImageRequest(ctx context.Context, logger *slog.Logger, v
 		ScreeningID:    screeningId,
 		Style:          string(payload.Style),
 		Inputs:         inputs,
-		Prompt:         prompt,
-	}); err != nil {
-		return err
-	}
-	recordFailure := func(err error) error {
-		_, dbErr := h.q.RecordImageRequestFailure(ctx, queries.RecordImageRequestFailureParams{
-			ImageRequestID: imageRequestId,
-			ErrorMessage:   err.Error(),
-		})
-		return dbErr
-	}
-
-	// If this is a friend request, obtain an AI-generated name for our new friend
-	imageType := eonscreen.ImageTypeGhost
-	generatedText := ""
-	if payload.Style == genreq.ImageStyleFriend {
-		imageType = eonscreen.ImageTypeFriend
-		friendNamePrompt := fmt.Sprintf("Please come up with a name for a friendly mascot character who is %s. Please answer with a single name, and no additional text.", payload.Inputs.Friend.Subject)
-		friendName, err := h.generationClient.GenerateText(ctx, friendNamePrompt, viewer.TwitchUserId)
-		if err != nil {
-			recordFailure(fmt.Errorf("error in text generation: %w", err))
-			return err
-		}
-		if err := h.q.RecordAnswer(ctx, queries.RecordAnswerParams{
-			ImageRequestID: imageRequestId,
-			Prompt:         friendNamePrompt,
-			Value:          friendName,
-		}); err != nil {
-			recordFailure(err)
-			return err
-		}
-		generatedText = friendName
-	}
-
-	// Generate a new image, waiting until it's ready
-	image, err := h.generationClient.GenerateImage(ctx, prompt, viewer.TwitchUserId)
-	if err != nil {
-		recordFailure(err)
-		return err
-	}
-
-	// If this is a friend image, prepare an in-memory JPEG, with the background still
-	// intact, that we can post to Discord (TODO this is overly-complicated control flow
-	// resulting from an attempt to cram too much functionality into this routine)
-	var friendJpegData []byte
-	if payload.Style == genreq.ImageStyleFriend && h.discordFriendsWebhookUrl != "" {
-		bmpData, err := png.Decode(bytes.NewReader(image.Data))
-		if err != nil {
-			err = fmt.Errorf("failed to decode PNG data for OpenAI-hosted image: %w", err)
-			recordFailure(err)
-			return err
-		}
-		jpegBuffer := bytes.NewBuffer(make([]byte, 0, 512*1024))
-		if err := jpeg.Encode(jpegBuffer, bmpData, &jpeg.Options{Quality: 80}); err != nil {
-			err = fmt.Errorf("failed to encode JPEG image from decoded PNG image: %w", err)
-			recordFailure(err)
-			return err
-		}
-		friendJpegData = jpegBuffer.Bytes()
-	}
-
-	// If the image needs its background removed, use our remove-background routine from
-	// the image-filters library to detect the background color and key it out,
-	// producing a compressed WEBP image with a transparent background
-	backgroundColor := "#000000"
-	if payload.Style == genreq.ImageStyleFriend {
-		// For a friend image, use an external utility to convert from PNG to WEBP,
-		// keying out the background in the process
-		basename := fmt.Sprintf("imf_%s", imageRequestId)
-
-		// Write the PNG to disk temporarily so it can be processed by another program
-		infile, err := os.CreateTemp("", basename+".png")
-		if err != nil {
-			recordFailure(err)
-			return err
-		}
-		defer infile.Close()
-		defer os.Remove(infile.Name())
-		if _, err := infile.Write(image.Data); err != nil {
-			recordFailure(err)
-			return err
-		}
-		infile.Close()
-
-		// Build the path to our processed WEBP file
-		outfileName := strings.TrimSuffix(infile.Name(), filepath.Ext(infile.Name())) + ".webp"
-		defer os.Remove(outfileName)
-
-		// Invoke 'imf remove-background -i <infile> -o <outfile>' to write a new image,
-		// capturing the detected background color
-		color, err := h.filterRunner.RemoveBackground(ctx, infile.Name(), outfileName)
-		if err != nil {
-			recordFailure(err)
-			return err
-		}
-		backgroundColor = color
-
-		// Read the newly-written WEBP file from disk to get our final image data
-		webpData, err := os.ReadFile(outfileName)
-		if err != nil {
-			recordFailure(err)
-			return err
-		}
-		image.ContentType = "image/webp"
-		image.Data = webpData
-	} else {
-		// For images that don't need to be processed with image-filters, convert from
-		// PNG to JPEG in-memory
-		bmpData, err := png.Decode(bytes.NewReader(image.Data))
-		if err != nil {
-			err = fmt.Errorf("failed to decode PNG data for OpenAI-hosted image: %w", err)
-			recordFailure(err)
-			return err
-		}
-
-		// Preallocate a buffer that's roughly as large as the largest 1024x1024 JPEG
-		// we can reasonably expect to produce, then write our compressed JPEG data into
-		// it
-		jpegBuffer := bytes.NewBuffer(make([]byte, 0, 512*1024))
-		if err := jpeg.Encode(jpegBuffer, bmpData, &jpeg.Options{Quality: 80}); err != nil {
-			err = fmt.Errorf("failed to encode JPEG image from decoded PNG image: %w", err)
-			recordFailure(err)
-			return err
-		}
-
-		// Replace the image with our compressed JPEG version
-		image.ContentType = "image/jpeg"
-		image.Data = jpegBuffer.Bytes()
-	}
-
-	// Store the resulting image in our S3-compatible bucket, for posterity and so it
-	// can be served to the alerts overlay
-	imageUrl, err := storeImage(ctx, imageRequestId, h.q, h.storageClient, image, backgroundColor)
-	if err != nil {
-		recordFailure(err)
-		return err
-	}
-
-	// Flag the image generation request as successful, since we've now generated all
-	// required assets
-	if _, err := h.q.RecordImageRequestSuccess(ctx, imageRequestId); err != nil {
-		return err
-	}
-
-	// Generate an alert that will display the image onscreen during the stream
-	ev := eonscreen.Event{
-		Type: eonscreen.EventTypeImage,
-		Payload: eonscreen.Payload{
-			Image: &eonscreen.PayloadImage{
-				Type:    imageType,
-				Viewer:  *viewer,
-				Details: eonscreen.ImageDetails{},
-			},
+		Prompt:         j.prompt,
+		TwitchDisplayName: sql.NullString{
+			Valid:  true,
+			String: viewer.TwitchDisplayName,
 		},
-	}
-	switch ev.Payload.Image.Type {
-	case eonscreen.ImageTypeGhost:
-		ev.Payload.Image.Details.Ghost = &eonscreen.ImageDetailsGhost{
-			ImageUrl:    imageUrl,
-			Description: description,
-		}
-	case eonscreen.ImageTypeFriend:
-		ev.Payload.Image.Details.Friend = &eonscreen.ImageDetailsFriend{
-			ImageUrl:        imageUrl,
-			Description:     description,
-			Name:            generatedText,
-			BackgroundColor: backgroundColor,
+		PromptTemplateID:      promptTemplateId,
+		PromptTemplateVersion: promptTemplateVersion,
+		IdempotencyKey: sql.NullString{
+			Valid:  idempotencyKey != "",
+			String: idempotencyKey,
+		},
+		ImageModel:    nullString(j.params.Image.Model),
+		ImageSize:     nullString(j.params.Image.Size),
+		ImageQuality:  nullString(j.params.Image.Quality),
+		ImageStyle:    nullString(j.params.Image.Style),
+		TextModel:     nullString(textModel),
+		ImageProvider: nullString(j.params.Image.Provider),
+	}); err != nil {
+		// If another worker recorded a request from the same message in the meantime,
+		// it's responsible for handling it, so this delivery is a no-op
+		if isDuplicateIdempotencyKey(err) {
+			logger.Warn("Image request is already being handled; ignoring duplicate message", "idempotencyKey", idempotencyKey)
+			return nil
 		}
-	default:
-		return fmt.Errorf("unhandled image type")
-	}
-	if err := h.produceOnscreenEvent(ctx, logger, ev); err != nil {
-		recordFailure(err)
 		return err
 	}
 
-	// We've successfully generated an alert from the user's request, so finalize the
-	// transaction to deduct the points we debited from them - if we don't make it here,
-	// our deferred called to transaction.Finalize will reject the transaction instead,
-	// causing the debited points to be refunded
-	if err := transaction.Accept(ctx); err != nil {
-		return fmt.Errorf("failed to finalize transaction: %w", err)
-	}
-
-	// Don't hold up the request to do this; just initiate a fire-and-forget HTTP
-	// request to a Discord webhook, so that we can post this image to our #ghosts
-	// channel in the Discord server. If the request fails, we'll simply print an error.
-	if h.discordGhostsWebhookUrl != "" && payload.Style == genreq.ImageStyleGhost {
-		go func() {
-			err := discord.PostGhostAlert(h.discordGhostsWebhookUrl, viewer.TwitchDisplayName, description, imageUrl)
-			if err != nil {
-				logger.Error("ERROR: Failed to post ghost alert to Discord", "error", err)
-			}
-		}()
-	}
-
-	// Post friend images to Discord asynchronously as well (TODO: too many concerns,
-	// wonky control flow / variable scope)
-	if h.discordFriendsWebhookUrl != "" && friendJpegData != nil {
-		go func() {
-			imageFilename := imageUrl
-			slashPos := strings.LastIndex(imageUrl, "/")
-			if slashPos >= 0 {
-				imageFilename = imageUrl[slashPos+1:]
-			}
-			err := discord.PostFriendAlert(h.discordFriendsWebhookUrl, viewer.TwitchDisplayName, description, generatedText, imageFilename, friendJpegData)
-			if err != nil {
-				logger.Error("ERROR: Failed to post friend alert to Discord", "error", err)
-			}
-		}()
-	}
-
-	return nil
+	// Carry out all remaining stages of processing
+	return h.processImageRequest(ctx, logger, j, StageReceived)
 }
 
-func formatDescription(style genreq.ImageStyle, inputs genreq.ImageInputs) string {
-	switch style {
-	case genreq.ImageStyleGhost:
-		return inputs.Ghost.Subject
-	case genreq.ImageStyleFriend:
-		return inputs.Friend.Subject
-	}
-	return "an image"
+// requestServiceToken gets an access token from the auth service that will authorize us
+// to debit points from (and finalize transactions for) the given viewer
+func (h *handler) requestServiceToken(ctx context.Context, viewer *core.Viewer) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "auth.request_service_token")
+	defer func() { tracing.End(span, err) }()
+	defer metrics.ObserveDuration(metrics.OperationAuthToken, time.Now())
+	return h.authServiceClient.RequestServiceToken(ctx, auth.ServiceTokenRequest{
+		Service: "dynamo",
+		User: auth.UserDetails{
+			Id:          viewer.TwitchUserId,
+			Login:       strings.ToLower(viewer.TwitchDisplayName),
+			DisplayName: viewer.TwitchDisplayName,
+		},
+	})
 }
 
-func formatPrompt(style genreq.ImageStyle, inputs genreq.ImageInputs) string {
-	switch style {
-	case genreq.ImageStyleGhost:
-		return fmt.Sprintf("a ghostly image of %s, with glitchy VHS artifacts, dark background", inputs.Ghost.Subject)
-	case genreq.ImageStyleFriend:
-		color := inputs.Friend.Color
-		backgroundColor := inputs.Friend.Color.GetComplement()
-
-		article := ""
-		subject := inputs.Friend.Subject
-		if strings.HasPrefix(subject, "a ") {
-			if color[0] == 'a' || color[0] == 'e' || color[0] == 'i' || color[0] == 'o' || color[0] == 'u' {
-				article = "an"
-			} else {
-				article = "a"
-			}
-			subject = subject[2:]
-		} else if strings.HasPrefix(subject, "an ") {
-			if color[0] == 'a' || color[0] == 'e' || color[0] == 'i' || color[0] == 'o' || color[0] == 'u' {
-				article = "an"
-			} else {
-				article = "a"
-			}
-			subject = subject[3:]
-		} else if strings.HasPrefix(subject, "the ") {
-			subject = subject[4:]
-		}
-
-		return fmt.Sprintf("%s %s %s, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid %s background suitable for chroma keying",
-			article,
-			color,
-			subject,
-			backgroundColor,
-		)
+// setStage records that the given image request has completed the given stage
+func (h *handler) setStage(ctx context.Context, imageRequestId uuid.UUID, stage Stage) error {
+	if err := h.q.SetImageRequestStage(ctx, queries.SetImageRequestStageParams{
+		Stage:          string(stage),
+		ImageRequestID: imageRequestId,
+	}); err != nil {
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record stage '%s': %w", stage, err))
 	}
-	return "a sign that says BAD STYLE, UNABLE TO FORMAT PROMPT"
+	return nil
 }
 
-func formatImageKey(imageRequestId uuid.UUID, contentType string) string {
+func formatImageKey(imageRequestId uuid.UUID, index int, contentType string) string {
 	ext := ".jpg"
 	if contentType == "image/png" {
 		ext = ".png"
 	} else if contentType == "image/webp" {
 		ext = ".webp"
 	}
-	return fmt.Sprintf("%s/%s-0%s", imageRequestId, imageRequestId, ext)
+	return fmt.Sprintf("%s/%s-%d%s", imageRequestId, imageRequestId, index, ext)
 }
 
-func storeImage(ctx context.Context, imageRequestId uuid.UUID, q Queries, storageClient storage.Client, image *generation.Image, color string) (string, error) {
+func storeImage(ctx context.Context, imageRequestId uuid.UUID, index int, q Queries, storageClient storage.Client, image *generation.Image, color string) (string, error) {
 	// Store the image in our S3-compatible bucket
-	key := formatImageKey(imageRequestId, image.ContentType)
-	imageUrl, err := storageClient.Upload(ctx, key, image.ContentType, bytes.NewReader(image.Data))
+	key := formatImageKey(imageRequestId, index, image.ContentType)
+	uploadCtx, span := tracing.Start(ctx, "storage.upload", attribute.String("storage.key", key))
+	startedAt := time.Now()
+	imageUrl, err := storageClient.Upload(uploadCtx, key, image.ContentType, bytes.NewReader(image.Data))
+	metrics.ObserveDuration(metrics.OperationUpload, startedAt)
+	tracing.End(span, err)
 	if err != nil {
-		return "", fmt.Errorf("failed to upload generated image to storage: %w", err)
+		return "", errcode.Wrap(storage.CodeFailed, fmt.Errorf("failed to upload generated image to storage: %w", err))
 	}
 
 	// Record the fact that we've received this generated image
 	if err := q.RecordImage(ctx, queries.RecordImageParams{
 		ImageRequestID: imageRequestId,
-		Index:          0,
+		Index:          int32(index),
 		Url:            imageUrl,
 		Color:          color,
+		Provider:       nullString(image.Provider),
 	}); err != nil {
-		return "", fmt.Errorf("failed to record newly-stored image URL in database: %w", err)
+		return "", errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record newly-stored image URL in database: %w", err))
 	}
 	return imageUrl, nil
 }
+
+// nullString converts an optional string value to a nullable column value, treating
+// the empty string as NULL
+func nullString(s string) sql.NullString {
+	return sql.NullString{Valid: s != "", String: s}
+}
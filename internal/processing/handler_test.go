@@ -0,0 +1,457 @@
+package processing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_handler_Handle_idempotency(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	finishedAt := sql.NullTime{Valid: true, Time: time.Date(2024, 2, 1, 12, 0, 0, 0, time.UTC)}
+	request := genreq.Request{
+		Type:   genreq.RequestTypeImage,
+		Viewer: core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+		Payload: genreq.Payload{Image: &genreq.PayloadImage{
+			Style:  genreq.ImageStyleGhost,
+			Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+		}},
+	}
+	tests := []struct {
+		name               string
+		m                  Message
+		existingRows       map[string]queries.DynamoImageRequest
+		wantErr            string
+		wantErrCode        errcode.Code
+		wantNumRedemptions int
+		wantRecordedKey    sql.NullString
+	}{
+		{
+			"redelivered message for a successful request is a no-op",
+			Message{Request: request, MessageId: "msg-1234"},
+			map[string]queries.DynamoImageRequest{
+				"msg:17a8ae706bd584f9fa66ba2b4723bc3302909b0bacb84adf9cd763a8d56ec354": {
+					ID:         imageRequestId,
+					FinishedAt: finishedAt,
+				},
+			},
+			"",
+			"",
+			0,
+			sql.NullString{},
+		},
+		{
+			"redelivered message for a failed request reports the original failure",
+			Message{Id: imageRequestId, Request: request},
+			map[string]queries.DynamoImageRequest{
+				"id:b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11": {
+					ID:           imageRequestId,
+					FinishedAt:   finishedAt,
+					ErrorMessage: sql.NullString{Valid: true, String: "image generation request rejected: nope"},
+					ErrorCode:    sql.NullString{Valid: true, String: "generation.rejected"},
+				},
+			},
+			"image request b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11 has already failed: image generation request rejected: nope",
+			generation.CodeRejected,
+			0,
+			sql.NullString{},
+		},
+		{
+			"new message is recorded with its idempotency key",
+			Message{Request: request, MessageId: "msg-1234"},
+			nil,
+			"mock generation error",
+			errcode.Unknown,
+			1,
+			sql.NullString{Valid: true, String: "msg:17a8ae706bd584f9fa66ba2b4723bc3302909b0bacb84adf9cd763a8d56ec354"},
+		},
+		{
+			"new message without any ID is recorded with a key derived from its body",
+			Message{Request: request},
+			nil,
+			"mock generation error",
+			errcode.Unknown,
+			1,
+			sql.NullString{Valid: true, String: "body:fccb0fe360d4da8538f737a9d49b0b34f3b27504d1e22ca54f280084ddcdbf7c"},
+		},
+		{
+			"redelivered message without any ID is recognized by its body",
+			Message{Request: request, Redelivered: true},
+			map[string]queries.DynamoImageRequest{
+				"body:fccb0fe360d4da8538f737a9d49b0b34f3b27504d1e22ca54f280084ddcdbf7c": {
+					ID:         imageRequestId,
+					FinishedAt: finishedAt,
+				},
+			},
+			"",
+			"",
+			0,
+			sql.NullString{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{existingRows: tt.existingRows}
+			outflowClient := &mockOutflowClient{}
+			h := &handler{
+				q:                        q,
+				generationClient:         &mockGenerationClient{err: fmt.Errorf("mock generation error")},
+				storageClient:            &mockStorageClient{},
+				authServiceClient:        &mockAuthServiceClient{},
+				outflowClient:            outflowClient,
+				onscreenEventsProducer:   &mockProducer{},
+				generationEventsProducer: &mockProducer{},
+			}
+
+			err := h.Handle(context.Background(), slog.Default(), &tt.m)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, tt.wantErrCode, errcode.Of(err))
+			}
+			assert.Equal(t, tt.wantNumRedemptions, outflowClient.numRedemptions)
+			if tt.wantNumRedemptions > 0 && assert.Len(t, q.recorded, 1) {
+				assert.Equal(t, tt.wantRecordedKey, q.recorded[0].IdempotencyKey)
+			} else {
+				assert.Empty(t, q.recorded)
+			}
+		})
+	}
+}
+
+func Test_handler_Handle_redelivery(t *testing.T) {
+	m := Message{
+		Request: genreq.Request{
+			Type:   genreq.RequestTypeImage,
+			Viewer: core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+			Payload: genreq.Payload{Image: &genreq.PayloadImage{
+				Style:  genreq.ImageStyleGhost,
+				Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+			}},
+		},
+		MessageId: "msg-1234",
+	}
+	t.Run("transient failure is resumed on redelivery", func(t *testing.T) {
+		q := &mockQueries{}
+		generationClient := &mockGenerationClient{err: fmt.Errorf("mock generation error")}
+		outflowClient := &mockOutflowClient{}
+		onscreenEventsProducer := &mockProducer{}
+		generationEventsProducer := &mockProducer{}
+		h := &handler{
+			q:                        q,
+			generationClient:         generationClient,
+			storageClient:            &mockStorageClient{},
+			authServiceClient:        &mockAuthServiceClient{},
+			outflowClient:            outflowClient,
+			onscreenEventsProducer:   onscreenEventsProducer,
+			generationEventsProducer: generationEventsProducer,
+		}
+
+		// The first delivery fails transiently: the request is left unfinished, with the
+		// viewer's points still held, and the viewer isn't told that it failed
+		err := h.Handle(context.Background(), slog.Default(), &m)
+		assert.EqualError(t, err, "mock generation error")
+		assert.Equal(t, 1, outflowClient.numRedemptions)
+		assert.Equal(t, "", q.failure)
+		assert.Equal(t, uuid.Nil, outflowClient.rejected)
+		assert.Empty(t, generationEventsProducer.messages)
+
+		// Once the generation backend recovers, the redelivered message resumes the same
+		// request, without charging the viewer again
+		generationClient.err = nil
+		err = h.Handle(context.Background(), slog.Default(), &m)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, outflowClient.numRedemptions)
+		assert.Len(t, q.recorded, 1)
+		assert.True(t, q.succeeded)
+		assert.NotEqual(t, uuid.Nil, outflowClient.accepted)
+		assert.Len(t, onscreenEventsProducer.messages, 1)
+		assert.Empty(t, generationEventsProducer.messages)
+
+		// Any further redelivery is a no-op
+		err = h.Handle(context.Background(), slog.Default(), &m)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, outflowClient.numRedemptions)
+		assert.Len(t, onscreenEventsProducer.messages, 1)
+	})
+	t.Run("message without an ID is resumed when RabbitMQ redelivers it", func(t *testing.T) {
+		q := &mockQueries{}
+		generationClient := &mockGenerationClient{err: fmt.Errorf("mock generation error")}
+		outflowClient := &mockOutflowClient{}
+		h := &handler{
+			q:                        q,
+			generationClient:         generationClient,
+			storageClient:            &mockStorageClient{},
+			authServiceClient:        &mockAuthServiceClient{},
+			outflowClient:            outflowClient,
+			onscreenEventsProducer:   &mockProducer{},
+			generationEventsProducer: &mockProducer{},
+		}
+
+		// The first delivery fails transiently, and if we can't requeue a copy of the
+		// message, RabbitMQ redelivers the original, which still has no message ID
+		anonymous := Message{Request: m.Request}
+		err := h.Handle(context.Background(), slog.Default(), &anonymous)
+		assert.EqualError(t, err, "mock generation error")
+		assert.Equal(t, 1, outflowClient.numRedemptions)
+
+		// The redelivery has the same body, so it resumes the same request without
+		// charging the viewer again
+		generationClient.err = nil
+		redelivered := anonymous
+		redelivered.Redelivered = true
+		err = h.Handle(context.Background(), slog.Default(), &redelivered)
+		assert.NoError(t, err)
+		assert.Equal(t, 1, outflowClient.numRedemptions)
+		assert.Len(t, q.recorded, 1)
+		assert.True(t, q.succeeded)
+		assert.Empty(t, q.released)
+	})
+	t.Run("new message identical to an earlier request is handled separately", func(t *testing.T) {
+		q := &mockQueries{}
+		outflowClient := &mockOutflowClient{}
+		h := &handler{
+			q:                        q,
+			generationClient:         &mockGenerationClient{},
+			storageClient:            &mockStorageClient{},
+			authServiceClient:        &mockAuthServiceClient{},
+			outflowClient:            outflowClient,
+			onscreenEventsProducer:   &mockProducer{},
+			generationEventsProducer: &mockProducer{},
+		}
+
+		// A viewer who asks for the same thing twice should get two images, even though
+		// both messages have the same body
+		first := Message{Request: m.Request}
+		err := h.Handle(context.Background(), slog.Default(), &first)
+		assert.NoError(t, err)
+		second := Message{Request: m.Request}
+		err = h.Handle(context.Background(), slog.Default(), &second)
+		assert.NoError(t, err)
+		assert.Equal(t, 2, outflowClient.numRedemptions)
+		if assert.Len(t, q.recorded, 2) && assert.Len(t, q.released, 1) {
+			assert.Equal(t, q.recorded[0].ImageRequestID, q.released[0])
+			assert.Equal(t, q.recorded[0].IdempotencyKey, q.recorded[1].IdempotencyKey)
+		}
+	})
+	t.Run("transient failure on final delivery fails the request", func(t *testing.T) {
+		q := &mockQueries{}
+		outflowClient := &mockOutflowClient{}
+		generationEventsProducer := &mockProducer{}
+		h := &handler{
+			q:                        q,
+			generationClient:         &mockGenerationClient{err: fmt.Errorf("mock generation error")},
+			storageClient:            &mockStorageClient{},
+			authServiceClient:        &mockAuthServiceClient{},
+			outflowClient:            outflowClient,
+			onscreenEventsProducer:   &mockProducer{},
+			generationEventsProducer: generationEventsProducer,
+		}
+
+		final := m
+		final.FinalDelivery = true
+		err := h.Handle(context.Background(), slog.Default(), &final)
+		assert.EqualError(t, err, "mock generation error")
+		assert.Equal(t, "mock generation error", q.failure)
+		assert.NotEqual(t, uuid.Nil, outflowClient.rejected)
+		assert.Len(t, generationEventsProducer.messages, 1)
+	})
+}
+
+func Test_handler_Handle_generationParams(t *testing.T) {
+	tests := []struct {
+		name             string
+		payload          genreq.PayloadImage
+		generationParams generation.StyleParams
+		wantImageParams  generation.ImageParams
+		wantImageStyle   sql.NullString
+		wantTextModel    sql.NullString
+		wantProvider     sql.NullString
+	}{
+		{
+			"ghost request uses default params",
+			genreq.PayloadImage{
+				Style:  genreq.ImageStyleGhost,
+				Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+			},
+			nil,
+			generation.DefaultParams.Image,
+			sql.NullString{Valid: true, String: "vivid"},
+			sql.NullString{},
+			sql.NullString{},
+		},
+		{
+			"ghost request uses params configured for its style",
+			genreq.PayloadImage{
+				Style:  genreq.ImageStyleGhost,
+				Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+			},
+			generation.StyleParams{
+				genreq.ImageStyleGhost:  {Image: generation.ImageParams{Style: "natural"}},
+				genreq.ImageStyleFriend: {Image: generation.ImageParams{Quality: "hd"}},
+			},
+			generation.ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"},
+			sql.NullString{Valid: true, String: "natural"},
+			sql.NullString{},
+			sql.NullString{},
+		},
+		{
+			"friend request records text model",
+			genreq.PayloadImage{
+				Style:  genreq.ImageStyleFriend,
+				Inputs: genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a crab", Color: "blue"}},
+			},
+			generation.StyleParams{
+				genreq.ImageStyleFriend: {Text: generation.TextParams{Model: "gpt-4o"}},
+			},
+			generation.DefaultParams.Image,
+			sql.NullString{Valid: true, String: "vivid"},
+			sql.NullString{Valid: true, String: "gpt-4o"},
+			sql.NullString{},
+		},
+		{
+			"request records image provider",
+			genreq.PayloadImage{
+				Style:  genreq.ImageStyleGhost,
+				Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+			},
+			generation.StyleParams{
+				genreq.ImageStyleGhost: {Image: generation.ImageParams{Provider: "automatic1111", Model: "sd_xl_base_1.0.safetensors", NegativePrompt: "blurry", Backend: &generation.ImageParams{Style: "natural"}}},
+			},
+			generation.ImageParams{
+				Provider:       "automatic1111",
+				Model:          "sd_xl_base_1.0.safetensors",
+				Size:           "1024x1024",
+				NegativePrompt: "blurry",
+				Backend:        &generation.ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"},
+			},
+			sql.NullString{},
+			sql.NullString{},
+			sql.NullString{Valid: true, String: "automatic1111"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			generationClient := &mockGenerationClient{err: fmt.Errorf("mock generation error")}
+			h := &handler{
+				q:                        q,
+				generationClient:         generationClient,
+				storageClient:            &mockStorageClient{},
+				authServiceClient:        &mockAuthServiceClient{},
+				outflowClient:            &mockOutflowClient{},
+				onscreenEventsProducer:   &mockProducer{},
+				generationEventsProducer: &mockProducer{},
+				generationParams:         tt.generationParams,
+			}
+
+			payload := tt.payload
+			err := h.Handle(context.Background(), slog.Default(), &Message{Request: genreq.Request{
+				Type:    genreq.RequestTypeImage,
+				Viewer:  core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+				Payload: genreq.Payload{Image: &payload},
+			}})
+			assert.EqualError(t, err, "mock generation error")
+			if assert.Len(t, q.recorded, 1) {
+				assert.Equal(t, sql.NullString{Valid: true, String: tt.wantImageParams.Model}, q.recorded[0].ImageModel)
+				assert.Equal(t, tt.wantImageStyle, q.recorded[0].ImageStyle)
+				assert.Equal(t, tt.wantTextModel, q.recorded[0].TextModel)
+				assert.Equal(t, tt.wantProvider, q.recorded[0].ImageProvider)
+			}
+			assert.Equal(t, []generation.ImageParams{tt.wantImageParams}, generationClient.imageParams)
+		})
+	}
+}
+
+func Test_handler_Handle_backendOutage(t *testing.T) {
+	// Our generation backend is down, so every call to it fails transiently: moderation
+	// and text generation should fall back to local rules and canned names, and images
+	// should be generated by a Stable Diffusion provider instead
+	stableDiffusion := &mockGenerationClient{}
+	generationClient := generation.NewFailoverClient(
+		slog.Default(),
+		generation.NewProviderClient(&mockOutageClient{}, map[string]generation.ImageGenerator{
+			"automatic1111": stableDiffusion,
+		}),
+		generation.FailoverOptions{
+			BackendName: "openai",
+			Order:       []string{"openai", "automatic1111"},
+			MaxFailures: 3,
+			Cooldown:    time.Minute,
+			Fallback:    generation.NewFallbackClient(nil),
+		},
+	)
+	q := &mockQueries{}
+	outflowClient := &mockOutflowClient{}
+	onscreenEventsProducer := &mockProducer{}
+	h := &handler{
+		q:                        q,
+		generationClient:         generationClient,
+		filterRunner:             &mockFilterRunner{},
+		storageClient:            &mockStorageClient{},
+		authServiceClient:        &mockAuthServiceClient{},
+		outflowClient:            outflowClient,
+		onscreenEventsProducer:   onscreenEventsProducer,
+		generationEventsProducer: &mockProducer{},
+	}
+
+	err := h.Handle(context.Background(), slog.Default(), &Message{Request: genreq.Request{
+		Type:   genreq.RequestTypeImage,
+		Viewer: core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+		Payload: genreq.Payload{Image: &genreq.PayloadImage{
+			Style:  genreq.ImageStyleFriend,
+			Inputs: genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a crab", Color: "blue"}},
+		}},
+	}})
+	assert.NoError(t, err)
+	assert.True(t, q.succeeded)
+	assert.Equal(t, "", q.failure)
+	assert.NotEqual(t, uuid.Nil, outflowClient.accepted)
+	assert.Len(t, onscreenEventsProducer.messages, 1)
+	if assert.Len(t, q.moderations, 1) {
+		assert.Equal(t, "rules", q.moderations[0].ModerationSource)
+	}
+	assert.Equal(t, 1, stableDiffusion.numImages)
+	if assert.Len(t, q.recordedImages, 1) {
+		assert.Equal(t, sql.NullString{Valid: true, String: "automatic1111"}, q.recordedImages[0].Provider)
+	}
+}
+
+// mockOutageClient stands in for a generation backend that's unavailable
+type mockOutageClient struct{}
+
+func (m *mockOutageClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*generation.Moderation, error) {
+	return nil, &generation.StatusError{StatusCode: 503, Message: "outage"}
+}
+
+func (m *mockOutageClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params generation.TextParams) (string, error) {
+	return "", &generation.StatusError{StatusCode: 503, Message: "outage"}
+}
+
+func (m *mockOutageClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params generation.ImageParams) (*generation.Image, error) {
+	return nil, &generation.StatusError{StatusCode: 503, Message: "outage"}
+}
+
+// mockFilterRunner removes the background from an image by copying it as-is
+type mockFilterRunner struct{}
+
+func (m *mockFilterRunner) RemoveBackground(ctx context.Context, infile string, outfile string) (string, error) {
+	data, err := os.ReadFile(infile)
+	if err != nil {
+		return "", err
+	}
+	return "#000000", os.WriteFile(outfile, data, 0o644)
+}
@@ -0,0 +1,117 @@
+package processing
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+)
+
+// Message is the body of a message produced to the generation-requests queue: a
+// genreq.Request, optionally accompanied by an ID that the producer has preassigned to
+// the request (e.g. so that a client who submitted it via the dynamo API can look up
+// the results later). If Id is uuid.Nil, the handler will assign a new ID. MessageId,
+// Redelivered, and FinalDelivery are not part of the message body: MessageId is the ID
+// that the producer assigned to the AMQP message that carried it, if any; Redelivered
+// is true if we've received the same message before; and FinalDelivery is true if the
+// message won't be redelivered should handling it fail.
+type Message struct {
+	Id            uuid.UUID
+	Request       genreq.Request
+	MessageId     string
+	Redelivered   bool
+	FinalDelivery bool
+}
+
+// IdempotencyKey returns a key that identifies the generation request carried by this
+// message, such that every delivery of the same message yields the same key: if the
+// producer preassigned an ID to the request, the key is derived from that ID; if it
+// assigned an ID to the AMQP message, the key is derived from a hash of that ID;
+// otherwise it's derived from a hash of the message body. Most producers (including
+// server-common's rmq.Producer) don't set a message ID, and a body-derived key is
+// shared by any two messages that carry identical requests, so it only identifies a
+// message that has the same key as an earlier request if Redelivered is true.
+func (m *Message) IdempotencyKey() string {
+	if m.Id != uuid.Nil {
+		return "id:" + m.Id.String()
+	}
+	if m.MessageId != "" {
+		sum := sha256.Sum256([]byte(m.MessageId))
+		return "msg:" + hex.EncodeToString(sum[:])
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(data)
+	return "body:" + hex.EncodeToString(sum[:])
+}
+
+// hasBodyKey returns true if the message's idempotency key is derived from its body,
+// since the producer didn't assign an ID to the request or the message
+func (m *Message) hasBodyKey() bool {
+	return m.Id == uuid.Nil && m.MessageId == ""
+}
+
+func (m *Message) UnmarshalJSON(data []byte) error {
+	var f struct {
+		Id uuid.UUID `json:"id"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &m.Request); err != nil {
+		return err
+	}
+	m.Id = f.Id
+	return nil
+}
+
+func (m Message) MarshalJSON() ([]byte, error) {
+	type fields struct {
+		Id      *uuid.UUID         `json:"id,omitempty"`
+		Type    genreq.RequestType `json:"type"`
+		Viewer  core.Viewer        `json:"viewer"`
+		State   core.State         `json:"state"`
+		Payload genreq.Payload     `json:"payload"`
+	}
+	f := fields{
+		Type:    m.Request.Type,
+		Viewer:  m.Request.Viewer,
+		State:   m.Request.State,
+		Payload: m.Request.Payload,
+	}
+	if m.Id != uuid.Nil {
+		f.Id = &m.Id
+	}
+	return json.Marshal(f)
+}
+
+// ValidateRequest returns an error if the given request is not something we can
+// handle, e.g. because it has an unsupported type or is missing required inputs
+func ValidateRequest(r *genreq.Request) error {
+	if r.Viewer.TwitchUserId == "" || r.Viewer.TwitchDisplayName == "" {
+		return fmt.Errorf("viewer must have a twitch_user_id and twitch_display_name")
+	}
+	switch r.Type {
+	case genreq.RequestTypeImage:
+		if r.Payload.Image == nil {
+			return fmt.Errorf("payload is required for request type '%s'", r.Type)
+		}
+		return validateImageInputs(r.Payload.Image.Style, &r.Payload.Image.Inputs)
+	}
+	return fmt.Errorf("unsupported request type '%s'", r.Type)
+}
+
+func validateImageInputs(name genreq.ImageStyle, inputs *genreq.ImageInputs) error {
+	style, ok := styles.Get(name)
+	if !ok {
+		return fmt.Errorf("unsupported image style '%s'", name)
+	}
+	return style.Validate(inputs)
+}
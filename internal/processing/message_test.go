@@ -0,0 +1,182 @@
+package processing
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Message(t *testing.T) {
+	request := genreq.Request{
+		Type: genreq.RequestTypeImage,
+		Viewer: core.Viewer{
+			TwitchUserId:      "90790024",
+			TwitchDisplayName: "wasabimilkshake",
+		},
+		Payload: genreq.Payload{
+			Image: &genreq.PayloadImage{
+				Style: genreq.ImageStyleGhost,
+				Inputs: genreq.ImageInputs{
+					Ghost: &genreq.ImageInputsGhost{
+						Subject: "a seal",
+					},
+				},
+			},
+		},
+	}
+	t.Run("message with preassigned ID", func(t *testing.T) {
+		m := Message{
+			Id:      uuid.MustParse("ab4b8d0e-5c1f-4b2a-9d3e-7f6a5b4c3d21"),
+			Request: request,
+		}
+		data, err := json.Marshal(m)
+		assert.NoError(t, err)
+		assert.Equal(t, `{"id":"ab4b8d0e-5c1f-4b2a-9d3e-7f6a5b4c3d21","type":"image","viewer":{"twitch_user_id":"90790024","twitch_display_name":"wasabimilkshake"},"state":{"broadcast_id":0,"screening_id":"00000000-0000-0000-0000-000000000000","tape_id":0},"payload":{"style":"ghost","inputs":{"subject":"a seal"}}}`, string(data))
+
+		var got Message
+		err = json.Unmarshal(data, &got)
+		assert.NoError(t, err)
+		assert.Equal(t, m, got)
+	})
+	t.Run("plain genreq.Request is a message with no ID", func(t *testing.T) {
+		data, err := json.Marshal(request)
+		assert.NoError(t, err)
+
+		var got Message
+		err = json.Unmarshal(data, &got)
+		assert.NoError(t, err)
+		assert.Equal(t, Message{Request: request}, got)
+
+		roundTripped, err := json.Marshal(got)
+		assert.NoError(t, err)
+		assert.Equal(t, string(data), string(roundTripped))
+	})
+}
+
+func Test_Message_IdempotencyKey(t *testing.T) {
+	tests := []struct {
+		name string
+		m    Message
+		want string
+	}{
+		{
+			"preassigned ID is used as-is",
+			Message{Id: uuid.MustParse("ab4b8d0e-5c1f-4b2a-9d3e-7f6a5b4c3d21"), MessageId: "ignored"},
+			"id:ab4b8d0e-5c1f-4b2a-9d3e-7f6a5b4c3d21",
+		},
+		{
+			"AMQP message ID is hashed",
+			Message{MessageId: "msg-1234"},
+			"msg:17a8ae706bd584f9fa66ba2b4723bc3302909b0bacb84adf9cd763a8d56ec354",
+		},
+		{
+			"body is hashed without an ID",
+			Message{Redelivered: true, FinalDelivery: true},
+			"body:b48ae17ca995080c2de8a21c0c48496c4875641441cbd3ddc3fa023d9af2a929",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.m.IdempotencyKey())
+		})
+	}
+}
+
+func Test_ValidateRequest(t *testing.T) {
+	viewer := core.Viewer{
+		TwitchUserId:      "1234",
+		TwitchDisplayName: "SomeViewer",
+	}
+	tests := []struct {
+		name    string
+		r       genreq.Request
+		wantErr string
+	}{
+		{
+			"valid ghost request",
+			genreq.Request{
+				Type:   genreq.RequestTypeImage,
+				Viewer: viewer,
+				Payload: genreq.Payload{Image: &genreq.PayloadImage{
+					Style:  genreq.ImageStyleGhost,
+					Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+				}},
+			},
+			"",
+		},
+		{
+			"valid friend request",
+			genreq.Request{
+				Type:   genreq.RequestTypeImage,
+				Viewer: viewer,
+				Payload: genreq.Payload{Image: &genreq.PayloadImage{
+					Style:  genreq.ImageStyleFriend,
+					Inputs: genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Color: genreq.ColorBlue, Subject: "a crab"}},
+				}},
+			},
+			"",
+		},
+		{
+			"missing viewer",
+			genreq.Request{
+				Type: genreq.RequestTypeImage,
+				Payload: genreq.Payload{Image: &genreq.PayloadImage{
+					Style:  genreq.ImageStyleGhost,
+					Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+				}},
+			},
+			"viewer must have a twitch_user_id and twitch_display_name",
+		},
+		{
+			"unsupported request type",
+			genreq.Request{
+				Type:   "sound",
+				Viewer: viewer,
+			},
+			"unsupported request type 'sound'",
+		},
+		{
+			"missing payload",
+			genreq.Request{
+				Type:   genreq.RequestTypeImage,
+				Viewer: viewer,
+			},
+			"payload is required for request type 'image'",
+		},
+		{
+			"unsupported style",
+			genreq.Request{
+				Type:    genreq.RequestTypeImage,
+				Viewer:  viewer,
+				Payload: genreq.Payload{Image: &genreq.PayloadImage{Style: "clown"}},
+			},
+			"unsupported image style 'clown'",
+		},
+		{
+			"blank subject",
+			genreq.Request{
+				Type:   genreq.RequestTypeImage,
+				Viewer: viewer,
+				Payload: genreq.Payload{Image: &genreq.PayloadImage{
+					Style:  genreq.ImageStyleGhost,
+					Inputs: genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "  "}},
+				}},
+			},
+			"inputs for style 'ghost' must have a subject",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateRequest(&tt.r)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,484 @@
+package processing
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/filters"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/golden-vcr/dynamo/internal/outflow"
+	"github.com/golden-vcr/dynamo/internal/prompts"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+	"golang.org/x/sync/errgroup"
+)
+
+// imageJob holds the state of an image request as it moves through each stage of
+// processing: the fields in the second group are populated as the corresponding
+// stages are completed
+type imageJob struct {
+	id          uuid.UUID
+	viewer      core.Viewer
+	payload     genreq.PayloadImage
+	style       styles.Style
+	prompt      string
+	accessToken string
+	flowId      uuid.NullUUID
+	params      generation.Params
+	// finalAttempt is true if a transient failure won't be retried: i.e. if we're
+	// handling the final delivery of the message that carried the request, or if we're
+	// recovering the request at startup
+	finalAttempt bool
+	// resumable is true if a redelivery of the message that carried the request will
+	// resume it, i.e. if the request was recorded with an idempotency key
+	resumable bool
+	// selectionDue is true if we're resuming the request because the broadcaster has
+	// selected a candidate or the selection timeout has elapsed (see ResumeSelections)
+	selectionDue bool
+
+	// text is the text generated from the style's text prompt (if any), once named
+	text string
+	// candidates holds each of the images we generated for this request, once
+	// generated: they're indexed in the order in which they were requested
+	candidates []candidate
+	// selected is the index of the candidate that will be displayed onscreen, once
+	// selected
+	selected int
+}
+
+// candidate holds the state of a single candidate image as it moves through processing
+type candidate struct {
+	// image is the generated image once generated, replaced by the final image once
+	// filtered
+	image *generation.Image
+	// backgroundColor is the background color of the final image, once filtered
+	backgroundColor string
+	// discordPreview is the image that we attach when posting the alert to Discord, if
+	// the style calls for one: it's only available if we filtered the image in this
+	// process
+	discordPreview *styles.Image
+	// imageUrl is the URL of the final image, once stored
+	imageUrl string
+}
+
+// setSelected records that the candidate with the given index will be displayed
+// onscreen
+func (j *imageJob) setSelected(index int) error {
+	if index < 0 || index >= len(j.candidates) {
+		return fmt.Errorf("selected image index %d is out of range for %d candidate(s)", index, len(j.candidates))
+	}
+	j.selected = index
+	return nil
+}
+
+// imageStage pairs a processing stage with the function that carries it out
+type imageStage struct {
+	stage Stage
+	run   func(h *handler, ctx context.Context, logger *slog.Logger, j *imageJob) error
+}
+
+// imageStages lists the functions that carry out each stage of processing that follows
+// StageReceived, in order
+var imageStages = []imageStage{
+	{StageDebited, (*handler).debitPoints},
+	{StageModerated, (*handler).moderateInputs},
+	{StageNamed, (*handler).generateText},
+	{StageGenerated, (*handler).generateImages},
+	{StageFiltered, (*handler).filterImages},
+	{StageStored, (*handler).storeFinalImages},
+	{StageSelected, (*handler).selectImage},
+	{StageAnnounced, (*handler).announceImage},
+	{StageAccepted, (*handler).acceptTransaction},
+}
+
+// processImageRequest carries out each stage of processing that comes after the given
+// stage, recording each stage as it's completed, then flags the request as successful.
+// If the request has to wait for the broadcaster to select an image, we stop after
+// StageStored, leaving the request unfinished for ResumeSelections to pick up.
+func (h *handler) processImageRequest(ctx context.Context, logger *slog.Logger, j *imageJob, completed Stage) error {
+	for _, s := range imageStages {
+		if completed.Reached(s.stage) {
+			continue
+		}
+		stageCtx, span := tracing.Start(ctx, "processing."+string(s.stage), attribute.String(tracing.AttributeImageRequestId, j.id.String()))
+		err := s.run(h, stageCtx, logger, j)
+		if errors.Is(err, errAwaitingSelection) {
+			// The request will be resumed once the broadcaster has made a selection,
+			// so we're done with it for now
+			tracing.End(span, nil)
+			logger.Info("Waiting for the broadcaster to select an image", "numCandidates", len(j.candidates))
+			return nil
+		}
+		tracing.End(span, err)
+		if err != nil {
+			return h.failImageRequest(ctx, logger, j, completed, err)
+		}
+		completed = s.stage
+		if err := h.setStage(ctx, j.id, s.stage); err != nil {
+			return h.failImageRequest(ctx, logger, j, completed, err)
+		}
+	}
+
+	// We've gotten through every stage, so flag the request as successful
+	if _, err := h.q.RecordImageRequestSuccess(ctx, j.id); err != nil {
+		return err
+	}
+	metrics.RequestsFinished.WithLabelValues(string(j.payload.Style), metrics.OutcomeSucceeded, "").Inc()
+	return nil
+}
+
+// failImageRequest handles an error that occurred after the given stage was completed:
+// ordinarily, we record the request as failed and reject its ledger transaction so that
+// the user's points are refunded. We only notify the user of the failure once it's
+// final, since a message that failed transiently will be redelivered. If we were
+// interrupted (e.g. because the consumer is shutting down), if the alert has already
+// been announced, or if the error is transient and the message will be redelivered
+// and resume the request, we leave the request unfinished instead, so that it can be
+// resumed later.
+func (h *handler) failImageRequest(ctx context.Context, logger *slog.Logger, j *imageJob, completed Stage, err error) error {
+	if ctx.Err() != nil || completed.Reached(StageAnnounced) {
+		return err
+	}
+	if j.resumable && !j.finalAttempt && !IsPermanent(err) {
+		logger.Warn("Leaving image request unfinished to be resumed on redelivery", "stage", completed, "error", err)
+		return err
+	}
+	code := errcode.Of(err)
+	if _, dbErr := h.q.RecordImageRequestFailure(ctx, queries.RecordImageRequestFailureParams{
+		ImageRequestID: j.id,
+		ErrorMessage:   err.Error(),
+		ErrorCode:      string(code),
+	}); dbErr != nil {
+		logger.Error("Failed to record image request failure", "error", dbErr)
+	}
+	metrics.RequestsFinished.WithLabelValues(string(j.payload.Style), metrics.OutcomeFailed, string(code)).Inc()
+	refunded := h.rejectTransaction(ctx, logger, j)
+	if IsPermanent(err) || j.finalAttempt {
+		h.notifyFailure(ctx, logger, j.id, j.viewer, j.payload.Style, j.flowId.Valid, refunded, err)
+	}
+	return err
+}
+
+// rejectTransaction rejects the ledger transaction associated with an image request (if
+// any), refunding the user's points: it returns true only if the transaction was
+// rejected by this call
+func (h *handler) rejectTransaction(ctx context.Context, logger *slog.Logger, j *imageJob) bool {
+	if !j.flowId.Valid {
+		return false
+	}
+	ctx, span := tracing.Start(ctx, "ledger.reject")
+	startedAt := time.Now()
+	err := h.outflowClient.Reject(ctx, j.accessToken, j.flowId.UUID)
+	metrics.ObserveDuration(metrics.OperationLedger, startedAt)
+	tracing.End(span, err)
+	if err != nil {
+		if !errors.Is(err, outflow.ErrNotPending) {
+			logger.Error("Failed to reject transaction", "flowId", j.flowId.UUID, "error", err)
+		}
+		return false
+	}
+	return true
+}
+
+// debitPoints contacts the ledger service to create a pending transaction, ensuring
+// that we can deduct the requisite number of points for the request, then records the
+// ID of that transaction so that it can be finalized once the request succeeds or
+// fails. If we already recorded a transaction before we were interrupted, the viewer
+// isn't debited again.
+func (h *handler) debitPoints(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	if j.flowId.Valid {
+		return nil
+	}
+	alertMetadata := json.RawMessage([]byte(fmt.Sprintf(`{"imageRequestId":"%s","style":"%s"}`, j.id, j.payload.Style)))
+	ledgerCtx, span := tracing.Start(ctx, "ledger.request_alert_redemption")
+	startedAt := time.Now()
+	flowId, err := h.outflowClient.RequestAlertRedemption(ledgerCtx, j.accessToken, ImageAlertPointsCost, string(ImageAlertType), &alertMetadata)
+	metrics.ObserveDuration(metrics.OperationLedger, startedAt)
+	tracing.End(span, err)
+	if err != nil {
+		return err
+	}
+	j.flowId = uuid.NullUUID{Valid: true, UUID: flowId}
+
+	if err := h.q.RecordImageRequestDebit(ctx, queries.RecordImageRequestDebitParams{
+		LedgerFlowID:   flowId,
+		ImageRequestID: j.id,
+	}); err != nil {
+		// We have no record of the transaction, so it could never be finalized if we
+		// were interrupted: refund the viewer's points immediately
+		h.rejectTransaction(ctx, logger, j)
+		j.flowId = uuid.NullUUID{}
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record ledger transaction: %w", err))
+	}
+	return nil
+}
+
+// moderateInputs screens the viewer's inputs before we generate anything from them,
+// recording the results of moderation: if the inputs are flagged, the request is
+// rejected with a *generation.ModerationError
+func (h *handler) moderateInputs(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	startedAt := time.Now()
+	m, err := h.generationClient.Moderate(ctx, j.style.Description(j.payload.Inputs), j.viewer.TwitchUserId)
+	metrics.ObserveDuration(metrics.OperationModeration, startedAt)
+	if err != nil {
+		return fmt.Errorf("error in moderation: %w", err)
+	}
+	scores, err := json.Marshal(m.Scores)
+	if err != nil {
+		return err
+	}
+	if err := h.q.RecordImageRequestModeration(ctx, queries.RecordImageRequestModerationParams{
+		ModerationSource:  m.Source,
+		ModerationFlagged: m.Flagged,
+		ModerationScores:  scores,
+		ImageRequestID:    j.id,
+	}); err != nil {
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record moderation results: %w", err))
+	}
+	if m.Flagged {
+		logger.Info("Inputs were flagged by moderation", "source", m.Source, "categories", m.Categories)
+	}
+	return m.Err()
+}
+
+// generateText obtains AI-generated text to accompany the image (e.g. a name for our
+// new friend), if the request's style calls for it
+func (h *handler) generateText(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	if j.style.TextPrompt(j.payload.Inputs) == "" {
+		return nil
+	}
+	textPrompt, promptTemplateId, promptTemplateVersion := h.renderPrompt(logger, j.style, prompts.KindText, j.payload.Inputs)
+	startedAt := time.Now()
+	text, err := h.generationClient.GenerateText(ctx, textPrompt, j.viewer.TwitchUserId, j.params.Text)
+	metrics.ObserveDuration(metrics.OperationTextGeneration, startedAt)
+	if err != nil {
+		return fmt.Errorf("error in text generation: %w", err)
+	}
+	if err := h.q.RecordAnswer(ctx, queries.RecordAnswerParams{
+		ImageRequestID:        j.id,
+		Prompt:                textPrompt,
+		Value:                 text,
+		PromptTemplateID:      promptTemplateId,
+		PromptTemplateVersion: promptTemplateVersion,
+	}); err != nil {
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record answer: %w", err))
+	}
+	j.text = text
+	return nil
+}
+
+// generateImages generates each candidate image, making a separate request for each
+// one in parallel and waiting until they're all ready, then keeps a copy of each of
+// them so that we won't need to generate them again if we're interrupted
+func (h *handler) generateImages(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	images := make([]*generation.Image, h.candidates.numCandidates())
+	g, groupCtx := errgroup.WithContext(ctx)
+	for i := range images {
+		i := i
+		g.Go(func() error {
+			startedAt := time.Now()
+			image, err := h.generationClient.GenerateImage(groupCtx, j.prompt, j.viewer.TwitchUserId, j.params.Image)
+			metrics.ObserveDuration(metrics.OperationImageGeneration, startedAt)
+			if err != nil {
+				return err
+			}
+			images[i] = image
+			return nil
+		})
+	}
+	if err := g.Wait(); err != nil {
+		return err
+	}
+
+	candidates := make([]candidate, len(images))
+	for i, image := range images {
+		if err := h.q.SaveIntermediateImage(ctx, queries.SaveIntermediateImageParams{
+			ImageRequestID: j.id,
+			Stage:          string(StageGenerated),
+			Index:          int32(i),
+			ContentType:    image.ContentType,
+			Data:           image.Data,
+			Provider:       nullString(image.Provider),
+		}); err != nil {
+			return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to save generated image: %w", err))
+		}
+		candidates[i].image = image
+	}
+	j.candidates = candidates
+	return nil
+}
+
+// filterImages runs each generated candidate through the post-processing pipeline
+// defined by the request's style, and keeps a copy of each result
+func (h *handler) filterImages(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	for i := range j.candidates {
+		if err := h.filterImage(ctx, j, i, &j.candidates[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// filterImage runs a single generated candidate through the post-processing pipeline
+func (h *handler) filterImage(ctx context.Context, j *imageJob, index int, c *candidate) error {
+	// If the style attaches a preview image to its Discord posts, and we're going to
+	// post to Discord, prepare that preview from the generated image
+	tools := &styles.Tools{FilterRunner: h.filterRunner}
+	if previewSteps := j.style.DiscordPreview(); previewSteps != nil && h.getDiscordWebhookUrl(j.style) != "" {
+		preview := &styles.Image{
+			ContentType: c.image.ContentType,
+			Data:        c.image.Data,
+		}
+		if err := applySteps(ctx, tools, previewSteps, preview); err != nil {
+			return err
+		}
+		c.discordPreview = preview
+	}
+
+	// Apply each post-processing step in turn: unless a step detects otherwise, we
+	// assume that images have a black background
+	image := &styles.Image{
+		ContentType:     c.image.ContentType,
+		Data:            c.image.Data,
+		BackgroundColor: "#000000",
+	}
+	if err := applySteps(ctx, tools, j.style.Pipeline(), image); err != nil {
+		return err
+	}
+
+	if err := h.q.SaveIntermediateImage(ctx, queries.SaveIntermediateImageParams{
+		ImageRequestID: j.id,
+		Stage:          string(StageFiltered),
+		Index:          int32(index),
+		ContentType:    image.ContentType,
+		Data:           image.Data,
+		Color:          sql.NullString{Valid: true, String: image.BackgroundColor},
+		Provider:       nullString(c.image.Provider),
+	}); err != nil {
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to save filtered image: %w", err))
+	}
+	c.image = &generation.Image{
+		ContentType: image.ContentType,
+		Data:        image.Data,
+		Provider:    c.image.Provider,
+	}
+	c.backgroundColor = image.BackgroundColor
+	return nil
+}
+
+// applySteps applies each of the given post-processing steps to image, in turn
+func applySteps(ctx context.Context, tools *styles.Tools, steps []styles.Step, image *styles.Image) error {
+	for _, step := range steps {
+		if err := step(ctx, tools, image); err != nil {
+			return errcode.Wrap(filters.CodeFailed, err)
+		}
+	}
+	return nil
+}
+
+// storeFinalImages stores each final candidate image in our S3-compatible bucket, for
+// posterity and so it can be served to the alerts overlay (or reviewed by the
+// broadcaster), then discards our intermediate images
+func (h *handler) storeFinalImages(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	for i := range j.candidates {
+		c := &j.candidates[i]
+		imageUrl, err := storeImage(ctx, j.id, i, h.q, h.storageClient, c.image, c.backgroundColor)
+		if err != nil {
+			return err
+		}
+		c.imageUrl = imageUrl
+	}
+	if err := h.q.DeleteIntermediateImages(ctx, j.id); err != nil {
+		logger.Error("Failed to delete intermediate images", "error", err)
+	}
+	return nil
+}
+
+// announceImage generates an alert that will display the selected image onscreen
+// during the stream, then posts the image to Discord
+func (h *handler) announceImage(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	c := &j.candidates[j.selected]
+	alert := styles.Alert{
+		Description:     j.style.Description(j.payload.Inputs),
+		ImageUrl:        c.imageUrl,
+		Text:            j.text,
+		BackgroundColor: c.backgroundColor,
+	}
+	payload, err := j.style.OnscreenImage(j.viewer, alert)
+	if err != nil {
+		return err
+	}
+	ev := eonscreen.Event{
+		Type: eonscreen.EventTypeImage,
+		Payload: eonscreen.Payload{
+			Image: payload,
+		},
+	}
+	if err := h.produceOnscreenEvent(ctx, logger, ev); err != nil {
+		return errcode.Wrap(CodeAnnounceFailed, fmt.Errorf("failed to produce onscreen event: %w", err))
+	}
+
+	// Don't hold up the request to do this; just initiate a fire-and-forget HTTP
+	// request to a Discord webhook, so that we can post this image to the style's
+	// channel in the Discord server. If the request fails, we'll simply print an error.
+	// If the style requires a preview image that we no longer have (because the request
+	// was resumed after filtering), we skip the post.
+	channel := j.style.DiscordChannel()
+	webhookUrl := h.getDiscordWebhookUrl(j.style)
+	if webhookUrl != "" && (c.discordPreview != nil || j.style.DiscordPreview() == nil) {
+		_, span := tracing.Start(ctx, "discord.post_alert", attribute.String("discord.channel", channel))
+		go func() {
+			err := j.style.PostToDiscord(webhookUrl, j.viewer, alert, c.discordPreview)
+			tracing.End(span, err)
+			metrics.DiscordPosts.WithLabelValues(channel, metrics.Outcome(err)).Inc()
+			if err != nil {
+				logger.Error("ERROR: Failed to post alert to Discord", "channel", channel, "error", err)
+			}
+		}()
+	}
+	return nil
+}
+
+// acceptTransaction finalizes the ledger transaction to deduct the points we debited
+// from the user, now that we've successfully generated an alert from their request. If
+// the transaction has already been finalized, we proceed regardless.
+func (h *handler) acceptTransaction(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	if !j.flowId.Valid {
+		return nil
+	}
+	acceptCtx, span := tracing.Start(ctx, "ledger.accept")
+	startedAt := time.Now()
+	err := h.outflowClient.Accept(acceptCtx, j.accessToken, j.flowId.UUID)
+	metrics.ObserveDuration(metrics.OperationLedger, startedAt)
+	tracing.End(span, err)
+	if err != nil {
+		if !errors.Is(err, outflow.ErrNotPending) {
+			return fmt.Errorf("failed to finalize transaction: %w", err)
+		}
+		logger.Warn("Transaction was already finalized", "flowId", j.flowId.UUID)
+	}
+	return nil
+}
+
+// getDiscordWebhookUrl returns the URL of the webhook that should be used to post
+// alerts in the given style to Discord, or an empty string if they shouldn't be posted
+func (h *handler) getDiscordWebhookUrl(style styles.Style) string {
+	channel := style.DiscordChannel()
+	if channel == "" {
+		return ""
+	}
+	return h.discordWebhookUrls[channel]
+}
@@ -0,0 +1,422 @@
+package processing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/storage"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/ledger"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_handler_debitPoints(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name               string
+		flowId             uuid.NullUUID
+		redemptionErr      error
+		debitErr           error
+		wantErr            string
+		wantNumRedemptions int
+		wantDebited        []uuid.UUID
+		wantRejected       bool
+		wantFlowId         uuid.NullUUID
+	}{
+		{
+			"transaction is created and recorded",
+			uuid.NullUUID{},
+			nil,
+			nil,
+			"",
+			1,
+			[]uuid.UUID{flowId},
+			false,
+			uuid.NullUUID{Valid: true, UUID: flowId},
+		},
+		{
+			"viewer is not debited again if transaction was already recorded",
+			uuid.NullUUID{Valid: true, UUID: flowId},
+			nil,
+			nil,
+			"",
+			0,
+			nil,
+			false,
+			uuid.NullUUID{Valid: true, UUID: flowId},
+		},
+		{
+			"ledger error is returned",
+			uuid.NullUUID{},
+			ledger.ErrNotEnoughPoints,
+			nil,
+			ledger.ErrNotEnoughPoints.Error(),
+			1,
+			nil,
+			false,
+			uuid.NullUUID{},
+		},
+		{
+			"transaction is rejected if it can't be recorded",
+			uuid.NullUUID{},
+			nil,
+			fmt.Errorf("connection refused"),
+			"failed to record ledger transaction: connection refused",
+			1,
+			nil,
+			true,
+			uuid.NullUUID{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{debitErr: tt.debitErr}
+			outflowClient := &mockOutflowClient{flowId: flowId, redemptionErr: tt.redemptionErr}
+			h := &handler{
+				q:             q,
+				outflowClient: outflowClient,
+			}
+			j := &imageJob{
+				id:      imageRequestId,
+				payload: genreq.PayloadImage{Style: genreq.ImageStyleGhost},
+				flowId:  tt.flowId,
+			}
+
+			err := h.debitPoints(context.Background(), slog.Default(), j)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+			} else {
+				assert.EqualError(t, err, tt.wantErr)
+			}
+			assert.Equal(t, tt.wantNumRedemptions, outflowClient.numRedemptions)
+			assert.Equal(t, tt.wantDebited, q.debited)
+			assert.Equal(t, tt.wantRejected, outflowClient.rejected == flowId)
+			assert.Equal(t, tt.wantFlowId, j.flowId)
+		})
+	}
+}
+
+func Test_handler_moderateInputs(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	tests := []struct {
+		name       string
+		flagged    bool
+		wantErr    string
+		wantRecord queries.RecordImageRequestModerationParams
+	}{
+		{
+			"unflagged inputs are recorded and pass",
+			false,
+			"",
+			queries.RecordImageRequestModerationParams{
+				ModerationSource:  "mock",
+				ModerationFlagged: false,
+				ModerationScores:  []byte(`{"violence":0.1}`),
+				ImageRequestID:    imageRequestId,
+			},
+		},
+		{
+			"flagged inputs are recorded and rejected",
+			true,
+			"image generation request rejected: prompt was flagged by moderation for violence",
+			queries.RecordImageRequestModerationParams{
+				ModerationSource:  "mock",
+				ModerationFlagged: true,
+				ModerationScores:  []byte(`{"violence":0.9}`),
+				ImageRequestID:    imageRequestId,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			generationClient := &mockGenerationClient{flagged: tt.flagged}
+			h := &handler{
+				q:                q,
+				generationClient: generationClient,
+			}
+			style, _ := styles.Get(genreq.ImageStyleGhost)
+			j := &imageJob{
+				id:    imageRequestId,
+				style: style,
+				payload: genreq.PayloadImage{
+					Style: genreq.ImageStyleGhost,
+					Inputs: genreq.ImageInputs{
+						Ghost: &genreq.ImageInputsGhost{Subject: "a seal"},
+					},
+				},
+			}
+
+			err := h.moderateInputs(context.Background(), slog.Default(), j)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.True(t, IsPermanent(err))
+				var moderationErr *generation.ModerationError
+				assert.ErrorAs(t, err, &moderationErr)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, []string{"a seal"}, generationClient.moderations)
+			assert.Equal(t, []queries.RecordImageRequestModerationParams{tt.wantRecord}, q.moderations)
+		})
+	}
+}
+
+func Test_handler_failImageRequest(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name         string
+		completed    Stage
+		finalAttempt bool
+		err          error
+		wantCode     string
+		wantRejected bool
+		wantNotified bool
+	}{
+		{
+			"flagged inputs are recorded as flagged",
+			StageDebited,
+			false,
+			&generation.ModerationError{Categories: []string{"violence"}},
+			"generation.flagged",
+			true,
+			true,
+		},
+		{
+			"rejected prompt is recorded as rejected",
+			StageModerated,
+			false,
+			fmt.Errorf("error in text generation: %w", generation.ErrRejected),
+			"generation.rejected",
+			true,
+			true,
+		},
+		{
+			"storage failure is recorded as such",
+			StageFiltered,
+			true,
+			errcode.Wrap(storage.CodeFailed, fmt.Errorf("failed to upload generated image to storage: connection reset")),
+			"storage.failed",
+			true,
+			true,
+		},
+		{
+			"database failure is recorded as such",
+			StageGenerated,
+			true,
+			errcode.Wrap(CodeDatabase, fmt.Errorf("failed to save filtered image: connection refused")),
+			"processing.database",
+			true,
+			true,
+		},
+		{
+			"uncategorized error is recorded as unknown",
+			StageGenerated,
+			true,
+			fmt.Errorf("something went wrong"),
+			"unknown",
+			true,
+			true,
+		},
+		{
+			"transient error that will be retried does not notify the viewer",
+			StageGenerated,
+			false,
+			fmt.Errorf("something went wrong"),
+			"unknown",
+			true,
+			false,
+		},
+		{
+			"error after announcement leaves request unfinished",
+			StageAnnounced,
+			true,
+			fmt.Errorf("failed to finalize transaction: connection refused"),
+			"",
+			false,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			outflowClient := &mockOutflowClient{}
+			generationEventsProducer := &mockProducer{}
+			h := &handler{
+				q:                        q,
+				outflowClient:            outflowClient,
+				generationEventsProducer: generationEventsProducer,
+			}
+			j := &imageJob{
+				id:           imageRequestId,
+				flowId:       uuid.NullUUID{Valid: true, UUID: flowId},
+				finalAttempt: tt.finalAttempt,
+			}
+
+			err := h.failImageRequest(context.Background(), slog.Default(), j, tt.completed, tt.err)
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.wantCode, q.failureCode)
+			assert.Equal(t, tt.wantRejected, outflowClient.rejected == flowId)
+			assert.Equal(t, tt.wantNotified, len(generationEventsProducer.messages) == 1)
+		})
+	}
+}
+
+func Test_handler_failImageRequest_refund(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name      string
+		flowId    uuid.NullUUID
+		rejectErr error
+		wantEvent string
+	}{
+		{
+			"viewer is told that their points were refunded",
+			uuid.NullUUID{Valid: true, UUID: flowId},
+			nil,
+			`{"type":"failed","payload":{"imageRequestId":"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"style":"ghost","reason":"error","message":"Something went wrong while generating your image. Your points were not spent.","pointsRefunded":true}}`,
+		},
+		{
+			"viewer is not told that their points were refunded if rejection fails",
+			uuid.NullUUID{Valid: true, UUID: flowId},
+			fmt.Errorf("got response 503 from DELETE /outflow"),
+			`{"type":"failed","payload":{"imageRequestId":"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"style":"ghost","reason":"error","message":"Something went wrong while generating your image. Your points could not be refunded automatically.","pointsRefunded":false}}`,
+		},
+		{
+			"viewer who was never debited is told that their points were not spent",
+			uuid.NullUUID{},
+			nil,
+			`{"type":"failed","payload":{"imageRequestId":"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"style":"ghost","reason":"not-enough-points","message":"You don't have enough points for that request. Your points were not spent.","pointsRefunded":false}}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			generationEventsProducer := &mockProducer{}
+			h := &handler{
+				q:                        &mockQueries{},
+				outflowClient:            &mockOutflowClient{rejectErr: tt.rejectErr},
+				generationEventsProducer: generationEventsProducer,
+			}
+			j := &imageJob{
+				id:           imageRequestId,
+				viewer:       core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+				payload:      genreq.PayloadImage{Style: genreq.ImageStyleGhost},
+				flowId:       tt.flowId,
+				finalAttempt: true,
+			}
+
+			err := fmt.Errorf("failed to upload generated image to storage: connection refused")
+			if !tt.flowId.Valid {
+				err = ledger.ErrNotEnoughPoints
+			}
+			h.failImageRequest(context.Background(), slog.Default(), j, StageReceived, err)
+			if assert.Len(t, generationEventsProducer.messages, 1) {
+				assert.JSONEq(t, tt.wantEvent, generationEventsProducer.messages[0])
+			}
+		})
+	}
+}
+
+func Test_handler_generateImages(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	tests := []struct {
+		name          string
+		numCandidates int
+		generateErr   error
+		wantErr       error
+		wantNumImages int
+		wantIndices   []int32
+	}{
+		{
+			"a single image is generated by default",
+			0,
+			nil,
+			nil,
+			1,
+			[]int32{0},
+		},
+		{
+			"each candidate is generated and saved with its index",
+			3,
+			nil,
+			nil,
+			3,
+			[]int32{0, 1, 2},
+		},
+		{
+			"failure to generate any candidate fails the stage",
+			2,
+			generation.ErrRejected,
+			generation.ErrRejected,
+			2,
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{}
+			generationClient := &mockGenerationClient{err: tt.generateErr}
+			h := &handler{
+				q:                q,
+				generationClient: generationClient,
+				candidates:       CandidateOptions{NumCandidates: tt.numCandidates},
+			}
+			j := &imageJob{
+				id:     imageRequestId,
+				prompt: "a ghostly image of a seal",
+			}
+
+			err := h.generateImages(context.Background(), slog.Default(), j)
+			assert.ErrorIs(t, err, tt.wantErr)
+			assert.Equal(t, tt.wantNumImages, generationClient.numImages)
+			var indices []int32
+			for _, image := range q.intermediateImages[string(StageGenerated)] {
+				indices = append(indices, image.Index)
+				assert.Equal(t, sql.NullString{Valid: true, String: "mock"}, image.Provider)
+			}
+			assert.Equal(t, tt.wantIndices, indices)
+			assert.Len(t, j.candidates, len(tt.wantIndices))
+		})
+	}
+}
+
+func Test_handler_storeFinalImages(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	storageClient := &mockStorageClient{}
+	q := &mockQueries{}
+	h := &handler{
+		q:             q,
+		storageClient: storageClient,
+	}
+	j := &imageJob{
+		id: imageRequestId,
+		candidates: []candidate{
+			{image: &generation.Image{ContentType: "image/png", Data: []byte("first"), Provider: "openai"}, backgroundColor: "#000000"},
+			{image: &generation.Image{ContentType: "image/webp", Data: []byte("second"), Provider: "automatic1111"}, backgroundColor: "#ff8800"},
+		},
+	}
+
+	err := h.storeFinalImages(context.Background(), slog.Default(), j)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-0.png",
+		"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-1.webp",
+	}, storageClient.keys)
+	assert.Equal(t, "https://example.com/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-1.webp", j.candidates[1].imageUrl)
+	if assert.Len(t, q.recordedImages, 2) {
+		assert.Equal(t, sql.NullString{Valid: true, String: "openai"}, q.recordedImages[0].Provider)
+		assert.Equal(t, sql.NullString{Valid: true, String: "automatic1111"}, q.recordedImages[1].Provider)
+	}
+}
@@ -3,7 +3,10 @@ package processing
 import (
 	"context"
 	"encoding/json"
+	"time"
 
+	"github.com/golden-vcr/dynamo/internal/metrics"
+	"github.com/golden-vcr/dynamo/internal/tracing"
 	"golang.org/x/exp/slog"
 
 	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
@@ -15,7 +18,11 @@ func (h *handler) produceOnscreenEvent(ctx context.Context, logger *slog.Logger,
 	if err != nil {
 		return err
 	}
+	ctx, span := tracing.Start(ctx, "onscreen-events publish")
+	startedAt := time.Now()
 	err = h.onscreenEventsProducer.Send(ctx, data)
+	metrics.ObserveDuration(metrics.OperationProduce, startedAt)
+	tracing.End(span, err)
 	if err != nil {
 		logger.Error("Failed to produce to onscreen-events")
 	} else {
@@ -23,3 +30,22 @@ func (h *handler) produceOnscreenEvent(ctx context.Context, logger *slog.Logger,
 	}
 	return err
 }
+
+func (h *handler) produceGenerationEvent(ctx context.Context, logger *slog.Logger, ev GenerationEvent) error {
+	logger = logger.With("generationEvent", ev)
+	data, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	ctx, span := tracing.Start(ctx, "generation-events publish")
+	startedAt := time.Now()
+	err = h.generationEventsProducer.Send(ctx, data)
+	metrics.ObserveDuration(metrics.OperationProduce, startedAt)
+	tracing.End(span, err)
+	if err != nil {
+		logger.Error("Failed to produce to generation-events", "error", err)
+	} else {
+		logger.Info("Produced to generation-events")
+	}
+	return err
+}
@@ -0,0 +1,31 @@
+package processing
+
+import (
+	"database/sql"
+
+	"github.com/golden-vcr/dynamo/internal/prompts"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"golang.org/x/exp/slog"
+)
+
+// renderPrompt builds the prompt of the given kind for a request with the given style
+// and inputs. If a version of the corresponding template is active, we render it, and
+// we return its ID and version along with the prompt. Otherwise (or if the template
+// can't be rendered with these inputs) we fall back to the style's built-in prompt, and
+// the returned ID and version are NULL.
+func (h *handler) renderPrompt(logger *slog.Logger, style styles.Style, kind prompts.Kind, inputs genreq.ImageInputs) (string, sql.NullInt32, sql.NullInt32) {
+	if h.promptSource != nil {
+		if t := h.promptSource.Get(style.Name(), kind); t != nil {
+			prompt, err := t.Render(inputs)
+			if err == nil {
+				return prompt, sql.NullInt32{Valid: true, Int32: t.ID}, sql.NullInt32{Valid: true, Int32: t.Version}
+			}
+			logger.Error("Failed to render prompt template; using built-in prompt", "style", style.Name(), "kind", kind, "version", t.Version, "error", err)
+		}
+	}
+	if kind == prompts.KindText {
+		return style.TextPrompt(inputs), sql.NullInt32{}, sql.NullInt32{}
+	}
+	return style.Prompt(inputs), sql.NullInt32{}, sql.NullInt32{}
+}
@@ -0,0 +1,100 @@
+package processing
+
+import (
+	"database/sql"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/prompts"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_handler_renderPrompt(t *testing.T) {
+	ghostV7, err := prompts.Parse(queries.DynamoPromptTemplate{ID: 12, Style: "ghost", Kind: "image", Version: 7, Body: "a spooky {{ .Ghost.Subject }}"})
+	assert.NoError(t, err)
+	friendV2, err := prompts.Parse(queries.DynamoPromptTemplate{ID: 13, Style: "friend", Kind: "image", Version: 2, Body: "{{ .Ghost.Subject }}"})
+	assert.NoError(t, err)
+	promptSource := &mockPromptSource{templates: []*prompts.Template{ghostV7, friendV2}}
+
+	ghost, _ := styles.Get(genreq.ImageStyleGhost)
+	friend, _ := styles.Get(genreq.ImageStyleFriend)
+	ghostInputs := genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}}
+	friendInputs := genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a frog", Color: genreq.ColorGreen}}
+
+	tests := []struct {
+		name         string
+		promptSource PromptSource
+		style        styles.Style
+		kind         prompts.Kind
+		inputs       genreq.ImageInputs
+		wantPrompt   string
+		wantId       sql.NullInt32
+		wantVersion  sql.NullInt32
+	}{
+		{
+			"active template is rendered",
+			promptSource,
+			ghost,
+			prompts.KindImage,
+			ghostInputs,
+			"a spooky a seal",
+			sql.NullInt32{Valid: true, Int32: 12},
+			sql.NullInt32{Valid: true, Int32: 7},
+		},
+		{
+			"built-in prompt is used with no active template",
+			promptSource,
+			friend,
+			prompts.KindText,
+			friendInputs,
+			friend.TextPrompt(friendInputs),
+			sql.NullInt32{},
+			sql.NullInt32{},
+		},
+		{
+			"built-in prompt is used if template fails to render",
+			promptSource,
+			friend,
+			prompts.KindImage,
+			friendInputs,
+			friend.Prompt(friendInputs),
+			sql.NullInt32{},
+			sql.NullInt32{},
+		},
+		{
+			"built-in prompt is used with no prompt source",
+			nil,
+			ghost,
+			prompts.KindImage,
+			ghostInputs,
+			ghost.Prompt(ghostInputs),
+			sql.NullInt32{},
+			sql.NullInt32{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{promptSource: tt.promptSource}
+			prompt, id, version := h.renderPrompt(slog.Default(), tt.style, tt.kind, tt.inputs)
+			assert.Equal(t, tt.wantPrompt, prompt)
+			assert.Equal(t, tt.wantId, id)
+			assert.Equal(t, tt.wantVersion, version)
+		})
+	}
+}
+
+type mockPromptSource struct {
+	templates []*prompts.Template
+}
+
+func (m *mockPromptSource) Get(style genreq.ImageStyle, kind prompts.Kind) *prompts.Template {
+	for _, t := range m.templates {
+		if t.Style == style && t.Kind == kind {
+			return t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package processing
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/styles"
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+)
+
+// Recover finds all image requests that have been left unfinished for at least minAge
+// (presumably because the consumer was stopped while handling them) and resumes each
+// of them from the last stage it completed. Requests created more than maxAge ago
+// (if maxAge is nonzero) are too stale to be displayed, so unless their alert has
+// already been announced, they're failed and refunded instead. Errors encountered
+// while recovering individual requests are logged, and those requests are left as-is
+// to be retried next time.
+func (h *handler) Recover(ctx context.Context, logger *slog.Logger, minAge time.Duration, maxAge time.Duration) error {
+	rows, err := h.q.ListStaleImageRequests(ctx, int32(minAge.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to list stale image requests: %w", err)
+	}
+	for i := range rows {
+		row := &rows[i]
+		rowLogger := logger.With("imageRequestId", row.ID, "stage", row.Stage)
+		rowCtx, span := tracing.Start(ctx, "processing.recover",
+			attribute.String(tracing.AttributeImageRequestId, row.ID.String()),
+			attribute.String(tracing.AttributeStyle, row.Style),
+		)
+		if maxAge > 0 && time.Since(row.CreatedAt) > maxAge && !Stage(row.Stage).Reached(StageAnnounced) {
+			err := h.expireImageRequest(rowCtx, rowLogger, row, maxAge)
+			tracing.End(span, err)
+			if err != nil {
+				rowLogger.Error("Failed to expire image request", "error", err)
+				continue
+			}
+			rowLogger.Info("Expired image request", "createdAt", row.CreatedAt)
+			continue
+		}
+		err := h.resumeImageRequest(rowCtx, rowLogger, row, true, false)
+		tracing.End(span, err)
+		if err != nil {
+			rowLogger.Error("Failed to recover image request", "error", err)
+			continue
+		}
+		rowLogger.Info("Recovered image request")
+	}
+	return nil
+}
+
+// expireImageRequest fails an unfinished image request that's too old to be resumed,
+// rejecting its ledger transaction (if any) and notifying the viewer
+func (h *handler) expireImageRequest(ctx context.Context, logger *slog.Logger, row *queries.DynamoImageRequest, maxAge time.Duration) error {
+	j := &imageJob{
+		id: row.ID,
+		viewer: core.Viewer{
+			TwitchUserId:      row.TwitchUserID,
+			TwitchDisplayName: row.TwitchDisplayName.String,
+		},
+		payload:      genreq.PayloadImage{Style: genreq.ImageStyle(row.Style)},
+		flowId:       row.LedgerFlowID,
+		finalAttempt: true,
+	}
+
+	// We need an access token in order to reject the transaction and refund the user
+	if j.flowId.Valid {
+		accessToken, err := h.requestServiceToken(ctx, &j.viewer)
+		if err != nil {
+			return err
+		}
+		j.accessToken = accessToken
+	}
+
+	// failImageRequest returns the error it was given, which has now been recorded
+	err := Permanent(errcode.Wrap(CodeExpired, fmt.Errorf("request is older than the maximum recovery age of %s", maxAge)))
+	h.failImageRequest(ctx, logger, j, Stage(row.Stage), err)
+	return nil
+}
+
+// ResumeSelections finds all image requests that were left awaiting the broadcaster's
+// selection and can now proceed, either because the broadcaster has selected an image
+// or because the selection timeout has elapsed, and resumes each of them. As with
+// Recover, errors encountered while resuming individual requests are logged.
+func (h *handler) ResumeSelections(ctx context.Context, logger *slog.Logger) error {
+	rows, err := h.q.ListImageRequestsAwaitingSelection(ctx, int32(h.candidates.SelectionTimeout.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to list image requests awaiting selection: %w", err)
+	}
+	for i := range rows {
+		row := &rows[i]
+		rowLogger := logger.With("imageRequestId", row.ID, "stage", row.Stage)
+		rowCtx, span := tracing.Start(ctx, "processing.resume_selection",
+			attribute.String(tracing.AttributeImageRequestId, row.ID.String()),
+			attribute.String(tracing.AttributeStyle, row.Style),
+		)
+		err := h.resumeImageRequest(rowCtx, rowLogger, row, true, true)
+		tracing.End(span, err)
+		if err != nil {
+			rowLogger.Error("Failed to resume image request awaiting selection", "error", err)
+			continue
+		}
+		rowLogger.Info("Resumed image request awaiting selection", "selectedIndex", row.SelectedIndex)
+	}
+	return nil
+}
+
+// resumeImageRequest carries out all remaining stages of processing for an unfinished
+// image request, picking up from the last stage it completed: finalAttempt indicates
+// whether a transient failure will be retried, and selectionDue indicates whether we
+// can stop waiting on the broadcaster's selection (see imageJob)
+func (h *handler) resumeImageRequest(ctx context.Context, logger *slog.Logger, row *queries.DynamoImageRequest, finalAttempt bool, selectionDue bool) error {
+	ctx = generation.WithImageRequestId(ctx, row.ID)
+
+	// We can only finalize the ledger transaction if we know its ID (unless we haven't
+	// debited the user yet), and we need the user's display name in order to get a
+	// token that authorizes us to do so: requests recorded before we kept track of
+	// those details are simply abandoned
+	completed := Stage(row.Stage)
+	if !completed.IsValid() || (completed.Reached(StageDebited) && !row.LedgerFlowID.Valid) || !row.TwitchDisplayName.Valid {
+		_, err := h.q.RecordImageRequestFailure(ctx, queries.RecordImageRequestFailureParams{
+			ImageRequestID: row.ID,
+			ErrorMessage:   fmt.Sprintf("request could not be resumed from stage '%s'", row.Stage),
+			ErrorCode:      string(CodeUnresumable),
+		})
+		return err
+	}
+	viewer := core.Viewer{
+		TwitchUserId:      row.TwitchUserID,
+		TwitchDisplayName: row.TwitchDisplayName.String,
+	}
+	accessToken, err := h.requestServiceToken(ctx, &viewer)
+	if err != nil {
+		return err
+	}
+
+	// Rebuild the state of the request from what we've recorded in the database
+	var payload genreq.PayloadImage
+	payloadJson := fmt.Sprintf(`{"style":%q,"inputs":%s}`, row.Style, row.Inputs)
+	if err := json.Unmarshal([]byte(payloadJson), &payload); err != nil {
+		return fmt.Errorf("failed to parse recorded inputs: %w", err)
+	}
+	style, ok := styles.Get(payload.Style)
+	if !ok {
+		return fmt.Errorf("unsupported image style '%s'", payload.Style)
+	}
+	j := &imageJob{
+		id:           row.ID,
+		viewer:       viewer,
+		payload:      payload,
+		style:        style,
+		prompt:       row.Prompt,
+		accessToken:  accessToken,
+		flowId:       row.LedgerFlowID,
+		params:       h.recordedParams(row, payload.Style),
+		selected:     int(row.SelectedIndex.Int32),
+		finalAttempt: finalAttempt,
+		resumable:    row.IdempotencyKey.Valid,
+		selectionDue: selectionDue,
+	}
+	completed, err = h.loadImageJob(ctx, j, completed)
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Resuming image request", "fromStage", completed)
+	return h.processImageRequest(ctx, logger, j, completed)
+}
+
+// recordedParams returns the params that were recorded for the given request, so that
+// it's resumed with the same provider and model it was started with. Provider-specific
+// settings aren't recorded, so they're taken from the params currently configured for
+// the style, if that style still uses the same provider. If the request was started
+// with another provider, failing over to our generation backend uses the style's
+// current backend params. Requests recorded before we kept track of params are resumed
+// with the params currently configured for the style.
+func (h *handler) recordedParams(row *queries.DynamoImageRequest, style genreq.ImageStyle) generation.Params {
+	params := h.generationParams.Get(style)
+	if row.ImageModel.Valid || row.ImageProvider.Valid {
+		recorded := generation.ImageParams{
+			Provider: row.ImageProvider.String,
+			Model:    row.ImageModel.String,
+			Size:     row.ImageSize.String,
+			Quality:  row.ImageQuality.String,
+			Style:    row.ImageStyle.String,
+		}
+		if recorded.Provider == params.Image.Provider {
+			recorded.NegativePrompt = params.Image.NegativePrompt
+			recorded.Seed = params.Image.Seed
+			recorded.Steps = params.Image.Steps
+			recorded.CfgScale = params.Image.CfgScale
+			recorded.Sampler = params.Image.Sampler
+		}
+		if recorded.Provider != "" {
+			recorded.Backend = params.Image.Backend
+			if params.Image.Provider == "" {
+				backend := params.Image
+				recorded.Backend = &backend
+			}
+		}
+		params.Image = recorded
+	}
+	if row.TextModel.Valid {
+		params.Text.Model = row.TextModel.String
+	}
+	return params
+}
+
+// loadImageJob populates j with the outputs of every stage up to and including the
+// given stage, returning the stage from which processing should resume: if the output
+// of an intermediate stage is missing, we fall back to an earlier stage
+func (h *handler) loadImageJob(ctx context.Context, j *imageJob, completed Stage) (Stage, error) {
+	// Once stored, we have a record of each final candidate image, and once selected,
+	// we know which one to display
+	if completed.Reached(StageStored) {
+		images, err := h.q.GetImageRequestImages(ctx, j.id)
+		if err != nil {
+			return completed, err
+		}
+		if len(images) == 0 {
+			return completed, fmt.Errorf("no images recorded for image request in stage '%s'", completed)
+		}
+		j.candidates = make([]candidate, len(images))
+		for i := range images {
+			j.candidates[i].imageUrl = images[i].Url
+			j.candidates[i].backgroundColor = images[i].Color
+		}
+		if completed.Reached(StageSelected) {
+			if err := j.setSelected(j.selected); err != nil {
+				return completed, err
+			}
+		}
+	}
+
+	// Once filtered or generated, we have a copy of each candidate in the corresponding
+	// stage; otherwise we have to generate them again
+	if completed == StageFiltered || completed == StageGenerated {
+		images, err := h.q.ListIntermediateImages(ctx, queries.ListIntermediateImagesParams{
+			ImageRequestID: j.id,
+			Stage:          string(completed),
+		})
+		if err != nil {
+			return completed, err
+		}
+		if len(images) == 0 {
+			completed = StageNamed
+		}
+		j.candidates = make([]candidate, len(images))
+		for i := range images {
+			j.candidates[i].image = &generation.Image{
+				ContentType: images[i].ContentType,
+				Data:        images[i].Data,
+				Provider:    images[i].Provider.String,
+			}
+			j.candidates[i].backgroundColor = images[i].Color.String
+		}
+	}
+
+	// Once named, any text required by the style has been recorded; otherwise we have
+	// to generate it again
+	if completed.Reached(StageNamed) && j.style.TextPrompt(j.payload.Inputs) != "" {
+		answers, err := h.q.GetImageRequestAnswers(ctx, j.id)
+		if err != nil {
+			return completed, err
+		}
+		if len(answers) > 0 {
+			j.text = answers[0].Value
+		} else if !completed.Reached(StageStored) {
+			completed = StageDebited
+		}
+	}
+	return completed, nil
+}
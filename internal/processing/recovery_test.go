@@ -0,0 +1,810 @@
+package processing
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/generation"
+	"github.com/golden-vcr/dynamo/internal/outflow"
+	"github.com/golden-vcr/dynamo/internal/storage"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_handler_Recover(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	ghostRow := func(stage Stage) queries.DynamoImageRequest {
+		return queries.DynamoImageRequest{
+			ID:                imageRequestId,
+			TwitchUserID:      "1001",
+			Style:             "ghost",
+			Inputs:            []byte(`{"subject":"a seal"}`),
+			Prompt:            "a ghostly image of a seal",
+			TwitchDisplayName: sql.NullString{Valid: true, String: "BigJoe"},
+			LedgerFlowID:      uuid.NullUUID{Valid: true, UUID: flowId},
+			Stage:             string(stage),
+		}
+	}
+	tests := []struct {
+		name               string
+		row                queries.DynamoImageRequest
+		intermediateImages map[string][]queries.ListIntermediateImagesRow
+		generateErr        error
+		acceptErr          error
+		wantNumGenerated   int
+		wantUploads        []string
+		wantEvents         []string
+		wantAccepted       bool
+		wantRejected       bool
+		wantStages         []string
+		wantSucceeded      bool
+		wantFailure        string
+		wantFailureEvents  []string
+	}{
+		{
+			"stored request is announced and accepted",
+			queries.DynamoImageRequest{
+				ID:                imageRequestId,
+				TwitchUserID:      "1001",
+				Style:             "friend",
+				Inputs:            []byte(`{"color":"blue","subject":"a crab"}`),
+				TwitchDisplayName: sql.NullString{Valid: true, String: "BigJoe"},
+				LedgerFlowID:      uuid.NullUUID{Valid: true, UUID: flowId},
+				Stage:             "stored",
+			},
+			nil,
+			nil,
+			nil,
+			0,
+			nil,
+			[]string{`{"type":"image","payload":{"type":"friend","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"details":{"image_url":"https://example.com/0.webp","description":"a crab","name":"Clawdia","background_color":"#ff8800"}}}`},
+			true,
+			false,
+			[]string{"selected", "announced", "accepted"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"announced request is accepted",
+			ghostRow(StageAnnounced),
+			nil,
+			nil,
+			nil,
+			0,
+			nil,
+			nil,
+			true,
+			false,
+			[]string{"accepted"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"announced request is finished even if transaction was already finalized",
+			ghostRow(StageAnnounced),
+			nil,
+			nil,
+			outflow.ErrNotPending,
+			0,
+			nil,
+			nil,
+			true,
+			false,
+			[]string{"accepted"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"generated request is resumed from its intermediate image",
+			ghostRow(StageGenerated),
+			map[string][]queries.ListIntermediateImagesRow{
+				"generated": {{ContentType: "image/png", Data: mustEncodePng()}},
+			},
+			nil,
+			nil,
+			0,
+			[]string{"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-0.jpg"},
+			[]string{`{"type":"image","payload":{"type":"ghost","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"details":{"image_url":"https://example.com/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-0.jpg","description":"a seal"}}}`},
+			true,
+			false,
+			[]string{"filtered", "stored", "selected", "announced", "accepted"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"generated request without an intermediate image is generated again",
+			ghostRow(StageGenerated),
+			nil,
+			nil,
+			nil,
+			1,
+			[]string{"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-0.jpg"},
+			[]string{`{"type":"image","payload":{"type":"ghost","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"details":{"image_url":"https://example.com/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11/b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11-0.jpg","description":"a seal"}}}`},
+			true,
+			false,
+			[]string{"generated", "filtered", "stored", "selected", "announced", "accepted"},
+			true,
+			"",
+			nil,
+		},
+		{
+			"debited request that fails is rejected",
+			ghostRow(StageDebited),
+			nil,
+			generation.ErrRejected,
+			nil,
+			1,
+			nil,
+			nil,
+			false,
+			true,
+			[]string{"moderated", "named"},
+			false,
+			generation.ErrRejected.Error(),
+			[]string{`{"type":"failed","payload":{"imageRequestId":"b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"style":"ghost","reason":"rejected","message":"Your request was rejected by the image generator's content policy, so no image was generated. Your points were not spent.","pointsRefunded":true}}`},
+		},
+		{
+			"request without a recorded transaction is abandoned",
+			queries.DynamoImageRequest{
+				ID:           imageRequestId,
+				TwitchUserID: "1001",
+				Style:        "ghost",
+				Inputs:       []byte(`{"subject":"a seal"}`),
+				Stage:        "debited",
+			},
+			nil,
+			nil,
+			nil,
+			0,
+			nil,
+			nil,
+			false,
+			false,
+			nil,
+			false,
+			"request could not be resumed from stage 'debited'",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				staleRows: []queries.DynamoImageRequest{tt.row},
+				images: []queries.GetImageRequestImagesRow{
+					{Index: 0, Url: "https://example.com/0.webp", Color: "#ff8800"},
+				},
+				answers: []queries.GetImageRequestAnswersRow{
+					{Prompt: "what is its name?", Value: "Clawdia"},
+				},
+				intermediateImages: tt.intermediateImages,
+			}
+			generationClient := &mockGenerationClient{err: tt.generateErr}
+			storageClient := &mockStorageClient{}
+			outflowClient := &mockOutflowClient{acceptErr: tt.acceptErr}
+			producer := &mockProducer{}
+			generationEventsProducer := &mockProducer{}
+			h := &handler{
+				q:                        q,
+				generationClient:         generationClient,
+				storageClient:            storageClient,
+				authServiceClient:        &mockAuthServiceClient{},
+				outflowClient:            outflowClient,
+				onscreenEventsProducer:   producer,
+				generationEventsProducer: generationEventsProducer,
+			}
+
+			err := h.Recover(context.Background(), slog.Default(), 15*time.Minute, 0)
+			assert.NoError(t, err)
+			assert.Equal(t, int32(900), q.minAgeSeconds)
+
+			assert.Equal(t, tt.wantNumGenerated, generationClient.numImages)
+			assert.Equal(t, tt.wantUploads, storageClient.keys)
+			assert.Equal(t, len(tt.wantEvents), len(producer.messages))
+			for i := range tt.wantEvents {
+				if i < len(producer.messages) {
+					assert.JSONEq(t, tt.wantEvents[i], producer.messages[i])
+				}
+			}
+			assert.Equal(t, tt.wantAccepted, outflowClient.accepted == flowId)
+			assert.Equal(t, tt.wantRejected, outflowClient.rejected == flowId)
+			assert.Equal(t, tt.wantStages, q.stages)
+			assert.Equal(t, tt.wantSucceeded, q.succeeded)
+			assert.Equal(t, tt.wantFailure, q.failure)
+			assert.Equal(t, len(tt.wantFailureEvents), len(generationEventsProducer.messages))
+			for i := range tt.wantFailureEvents {
+				if i < len(generationEventsProducer.messages) {
+					assert.JSONEq(t, tt.wantFailureEvents[i], generationEventsProducer.messages[i])
+				}
+			}
+		})
+	}
+}
+
+func Test_handler_Recover_maxAge(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name             string
+		stage            Stage
+		age              time.Duration
+		wantNumGenerated int
+		wantAccepted     bool
+		wantRejected     bool
+		wantFailureCode  string
+		wantNotified     bool
+	}{
+		{
+			"recent request is resumed",
+			StageDebited,
+			30 * time.Minute,
+			1,
+			true,
+			false,
+			"",
+			false,
+		},
+		{
+			"stale request is failed and refunded",
+			StageDebited,
+			3 * time.Hour,
+			0,
+			false,
+			true,
+			"processing.expired",
+			true,
+		},
+		{
+			"stale request that was already announced is finished",
+			StageAnnounced,
+			3 * time.Hour,
+			0,
+			true,
+			false,
+			"",
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				staleRows: []queries.DynamoImageRequest{
+					{
+						ID:                imageRequestId,
+						TwitchUserID:      "1001",
+						Style:             "ghost",
+						Inputs:            []byte(`{"subject":"a seal"}`),
+						Prompt:            "a ghostly image of a seal",
+						CreatedAt:         time.Now().Add(-tt.age),
+						TwitchDisplayName: sql.NullString{Valid: true, String: "BigJoe"},
+						LedgerFlowID:      uuid.NullUUID{Valid: true, UUID: flowId},
+						Stage:             string(tt.stage),
+					},
+				},
+				images: []queries.GetImageRequestImagesRow{
+					{Index: 0, Url: "https://example.com/0.webp", Color: "#ff8800"},
+				},
+			}
+			generationClient := &mockGenerationClient{}
+			outflowClient := &mockOutflowClient{}
+			generationEventsProducer := &mockProducer{}
+			h := &handler{
+				q:                        q,
+				generationClient:         generationClient,
+				storageClient:            &mockStorageClient{},
+				authServiceClient:        &mockAuthServiceClient{},
+				outflowClient:            outflowClient,
+				onscreenEventsProducer:   &mockProducer{},
+				generationEventsProducer: generationEventsProducer,
+			}
+
+			err := h.Recover(context.Background(), slog.Default(), 15*time.Minute, time.Hour)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantNumGenerated, generationClient.numImages)
+			assert.Equal(t, tt.wantAccepted, outflowClient.accepted == flowId)
+			assert.Equal(t, tt.wantRejected, outflowClient.rejected == flowId)
+			assert.Equal(t, tt.wantFailureCode, q.failureCode)
+			assert.Equal(t, tt.wantNotified, len(generationEventsProducer.messages) == 1)
+		})
+	}
+}
+
+func Test_handler_ResumeSelections(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	flowId := uuid.MustParse("4ac7cba7-5e8e-4d8c-9c1a-6d9b0dc5e2a1")
+	tests := []struct {
+		name          string
+		recover       bool
+		selectedIndex sql.NullInt32
+		wantEvents    []string
+		wantStages    []string
+		wantSucceeded bool
+	}{
+		{
+			"request is announced with the broadcaster's selection",
+			false,
+			sql.NullInt32{Valid: true, Int32: 1},
+			[]string{`{"type":"image","payload":{"type":"ghost","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"details":{"image_url":"https://example.com/1.webp","description":"a seal"}}}`},
+			[]string{"selected", "announced", "accepted"},
+			true,
+		},
+		{
+			"request is announced with an automatic selection once the timeout elapses",
+			false,
+			sql.NullInt32{},
+			[]string{`{"type":"image","payload":{"type":"ghost","viewer":{"twitch_user_id":"1001","twitch_display_name":"BigJoe"},"details":{"image_url":"https://example.com/0.webp","description":"a seal"}}}`},
+			[]string{"selected", "announced", "accepted"},
+			true,
+		},
+		{
+			"recovered request is left awaiting selection",
+			true,
+			sql.NullInt32{},
+			nil,
+			nil,
+			false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			row := queries.DynamoImageRequest{
+				ID:                imageRequestId,
+				TwitchUserID:      "1001",
+				Style:             "ghost",
+				Inputs:            []byte(`{"subject":"a seal"}`),
+				Prompt:            "a ghostly image of a seal",
+				TwitchDisplayName: sql.NullString{Valid: true, String: "BigJoe"},
+				LedgerFlowID:      uuid.NullUUID{Valid: true, UUID: flowId},
+				Stage:             string(StageStored),
+				SelectedIndex:     tt.selectedIndex,
+			}
+			q := &mockQueries{
+				images: []queries.GetImageRequestImagesRow{
+					{Index: 0, Url: "https://example.com/0.webp", Color: "#ff8800"},
+					{Index: 1, Url: "https://example.com/1.webp", Color: "#0088ff"},
+				},
+				selectedIndex: tt.selectedIndex,
+			}
+			outflowClient := &mockOutflowClient{}
+			producer := &mockProducer{}
+			h := &handler{
+				q:                        q,
+				generationClient:         &mockGenerationClient{},
+				storageClient:            &mockStorageClient{},
+				authServiceClient:        &mockAuthServiceClient{},
+				outflowClient:            outflowClient,
+				onscreenEventsProducer:   producer,
+				generationEventsProducer: &mockProducer{},
+				candidates: CandidateOptions{
+					NumCandidates:    2,
+					Selection:        SelectionModeBroadcaster,
+					SelectionTimeout: time.Minute,
+				},
+			}
+
+			var err error
+			if tt.recover {
+				q.staleRows = []queries.DynamoImageRequest{row}
+				err = h.Recover(context.Background(), slog.Default(), 15*time.Minute, 0)
+			} else {
+				q.awaitingRows = []queries.DynamoImageRequest{row}
+				err = h.ResumeSelections(context.Background(), slog.Default())
+				assert.Equal(t, int32(60), q.timeoutSeconds)
+			}
+			assert.NoError(t, err)
+
+			assert.Equal(t, len(tt.wantEvents), len(producer.messages))
+			for i := range tt.wantEvents {
+				if i < len(producer.messages) {
+					assert.JSONEq(t, tt.wantEvents[i], producer.messages[i])
+				}
+			}
+			assert.Equal(t, tt.wantSucceeded, outflowClient.accepted == flowId)
+			assert.Equal(t, tt.wantStages, q.stages)
+			assert.Equal(t, tt.wantSucceeded, q.succeeded)
+			assert.Equal(t, "", q.failure)
+		})
+	}
+}
+
+func Test_handler_recordedParams(t *testing.T) {
+	tests := []struct {
+		name string
+		row  queries.DynamoImageRequest
+		want generation.Params
+	}{
+		{
+			"request recorded without params uses params configured for its style",
+			queries.DynamoImageRequest{Style: "ghost"},
+			generation.Params{
+				Image: generation.ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"},
+				Text:  generation.DefaultParams.Text,
+			},
+		},
+		{
+			"request is resumed with its recorded params",
+			queries.DynamoImageRequest{
+				Style:      "friend",
+				ImageModel: sql.NullString{Valid: true, String: "dall-e-2"},
+				ImageSize:  sql.NullString{Valid: true, String: "512x512"},
+				TextModel:  sql.NullString{Valid: true, String: "gpt-4o"},
+			},
+			generation.Params{
+				Image: generation.ImageParams{Model: "dall-e-2", Size: "512x512"},
+				Text:  generation.TextParams{Model: "gpt-4o"},
+			},
+		},
+		{
+			"request is resumed with its recorded provider and configured provider settings",
+			queries.DynamoImageRequest{
+				Style:         "friend",
+				ImageProvider: sql.NullString{Valid: true, String: "automatic1111"},
+				ImageSize:     sql.NullString{Valid: true, String: "768x768"},
+			},
+			generation.Params{
+				Image: generation.ImageParams{
+					Provider:       "automatic1111",
+					Size:           "768x768",
+					NegativePrompt: "blurry",
+					Steps:          30,
+					Backend:        &generation.ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "hd", Style: "vivid"},
+				},
+				Text: generation.DefaultParams.Text,
+			},
+		},
+		{
+			"request is resumed with its recorded provider even if the style now uses another",
+			queries.DynamoImageRequest{
+				Style:         "ghost",
+				ImageProvider: sql.NullString{Valid: true, String: "comfyui"},
+				ImageModel:    sql.NullString{Valid: true, String: "sd_xl_base_1.0.safetensors"},
+			},
+			generation.Params{
+				Image: generation.ImageParams{
+					Provider: "comfyui",
+					Model:    "sd_xl_base_1.0.safetensors",
+					Backend:  &generation.ImageParams{Model: "dall-e-3", Size: "1024x1024", Quality: "standard", Style: "natural"},
+				},
+				Text: generation.DefaultParams.Text,
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := &handler{
+				generationParams: generation.StyleParams{
+					"ghost":  {Image: generation.ImageParams{Style: "natural"}},
+					"friend": {Image: generation.ImageParams{Provider: "automatic1111", NegativePrompt: "blurry", Steps: 30, Backend: &generation.ImageParams{Quality: "hd"}}},
+				},
+			}
+			got := h.recordedParams(&tt.row, genreq.ImageStyle(tt.row.Style))
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func mustEncodePng() []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{R: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		panic(err)
+	}
+	return buf.Bytes()
+}
+
+type mockQueries struct {
+	existingRows       map[string]queries.DynamoImageRequest
+	staleRows          []queries.DynamoImageRequest
+	awaitingRows       []queries.DynamoImageRequest
+	images             []queries.GetImageRequestImagesRow
+	answers            []queries.GetImageRequestAnswersRow
+	intermediateImages map[string][]queries.ListIntermediateImagesRow
+	selectedIndex      sql.NullInt32
+	minAgeSeconds      int32
+	timeoutSeconds     int32
+	stages             []string
+	succeeded          bool
+	failure            string
+	failureCode        string
+	moderations        []queries.RecordImageRequestModerationParams
+	recorded           []queries.RecordImageRequestParams
+	debited            []uuid.UUID
+	debitErr           error
+	recordedImages     []queries.RecordImageParams
+	released           []uuid.UUID
+}
+
+func (m *mockQueries) DeleteIntermediateImages(ctx context.Context, imageRequestID uuid.UUID) error {
+	m.intermediateImages = nil
+	return nil
+}
+
+func (m *mockQueries) GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error) {
+	return queries.DynamoImageRequest{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) GetImageRequestByIdempotencyKey(ctx context.Context, idempotencyKey string) (queries.DynamoImageRequest, error) {
+	row, ok := m.existingRows[idempotencyKey]
+	if !ok {
+		return queries.DynamoImageRequest{}, sql.ErrNoRows
+	}
+	return row, nil
+}
+
+func (m *mockQueries) GetImageRequestAnswers(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestAnswersRow, error) {
+	return m.answers, nil
+}
+
+func (m *mockQueries) GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error) {
+	return m.images, nil
+}
+
+func (m *mockQueries) GetImageRequestSelection(ctx context.Context, imageRequestID uuid.UUID) (sql.NullInt32, error) {
+	return m.selectedIndex, nil
+}
+
+func (m *mockQueries) ListImageRequestsAwaitingSelection(ctx context.Context, timeoutSeconds int32) ([]queries.DynamoImageRequest, error) {
+	m.timeoutSeconds = timeoutSeconds
+	return m.awaitingRows, nil
+}
+
+func (m *mockQueries) ListIntermediateImages(ctx context.Context, arg queries.ListIntermediateImagesParams) ([]queries.ListIntermediateImagesRow, error) {
+	return m.intermediateImages[arg.Stage], nil
+}
+
+func (m *mockQueries) ListStaleImageRequests(ctx context.Context, minAgeSeconds int32) ([]queries.DynamoImageRequest, error) {
+	m.minAgeSeconds = minAgeSeconds
+	return m.staleRows, nil
+}
+
+func (m *mockQueries) RecordImageRequest(ctx context.Context, arg queries.RecordImageRequestParams) error {
+	m.recorded = append(m.recorded, arg)
+
+	// Keep track of requests recorded with an idempotency key, so that they can be
+	// found (and resumed) when a message is redelivered
+	if arg.IdempotencyKey.Valid {
+		if m.existingRows == nil {
+			m.existingRows = make(map[string]queries.DynamoImageRequest)
+		}
+		m.existingRows[arg.IdempotencyKey.String] = queries.DynamoImageRequest{
+			ID:                arg.ImageRequestID,
+			TwitchUserID:      arg.TwitchUserID,
+			Style:             arg.Style,
+			Inputs:            arg.Inputs,
+			Prompt:            arg.Prompt,
+			TwitchDisplayName: arg.TwitchDisplayName,
+			IdempotencyKey:    arg.IdempotencyKey,
+			Stage:             string(StageReceived),
+		}
+	}
+	return nil
+}
+
+func (m *mockQueries) RecordImageRequestDebit(ctx context.Context, arg queries.RecordImageRequestDebitParams) error {
+	if m.debitErr != nil {
+		return m.debitErr
+	}
+	m.debited = append(m.debited, arg.LedgerFlowID)
+	m.updateExistingRow(arg.ImageRequestID, func(row *queries.DynamoImageRequest) {
+		row.LedgerFlowID = uuid.NullUUID{Valid: true, UUID: arg.LedgerFlowID}
+	})
+	return nil
+}
+
+func (m *mockQueries) RecordImageRequestFailure(ctx context.Context, arg queries.RecordImageRequestFailureParams) (sql.Result, error) {
+	m.failure = arg.ErrorMessage
+	m.failureCode = arg.ErrorCode
+	m.updateExistingRow(arg.ImageRequestID, func(row *queries.DynamoImageRequest) {
+		row.FinishedAt = sql.NullTime{Valid: true, Time: time.Now()}
+		row.ErrorMessage = sql.NullString{Valid: true, String: arg.ErrorMessage}
+		row.ErrorCode = sql.NullString{Valid: true, String: arg.ErrorCode}
+	})
+	return nil, nil
+}
+
+func (m *mockQueries) RecordImageRequestModeration(ctx context.Context, arg queries.RecordImageRequestModerationParams) error {
+	m.moderations = append(m.moderations, arg)
+	return nil
+}
+
+func (m *mockQueries) RecordImageRequestSuccess(ctx context.Context, imageRequestID uuid.UUID) (sql.Result, error) {
+	m.succeeded = true
+	m.updateExistingRow(imageRequestID, func(row *queries.DynamoImageRequest) {
+		row.FinishedAt = sql.NullTime{Valid: true, Time: time.Now()}
+	})
+	return nil, nil
+}
+
+func (m *mockQueries) RecordImage(ctx context.Context, arg queries.RecordImageParams) error {
+	m.recordedImages = append(m.recordedImages, arg)
+	return nil
+}
+
+func (m *mockQueries) RecordAnswer(ctx context.Context, arg queries.RecordAnswerParams) error {
+	return nil
+}
+
+func (m *mockQueries) ReleaseImageRequestIdempotencyKey(ctx context.Context, imageRequestID uuid.UUID) error {
+	m.released = append(m.released, imageRequestID)
+	for key, row := range m.existingRows {
+		if row.ID == imageRequestID {
+			delete(m.existingRows, key)
+		}
+	}
+	return nil
+}
+
+func (m *mockQueries) SaveIntermediateImage(ctx context.Context, arg queries.SaveIntermediateImageParams) error {
+	if m.intermediateImages == nil {
+		m.intermediateImages = make(map[string][]queries.ListIntermediateImagesRow)
+	}
+	images := m.intermediateImages[arg.Stage]
+	for len(images) <= int(arg.Index) {
+		images = append(images, queries.ListIntermediateImagesRow{Index: int32(len(images))})
+	}
+	images[arg.Index] = queries.ListIntermediateImagesRow{
+		Index:       arg.Index,
+		ContentType: arg.ContentType,
+		Data:        arg.Data,
+		Color:       arg.Color,
+		Provider:    arg.Provider,
+	}
+	m.intermediateImages[arg.Stage] = images
+	return nil
+}
+
+func (m *mockQueries) SelectImage(ctx context.Context, arg queries.SelectImageParams) (sql.Result, error) {
+	if m.selectedIndex.Valid {
+		return mockResult(0), nil
+	}
+	m.selectedIndex = sql.NullInt32{Valid: true, Int32: arg.SelectedIndex}
+	return mockResult(1), nil
+}
+
+func (m *mockQueries) SetImageRequestStage(ctx context.Context, arg queries.SetImageRequestStageParams) error {
+	m.stages = append(m.stages, arg.Stage)
+	m.updateExistingRow(arg.ImageRequestID, func(row *queries.DynamoImageRequest) {
+		row.Stage = arg.Stage
+	})
+	return nil
+}
+
+// updateExistingRow applies update to the existing row for the given image request, if
+// we're keeping track of one
+func (m *mockQueries) updateExistingRow(imageRequestID uuid.UUID, update func(row *queries.DynamoImageRequest)) {
+	for key, row := range m.existingRows {
+		if row.ID == imageRequestID {
+			update(&row)
+			m.existingRows[key] = row
+		}
+	}
+}
+
+type mockResult int64
+
+func (m mockResult) LastInsertId() (int64, error) {
+	return 0, fmt.Errorf("not supported")
+}
+
+func (m mockResult) RowsAffected() (int64, error) {
+	return int64(m), nil
+}
+
+type mockAuthServiceClient struct{}
+
+func (m *mockAuthServiceClient) RequestServiceToken(ctx context.Context, payload auth.ServiceTokenRequest) (string, error) {
+	if payload.User.DisplayName == "" {
+		return "", fmt.Errorf("display name is required")
+	}
+	return "token-for-" + payload.User.Id, nil
+}
+
+type mockGenerationClient struct {
+	mu          sync.Mutex
+	err         error
+	numImages   int
+	moderations []string
+	flagged     bool
+	imageParams []generation.ImageParams
+}
+
+func (m *mockGenerationClient) Moderate(ctx context.Context, input string, opaqueUserId string) (*generation.Moderation, error) {
+	m.moderations = append(m.moderations, input)
+	if m.flagged {
+		return &generation.Moderation{
+			Source:     "mock",
+			Flagged:    true,
+			Categories: []string{"violence"},
+			Scores:     map[string]float64{"violence": 0.9},
+		}, nil
+	}
+	return &generation.Moderation{Source: "mock", Scores: map[string]float64{"violence": 0.1}}, nil
+}
+
+func (m *mockGenerationClient) GenerateText(ctx context.Context, prompt string, opaqueUserId string, params generation.TextParams) (string, error) {
+	return "Clawdia", nil
+}
+
+func (m *mockGenerationClient) GenerateImage(ctx context.Context, prompt string, opaqueUserId string, params generation.ImageParams) (*generation.Image, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.numImages++
+	m.imageParams = append(m.imageParams, params)
+	if m.err != nil {
+		return nil, m.err
+	}
+	return &generation.Image{ContentType: "image/png", Data: mustEncodePng(), Provider: "mock"}, nil
+}
+
+type mockStorageClient struct {
+	storage.Client
+	keys []string
+}
+
+func (m *mockStorageClient) Upload(ctx context.Context, key string, contentType string, data io.ReadSeeker) (string, error) {
+	m.keys = append(m.keys, key)
+	return "https://example.com/" + key, nil
+}
+
+type mockOutflowClient struct {
+	flowId         uuid.UUID
+	redemptionErr  error
+	acceptErr      error
+	rejectErr      error
+	numRedemptions int
+	accepted       uuid.UUID
+	rejected       uuid.UUID
+}
+
+func (m *mockOutflowClient) RequestAlertRedemption(ctx context.Context, accessToken string, numPointsToDebit int, alertType string, alertMetadata *json.RawMessage) (uuid.UUID, error) {
+	m.numRedemptions++
+	if m.redemptionErr != nil {
+		return uuid.Nil, m.redemptionErr
+	}
+	if m.flowId != uuid.Nil {
+		return m.flowId, nil
+	}
+	return uuid.New(), nil
+}
+
+func (m *mockOutflowClient) Accept(ctx context.Context, accessToken string, flowId uuid.UUID) error {
+	m.accepted = flowId
+	return m.acceptErr
+}
+
+func (m *mockOutflowClient) Reject(ctx context.Context, accessToken string, flowId uuid.UUID) error {
+	m.rejected = flowId
+	return m.rejectErr
+}
+
+type mockProducer struct {
+	messages []string
+}
+
+func (m *mockProducer) Send(ctx context.Context, jsonData []byte) error {
+	m.messages = append(m.messages, string(jsonData))
+	return nil
+}
@@ -0,0 +1,146 @@
+package processing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/errcode"
+	"golang.org/x/exp/slog"
+)
+
+// SelectionMode determines how we choose which of an image request's candidate images
+// is displayed onscreen
+type SelectionMode string
+
+const (
+	// SelectionModeAuto selects a candidate as soon as all candidates have been stored,
+	// using our Scorer (if any) to pick the best one
+	SelectionModeAuto SelectionMode = "auto"
+	// SelectionModeBroadcaster leaves the request awaiting the broadcaster's selection
+	// via POST /requests/:id/selection, falling back to automatic selection if no
+	// choice is made before the selection timeout elapses: see ResumeSelections
+	SelectionModeBroadcaster SelectionMode = "broadcaster"
+)
+
+// IsValid returns true if m is a known selection mode
+func (m SelectionMode) IsValid() bool {
+	return m == SelectionModeAuto || m == SelectionModeBroadcaster
+}
+
+// errAwaitingSelection is returned from selectImage when the request can't proceed
+// until the broadcaster selects a candidate (or the selection timeout elapses): the
+// request is left unfinished, to be picked up again by ResumeSelections
+var errAwaitingSelection = errors.New("awaiting broadcaster's selection")
+
+// Scorer rates a stored candidate image against the prompt that was used to generate
+// it, so that the best candidate can be selected automatically: higher scores are
+// better
+type Scorer interface {
+	Score(ctx context.Context, prompt string, imageUrl string) (float64, error)
+}
+
+// ScorerFunc adapts an ordinary function to the Scorer interface
+type ScorerFunc func(ctx context.Context, prompt string, imageUrl string) (float64, error)
+
+// Score calls f(ctx, prompt, imageUrl)
+func (f ScorerFunc) Score(ctx context.Context, prompt string, imageUrl string) (float64, error) {
+	return f(ctx, prompt, imageUrl)
+}
+
+// CandidateOptions configures how many candidate images we generate for each image
+// request, and how we choose the one that gets displayed onscreen
+type CandidateOptions struct {
+	// NumCandidates is the number of images to generate for each request; values less
+	// than 1 are treated as 1
+	NumCandidates int
+	// Selection determines who chooses between candidates, if there's more than one
+	Selection SelectionMode
+	// SelectionTimeout is how long we'll wait for the broadcaster to choose a
+	// candidate, in SelectionModeBroadcaster
+	SelectionTimeout time.Duration
+	// Scorer is used to pick a candidate automatically; if nil, we pick the first
+	Scorer Scorer
+}
+
+// numCandidates returns the number of candidate images to generate for each request
+func (o *CandidateOptions) numCandidates() int {
+	if o.NumCandidates < 1 {
+		return 1
+	}
+	return o.NumCandidates
+}
+
+// selectImage chooses which of the request's stored candidates will be displayed
+// onscreen, recording the selection in the database. If the broadcaster (or an
+// earlier attempt at this stage) has already recorded a selection, it takes precedence.
+// If we're waiting on the broadcaster's choice, we return errAwaitingSelection unless
+// the request has been resumed by ResumeSelections.
+func (h *handler) selectImage(ctx context.Context, logger *slog.Logger, j *imageJob) error {
+	index := 0
+	if len(j.candidates) > 1 {
+		if h.candidates.Selection == SelectionModeBroadcaster {
+			if !j.selectionDue {
+				return errAwaitingSelection
+			}
+			selected, err := h.q.GetImageRequestSelection(ctx, j.id)
+			if err != nil {
+				return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to get selected image: %w", err))
+			}
+			if selected.Valid {
+				return j.setSelected(int(selected.Int32))
+			}
+			logger.Info("Broadcaster did not select an image in time; selecting automatically", "numCandidates", len(j.candidates))
+		}
+		index = h.scoreCandidates(ctx, logger, j)
+	}
+
+	// Record our selection, unless one has already been recorded, in which case we
+	// defer to it
+	result, err := h.q.SelectImage(ctx, queries.SelectImageParams{
+		SelectedIndex:  int32(index),
+		ImageRequestID: j.id,
+	})
+	if err != nil {
+		return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to record selected image: %w", err))
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		return errcode.Wrap(CodeDatabase, err)
+	}
+	if numRows == 0 {
+		selected, err := h.q.GetImageRequestSelection(ctx, j.id)
+		if err != nil {
+			return errcode.Wrap(CodeDatabase, fmt.Errorf("failed to get selected image: %w", err))
+		}
+		if selected.Valid {
+			index = int(selected.Int32)
+		}
+	}
+	return j.setSelected(index)
+}
+
+// scoreCandidates returns the index of the candidate with the highest score. If we
+// have no scorer, or if none of the candidates could be scored, we pick the first.
+func (h *handler) scoreCandidates(ctx context.Context, logger *slog.Logger, j *imageJob) int {
+	if h.candidates.Scorer == nil {
+		return 0
+	}
+	best := 0
+	bestScore := math.Inf(-1)
+	for i := range j.candidates {
+		score, err := h.candidates.Scorer.Score(ctx, j.prompt, j.candidates[i].imageUrl)
+		if err != nil {
+			logger.Warn("Failed to score candidate image", "index", i, "error", err)
+			continue
+		}
+		if score > bestScore {
+			best = i
+			bestScore = score
+		}
+	}
+	return best
+}
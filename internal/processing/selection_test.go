@@ -0,0 +1,137 @@
+package processing
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_handler_selectImage(t *testing.T) {
+	imageRequestId := uuid.MustParse("b1c7f3c2-8d0e-4e4a-a2d4-3f0f3c9d6e11")
+	scorer := ScorerFunc(func(ctx context.Context, prompt string, imageUrl string) (float64, error) {
+		switch {
+		case strings.HasSuffix(imageUrl, "/0.png"):
+			return 0.2, nil
+		case strings.HasSuffix(imageUrl, "/1.png"):
+			return 0.9, nil
+		case strings.HasSuffix(imageUrl, "/2.png"):
+			return 0, fmt.Errorf("scoring service unavailable")
+		}
+		return 0.5, nil
+	})
+	tests := []struct {
+		name          string
+		numCandidates int
+		options       CandidateOptions
+		selectionDue  bool
+		selectedIndex sql.NullInt32
+		wantErr       string
+		wantSelected  int
+	}{
+		{
+			"only candidate is selected",
+			1,
+			CandidateOptions{Selection: SelectionModeAuto, Scorer: scorer},
+			false,
+			sql.NullInt32{},
+			"",
+			0,
+		},
+		{
+			"first candidate is selected if we have no scorer",
+			3,
+			CandidateOptions{Selection: SelectionModeAuto},
+			false,
+			sql.NullInt32{},
+			"",
+			0,
+		},
+		{
+			"best-scoring candidate is selected, ignoring scoring errors",
+			3,
+			CandidateOptions{Selection: SelectionModeAuto, Scorer: scorer},
+			false,
+			sql.NullInt32{},
+			"",
+			1,
+		},
+		{
+			"existing selection takes precedence over automatic selection",
+			3,
+			CandidateOptions{Selection: SelectionModeAuto, Scorer: scorer},
+			false,
+			sql.NullInt32{Valid: true, Int32: 2},
+			"",
+			2,
+		},
+		{
+			"broadcaster's selection is honored",
+			3,
+			CandidateOptions{Selection: SelectionModeBroadcaster, SelectionTimeout: time.Minute, Scorer: scorer},
+			true,
+			sql.NullInt32{Valid: true, Int32: 0},
+			"",
+			0,
+		},
+		{
+			"candidate is selected automatically if broadcaster doesn't select one in time",
+			3,
+			CandidateOptions{Selection: SelectionModeBroadcaster, SelectionTimeout: time.Minute, Scorer: scorer},
+			true,
+			sql.NullInt32{},
+			"",
+			1,
+		},
+		{
+			"request awaits broadcaster's selection until resumed",
+			3,
+			CandidateOptions{Selection: SelectionModeBroadcaster, SelectionTimeout: time.Minute, Scorer: scorer},
+			false,
+			sql.NullInt32{Valid: true, Int32: 0},
+			"awaiting broadcaster's selection",
+			0,
+		},
+		{
+			"selection must refer to an existing candidate",
+			2,
+			CandidateOptions{Selection: SelectionModeAuto},
+			false,
+			sql.NullInt32{Valid: true, Int32: 5},
+			"selected image index 5 is out of range for 2 candidate(s)",
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{selectedIndex: tt.selectedIndex}
+			h := &handler{
+				q:          q,
+				candidates: tt.options,
+			}
+			j := &imageJob{
+				id:           imageRequestId,
+				prompt:       "a ghostly image of a seal",
+				selectionDue: tt.selectionDue,
+			}
+			for i := 0; i < tt.numCandidates; i++ {
+				j.candidates = append(j.candidates, candidate{imageUrl: fmt.Sprintf("https://example.com/%d.png", i)})
+			}
+
+			err := h.selectImage(context.Background(), slog.Default(), j)
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.wantSelected, j.selected)
+				assert.Equal(t, sql.NullInt32{Valid: true, Int32: int32(tt.wantSelected)}, q.selectedIndex)
+			}
+		})
+	}
+}
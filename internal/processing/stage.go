@@ -0,0 +1,71 @@
+package processing
+
+// Stage identifies the last processing stage that was completed for an image request,
+// as recorded in the stage column of dynamo.image_request
+type Stage string
+
+const (
+	// StageReceived indicates that the request was recorded, before any points were
+	// debited: recording it first ensures that we always have a record of any ledger
+	// transaction we create
+	StageReceived Stage = "received"
+	// StageDebited indicates that points were debited from the user (via a pending
+	// ledger transaction) and the ID of that transaction was recorded
+	StageDebited Stage = "debited"
+	// StageModerated indicates that the viewer's inputs were screened by moderation and
+	// were not flagged
+	StageModerated Stage = "moderated"
+	// StageNamed indicates that any text required by the request's style (e.g. a name
+	// for a friend) was generated and recorded
+	StageNamed Stage = "named"
+	// StageGenerated indicates that one or more candidate images were generated
+	StageGenerated Stage = "generated"
+	// StageFiltered indicates that each candidate image was converted to its final
+	// format, with any required post-processing (e.g. background removal) applied
+	StageFiltered Stage = "filtered"
+	// StageStored indicates that each final candidate image was stored and recorded
+	StageStored Stage = "stored"
+	// StageSelected indicates that one of the stored candidates was chosen, either
+	// automatically or by the broadcaster, to be displayed onscreen
+	StageSelected Stage = "selected"
+	// StageAnnounced indicates that an onscreen event was produced to display the alert
+	StageAnnounced Stage = "announced"
+	// StageAccepted indicates that the ledger transaction was accepted, permanently
+	// deducting the debited points
+	StageAccepted Stage = "accepted"
+)
+
+// stageOrder lists all stages in the order in which they're completed
+var stageOrder = []Stage{
+	StageReceived,
+	StageDebited,
+	StageModerated,
+	StageNamed,
+	StageGenerated,
+	StageFiltered,
+	StageStored,
+	StageSelected,
+	StageAnnounced,
+	StageAccepted,
+}
+
+// index returns the position of the stage in stageOrder, or -1 if it's not a known
+// stage
+func (s Stage) index() int {
+	for i := range stageOrder {
+		if stageOrder[i] == s {
+			return i
+		}
+	}
+	return -1
+}
+
+// IsValid returns true if s is a known stage
+func (s Stage) IsValid() bool {
+	return s.index() >= 0
+}
+
+// Reached returns true if s is the same as, or comes after, other
+func (s Stage) Reached(other Stage) bool {
+	return s.index() >= other.index()
+}
@@ -0,0 +1,18 @@
+package processing
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Stage_Reached(t *testing.T) {
+	assert.True(t, StageDebited.Reached(StageDebited))
+	assert.True(t, StageStored.Reached(StageFiltered))
+	assert.False(t, StageGenerated.Reached(StageAnnounced))
+	assert.True(t, StageAccepted.Reached(StageAnnounced))
+	assert.True(t, StageAnnounced.Reached(StageSelected))
+	assert.False(t, StageStored.Reached(StageSelected))
+	assert.False(t, Stage("bogus").IsValid())
+	assert.True(t, StageNamed.IsValid())
+}
@@ -5,13 +5,35 @@ import (
 	"database/sql"
 
 	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/dynamo/internal/prompts"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
 	"github.com/google/uuid"
 )
 
 type Queries interface {
+	DeleteIntermediateImages(ctx context.Context, imageRequestID uuid.UUID) error
+	GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error)
+	GetImageRequestByIdempotencyKey(ctx context.Context, idempotencyKey string) (queries.DynamoImageRequest, error)
+	GetImageRequestAnswers(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestAnswersRow, error)
+	GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error)
+	GetImageRequestSelection(ctx context.Context, imageRequestID uuid.UUID) (sql.NullInt32, error)
+	ListImageRequestsAwaitingSelection(ctx context.Context, timeoutSeconds int32) ([]queries.DynamoImageRequest, error)
+	ListIntermediateImages(ctx context.Context, arg queries.ListIntermediateImagesParams) ([]queries.ListIntermediateImagesRow, error)
+	ListStaleImageRequests(ctx context.Context, minAgeSeconds int32) ([]queries.DynamoImageRequest, error)
 	RecordImageRequest(ctx context.Context, arg queries.RecordImageRequestParams) error
+	RecordImageRequestDebit(ctx context.Context, arg queries.RecordImageRequestDebitParams) error
 	RecordImageRequestFailure(ctx context.Context, arg queries.RecordImageRequestFailureParams) (sql.Result, error)
+	RecordImageRequestModeration(ctx context.Context, arg queries.RecordImageRequestModerationParams) error
 	RecordImageRequestSuccess(ctx context.Context, imageRequestID uuid.UUID) (sql.Result, error)
 	RecordImage(ctx context.Context, arg queries.RecordImageParams) error
 	RecordAnswer(ctx context.Context, arg queries.RecordAnswerParams) error
+	ReleaseImageRequestIdempotencyKey(ctx context.Context, imageRequestID uuid.UUID) error
+	SaveIntermediateImage(ctx context.Context, arg queries.SaveIntermediateImageParams) error
+	SelectImage(ctx context.Context, arg queries.SelectImageParams) (sql.Result, error)
+	SetImageRequestStage(ctx context.Context, arg queries.SetImageRequestStageParams) error
+}
+
+// PromptSource supplies the active version of each prompt template, if any
+type PromptSource interface {
+	Get(style genreq.ImageStyle, kind prompts.Kind) *prompts.Template
 }
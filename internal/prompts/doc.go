@@ -0,0 +1,10 @@
+// Package prompts renders the prompts that we submit for image and text generation from
+// versioned templates stored in the dynamo.prompt_template table. Templates use Go's
+// text/template syntax, and they're rendered with the genreq.ImageInputs from each
+// request as data, so a prompt can be revised simply by inserting and activating a new
+// version of its template.
+//
+// A Store holds the active version of each template in memory, and it periodically
+// reloads them from the database so that newly-activated versions take effect without
+// restarting the consumer.
+package prompts
@@ -0,0 +1,93 @@
+package prompts
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"golang.org/x/exp/slog"
+)
+
+// Queries is the subset of database queries required to load prompt templates
+type Queries interface {
+	ListActivePromptTemplates(ctx context.Context) ([]queries.DynamoPromptTemplate, error)
+}
+
+// Store holds the active version of each prompt template
+type Store struct {
+	q Queries
+
+	mu     sync.RWMutex
+	active map[templateKey]*Template
+}
+
+type templateKey struct {
+	style genreq.ImageStyle
+	kind  Kind
+}
+
+// NewStore returns an empty Store that loads templates using q: call Load to populate
+// it, and Run to keep it up to date
+func NewStore(q Queries) *Store {
+	return &Store{
+		q:      q,
+		active: make(map[templateKey]*Template),
+	}
+}
+
+// Get returns the active version of the template for the given style and kind, or nil
+// if no version is active
+func (s *Store) Get(style genreq.ImageStyle, kind Kind) *Template {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.active[templateKey{style, kind}]
+}
+
+// Load replaces the store's templates with the versions that are currently active in
+// the database. If an active version fails to parse, it's logged and ignored, and any
+// version of the same template that was previously loaded remains in use.
+func (s *Store) Load(ctx context.Context, logger *slog.Logger) error {
+	rows, err := s.q.ListActivePromptTemplates(ctx)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	active := make(map[templateKey]*Template, len(rows))
+	for _, row := range rows {
+		key := templateKey{genreq.ImageStyle(row.Style), Kind(row.Kind)}
+		t, err := Parse(row)
+		if err != nil {
+			logger.Error("Failed to parse prompt template", "style", row.Style, "kind", row.Kind, "version", row.Version, "error", err)
+			if prev, ok := s.active[key]; ok {
+				active[key] = prev
+			}
+			continue
+		}
+		if prev, ok := s.active[key]; !ok || prev.ID != t.ID {
+			logger.Info("Loaded prompt template", "style", row.Style, "kind", row.Kind, "version", row.Version)
+		}
+		active[key] = t
+	}
+	s.active = active
+	return nil
+}
+
+// Run reloads templates from the database at the given interval, until ctx is done
+func (s *Store) Run(ctx context.Context, logger *slog.Logger, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Load(ctx, logger); err != nil && ctx.Err() == nil {
+				logger.Error("Failed to reload prompt templates", "error", err)
+			}
+		}
+	}
+}
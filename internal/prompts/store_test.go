@@ -0,0 +1,67 @@
+package prompts
+
+import (
+	"context"
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+func Test_Store(t *testing.T) {
+	q := &mockQueries{
+		rows: []queries.DynamoPromptTemplate{
+			{ID: 1, Style: "ghost", Kind: "image", Version: 1, Body: "ghost v1: {{ .Ghost.Subject }}"},
+		},
+	}
+	s := NewStore(q)
+	assert.Nil(t, s.Get(genreq.ImageStyleGhost, KindImage))
+
+	// Loading should make the active template available
+	err := s.Load(context.Background(), slog.Default())
+	assert.NoError(t, err)
+	tmpl := s.Get(genreq.ImageStyleGhost, KindImage)
+	assert.NotNil(t, tmpl)
+	assert.Equal(t, int32(1), tmpl.Version)
+	assert.Nil(t, s.Get(genreq.ImageStyleGhost, KindText))
+	assert.Nil(t, s.Get(genreq.ImageStyleFriend, KindImage))
+
+	// Once a new version is activated, reloading should pick it up
+	q.rows = []queries.DynamoPromptTemplate{
+		{ID: 2, Style: "ghost", Kind: "image", Version: 2, Body: "ghost v2: {{ .Ghost.Subject }}"},
+		{ID: 3, Style: "friend", Kind: "text", Version: 1, Body: "name for {{ .Friend.Subject }}"},
+	}
+	err = s.Load(context.Background(), slog.Default())
+	assert.NoError(t, err)
+	tmpl = s.Get(genreq.ImageStyleGhost, KindImage)
+	assert.NotNil(t, tmpl)
+	assert.Equal(t, int32(2), tmpl.Version)
+	assert.NotNil(t, s.Get(genreq.ImageStyleFriend, KindText))
+
+	// If a newly-activated version is invalid, we should keep using the prior version
+	q.rows = []queries.DynamoPromptTemplate{
+		{ID: 4, Style: "ghost", Kind: "image", Version: 3, Body: "ghost v3: {{ .Ghost.Subject"},
+	}
+	err = s.Load(context.Background(), slog.Default())
+	assert.NoError(t, err)
+	tmpl = s.Get(genreq.ImageStyleGhost, KindImage)
+	assert.NotNil(t, tmpl)
+	assert.Equal(t, int32(2), tmpl.Version)
+
+	// Deactivating a template altogether should cause it to be dropped
+	q.rows = nil
+	err = s.Load(context.Background(), slog.Default())
+	assert.NoError(t, err)
+	assert.Nil(t, s.Get(genreq.ImageStyleGhost, KindImage))
+	assert.Nil(t, s.Get(genreq.ImageStyleFriend, KindText))
+}
+
+type mockQueries struct {
+	rows []queries.DynamoPromptTemplate
+}
+
+func (m *mockQueries) ListActivePromptTemplates(ctx context.Context) ([]queries.DynamoPromptTemplate, error) {
+	return m.rows, nil
+}
@@ -0,0 +1,97 @@
+package prompts
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+)
+
+// Kind identifies the type of prompt that's built from a template
+type Kind string
+
+const (
+	// KindImage templates build the prompt that's submitted for image generation
+	KindImage Kind = "image"
+	// KindText templates build the prompt that's submitted to generate text (e.g. a
+	// name) to accompany the image
+	KindText Kind = "text"
+)
+
+// Template is a single, parsed version of a prompt template
+type Template struct {
+	ID      int32
+	Style   genreq.ImageStyle
+	Kind    Kind
+	Version int32
+
+	t *template.Template
+}
+
+// Parse builds a Template from its database record, returning an error if the body is
+// not a valid template
+func Parse(row queries.DynamoPromptTemplate) (*Template, error) {
+	name := fmt.Sprintf("%s-%s-v%d", row.Style, row.Kind, row.Version)
+	t, err := template.New(name).Funcs(funcs).Option("missingkey=error").Parse(row.Body)
+	if err != nil {
+		return nil, err
+	}
+	return &Template{
+		ID:      row.ID,
+		Style:   genreq.ImageStyle(row.Style),
+		Kind:    Kind(row.Kind),
+		Version: row.Version,
+		t:       t,
+	}, nil
+}
+
+// Render executes the template with the given inputs, returning the resulting prompt
+func (t *Template) Render(inputs genreq.ImageInputs) (string, error) {
+	var b strings.Builder
+	if err := t.t.Execute(&b, inputs); err != nil {
+		return "", err
+	}
+	prompt := strings.TrimSpace(b.String())
+	if prompt == "" {
+		return "", fmt.Errorf("template %s rendered an empty prompt", t.t.Name())
+	}
+	return prompt, nil
+}
+
+// funcs are the helper functions available to all templates
+var funcs = template.FuncMap{
+	"article":     article,
+	"trimArticle": trimArticle,
+	"complement":  complement,
+}
+
+// article returns the indefinite article ("a" or "an") that should precede the given
+// color when it's used to describe the given subject, or an empty string if the subject
+// doesn't begin with an indefinite article, e.g. article("orange", "a cat") == "an"
+func article(color genreq.Color, subject string) string {
+	if !strings.HasPrefix(subject, "a ") && !strings.HasPrefix(subject, "an ") {
+		return ""
+	}
+	if strings.ContainsAny(string(color[:1]), "aeiou") {
+		return "an"
+	}
+	return "a"
+}
+
+// trimArticle removes any leading article ("a", "an", or "the") from the given subject
+func trimArticle(subject string) string {
+	for _, prefix := range []string{"a ", "an ", "the "} {
+		if strings.HasPrefix(subject, prefix) {
+			return subject[len(prefix):]
+		}
+	}
+	return subject
+}
+
+// complement returns the color that contrasts with the given color, e.g. for use as a
+// chroma-keyed background
+func complement(color genreq.Color) genreq.Color {
+	return color.GetComplement()
+}
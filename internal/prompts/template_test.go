@@ -0,0 +1,77 @@
+package prompts
+
+import (
+	"testing"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/stretchr/testify/assert"
+)
+
+// friendImageBody matches the initial version of the friend image template, as seeded
+// in the database by our migrations
+const friendImageBody = `{{ with .Friend }}{{ with article .Color .Subject }}{{ . }} {{ end }}{{ .Color }} {{ trimArticle .Subject }}, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid {{ complement .Color }} background suitable for chroma keying{{ end }}`
+
+func Test_Template_Render(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		inputs  genreq.ImageInputs
+		want    string
+		wantErr string
+	}{
+		{
+			"ghost",
+			"a ghostly image of {{ .Ghost.Subject }}, with glitchy VHS artifacts, dark background",
+			genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}},
+			"a ghostly image of a seal, with glitchy VHS artifacts, dark background",
+			"",
+		},
+		{
+			"friend with indefinite article",
+			friendImageBody,
+			genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a caterpillar", Color: genreq.ColorOrange}},
+			"an orange caterpillar, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid sky-blue background suitable for chroma keying",
+			"",
+		},
+		{
+			"friend with definite article",
+			friendImageBody,
+			genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "the moon", Color: genreq.ColorYellow}},
+			"yellow moon, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid indigo background suitable for chroma keying",
+			"",
+		},
+		{
+			"empty result is an error",
+			"{{ with .Ghost }}{{ .Subject }}{{ end }}",
+			genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a frog", Color: genreq.ColorGreen}},
+			"",
+			"rendered an empty prompt",
+		},
+		{
+			"nil inputs are an error",
+			"{{ .Ghost.Subject }}",
+			genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Subject: "a frog", Color: genreq.ColorGreen}},
+			"",
+			"nil pointer",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := Parse(queries.DynamoPromptTemplate{ID: 1, Style: "ghost", Kind: "image", Version: 1, Body: tt.body})
+			assert.NoError(t, err)
+			got, err := tmpl.Render(tt.inputs)
+			if tt.wantErr != "" {
+				assert.ErrorContains(t, err, tt.wantErr)
+			} else {
+				assert.NoError(t, err)
+				assert.Equal(t, tt.want, got)
+			}
+		})
+	}
+}
+
+func Test_Parse_invalid(t *testing.T) {
+	_, err := Parse(queries.DynamoPromptTemplate{Style: "ghost", Kind: "image", Version: 1, Body: "{{ .Ghost.Subject "})
+	assert.Error(t, err)
+}
@@ -0,0 +1,329 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	"github.com/golden-vcr/server-common/rmq"
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// DeliveryCountHeader is the name of the AMQP header that records how many times a
+// message has been delivered to a consumer
+const DeliveryCountHeader = "x-delivery-count"
+
+// Consumer is an rmq.Consumer that can also requeue a message it has received, so that
+// it will be delivered again
+type Consumer interface {
+	rmq.Consumer
+	Requeuer
+
+	// Check returns an error if the consumer's channel has been closed, in which case
+	// no further messages will be received
+	Check() error
+	// NumWaiting returns the number of messages that are waiting in the consumer's
+	// queue, not yet delivered to the consumer
+	NumWaiting() (int, error)
+}
+
+// Requeuer can send a copy of a delivery back to the queue from which it was
+// received, with an incremented delivery count
+type Requeuer interface {
+	Requeue(ctx context.Context, d *amqp.Delivery) error
+}
+
+// RequeueBackoff determines how long a requeued message waits before it's delivered
+// again, so that a message that failed due to a transient problem (e.g. an outage in
+// an upstream API) isn't retried until that problem has had a chance to clear up
+type RequeueBackoff struct {
+	// InitialDelay is how long we wait before redelivering a message that failed on
+	// its first delivery; if zero, messages are redelivered immediately
+	InitialDelay time.Duration
+	// MaxDelay caps the delay, which doubles with each subsequent delivery
+	MaxDelay time.Duration
+}
+
+// Delay returns how long to wait before redelivering a message that has failed on its
+// deliveryCount'th delivery
+func (b RequeueBackoff) Delay(deliveryCount int) time.Duration {
+	delay := b.InitialDelay
+	for i := 1; i < deliveryCount; i++ {
+		if b.MaxDelay > 0 && delay >= b.MaxDelay {
+			break
+		}
+		delay *= 2
+	}
+	if b.MaxDelay > 0 && delay > b.MaxDelay {
+		delay = b.MaxDelay
+	}
+	return delay
+}
+
+// NewConsumer initializes a Consumer that receives messages from the fanout exchange
+// with the given name, via a temporary queue that's declared for the lifetime of the
+// consumer process. Messages are not acknowledged automatically: the recipient must
+// settle each delivery, and RabbitMQ will deliver no more than prefetchCount
+// unacknowledged messages at a time. Messages that are rejected without being requeued
+// are routed to a dead-letter exchange named '<exchange>.dlx', and retained in a
+// durable queue named '<exchange>.dead-letter'. Requeued messages are held in a
+// temporary delay queue for the duration given by backoff, then routed back to the
+// consumer's queue.
+func NewConsumer(conn *amqp.Connection, exchange string, prefetchCount int, backoff RequeueBackoff) (Consumer, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel: %w", err)
+	}
+
+	prefetchSize := 0
+	global := false
+	if err := ch.Qos(prefetchCount, prefetchSize, global); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	if err := declareFanoutExchange(ch, exchange); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	deadLetterExchange := exchange + ".dlx"
+	if err := declareDeadLetterQueue(ch, deadLetterExchange, exchange+".dead-letter"); err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare dead-letter queue: %w", err)
+	}
+
+	q, err := declareConsumerQueue(ch, exchange, deadLetterExchange)
+	if err != nil {
+		ch.Close()
+		return nil, fmt.Errorf("failed to declare consumer queue: %w", err)
+	}
+
+	return &consumer{
+		ch:          ch,
+		q:           q,
+		backoff:     backoff,
+		delayQueues: make(map[time.Duration]string),
+	}, nil
+}
+
+// consumer is a concrete implementation of Consumer that requires manual settlement of
+// deliveries
+type consumer struct {
+	ch      *amqp.Channel
+	q       *amqp.Queue
+	backoff RequeueBackoff
+
+	mu          sync.Mutex
+	delayQueues map[time.Duration]string
+}
+
+func (c *consumer) Close() {
+	c.ch.Close()
+}
+
+func (c *consumer) Recv(ctx context.Context) (<-chan amqp.Delivery, error) {
+	autoAck := false
+	exclusive := false
+	noLocal := false
+	noWait := false
+	return c.ch.ConsumeWithContext(ctx, c.q.Name, "", autoAck, exclusive, noLocal, noWait, nil)
+}
+
+func (c *consumer) Check() error {
+	if c.ch.IsClosed() {
+		return fmt.Errorf("channel is closed")
+	}
+	return nil
+}
+
+func (c *consumer) NumWaiting() (int, error) {
+	durable := false
+	autoDelete := false
+	exclusive := true
+	noWait := false
+	q, err := c.ch.QueueDeclarePassive(c.q.Name, durable, autoDelete, exclusive, noWait, nil)
+	if err != nil {
+		return 0, err
+	}
+	return q.Messages, nil
+}
+
+func (c *consumer) Requeue(ctx context.Context, d *amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[DeliveryCountHeader] = int32(GetDeliveryCount(d) + 1)
+
+	// Publish directly to our queue (or to the delay queue that will dead-letter the
+	// message back to our queue once its delay elapses) via the default exchange, so
+	// that other consumers bound to the same fanout exchange don't receive the message
+	// a second time
+	routingKey := c.q.Name
+	if delay := c.backoff.Delay(GetDeliveryCount(d)); delay > 0 {
+		delayQueue, err := c.getDelayQueue(delay)
+		if err != nil {
+			return fmt.Errorf("failed to declare delay queue: %w", err)
+		}
+		routingKey = delayQueue
+	}
+	mandatory := false
+	immediate := false
+	return c.ch.PublishWithContext(ctx, "", routingKey, mandatory, immediate, amqp.Publishing{
+		Headers:       headers,
+		ContentType:   d.ContentType,
+		DeliveryMode:  d.DeliveryMode,
+		CorrelationId: d.CorrelationId,
+		MessageId:     d.MessageId,
+		Timestamp:     d.Timestamp,
+		Body:          d.Body,
+	})
+}
+
+// getDelayQueue returns the name of a temporary queue in which messages are held for
+// the given delay before being dead-lettered back to our queue, declaring it if it
+// doesn't exist yet. Each delay has its own queue, since a message can only expire
+// once it's reached the head of its queue.
+func (c *consumer) getDelayQueue(delay time.Duration) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if name, ok := c.delayQueues[delay]; ok {
+		return name, nil
+	}
+
+	durable := false
+	autoDelete := false
+	exclusive := true
+	noWait := false
+	q, err := c.ch.QueueDeclare("", durable, autoDelete, exclusive, noWait, amqp.Table{
+		"x-message-ttl":             delay.Milliseconds(),
+		"x-dead-letter-exchange":    "",
+		"x-dead-letter-routing-key": c.q.Name,
+	})
+	if err != nil {
+		return "", err
+	}
+	c.delayQueues[delay] = q.Name
+	return q.Name, nil
+}
+
+// GetDeliveryCount returns the number of times the given message has been delivered,
+// including this delivery
+func GetDeliveryCount(d *amqp.Delivery) int {
+	switch v := d.Headers[DeliveryCountHeader].(type) {
+	case int:
+		return v
+	case int16:
+		return int(v)
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	}
+	return 1
+}
+
+// IsRedelivery returns true if the given delivery is known to carry a message that
+// we've received before: either because RabbitMQ is redelivering it (e.g. after it was
+// nacked, or after a consumer that received it disconnected without settling it), or
+// because it's a copy that we requeued after a transient failure
+func IsRedelivery(d *amqp.Delivery) bool {
+	return d.Redelivered || GetDeliveryCount(d) > 1
+}
+
+func declareFanoutExchange(ch *amqp.Channel, exchange string) error {
+	durable := true
+	autoDelete := false
+	internal := false
+	noWait := false
+	return ch.ExchangeDeclare(exchange, "fanout", durable, autoDelete, internal, noWait, nil)
+}
+
+// declareDeadLetterQueue declares an exchange to which rejected messages can be routed,
+// along with a durable queue bound to that exchange, so that dead-lettered messages are
+// retained for inspection even when no consumer is running
+func declareDeadLetterQueue(ch *amqp.Channel, exchange string, queue string) error {
+	if err := declareFanoutExchange(ch, exchange); err != nil {
+		return err
+	}
+
+	durable := true
+	autoDelete := false
+	exclusive := false
+	noWait := false
+	q, err := ch.QueueDeclare(queue, durable, autoDelete, exclusive, noWait, nil)
+	if err != nil {
+		return err
+	}
+	return ch.QueueBind(q.Name, "", exchange, noWait, nil)
+}
+
+// declareConsumerQueue declares a temporary queue for a consumer process, with
+// rejected messages routed to the given dead-letter exchange, then binds it to the
+// exchange with the given name
+func declareConsumerQueue(ch *amqp.Channel, exchange string, deadLetterExchange string) (*amqp.Queue, error) {
+	durable := false
+	autoDelete := false
+	exclusive := true
+	noWait := false
+	q, err := ch.QueueDeclare("", durable, autoDelete, exclusive, noWait, amqp.Table{
+		"x-dead-letter-exchange": deadLetterExchange,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.QueueBind(q.Name, "", exchange, noWait, nil); err != nil {
+		return nil, err
+	}
+	return &q, nil
+}
+
+// NewProducer initializes an rmq.Producer that sends messages to the fanout exchange
+// with the given name. Unlike the producer provided by server-common, it propagates
+// the trace context of each call to Send via the headers of the message it publishes.
+func NewProducer(conn *amqp.Connection, exchange string) (rmq.Producer, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, fmt.Errorf("failed to create channel: %w", err)
+	}
+	defer ch.Close()
+
+	if err := declareFanoutExchange(ch, exchange); err != nil {
+		return nil, fmt.Errorf("failed to declare exchange: %w", err)
+	}
+
+	return &producer{
+		conn:     conn,
+		exchange: exchange,
+	}, nil
+}
+
+// producer is a concrete implementation of rmq.Producer that injects trace context
+// into the headers of each message it sends
+type producer struct {
+	conn     *amqp.Connection
+	exchange string
+}
+
+func (p *producer) Send(ctx context.Context, jsonData []byte) error {
+	ch, err := p.conn.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	headers := amqp.Table{}
+	tracing.Inject(ctx, headers)
+
+	mandatory := false
+	immediate := false
+	return ch.PublishWithContext(ctx, p.exchange, "", mandatory, immediate, amqp.Publishing{
+		Headers:     headers,
+		ContentType: "application/json",
+		Body:        jsonData,
+	})
+}
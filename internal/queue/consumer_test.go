@@ -0,0 +1,48 @@
+package queue
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_RequeueBackoff_Delay(t *testing.T) {
+	tests := []struct {
+		name          string
+		backoff       RequeueBackoff
+		deliveryCount int
+		want          time.Duration
+	}{
+		{
+			"first delivery waits for the initial delay",
+			RequeueBackoff{InitialDelay: 10 * time.Second, MaxDelay: 5 * time.Minute},
+			1,
+			10 * time.Second,
+		},
+		{
+			"delay doubles with each delivery",
+			RequeueBackoff{InitialDelay: 10 * time.Second, MaxDelay: 5 * time.Minute},
+			4,
+			80 * time.Second,
+		},
+		{
+			"delay is capped at max delay",
+			RequeueBackoff{InitialDelay: 10 * time.Second, MaxDelay: 5 * time.Minute},
+			20,
+			5 * time.Minute,
+		},
+		{
+			"zero initial delay requeues immediately",
+			RequeueBackoff{},
+			3,
+			0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.backoff.Delay(tt.deliveryCount)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
@@ -0,0 +1,18 @@
+// Package queue contains the code that pulls generation requests from RabbitMQ: unlike
+// the auto-acknowledging consumer provided by server-common, we explicitly settle each
+// message once it's been handled. Messages that are handled successfully are acked;
+// messages that fail permanently are rejected to a dead-letter exchange; and messages
+// that fail transiently are requeued with an incremented delivery count (after a delay
+// that grows with each delivery), until they exceed the maximum number of deliveries.
+// Since messages are acknowledged manually, we can also limit the number of
+// unacknowledged messages in flight via AMQP QoS, and process them with a fixed-size
+// pool of workers.
+//
+// As with server-common's consumer, the consumer's queue is temporary: it's exclusive
+// to the consumer process and isn't durable, and neither are the delay queues that
+// hold requeued messages. If the consumer stops, any messages still waiting in those
+// queues (including messages that were requeued after a transient failure) are lost,
+// and only the dead-letter queue retains messages across restarts. Requests that had
+// already been recorded in the database are picked up by Recover once the consumer
+// starts again, but requests that were never recorded are dropped.
+package queue
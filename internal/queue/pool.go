@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/golden-vcr/dynamo/internal/tracing"
+	amqp "github.com/rabbitmq/amqp091-go"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/exp/slog"
+)
+
+// HandleFunc processes a single message received from the queue
+type HandleFunc func(ctx context.Context, d amqp.Delivery) error
+
+// Pool processes deliveries with a fixed number of workers, settling each delivery
+// according to the outcome of handling it
+type Pool struct {
+	logger        *slog.Logger
+	numWorkers    int
+	requeuer      Requeuer
+	isPermanent   func(err error) bool
+	maxDeliveries int
+
+	mu             sync.Mutex
+	isRunning      bool
+	numInFlight    int
+	lastProgressAt time.Time
+}
+
+// NewPool prepares a Pool with numWorkers workers. Since no more than numWorkers
+// messages are ever processed at once, the consumer's prefetch count should be set to
+// numWorkers as well, so that excess messages remain in RabbitMQ rather than sitting
+// in memory. When handling a message fails with an error for which isPermanent returns
+// true, the message is dead-lettered; otherwise it's requeued via requeuer, unless it's
+// already been delivered maxDeliveries times, in which case it's dead-lettered.
+func NewPool(logger *slog.Logger, numWorkers int, requeuer Requeuer, isPermanent func(err error) bool, maxDeliveries int) *Pool {
+	return &Pool{
+		logger:        logger,
+		numWorkers:    numWorkers,
+		requeuer:      requeuer,
+		isPermanent:   isPermanent,
+		maxDeliveries: maxDeliveries,
+	}
+}
+
+// Run starts the pool's workers, each of which receives deliveries from the given
+// channel and processes them with handle. Run blocks until the deliveries channel is
+// closed or ctx is canceled. Errors (and panics) in handle are never fatal: they only
+// determine how the offending message is settled.
+func (p *Pool) Run(ctx context.Context, deliveries <-chan amqp.Delivery, handle HandleFunc) error {
+	if p.numWorkers < 1 {
+		return fmt.Errorf("invalid number of workers: %d", p.numWorkers)
+	}
+	p.mu.Lock()
+	p.isRunning = true
+	p.lastProgressAt = time.Now()
+	p.mu.Unlock()
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < p.numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case d, ok := <-deliveries:
+					if !ok {
+						return
+					}
+					p.recordProgress(1)
+					p.settle(ctx, &d, p.handle(ctx, d, handle))
+					p.recordProgress(-1)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	return nil
+}
+
+// CheckProgress returns an error if the pool appears to be stuck: that is, if it has
+// messages to process (either deliveries that are being handled, or numWaiting
+// messages that are still waiting in the queue) but hasn't received or settled any
+// message within maxStall. A pool that's idle with no messages waiting, or that
+// hasn't started running yet, is always considered to be making progress.
+func (p *Pool) CheckProgress(numWaiting int, maxStall time.Duration) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	now := time.Now()
+	if !p.isRunning || (p.numInFlight == 0 && numWaiting == 0) {
+		p.lastProgressAt = now
+		return nil
+	}
+	if elapsed := now.Sub(p.lastProgressAt); elapsed > maxStall {
+		return fmt.Errorf("no progress in %s with %d message(s) in flight and %d waiting", elapsed.Truncate(time.Second), p.numInFlight, numWaiting)
+	}
+	return nil
+}
+
+// recordProgress notes that a delivery has been received (delta = 1) or settled
+// (delta = -1)
+func (p *Pool) recordProgress(delta int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.numInFlight += delta
+	p.lastProgressAt = time.Now()
+}
+
+// handle invokes the handler for a single delivery, converting any panic to a
+// permanent error. The handler is called within a span that continues the trace
+// propagated in the message's headers, if any.
+func (p *Pool) handle(ctx context.Context, d amqp.Delivery, handle HandleFunc) (err error) {
+	ctx, span := tracing.StartConsumer(tracing.Extract(ctx, d.Headers), "queue.handle",
+		attribute.String("messaging.system", "rabbitmq"),
+		attribute.String("messaging.source.name", d.Exchange),
+		attribute.Int("messaging.rabbitmq.delivery_count", GetDeliveryCount(&d)),
+	)
+	defer func() { tracing.End(span, err) }()
+	defer func() {
+		if r := recover(); r != nil {
+			p.logger.Error("Recovered from panic in message handler", "panic", r, "stack", string(debug.Stack()))
+			err = &panicError{value: r}
+		}
+	}()
+	return handle(ctx, d)
+}
+
+// settle acks, requeues, or dead-letters a delivery depending on the error (if any)
+// that resulted from handling it
+func (p *Pool) settle(ctx context.Context, d *amqp.Delivery, err error) {
+	if err == nil {
+		if ackErr := d.Ack(false); ackErr != nil {
+			p.logger.Error("Failed to ack delivery", "error", ackErr)
+		}
+		return
+	}
+
+	// If the message can never succeed, or if we've already tried too many times,
+	// reject it without requeueing, so that it's routed to the dead-letter exchange
+	deliveryCount := GetDeliveryCount(d)
+	_, isPanic := err.(*panicError)
+	if isPanic || p.isPermanent(err) || p.IsFinalDelivery(d) {
+		p.logger.Error("Dead-lettering message after failure", "error", err, "deliveryCount", deliveryCount)
+		if nackErr := d.Nack(false, false); nackErr != nil {
+			p.logger.Error("Failed to nack delivery", "error", nackErr)
+		}
+		return
+	}
+
+	// Otherwise, send a copy of the message back to the queue with an incremented
+	// delivery count, then ack the original; if we can't publish, fall back to letting
+	// RabbitMQ redeliver the original message as-is
+	p.logger.Warn("Requeueing message after transient failure", "error", err, "deliveryCount", deliveryCount)
+	if requeueErr := p.requeuer.Requeue(context.WithoutCancel(ctx), d); requeueErr != nil {
+		p.logger.Error("Failed to requeue message", "error", requeueErr)
+		if nackErr := d.Nack(false, true); nackErr != nil {
+			p.logger.Error("Failed to nack delivery", "error", nackErr)
+		}
+		return
+	}
+	if ackErr := d.Ack(false); ackErr != nil {
+		p.logger.Error("Failed to ack delivery", "error", ackErr)
+	}
+}
+
+// IsFinalDelivery returns true if the given delivery won't be requeued if handling it
+// fails, because the message has already been delivered the maximum number of times
+func (p *Pool) IsFinalDelivery(d *amqp.Delivery) bool {
+	return GetDeliveryCount(d) >= p.maxDeliveries
+}
+
+// panicError is returned from Pool.handle when the handler panicked
+type panicError struct {
+	value interface{}
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("panic in message handler: %v", e.value)
+}
@@ -0,0 +1,260 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/exp/slog"
+)
+
+var errMockPermanent = errors.New("mock permanent error")
+
+func isMockPermanent(err error) bool {
+	return errors.Is(err, errMockPermanent)
+}
+
+func Test_Pool_boundsConcurrency(t *testing.T) {
+	acks := &mockAcknowledger{}
+	deliveries := make(chan amqp.Delivery)
+	go func() {
+		for i := 0; i < 20; i++ {
+			deliveries <- amqp.Delivery{Acknowledger: acks, DeliveryTag: uint64(i + 1)}
+		}
+		close(deliveries)
+	}()
+
+	// Handle each message slowly, keeping track of how many are in flight at once
+	var numInFlight int32
+	var maxInFlight int32
+	var numHandled int32
+	p := NewPool(slog.Default(), 3, &mockRequeuer{}, isMockPermanent, 5)
+	err := p.Run(context.Background(), deliveries, func(ctx context.Context, d amqp.Delivery) error {
+		n := atomic.AddInt32(&numInFlight, 1)
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+		atomic.AddInt32(&numInFlight, -1)
+		atomic.AddInt32(&numHandled, 1)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, int32(20), numHandled)
+	assert.LessOrEqual(t, maxInFlight, int32(3))
+	assert.Len(t, acks.acked, 20)
+}
+
+func Test_Pool_settlement(t *testing.T) {
+	tests := []struct {
+		name         string
+		headers      amqp.Table
+		handleErr    error
+		handlePanic  bool
+		requeueErr   error
+		wantAcked    bool
+		wantNacked   bool
+		wantRequeued bool
+		wantNackWith bool
+	}{
+		{
+			"success is acked",
+			nil,
+			nil,
+			false,
+			nil,
+			true,
+			false,
+			false,
+			false,
+		},
+		{
+			"permanent failure is dead-lettered",
+			nil,
+			fmt.Errorf("wrapped: %w", errMockPermanent),
+			false,
+			nil,
+			false,
+			true,
+			false,
+			false,
+		},
+		{
+			"panic is dead-lettered",
+			nil,
+			nil,
+			true,
+			nil,
+			false,
+			true,
+			false,
+			false,
+		},
+		{
+			"transient failure is requeued and acked",
+			amqp.Table{DeliveryCountHeader: int32(2)},
+			fmt.Errorf("mock transient error"),
+			false,
+			nil,
+			true,
+			false,
+			true,
+			false,
+		},
+		{
+			"transient failure is dead-lettered after max deliveries",
+			amqp.Table{DeliveryCountHeader: int32(3)},
+			fmt.Errorf("mock transient error"),
+			false,
+			nil,
+			false,
+			true,
+			false,
+			false,
+		},
+		{
+			"failure to requeue falls back to nack with requeue",
+			nil,
+			fmt.Errorf("mock transient error"),
+			false,
+			fmt.Errorf("mock requeue error"),
+			false,
+			true,
+			true,
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			acks := &mockAcknowledger{}
+			requeuer := &mockRequeuer{err: tt.requeueErr}
+			deliveries := make(chan amqp.Delivery, 1)
+			deliveries <- amqp.Delivery{Acknowledger: acks, DeliveryTag: 1, Headers: tt.headers}
+			close(deliveries)
+
+			p := NewPool(slog.Default(), 1, requeuer, isMockPermanent, 3)
+			err := p.Run(context.Background(), deliveries, func(ctx context.Context, d amqp.Delivery) error {
+				if tt.handlePanic {
+					panic("oh no")
+				}
+				return tt.handleErr
+			})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantAcked, len(acks.acked) == 1)
+			assert.Equal(t, tt.wantNacked, len(acks.nacked) == 1)
+			assert.Equal(t, tt.wantRequeued, len(requeuer.requeued) == 1)
+			if tt.wantNacked {
+				assert.Equal(t, tt.wantNackWith, acks.nacked[0])
+			}
+		})
+	}
+}
+
+func Test_Pool_invalid(t *testing.T) {
+	p := NewPool(slog.Default(), 0, &mockRequeuer{}, isMockPermanent, 5)
+	err := p.Run(context.Background(), nil, nil)
+	assert.Error(t, err)
+}
+
+func Test_Pool_CheckProgress(t *testing.T) {
+	tests := []struct {
+		name        string
+		numInFlight int
+		numWaiting  int
+		idleFor     time.Duration
+		wantErr     bool
+	}{
+		{"idle with nothing waiting", 0, 0, time.Hour, false},
+		{"messages waiting but recently active", 0, 3, time.Second, false},
+		{"messages waiting with no recent progress", 0, 3, time.Hour, true},
+		{"handling a message for a while", 1, 0, time.Second, false},
+		{"stuck handling a message", 2, 0, time.Hour, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewPool(slog.Default(), 4, &mockRequeuer{}, isMockPermanent, 5)
+			p.isRunning = true
+			p.numInFlight = tt.numInFlight
+			p.lastProgressAt = time.Now().Add(-tt.idleFor)
+			err := p.CheckProgress(tt.numWaiting, 10*time.Minute)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func Test_Pool_CheckProgress_idleResetsClock(t *testing.T) {
+	// An idle pool shouldn't be flagged as stuck as soon as a message arrives, just
+	// because it hasn't had anything to do in a while
+	p := NewPool(slog.Default(), 4, &mockRequeuer{}, isMockPermanent, 5)
+	p.isRunning = true
+	p.lastProgressAt = time.Now().Add(-time.Hour)
+	assert.NoError(t, p.CheckProgress(0, 10*time.Minute))
+	assert.NoError(t, p.CheckProgress(1, 10*time.Minute))
+}
+
+func Test_Pool_CheckProgress_notRunning(t *testing.T) {
+	// Before the pool starts running (e.g. while we're recovering unfinished requests),
+	// messages may pile up without the pool being stuck
+	p := NewPool(slog.Default(), 4, &mockRequeuer{}, isMockPermanent, 5)
+	assert.NoError(t, p.CheckProgress(10, 0))
+}
+
+func Test_GetDeliveryCount(t *testing.T) {
+	assert.Equal(t, 1, GetDeliveryCount(&amqp.Delivery{}))
+	assert.Equal(t, 4, GetDeliveryCount(&amqp.Delivery{Headers: amqp.Table{DeliveryCountHeader: int32(4)}}))
+	assert.Equal(t, 7, GetDeliveryCount(&amqp.Delivery{Headers: amqp.Table{DeliveryCountHeader: int64(7)}}))
+	assert.Equal(t, 1, GetDeliveryCount(&amqp.Delivery{Headers: amqp.Table{DeliveryCountHeader: "bogus"}}))
+}
+
+func Test_IsRedelivery(t *testing.T) {
+	assert.False(t, IsRedelivery(&amqp.Delivery{}))
+	assert.True(t, IsRedelivery(&amqp.Delivery{Redelivered: true}))
+	assert.True(t, IsRedelivery(&amqp.Delivery{Headers: amqp.Table{DeliveryCountHeader: int32(2)}}))
+}
+
+type mockAcknowledger struct {
+	mu     sync.Mutex
+	acked  []uint64
+	nacked []bool
+}
+
+func (m *mockAcknowledger) Ack(tag uint64, multiple bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.acked = append(m.acked, tag)
+	return nil
+}
+
+func (m *mockAcknowledger) Nack(tag uint64, multiple bool, requeue bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.nacked = append(m.nacked, requeue)
+	return nil
+}
+
+func (m *mockAcknowledger) Reject(tag uint64, requeue bool) error {
+	return fmt.Errorf("unexpected reject")
+}
+
+type mockRequeuer struct {
+	err      error
+	requeued []*amqp.Delivery
+}
+
+func (m *mockRequeuer) Requeue(ctx context.Context, d *amqp.Delivery) error {
+	m.requeued = append(m.requeued, d)
+	return m.err
+}
@@ -0,0 +1,3 @@
+// Package records implements read-only API routes that allow HTTP clients to inspect
+// image generation requests, along with the images and answers they've produced
+package records
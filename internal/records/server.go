@@ -0,0 +1,233 @@
+package records
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	q Queries
+}
+
+func NewServer(q Queries) *Server {
+	return &Server{
+		q: q,
+	}
+}
+
+func (s *Server) RegisterRoutes(r *mux.Router) {
+	r.Path("/requests").Methods("GET").HandlerFunc(s.handleGetRequests)
+	r.Path("/requests/{id}").Methods("GET").HandlerFunc(s.handleGetRequest)
+}
+
+func (s *Server) handleGetRequest(res http.ResponseWriter, req *http.Request) {
+	// Parse the ID of the desired image request from the URL
+	imageRequestId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		http.Error(res, "invalid image request ID", http.StatusBadRequest)
+		return
+	}
+
+	// Look up the request itself, returning 404 if it doesn't exist
+	row, err := s.q.GetImageRequest(req.Context(), imageRequestId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(res, "no such image request", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	result := buildImageRequest(&row)
+
+	// Include all images that have been generated for this request so far, unless the
+	// request has been taken down
+	if result.Removal == nil {
+		imageRows, err := s.q.GetImageRequestImages(req.Context(), imageRequestId)
+		if err != nil {
+			http.Error(res, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		for _, imageRow := range imageRows {
+			result.Images = append(result.Images, Image{
+				Index:    int(imageRow.Index),
+				Url:      imageRow.Url,
+				Color:    imageRow.Color,
+				Provider: imageRow.Provider.String,
+			})
+		}
+	}
+
+	// Include any answers we got from the text generation API as well
+	answerRows, err := s.q.GetImageRequestAnswers(req.Context(), imageRequestId)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	for _, answerRow := range answerRows {
+		result.Answers = append(result.Answers, Answer{
+			Prompt: answerRow.Prompt,
+			Value:  answerRow.Value,
+		})
+	}
+
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func (s *Server) handleGetRequests(res http.ResponseWriter, req *http.Request) {
+	// Parse any filters supplied as URL parameters
+	params, limit, err := parseListParams(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Query for one more record than we need, so we know whether there's another page
+	rows, err := s.q.ListImageRequests(req.Context(), params)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	numItemsToReturn := min(limit, len(rows))
+	items := make([]ImageRequest, 0, numItemsToReturn)
+	for i := 0; i < numItemsToReturn; i++ {
+		items = append(items, buildImageRequest(&rows[i]))
+	}
+	nextCursor := ""
+	if len(rows) > limit {
+		nextCursor = rows[limit].ID.String()
+	}
+	history := &ImageRequestHistory{
+		Items:      items,
+		NextCursor: nextCursor,
+	}
+	if err := json.NewEncoder(res).Encode(history); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
+
+func parseListParams(req *http.Request) (queries.ListImageRequestsParams, int, error) {
+	params := queries.ListImageRequestsParams{}
+	values := req.URL.Query()
+
+	limit := 50
+	if maxStr := values.Get("max"); maxStr != "" {
+		maxValue, err := strconv.Atoi(maxStr)
+		if err != nil {
+			return params, 0, fmt.Errorf("invalid 'max' value")
+		}
+		limit = max(1, min(maxValue, 100))
+	}
+	params.NumRecords = int32(limit + 1)
+
+	if fromStr := values.Get("from"); fromStr != "" {
+		fromUUID, err := uuid.Parse(fromStr)
+		if err != nil {
+			return params, 0, fmt.Errorf("invalid 'from' value")
+		}
+		params.StartID = uuid.NullUUID{Valid: true, UUID: fromUUID}
+	}
+	if user := values.Get("user"); user != "" {
+		params.TwitchUserID = sql.NullString{Valid: true, String: user}
+	}
+	if broadcastStr := values.Get("broadcast"); broadcastStr != "" {
+		broadcastId, err := strconv.Atoi(broadcastStr)
+		if err != nil {
+			return params, 0, fmt.Errorf("invalid 'broadcast' value")
+		}
+		params.BroadcastID = sql.NullInt32{Valid: true, Int32: int32(broadcastId)}
+	}
+	if screeningStr := values.Get("screening"); screeningStr != "" {
+		screeningId, err := uuid.Parse(screeningStr)
+		if err != nil {
+			return params, 0, fmt.Errorf("invalid 'screening' value")
+		}
+		params.ScreeningID = uuid.NullUUID{Valid: true, UUID: screeningId}
+	}
+	if style := values.Get("style"); style != "" {
+		params.Style = sql.NullString{Valid: true, String: style}
+	}
+	if status := values.Get("status"); status != "" {
+		switch Status(status) {
+		case StatusPending, StatusSucceeded, StatusFailed:
+			params.Status = sql.NullString{Valid: true, String: status}
+		default:
+			return params, 0, fmt.Errorf("invalid 'status' value: must be one of '%s', '%s', or '%s'", StatusPending, StatusSucceeded, StatusFailed)
+		}
+	}
+	return params, limit, nil
+}
+
+func buildImageRequest(row *queries.DynamoImageRequest) ImageRequest {
+	result := ImageRequest{
+		Id:           row.ID,
+		TwitchUserId: row.TwitchUserID,
+		Style:        row.Style,
+		Inputs:       row.Inputs,
+		Prompt:       row.Prompt,
+		Status:       StatusPending,
+		CreatedAt:    row.CreatedAt,
+		Stage:        row.Stage,
+	}
+	if row.BroadcastID.Valid {
+		broadcastId := int(row.BroadcastID.Int32)
+		result.BroadcastId = &broadcastId
+	}
+	if row.ScreeningID.Valid {
+		screeningId := row.ScreeningID.UUID
+		result.ScreeningId = &screeningId
+	}
+	for _, st := range []struct {
+		stage string
+		t     sql.NullTime
+	}{
+		{"debited", row.DebitedAt},
+		{"moderated", row.ModeratedAt},
+		{"named", row.NamedAt},
+		{"generated", row.GeneratedAt},
+		{"filtered", row.FilteredAt},
+		{"stored", row.StoredAt},
+		{"selected", row.SelectedAt},
+		{"announced", row.AnnouncedAt},
+		{"accepted", row.AcceptedAt},
+	} {
+		if st.t.Valid {
+			if result.StageTimes == nil {
+				result.StageTimes = make(StageTimes)
+			}
+			result.StageTimes[st.stage] = st.t.Time
+		}
+	}
+	if row.SelectedIndex.Valid {
+		selectedIndex := int(row.SelectedIndex.Int32)
+		result.SelectedIndex = &selectedIndex
+	}
+	if row.RemovedAt.Valid {
+		result.Removal = &Removal{
+			Reason:    row.RemovalReason.String,
+			RemovedAt: row.RemovedAt.Time,
+		}
+	}
+	if row.FinishedAt.Valid {
+		finishedAt := row.FinishedAt.Time
+		result.FinishedAt = &finishedAt
+		if row.ErrorMessage.Valid {
+			result.Status = StatusFailed
+			result.ErrorMessage = row.ErrorMessage.String
+			result.ErrorCode = row.ErrorCode.String
+		} else {
+			result.Status = StatusSucceeded
+		}
+	}
+	return result
+}
@@ -0,0 +1,320 @@
+package records
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handleGetRequest(t *testing.T) {
+	tests := []struct {
+		name       string
+		q          *mockQueries
+		url        string
+		wantStatus int
+		wantBody   string
+	}{
+		{
+			"successful request includes images and answers",
+			&mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:           uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"),
+						TwitchUserID: "1234",
+						BroadcastID:  sql.NullInt32{Valid: true, Int32: 42},
+						Style:        "friend",
+						Inputs:       json.RawMessage(`{"color":"red","subject":"a frog"}`),
+						Prompt:       "a red frog",
+						CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						FinishedAt:   sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 30, 0, time.UTC)},
+					},
+				},
+				images: map[uuid.UUID][]queries.GetImageRequestImagesRow{
+					uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"): {
+						{Index: 0, Url: "http://example.com/frog.webp", Color: "#00ff00", Provider: sql.NullString{Valid: true, String: "openai"}},
+					},
+				},
+				answers: map[uuid.UUID][]queries.GetImageRequestAnswersRow{
+					uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"): {
+						{Prompt: "name a frog", Value: "Fred"},
+					},
+				},
+			},
+			"/requests/4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5",
+			http.StatusOK,
+			`{"id":"4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5","twitchUserId":"1234","broadcastId":42,"style":"friend","inputs":{"color":"red","subject":"a frog"},"prompt":"a red frog","status":"succeeded","createdAt":"1997-09-01T12:00:00Z","finishedAt":"1997-09-01T12:00:30Z","images":[{"index":0,"url":"http://example.com/frog.webp","color":"#00ff00","provider":"openai"}],"answers":[{"prompt":"name a frog","value":"Fred"}]}`,
+		},
+		{
+			"request with several candidates includes the selected index",
+			&mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:            uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"),
+						TwitchUserID:  "1234",
+						Style:         "ghost",
+						Inputs:        json.RawMessage(`{"subject":"a frog"}`),
+						Prompt:        "a ghostly image of a frog",
+						CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						Stage:         "selected",
+						StoredAt:      sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 20, 0, time.UTC)},
+						SelectedAt:    sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 45, 0, time.UTC)},
+						SelectedIndex: sql.NullInt32{Valid: true, Int32: 1},
+					},
+				},
+				images: map[uuid.UUID][]queries.GetImageRequestImagesRow{
+					uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"): {
+						{Index: 0, Url: "http://example.com/frog-0.webp", Color: "#000000"},
+						{Index: 1, Url: "http://example.com/frog-1.webp", Color: "#000000"},
+					},
+				},
+			},
+			"/requests/4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5",
+			http.StatusOK,
+			`{"id":"4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5","twitchUserId":"1234","style":"ghost","inputs":{"subject":"a frog"},"prompt":"a ghostly image of a frog","status":"pending","createdAt":"1997-09-01T12:00:00Z","stage":"selected","stageTimes":{"selected":"1997-09-01T12:00:45Z","stored":"1997-09-01T12:00:20Z"},"selectedIndex":1,"images":[{"index":0,"url":"http://example.com/frog-0.webp","color":"#000000"},{"index":1,"url":"http://example.com/frog-1.webp","color":"#000000"}]}`,
+		},
+		{
+			"failed request includes error message and code",
+			&mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:           uuid.MustParse("9b0e8b9c-6ab0-4b6d-8c39-2a8f4b9a7e21"),
+						TwitchUserID: "1234",
+						Style:        "ghost",
+						Inputs:       json.RawMessage(`{"subject":"something awful"}`),
+						Prompt:       "a ghostly image of something awful",
+						CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						FinishedAt:   sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 5, 0, time.UTC)},
+						ErrorMessage: sql.NullString{Valid: true, String: "image generation request rejected"},
+						ErrorCode:    sql.NullString{Valid: true, String: "generation.rejected"},
+					},
+				},
+			},
+			"/requests/9b0e8b9c-6ab0-4b6d-8c39-2a8f4b9a7e21",
+			http.StatusOK,
+			`{"id":"9b0e8b9c-6ab0-4b6d-8c39-2a8f4b9a7e21","twitchUserId":"1234","style":"ghost","inputs":{"subject":"something awful"},"prompt":"a ghostly image of something awful","status":"failed","createdAt":"1997-09-01T12:00:00Z","finishedAt":"1997-09-01T12:00:05Z","errorMessage":"image generation request rejected","errorCode":"generation.rejected"}`,
+		},
+		{
+			"pending request includes its stage and the time each stage was reached",
+			&mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:           uuid.MustParse("e3a3a0d4-2c7f-4f37-8a5b-6b1c2d3e4f50"),
+						TwitchUserID: "1234",
+						Style:        "ghost",
+						Inputs:       json.RawMessage(`{"subject":"a seal"}`),
+						Prompt:       "a ghostly image of a seal",
+						CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						Stage:        "generated",
+						DebitedAt:    sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 1, 0, time.UTC)},
+						NamedAt:      sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 1, 0, time.UTC)},
+						GeneratedAt:  sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 20, 0, time.UTC)},
+					},
+				},
+			},
+			"/requests/e3a3a0d4-2c7f-4f37-8a5b-6b1c2d3e4f50",
+			http.StatusOK,
+			`{"id":"e3a3a0d4-2c7f-4f37-8a5b-6b1c2d3e4f50","twitchUserId":"1234","style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","status":"pending","createdAt":"1997-09-01T12:00:00Z","stage":"generated","stageTimes":{"debited":"1997-09-01T12:00:01Z","generated":"1997-09-01T12:00:20Z","named":"1997-09-01T12:00:01Z"}}`,
+		},
+		{
+			"removed request includes removal details but no images",
+			&mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:            uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"),
+						TwitchUserID:  "1234",
+						Style:         "ghost",
+						Inputs:        json.RawMessage(`{"subject":"a frog"}`),
+						Prompt:        "a ghostly image of a frog",
+						CreatedAt:     time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+						FinishedAt:    sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 30, 0, time.UTC)},
+						RemovedAt:     sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 5, 0, 0, time.UTC)},
+						RemovalReason: sql.NullString{Valid: true, String: "offensive"},
+					},
+				},
+				images: map[uuid.UUID][]queries.GetImageRequestImagesRow{
+					uuid.MustParse("4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5"): {
+						{Index: 0, Url: "http://example.com/frog.webp", Color: "#00ff00"},
+					},
+				},
+			},
+			"/requests/4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5",
+			http.StatusOK,
+			`{"id":"4c1fa28b-5c9a-4a62-9f03-d7d2e3f3f8f5","twitchUserId":"1234","style":"ghost","inputs":{"subject":"a frog"},"prompt":"a ghostly image of a frog","status":"succeeded","createdAt":"1997-09-01T12:00:00Z","finishedAt":"1997-09-01T12:00:30Z","removal":{"reason":"offensive","removedAt":"1997-09-01T12:05:00Z"}}`,
+		},
+		{
+			"nonexistent request is a 404",
+			&mockQueries{},
+			"/requests/9b0e8b9c-6ab0-4b6d-8c39-2a8f4b9a7e21",
+			http.StatusNotFound,
+			"no such image request",
+		},
+		{
+			"invalid request ID is a 400",
+			&mockQueries{},
+			"/requests/not-a-uuid",
+			http.StatusBadRequest,
+			"invalid image request ID",
+		},
+		{
+			"database error is a 500",
+			&mockQueries{err: fmt.Errorf("mock error")},
+			"/requests/9b0e8b9c-6ab0-4b6d-8c39-2a8f4b9a7e21",
+			http.StatusInternalServerError,
+			"mock error",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(tt.q)
+			r := mux.NewRouter()
+			s.RegisterRoutes(r)
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+		})
+	}
+}
+
+func Test_Server_handleGetRequests(t *testing.T) {
+	tests := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantBody   string
+		wantParams queries.ListImageRequestsParams
+	}{
+		{
+			"unfiltered request returns most recent requests",
+			"/requests",
+			http.StatusOK,
+			`{"items":[{"id":"6e0b7a4c-3c8e-4f0c-9a57-3c5b2b0f7a10","twitchUserId":"1234","style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","status":"pending","createdAt":"1997-09-01T12:00:00Z"}]}`,
+			queries.ListImageRequestsParams{
+				NumRecords: 51,
+			},
+		},
+		{
+			"filters and cursor are passed through to query",
+			"/requests?user=1234&broadcast=42&screening=0a4c5c8d-5e33-4b53-9c4a-2a4e0f8f7d11&style=ghost&status=pending&max=10&from=6e0b7a4c-3c8e-4f0c-9a57-3c5b2b0f7a10",
+			http.StatusOK,
+			`{"items":[{"id":"6e0b7a4c-3c8e-4f0c-9a57-3c5b2b0f7a10","twitchUserId":"1234","style":"ghost","inputs":{"subject":"a seal"},"prompt":"a ghostly image of a seal","status":"pending","createdAt":"1997-09-01T12:00:00Z"}]}`,
+			queries.ListImageRequestsParams{
+				TwitchUserID: sql.NullString{Valid: true, String: "1234"},
+				BroadcastID:  sql.NullInt32{Valid: true, Int32: 42},
+				ScreeningID:  uuid.NullUUID{Valid: true, UUID: uuid.MustParse("0a4c5c8d-5e33-4b53-9c4a-2a4e0f8f7d11")},
+				Style:        sql.NullString{Valid: true, String: "ghost"},
+				Status:       sql.NullString{Valid: true, String: "pending"},
+				StartID:      uuid.NullUUID{Valid: true, UUID: uuid.MustParse("6e0b7a4c-3c8e-4f0c-9a57-3c5b2b0f7a10")},
+				NumRecords:   11,
+			},
+		},
+		{
+			"invalid status is a 400",
+			"/requests?status=bogus",
+			http.StatusBadRequest,
+			"invalid 'status' value: must be one of 'pending', 'succeeded', or 'failed'",
+			queries.ListImageRequestsParams{},
+		},
+		{
+			"invalid broadcast ID is a 400",
+			"/requests?broadcast=x",
+			http.StatusBadRequest,
+			"invalid 'broadcast' value",
+			queries.ListImageRequestsParams{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &mockQueries{
+				requests: []queries.DynamoImageRequest{
+					{
+						ID:           uuid.MustParse("6e0b7a4c-3c8e-4f0c-9a57-3c5b2b0f7a10"),
+						TwitchUserID: "1234",
+						Style:        "ghost",
+						Inputs:       json.RawMessage(`{"subject":"a seal"}`),
+						Prompt:       "a ghostly image of a seal",
+						CreatedAt:    time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC),
+					},
+				},
+			}
+			s := NewServer(q)
+			r := mux.NewRouter()
+			s.RegisterRoutes(r)
+
+			req := httptest.NewRequest(http.MethodGet, tt.url, nil)
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			if tt.wantStatus == http.StatusOK {
+				assert.Equal(t, []queries.ListImageRequestsParams{tt.wantParams}, q.listCalls)
+			} else {
+				assert.Empty(t, q.listCalls)
+			}
+		})
+	}
+}
+
+type mockQueries struct {
+	err       error
+	requests  []queries.DynamoImageRequest
+	images    map[uuid.UUID][]queries.GetImageRequestImagesRow
+	answers   map[uuid.UUID][]queries.GetImageRequestAnswersRow
+	listCalls []queries.ListImageRequestsParams
+}
+
+func (m *mockQueries) GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error) {
+	if m.err != nil {
+		return queries.DynamoImageRequest{}, m.err
+	}
+	for _, row := range m.requests {
+		if row.ID == imageRequestID {
+			return row, nil
+		}
+	}
+	return queries.DynamoImageRequest{}, sql.ErrNoRows
+}
+
+func (m *mockQueries) GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.images[imageRequestID], nil
+}
+
+func (m *mockQueries) GetImageRequestAnswers(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestAnswersRow, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return m.answers[imageRequestID], nil
+}
+
+func (m *mockQueries) ListImageRequests(ctx context.Context, arg queries.ListImageRequestsParams) ([]queries.DynamoImageRequest, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	m.listCalls = append(m.listCalls, arg)
+	return m.requests, nil
+}
@@ -0,0 +1,80 @@
+package records
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error)
+	GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error)
+	GetImageRequestAnswers(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestAnswersRow, error)
+	ListImageRequests(ctx context.Context, arg queries.ListImageRequestsParams) ([]queries.DynamoImageRequest, error)
+}
+
+// Status describes how far along an image request has progressed
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusSucceeded Status = "succeeded"
+	StatusFailed    Status = "failed"
+)
+
+// ImageRequest is the JSON representation of a dynamo.image_request record
+type ImageRequest struct {
+	Id            uuid.UUID       `json:"id"`
+	TwitchUserId  string          `json:"twitchUserId"`
+	BroadcastId   *int            `json:"broadcastId,omitempty"`
+	ScreeningId   *uuid.UUID      `json:"screeningId,omitempty"`
+	Style         string          `json:"style"`
+	Inputs        json.RawMessage `json:"inputs"`
+	Prompt        string          `json:"prompt"`
+	Status        Status          `json:"status"`
+	CreatedAt     time.Time       `json:"createdAt"`
+	FinishedAt    *time.Time      `json:"finishedAt,omitempty"`
+	ErrorMessage  string          `json:"errorMessage,omitempty"`
+	ErrorCode     string          `json:"errorCode,omitempty"`
+	Stage         string          `json:"stage,omitempty"`
+	StageTimes    StageTimes      `json:"stageTimes,omitempty"`
+	SelectedIndex *int            `json:"selectedIndex,omitempty"`
+	Removal       *Removal        `json:"removal,omitempty"`
+	Images        []Image         `json:"images,omitempty"`
+	Answers       []Answer        `json:"answers,omitempty"`
+}
+
+// StageTimes maps the name of each processing stage that an image request has reached
+// (e.g. "debited", "generated", "stored") to the time at which it was reached
+type StageTimes map[string]time.Time
+
+// Removal describes why and when an image request was taken down by a moderator
+type Removal struct {
+	Reason    string    `json:"reason"`
+	RemovedAt time.Time `json:"removedAt"`
+}
+
+// Image describes an image that was generated in response to a request
+type Image struct {
+	Index    int    `json:"index"`
+	Url      string `json:"url"`
+	Color    string `json:"color"`
+	Provider string `json:"provider,omitempty"`
+}
+
+// Answer describes a value that was obtained from a text generation API in the course
+// of fulfilling a request
+type Answer struct {
+	Prompt string `json:"prompt"`
+	Value  string `json:"value"`
+}
+
+// ImageRequestHistory is a page of results from a GET /requests query: if NextCursor
+// is set, it can be supplied as the 'from' URL parameter to get the next page
+type ImageRequestHistory struct {
+	Items      []ImageRequest `json:"items"`
+	NextCursor string         `json:"nextCursor,omitempty"`
+}
@@ -0,0 +1,4 @@
+// Package selection implements API routes that allow the broadcaster to choose which of
+// an image request's candidate images will be displayed onscreen, while the consumer is
+// waiting on that choice before announcing the alert
+package selection
@@ -0,0 +1,139 @@
+package selection
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	q Queries
+}
+
+func NewServer(q Queries) *Server {
+	return &Server{
+		q: q,
+	}
+}
+
+func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
+	r.Path("/requests/{id}/selection").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostSelection),
+		),
+	)
+}
+
+func (s *Server) handlePostSelection(res http.ResponseWriter, req *http.Request) {
+	// Identify the broadcaster who's making the selection
+	claims, err := auth.GetClaims(req)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Parse the ID of the image request from the URL
+	imageRequestId, err := uuid.Parse(mux.Vars(req)["id"])
+	if err != nil {
+		http.Error(res, "invalid image request ID", http.StatusBadRequest)
+		return
+	}
+
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the index of the selected image from the request body
+	var payload SelectionRequest
+	if err := json.NewDecoder(req.Body).Decode(&payload); err != nil {
+		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if payload.Index == nil {
+		http.Error(res, "invalid request payload: 'index' must be set", http.StatusBadRequest)
+		return
+	}
+
+	// Look up the image request, and make sure it's still awaiting a selection: once
+	// the consumer has announced the alert, it's too late to change the image
+	row, err := s.q.GetImageRequest(req.Context(), imageRequestId)
+	if errors.Is(err, sql.ErrNoRows) {
+		http.Error(res, "no such image request", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if row.FinishedAt.Valid {
+		http.Error(res, "image request has already finished", http.StatusConflict)
+		return
+	}
+	if row.SelectedIndex.Valid {
+		http.Error(res, "an image has already been selected", http.StatusConflict)
+		return
+	}
+
+	// Make sure the selected image is one of the candidates that have been stored
+	imageRows, err := s.q.GetImageRequestImages(req.Context(), imageRequestId)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(imageRows) == 0 {
+		http.Error(res, "image request has no images to select from yet", http.StatusConflict)
+		return
+	}
+	var selected *queries.GetImageRequestImagesRow
+	for i := range imageRows {
+		if int(imageRows[i].Index) == *payload.Index {
+			selected = &imageRows[i]
+			break
+		}
+	}
+	if selected == nil {
+		http.Error(res, fmt.Sprintf("invalid request payload: image request has no image with index %d", *payload.Index), http.StatusBadRequest)
+		return
+	}
+
+	// Record the selection, so long as nobody (including the consumer) got there first
+	result, err := s.q.SelectImage(req.Context(), queries.SelectImageParams{
+		SelectedIndex:  selected.Index,
+		SelectedBy:     sql.NullString{Valid: true, String: claims.User.Id},
+		ImageRequestID: imageRequestId,
+	})
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	numRows, err := result.RowsAffected()
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if numRows == 0 {
+		http.Error(res, "an image has already been selected", http.StatusConflict)
+		return
+	}
+	entry.Log(req).Info("Selected image", "imageRequestId", imageRequestId, "index", selected.Index, "twitchUserId", claims.User.Id)
+
+	// Return a JSON-serialized SelectionResult struct to the user
+	if err := json.NewEncoder(res).Encode(&SelectionResult{
+		Index: int(selected.Index),
+		Url:   selected.Url,
+	}); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,208 @@
+package selection
+
+import (
+	"context"
+	"database/sql"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golden-vcr/auth"
+	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostSelection(t *testing.T) {
+	requestId := uuid.MustParse("6d5c4b3a-2918-4f7e-8d6c-5b4a39281706")
+	finishedAt := sql.NullTime{Valid: true, Time: time.Date(1997, 9, 1, 12, 0, 0, 0, time.UTC)}
+	pending := queries.DynamoImageRequest{
+		ID:           requestId,
+		TwitchUserID: "1234",
+		Stage:        "stored",
+	}
+	selected := pending
+	selected.SelectedIndex = sql.NullInt32{Valid: true, Int32: 0}
+	finished := pending
+	finished.FinishedAt = finishedAt
+	images := []queries.GetImageRequestImagesRow{
+		{Index: 0, Url: "https://example.com/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706-0.png"},
+		{Index: 1, Url: "https://example.com/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706-1.png"},
+	}
+
+	tests := []struct {
+		name           string
+		row            *queries.DynamoImageRequest
+		images         []queries.GetImageRequestImagesRow
+		authorization  string
+		url            string
+		body           string
+		wantStatus     int
+		wantBody       string
+		wantSelections []queries.SelectImageParams
+	}{
+		{
+			"broadcaster can select a candidate image",
+			&pending,
+			images,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":1}`,
+			http.StatusOK,
+			`{"index":1,"url":"https://example.com/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706-1.png"}`,
+			[]queries.SelectImageParams{
+				{
+					SelectedIndex:  1,
+					SelectedBy:     sql.NullString{Valid: true, String: "1000"},
+					ImageRequestID: requestId,
+				},
+			},
+		},
+		{
+			"viewers may not select images",
+			&pending,
+			images,
+			"viewer-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":1}`,
+			http.StatusForbidden,
+			"insufficient access: requires broadcaster; you are viewer",
+			nil,
+		},
+		{
+			"index is required",
+			&pending,
+			images,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{}`,
+			http.StatusBadRequest,
+			"invalid request payload: 'index' must be set",
+			nil,
+		},
+		{
+			"unknown image request is 404",
+			nil,
+			nil,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":1}`,
+			http.StatusNotFound,
+			"no such image request",
+			nil,
+		},
+		{
+			"finished image request can not be changed",
+			&finished,
+			images,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":1}`,
+			http.StatusConflict,
+			"image request has already finished",
+			nil,
+		},
+		{
+			"selection can only be made once",
+			&selected,
+			images,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":1}`,
+			http.StatusConflict,
+			"an image has already been selected",
+			nil,
+		},
+		{
+			"selection can not be made before images are stored",
+			&pending,
+			nil,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":0}`,
+			http.StatusConflict,
+			"image request has no images to select from yet",
+			nil,
+		},
+		{
+			"selected index must refer to a stored image",
+			&pending,
+			images,
+			"broadcaster-token",
+			"/requests/6d5c4b3a-2918-4f7e-8d6c-5b4a39281706/selection",
+			`{"index":2}`,
+			http.StatusBadRequest,
+			"invalid request payload: image request has no image with index 2",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authClient := authmock.NewClient().AllowTwitchUserAccessToken("broadcaster-token", auth.RoleBroadcaster, auth.UserDetails{
+				Id:          "1000",
+				Login:       "broadcaster",
+				DisplayName: "Broadcaster",
+			}).AllowTwitchUserAccessToken("viewer-token", auth.RoleViewer, auth.UserDetails{
+				Id:          "1234",
+				Login:       "someviewer",
+				DisplayName: "SomeViewer",
+			})
+			q := &mockQueries{
+				row:    tt.row,
+				images: tt.images,
+			}
+			s := NewServer(q)
+			r := mux.NewRouter()
+			s.RegisterRoutes(authClient, r)
+
+			req := httptest.NewRequest(http.MethodPost, tt.url, strings.NewReader(tt.body))
+			req.Header.Set("authorization", tt.authorization)
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantSelections, q.selections)
+		})
+	}
+}
+
+type mockQueries struct {
+	row        *queries.DynamoImageRequest
+	images     []queries.GetImageRequestImagesRow
+	selections []queries.SelectImageParams
+}
+
+func (m *mockQueries) GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error) {
+	if m.row == nil || m.row.ID != imageRequestID {
+		return queries.DynamoImageRequest{}, sql.ErrNoRows
+	}
+	return *m.row, nil
+}
+
+func (m *mockQueries) GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error) {
+	return m.images, nil
+}
+
+func (m *mockQueries) SelectImage(ctx context.Context, arg queries.SelectImageParams) (sql.Result, error) {
+	m.selections = append(m.selections, arg)
+	return mockResult(1), nil
+}
+
+type mockResult int64
+
+func (m mockResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (m mockResult) RowsAffected() (int64, error) {
+	return int64(m), nil
+}
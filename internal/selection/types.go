@@ -0,0 +1,27 @@
+package selection
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/golden-vcr/dynamo/gen/queries"
+	"github.com/google/uuid"
+)
+
+type Queries interface {
+	GetImageRequest(ctx context.Context, imageRequestID uuid.UUID) (queries.DynamoImageRequest, error)
+	GetImageRequestImages(ctx context.Context, imageRequestID uuid.UUID) ([]queries.GetImageRequestImagesRow, error)
+	SelectImage(ctx context.Context, arg queries.SelectImageParams) (sql.Result, error)
+}
+
+// SelectionRequest is the payload accepted by POST /requests/:id/selection
+type SelectionRequest struct {
+	// Index identifies the candidate image that should be displayed onscreen
+	Index *int `json:"index"`
+}
+
+// SelectionResult is returned in response to a successful POST /requests/:id/selection
+type SelectionResult struct {
+	Index int    `json:"index"`
+	Url   string `json:"url"`
+}
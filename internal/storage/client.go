@@ -2,61 +2,263 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"net/http"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	awsSession "github.com/aws/aws-sdk-go/aws/session"
 	awsS3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/golden-vcr/dynamo/internal/errcode"
 )
 
+// Codes identifying the categories of error that may occur when accessing storage
+const (
+	// CodeNotFound indicates that the requested object does not exist
+	CodeNotFound errcode.Code = "storage.not-found"
+	// CodeFailed indicates that a storage operation failed for any other reason
+	CodeFailed errcode.Code = "storage.failed"
+)
+
+// ErrNotFound is returned when the requested object does not exist
+var ErrNotFound = errcode.New(CodeNotFound, "object not found")
+
 // Client is an interface to the S3-compatible bucket where we keep generated images for
 // display and archival
 type Client interface {
+	// Upload stores an object and returns the URL at which a user can later access it
 	Upload(ctx context.Context, key string, contentType string, data io.ReadSeeker) (string, error)
+	// Delete removes an object, succeeding if the object does not exist
+	Delete(ctx context.Context, key string) error
+	// Privatize retains an object but revokes public access to it, so that it can only
+	// be accessed via a presigned URL
+	Privatize(ctx context.Context, key string) error
+	// Head returns details about an object, or ErrNotFound if it does not exist
+	Head(ctx context.Context, key string) (*ObjectInfo, error)
+	// Exists reports whether an object exists
+	Exists(ctx context.Context, key string) (bool, error)
+	// List returns details about every object whose key begins with prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	// Download returns the contents of an object, or ErrNotFound if it does not
+	// exist: the caller is responsible for closing the returned reader
+	Download(ctx context.Context, key string) (io.ReadCloser, error)
+	// PresignGet returns a URL that grants temporary read access to an object, even
+	// if the object is not public
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// Ping verifies that the bucket exists and that we're able to access it
+	Ping(ctx context.Context) error
+}
+
+// ObjectInfo describes a stored object
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	ContentType  string
+	LastModified time.Time
+}
+
+// Options configures how a storage.Client communicates with its bucket
+type Options struct {
+	// Scheme is the URL scheme used to reach the endpoint: "https" by default, but
+	// local stand-ins such as MinIO are often served over plain "http"
+	Scheme string
+	// ForcePathStyle addresses the bucket as a path on the endpoint (e.g.
+	// http://localhost:9000/my-bucket) rather than as a subdomain of it (e.g.
+	// https://my-bucket.nyc3.digitaloceanspaces.com)
+	ForcePathStyle bool
+	// ACL is the canned ACL applied to uploaded objects, e.g. "public-read": if empty,
+	// the bucket's default ACL applies
+	ACL string
+	// CacheControl is the Cache-Control header applied to uploaded objects, if any
+	CacheControl string
 }
 
-// client implements imagegen.StorageClient using the S3 API to connect to a
-// DigitalOcean Spaces bucket (e.g. 'golden-vcr-user-images')
+// client implements storage.Client using the S3 API to connect to a DigitalOcean Spaces
+// bucket (e.g. 'golden-vcr-user-images') or any other S3-compatible store
 type client struct {
 	s3         *awsS3.S3
 	bucketName string
 	baseUrl    string
+	opts       Options
 }
 
 // NewClient initializes a storage.Client that will allow generated image files to be
 // uploaded to a Spaces bucket
-func NewClient(spacesAccessKeyId, spacesSecretKey, spacesEndpointOrigin, spacesRegionName, spacesBucketName string) (Client, error) {
+func NewClient(spacesAccessKeyId, spacesSecretKey, spacesEndpointOrigin, spacesRegionName, spacesBucketName string, opts Options) (Client, error) {
+	if opts.Scheme == "" {
+		opts.Scheme = "https"
+	}
 	config := &aws.Config{
 		Credentials:      credentials.NewStaticCredentials(spacesAccessKeyId, spacesSecretKey, ""),
-		Endpoint:         aws.String(fmt.Sprintf("https://%s", spacesEndpointOrigin)),
+		Endpoint:         aws.String(fmt.Sprintf("%s://%s", opts.Scheme, spacesEndpointOrigin)),
 		Region:           aws.String(spacesRegionName),
-		S3ForcePathStyle: aws.Bool(false),
+		S3ForcePathStyle: aws.Bool(opts.ForcePathStyle),
 	}
 	session, err := awsSession.NewSession(config)
 	if err != nil {
 		return nil, err
 	}
 	s3 := awsS3.New(session)
+	baseUrl := fmt.Sprintf("%s://%s.%s", opts.Scheme, spacesBucketName, spacesEndpointOrigin)
+	if opts.ForcePathStyle {
+		baseUrl = fmt.Sprintf("%s://%s/%s", opts.Scheme, spacesEndpointOrigin, spacesBucketName)
+	}
 	return &client{
 		s3:         s3,
 		bucketName: spacesBucketName,
-		baseUrl:    fmt.Sprintf("https://%s.%s", spacesBucketName, spacesEndpointOrigin),
+		baseUrl:    baseUrl,
+		opts:       opts,
 	}, nil
 }
 
 // Uploads stores a file in S3 and returns the URL at which a user can later access it
 func (c *client) Upload(ctx context.Context, key string, contentType string, data io.ReadSeeker) (string, error) {
-	_, err := c.s3.PutObjectWithContext(ctx, &awsS3.PutObjectInput{
+	input := &awsS3.PutObjectInput{
 		Bucket:      aws.String(c.bucketName),
 		Key:         aws.String(key),
 		Body:        data,
-		ACL:         aws.String("public-read"),
 		ContentType: aws.String(contentType),
-	})
-	if err != nil {
+	}
+	if c.opts.ACL != "" {
+		input.ACL = aws.String(c.opts.ACL)
+	}
+	if c.opts.CacheControl != "" {
+		input.CacheControl = aws.String(c.opts.CacheControl)
+	}
+	if _, err := c.s3.PutObjectWithContext(ctx, input); err != nil {
 		return "", err
 	}
 	return fmt.Sprintf("%s/%s", c.baseUrl, key), nil
 }
+
+// Delete removes a file from S3
+func (c *client) Delete(ctx context.Context, key string) error {
+	_, err := c.s3.DeleteObjectWithContext(ctx, &awsS3.DeleteObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil && !isNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Privatize replaces the ACL of a file in S3 so that it's no longer publicly readable
+func (c *client) Privatize(ctx context.Context, key string) error {
+	_, err := c.s3.PutObjectAclWithContext(ctx, &awsS3.PutObjectAclInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+		ACL:    aws.String(awsS3.ObjectCannedACLPrivate),
+	})
+	if err != nil && isNotFound(err) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Head returns the metadata of a file in S3
+func (c *client) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	res, err := c.s3.HeadObjectWithContext(ctx, &awsS3.HeadObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         aws.Int64Value(res.ContentLength),
+		ContentType:  aws.StringValue(res.ContentType),
+		LastModified: aws.TimeValue(res.LastModified),
+	}, nil
+}
+
+// Exists checks whether a file exists in S3
+func (c *client) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := c.Head(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the metadata of all files in S3 whose keys begin with the given prefix:
+// since listing doesn't report content types, ContentType is left empty
+func (c *client) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := c.s3.ListObjectsV2PagesWithContext(ctx, &awsS3.ListObjectsV2Input{
+		Bucket: aws.String(c.bucketName),
+		Prefix: aws.String(prefix),
+	}, func(page *awsS3.ListObjectsV2Output, lastPage bool) bool {
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:          aws.StringValue(obj.Key),
+				Size:         aws.Int64Value(obj.Size),
+				LastModified: aws.TimeValue(obj.LastModified),
+			})
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Download fetches the contents of a file from S3
+func (c *client) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	res, err := c.s3.GetObjectWithContext(ctx, &awsS3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return res.Body, nil
+}
+
+// PresignGet returns a signed URL that allows a file in S3 to be downloaded by anyone
+// who has the URL, until it expires
+func (c *client) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	req, _ := c.s3.GetObjectRequest(&awsS3.GetObjectInput{
+		Bucket: aws.String(c.bucketName),
+		Key:    aws.String(key),
+	})
+	req.SetContext(ctx)
+	return req.Presign(expires)
+}
+
+// Ping checks that the bucket exists and that our credentials grant access to it
+func (c *client) Ping(ctx context.Context) error {
+	_, err := c.s3.HeadBucketWithContext(ctx, &awsS3.HeadBucketInput{
+		Bucket: aws.String(c.bucketName),
+	})
+	return err
+}
+
+// isNotFound returns true if err indicates that the requested object or key does not
+// exist
+func isNotFound(err error) bool {
+	var requestFailure awserr.RequestFailure
+	if errors.As(err, &requestFailure) && requestFailure.StatusCode() == http.StatusNotFound {
+		return true
+	}
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case awsS3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,219 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_client(t *testing.T) {
+	bucket := &fakeBucket{name: "user-images", objects: make(map[string]*fakeObject)}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	endpointOrigin := strings.TrimPrefix(server.URL, "http://")
+
+	c, err := NewClient("key-id", "secret", endpointOrigin, "us-east-1", "user-images", Options{
+		Scheme:         "http",
+		ForcePathStyle: true,
+		ACL:            "public-read",
+		CacheControl:   "public, max-age=86400",
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// Ping should succeed, since the bucket exists
+	err = c.Ping(ctx)
+	assert.NoError(t, err)
+
+	// Uploading should apply our configured ACL and Cache-Control header, and the
+	// resulting URL should use path-style addressing
+	url, err := c.Upload(ctx, "abc/abc-0.png", "image/png", bytes.NewReader([]byte("png data")))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/user-images/abc/abc-0.png", url)
+	obj := bucket.objects["abc/abc-0.png"]
+	if assert.NotNil(t, obj) {
+		assert.Equal(t, "png data", string(obj.data))
+		assert.Equal(t, "image/png", obj.contentType)
+		assert.Equal(t, "public-read", obj.acl)
+		assert.Equal(t, "public, max-age=86400", obj.cacheControl)
+	}
+	_, err = c.Upload(ctx, "def/def-0.jpg", "image/jpeg", bytes.NewReader([]byte("jpg data")))
+	assert.NoError(t, err)
+
+	// Head and Exists should report on existing and missing objects
+	info, err := c.Head(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc/abc-0.png", info.Key)
+	assert.Equal(t, int64(8), info.Size)
+	assert.Equal(t, "image/png", info.ContentType)
+	_, err = c.Head(ctx, "nope.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+	exists, err := c.Exists(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	assert.True(t, exists)
+	exists, err = c.Exists(ctx, "nope.png")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	// List should return only objects matching the prefix
+	objects, err := c.List(ctx, "abc/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "abc/abc-0.png", objects[0].Key)
+	assert.Equal(t, int64(8), objects[0].Size)
+	objects, err = c.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	// Download should return object contents
+	r, err := c.Download(ctx, "def/def-0.jpg")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "jpg data", string(data))
+	_, err = c.Download(ctx, "nope.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// Presigned URLs should carry a signature and expiry
+	presigned, err := c.PresignGet(ctx, "abc/abc-0.png", 15*time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(presigned, server.URL+"/user-images/abc/abc-0.png?"))
+	assert.Contains(t, presigned, "X-Amz-Signature=")
+	assert.Contains(t, presigned, "X-Amz-Expires=900")
+
+	// Delete should remove the object, and deleting a missing object should succeed
+	err = c.Delete(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	assert.Nil(t, bucket.objects["abc/abc-0.png"])
+	err = c.Delete(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+}
+
+func Test_client_Ping_missingBucket(t *testing.T) {
+	bucket := &fakeBucket{name: "user-images", objects: make(map[string]*fakeObject)}
+	server := httptest.NewServer(bucket)
+	defer server.Close()
+	endpointOrigin := strings.TrimPrefix(server.URL, "http://")
+
+	c, err := NewClient("key-id", "secret", endpointOrigin, "us-east-1", "other-bucket", Options{
+		Scheme:         "http",
+		ForcePathStyle: true,
+	})
+	assert.NoError(t, err)
+	err = c.Ping(context.Background())
+	assert.Error(t, err)
+}
+
+func Test_NewClient_virtualHosted(t *testing.T) {
+	c, err := NewClient("key-id", "secret", "nyc3.digitaloceanspaces.com", "nyc3", "user-images", Options{})
+	assert.NoError(t, err)
+	assert.Equal(t, "https://user-images.nyc3.digitaloceanspaces.com", c.(*client).baseUrl)
+	presigned, err := c.PresignGet(context.Background(), "abc/abc-0.png", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(presigned, "https://user-images.nyc3.digitaloceanspaces.com/abc/abc-0.png?"))
+}
+
+// fakeBucket implements just enough of the S3 API, with path-style addressing, to
+// exercise our client
+type fakeBucket struct {
+	name    string
+	mu      sync.Mutex
+	objects map[string]*fakeObject
+}
+
+type fakeObject struct {
+	data         []byte
+	contentType  string
+	acl          string
+	cacheControl string
+	lastModified time.Time
+}
+
+func (b *fakeBucket) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	prefix := "/" + b.name
+	if req.URL.Path != prefix && !strings.HasPrefix(req.URL.Path, prefix+"/") {
+		http.Error(res, "no such bucket", http.StatusNotFound)
+		return
+	}
+	key := strings.TrimPrefix(strings.TrimPrefix(req.URL.Path, prefix), "/")
+
+	if key == "" && req.Method == http.MethodHead {
+		res.WriteHeader(http.StatusOK)
+		return
+	}
+	if key == "" && req.Method == http.MethodGet {
+		b.list(res, req.URL.Query())
+		return
+	}
+
+	switch req.Method {
+	case http.MethodPut:
+		data, _ := io.ReadAll(req.Body)
+		b.objects[key] = &fakeObject{
+			data:         data,
+			contentType:  req.Header.Get("content-type"),
+			acl:          req.Header.Get("x-amz-acl"),
+			cacheControl: req.Header.Get("cache-control"),
+			lastModified: time.Now().UTC().Truncate(time.Second),
+		}
+		res.WriteHeader(http.StatusOK)
+	case http.MethodHead, http.MethodGet:
+		obj, ok := b.objects[key]
+		if !ok {
+			if req.Method == http.MethodHead {
+				res.WriteHeader(http.StatusNotFound)
+				return
+			}
+			res.Header().Set("content-type", "application/xml")
+			res.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(res, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		res.Header().Set("content-type", obj.contentType)
+		res.Header().Set("content-length", fmt.Sprintf("%d", len(obj.data)))
+		res.Header().Set("last-modified", obj.lastModified.Format(http.TimeFormat))
+		res.WriteHeader(http.StatusOK)
+		if req.Method == http.MethodGet {
+			res.Write(obj.data)
+		}
+	case http.MethodDelete:
+		delete(b.objects, key)
+		res.WriteHeader(http.StatusNoContent)
+	default:
+		res.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (b *fakeBucket) list(res http.ResponseWriter, query url.Values) {
+	prefix := query.Get("prefix")
+	keys := make([]string, 0, len(b.objects))
+	for key := range b.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var contents strings.Builder
+	for _, key := range keys {
+		obj := b.objects[key]
+		fmt.Fprintf(&contents, "<Contents><Key>%s</Key><Size>%d</Size><LastModified>%s</LastModified></Contents>", key, len(obj.data), obj.lastModified.Format(time.RFC3339))
+	}
+	res.Header().Set("content-type", "application/xml")
+	fmt.Fprintf(res, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>%s</ListBucketResult>`, b.name, prefix, len(keys), contents.String())
+}
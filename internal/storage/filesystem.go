@@ -0,0 +1,243 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// privateDirName is the name of the directory, beneath the root directory, to which
+// privatized files are moved: since its name begins with a dot, it's never served
+const privateDirName = ".private"
+
+// filesystemClient implements storage.Client by writing files to a local directory,
+// from which they can be served over HTTP by a FileServer: this allows the entire
+// pipeline to run during development without any cloud storage
+type filesystemClient struct {
+	rootDir string
+	baseUrl string
+}
+
+// NewFilesystemClient initializes a storage.Client that will write files beneath the
+// given directory (creating it if necessary), returning URLs that resolve relative to
+// baseUrl: a FileServer for the same directory should be reachable at that URL
+func NewFilesystemClient(rootDir string, baseUrl string) (Client, error) {
+	if err := os.MkdirAll(rootDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create storage directory: %w", err)
+	}
+	return &filesystemClient{
+		rootDir: rootDir,
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+	}, nil
+}
+
+// Upload writes a file beneath the root directory and returns the URL at which it will
+// be served
+func (c *filesystemClient) Upload(ctx context.Context, key string, contentType string, data io.ReadSeeker) (string, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+
+	// Write to a temporary file first, so that the file server never serves a
+	// partially-written file
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(f.Name())
+	if _, err := io.Copy(f, data); err != nil {
+		f.Close()
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return "", err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s", c.baseUrl, key), nil
+}
+
+// Delete removes a file from beneath the root directory
+func (c *filesystemClient) Delete(ctx context.Context, key string) error {
+	path, err := c.resolve(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Privatize moves a file into a hidden directory beneath the root directory, from
+// which it won't be served
+func (c *filesystemClient) Privatize(ctx context.Context, key string) error {
+	path, err := c.resolve(key)
+	if err != nil {
+		return err
+	}
+	privatePath := filepath.Join(c.rootDir, privateDirName, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(privatePath), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(path, privatePath); err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return ErrNotFound
+		}
+		return err
+	}
+	return nil
+}
+
+// Head returns the details of a file beneath the root directory, inferring its content
+// type from its extension
+func (c *filesystemClient) Head(ctx context.Context, key string) (*ObjectInfo, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if fi.IsDir() {
+		return nil, ErrNotFound
+	}
+	return &ObjectInfo{
+		Key:          key,
+		Size:         fi.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+		LastModified: fi.ModTime(),
+	}, nil
+}
+
+// Exists checks whether a file exists beneath the root directory
+func (c *filesystemClient) Exists(ctx context.Context, key string) (bool, error) {
+	_, err := c.Head(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// List returns the details of all files beneath the root directory whose keys begin
+// with the given prefix
+func (c *filesystemClient) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	err := filepath.WalkDir(c.rootDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && path != c.rootDir {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(c.rootDir, path)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, ObjectInfo{
+			Key:          key,
+			Size:         fi.Size(),
+			ContentType:  mime.TypeByExtension(filepath.Ext(path)),
+			LastModified: fi.ModTime(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return objects, nil
+}
+
+// Download opens a file beneath the root directory for reading
+func (c *filesystemClient) Download(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := c.resolve(key)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// PresignGet returns the URL at which a file is served: since the file server doesn't
+// restrict access, no signature is required and the URL never expires
+func (c *filesystemClient) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	if _, err := c.resolve(key); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s/%s", c.baseUrl, key), nil
+}
+
+// Ping checks that the root directory still exists
+func (c *filesystemClient) Ping(ctx context.Context) error {
+	fi, err := os.Stat(c.rootDir)
+	if err != nil {
+		return err
+	}
+	if !fi.IsDir() {
+		return fmt.Errorf("storage root '%s' is not a directory", c.rootDir)
+	}
+	return nil
+}
+
+// resolve returns the path at which the file with the given key should be stored,
+// ensuring that the key can't refer to anything outside the root directory
+func (c *filesystemClient) resolve(key string) (string, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return "", fmt.Errorf("invalid storage key '%s'", key)
+	}
+	return filepath.Join(c.rootDir, filepath.FromSlash(key)), nil
+}
+
+// NewFileServer returns an HTTP handler that serves the files written beneath rootDir
+// by a filesystem storage.Client, at paths matching their keys. Directory listings,
+// hidden files, and privatized files are not served.
+func NewFileServer(rootDir string) http.Handler {
+	fileServer := http.FileServer(http.Dir(rootDir))
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if strings.HasSuffix(req.URL.Path, "/") || strings.Contains(req.URL.Path, "/.") {
+			http.NotFound(res, req)
+			return
+		}
+		fileServer.ServeHTTP(res, req)
+	})
+}
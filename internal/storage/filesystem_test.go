@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_filesystemClient(t *testing.T) {
+	rootDir := filepath.Join(t.TempDir(), "images")
+	server := httptest.NewServer(NewFileServer(rootDir))
+	defer server.Close()
+
+	c, err := NewFilesystemClient(rootDir, server.URL+"/")
+	assert.NoError(t, err)
+	assert.NoError(t, c.Ping(context.Background()))
+
+	// Uploading a file should write it to disk and return a URL from which it's served
+	url, err := c.Upload(context.Background(), "abc/abc-0.png", "image/png", bytes.NewReader([]byte("not really a png")))
+	assert.NoError(t, err)
+	assert.Equal(t, server.URL+"/abc/abc-0.png", url)
+
+	data, err := os.ReadFile(filepath.Join(rootDir, "abc", "abc-0.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "not really a png", string(data))
+
+	res, err := http.Get(url)
+	assert.NoError(t, err)
+	defer res.Body.Close()
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "image/png", res.Header.Get("content-type"))
+	body, err := io.ReadAll(res.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "not really a png", string(body))
+
+	// Uploading again with the same key should replace the file
+	_, err = c.Upload(context.Background(), "abc/abc-0.png", "image/png", bytes.NewReader([]byte("new data")))
+	assert.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(rootDir, "abc", "abc-0.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "new data", string(data))
+
+	// Keys that would escape the root directory should be rejected
+	for _, key := range []string{"../escape.png", "/etc/passwd", "abc/../../escape.png"} {
+		_, err = c.Upload(context.Background(), key, "image/png", bytes.NewReader([]byte("nope")))
+		assert.Error(t, err, key)
+	}
+
+	// Directories should not be listed
+	res, err = http.Get(server.URL + "/abc/")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func Test_filesystemClient_manage(t *testing.T) {
+	rootDir := t.TempDir()
+	c, err := NewFilesystemClient(rootDir, "http://localhost:5005")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	_, err = c.Upload(ctx, "abc/abc-0.png", "image/png", bytes.NewReader([]byte("png data")))
+	assert.NoError(t, err)
+	_, err = c.Upload(ctx, "def/def-0.jpg", "image/jpeg", bytes.NewReader([]byte("jpg data")))
+	assert.NoError(t, err)
+
+	info, err := c.Head(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	assert.Equal(t, "abc/abc-0.png", info.Key)
+	assert.Equal(t, int64(8), info.Size)
+	assert.Equal(t, "image/png", info.ContentType)
+	_, err = c.Head(ctx, "abc")
+	assert.ErrorIs(t, err, ErrNotFound)
+	exists, err := c.Exists(ctx, "nope.png")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+
+	objects, err := c.List(ctx, "abc/")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 1)
+	assert.Equal(t, "abc/abc-0.png", objects[0].Key)
+	objects, err = c.List(ctx, "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 2)
+
+	r, err := c.Download(ctx, "def/def-0.jpg")
+	assert.NoError(t, err)
+	data, err := io.ReadAll(r)
+	r.Close()
+	assert.NoError(t, err)
+	assert.Equal(t, "jpg data", string(data))
+	_, err = c.Download(ctx, "nope.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	url, err := c.PresignGet(ctx, "abc/abc-0.png", time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:5005/abc/abc-0.png", url)
+
+	err = c.Delete(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	exists, err = c.Exists(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+	assert.False(t, exists)
+	err = c.Delete(ctx, "abc/abc-0.png")
+	assert.NoError(t, err)
+}
+
+func Test_filesystemClient_Privatize(t *testing.T) {
+	rootDir := t.TempDir()
+	server := httptest.NewServer(NewFileServer(rootDir))
+	defer server.Close()
+
+	c, err := NewFilesystemClient(rootDir, server.URL)
+	assert.NoError(t, err)
+	url, err := c.Upload(context.Background(), "abc/abc-0.png", "image/png", bytes.NewReader([]byte("png data")))
+	assert.NoError(t, err)
+
+	err = c.Privatize(context.Background(), "abc/abc-0.png")
+	assert.NoError(t, err)
+	err = c.Privatize(context.Background(), "abc/abc-0.png")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// The file should no longer be served, or listed, but it should still be on disk
+	res, err := http.Get(url)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, err = http.Get(server.URL + "/.private/abc/abc-0.png")
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusNotFound, res.StatusCode)
+	objects, err := c.List(context.Background(), "")
+	assert.NoError(t, err)
+	assert.Len(t, objects, 0)
+	data, err := os.ReadFile(filepath.Join(rootDir, ".private", "abc", "abc-0.png"))
+	assert.NoError(t, err)
+	assert.Equal(t, "png data", string(data))
+}
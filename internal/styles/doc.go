@@ -0,0 +1,9 @@
+// Package styles defines the styles of image alert that viewers can request. Each style
+// describes how a request's inputs are turned into a prompt, whether any text (such as
+// a name) should be generated alongside the image, how the generated image is
+// post-processed, how the resulting alert is displayed onscreen, and how it's posted
+// to Discord.
+//
+// Styles are self-contained: each one is implemented in its own file, which registers
+// the style (via Register) when the package is initialized.
+package styles
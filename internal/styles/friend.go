@@ -0,0 +1,112 @@
+package styles
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/golden-vcr/dynamo/internal/discord"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+)
+
+func init() {
+	Register(&friendStyle{})
+}
+
+// friendStyle produces clip-art mascot characters in a viewer-chosen color, each with
+// an AI-generated name. Images are generated on a solid background of a complementary
+// color, which is keyed out so that the friend can be composited onscreen.
+type friendStyle struct{}
+
+func (s *friendStyle) Name() genreq.ImageStyle {
+	return genreq.ImageStyleFriend
+}
+
+func (s *friendStyle) Validate(inputs *genreq.ImageInputs) error {
+	if inputs.Friend == nil || strings.TrimSpace(inputs.Friend.Subject) == "" {
+		return fmt.Errorf("inputs for style '%s' must have a subject", s.Name())
+	}
+	if !slices.Contains(genreq.Colors, inputs.Friend.Color) {
+		return fmt.Errorf("inputs for style '%s' must have a valid color", s.Name())
+	}
+	return nil
+}
+
+func (s *friendStyle) Description(inputs genreq.ImageInputs) string {
+	return inputs.Friend.Subject
+}
+
+func (s *friendStyle) Prompt(inputs genreq.ImageInputs) string {
+	color := inputs.Friend.Color
+	backgroundColor := inputs.Friend.Color.GetComplement()
+
+	article := ""
+	subject := inputs.Friend.Subject
+	if strings.HasPrefix(subject, "a ") {
+		if color[0] == 'a' || color[0] == 'e' || color[0] == 'i' || color[0] == 'o' || color[0] == 'u' {
+			article = "an"
+		} else {
+			article = "a"
+		}
+		subject = subject[2:]
+	} else if strings.HasPrefix(subject, "an ") {
+		if color[0] == 'a' || color[0] == 'e' || color[0] == 'i' || color[0] == 'o' || color[0] == 'u' {
+			article = "an"
+		} else {
+			article = "a"
+		}
+		subject = subject[3:]
+	} else if strings.HasPrefix(subject, "the ") {
+		subject = subject[4:]
+	}
+
+	return fmt.Sprintf("%s %s %s, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid %s background suitable for chroma keying",
+		article,
+		color,
+		subject,
+		backgroundColor,
+	)
+}
+
+func (s *friendStyle) TextPrompt(inputs genreq.ImageInputs) string {
+	return fmt.Sprintf("Please come up with a name for a friendly mascot character who is %s. Please answer with a single name, and no additional text.", inputs.Friend.Subject)
+}
+
+func (s *friendStyle) Pipeline() []Step {
+	return []Step{RemoveBackground}
+}
+
+func (s *friendStyle) OnscreenImage(viewer core.Viewer, alert Alert) (*eonscreen.PayloadImage, error) {
+	return &eonscreen.PayloadImage{
+		Type:   eonscreen.ImageTypeFriend,
+		Viewer: viewer,
+		Details: eonscreen.ImageDetails{
+			Friend: &eonscreen.ImageDetailsFriend{
+				ImageUrl:        alert.ImageUrl,
+				Description:     alert.Description,
+				Name:            alert.Text,
+				BackgroundColor: alert.BackgroundColor,
+			},
+		},
+	}, nil
+}
+
+func (s *friendStyle) DiscordChannel() string {
+	return "friends"
+}
+
+// DiscordPreview converts the generated image to a JPEG with its background still
+// intact, since a keyed-out friend doesn't look right in Discord
+func (s *friendStyle) DiscordPreview() []Step {
+	return []Step{ConvertToJpeg}
+}
+
+func (s *friendStyle) PostToDiscord(webhookUrl string, viewer core.Viewer, alert Alert, preview *Image) error {
+	if preview == nil {
+		return fmt.Errorf("a preview image is required to post a friend alert to Discord")
+	}
+	return discord.PostFriendAlert(webhookUrl, viewer.TwitchDisplayName, alert.Description, alert.Text, path.Base(alert.ImageUrl), preview.Data)
+}
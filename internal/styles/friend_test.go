@@ -0,0 +1,74 @@
+package styles
+
+import (
+	"testing"
+
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_friendStyle_Validate(t *testing.T) {
+	s := &friendStyle{}
+	assert.NoError(t, s.Validate(&genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Color: genreq.ColorRed, Subject: "a frog"}}))
+	assert.EqualError(t, s.Validate(&genreq.ImageInputs{}), "inputs for style 'friend' must have a subject")
+	assert.EqualError(t, s.Validate(&genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Color: "plaid", Subject: "a frog"}}), "inputs for style 'friend' must have a valid color")
+}
+
+func Test_friendStyle_Prompt(t *testing.T) {
+	tests := []struct {
+		color   genreq.Color
+		subject string
+		want    string
+	}{
+		{
+			genreq.ColorRed,
+			"a frog",
+			"a red frog, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid green background suitable for chroma keying",
+		},
+		{
+			genreq.ColorOrange,
+			"a frog",
+			"an orange frog, illustrated in the style of 1990s digital clip art images, with a limited 256-color palette and sharp black outlines, with a solid sky-blue background suitable for chroma keying",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(string(tt.color)+" "+tt.subject, func(t *testing.T) {
+			s := &friendStyle{}
+			got := s.Prompt(genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Color: tt.color, Subject: tt.subject}})
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_friendStyle(t *testing.T) {
+	s := &friendStyle{}
+	inputs := genreq.ImageInputs{Friend: &genreq.ImageInputsFriend{Color: genreq.ColorRed, Subject: "a frog"}}
+
+	assert.Equal(t, "a frog", s.Description(inputs))
+	assert.Equal(t, "Please come up with a name for a friendly mascot character who is a frog. Please answer with a single name, and no additional text.", s.TextPrompt(inputs))
+	assert.Len(t, s.Pipeline(), 1)
+	assert.Equal(t, "friends", s.DiscordChannel())
+	assert.Len(t, s.DiscordPreview(), 1)
+
+	payload, err := s.OnscreenImage(core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"}, Alert{
+		Description:     "a frog",
+		ImageUrl:        "https://example.com/frog.webp",
+		Text:            "Fred",
+		BackgroundColor: "#00ff00",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &eonscreen.PayloadImage{
+		Type:   eonscreen.ImageTypeFriend,
+		Viewer: core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+		Details: eonscreen.ImageDetails{
+			Friend: &eonscreen.ImageDetailsFriend{
+				ImageUrl:        "https://example.com/frog.webp",
+				Description:     "a frog",
+				Name:            "Fred",
+				BackgroundColor: "#00ff00",
+			},
+		},
+	}, payload)
+}
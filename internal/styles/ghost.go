@@ -0,0 +1,71 @@
+package styles
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golden-vcr/dynamo/internal/discord"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+)
+
+func init() {
+	Register(&ghostStyle{})
+}
+
+// ghostStyle produces spooky, glitchy images that are displayed as ghosts in the
+// alerts overlay, with a dark background that's blended out onscreen
+type ghostStyle struct{}
+
+func (s *ghostStyle) Name() genreq.ImageStyle {
+	return genreq.ImageStyleGhost
+}
+
+func (s *ghostStyle) Validate(inputs *genreq.ImageInputs) error {
+	if inputs.Ghost == nil || strings.TrimSpace(inputs.Ghost.Subject) == "" {
+		return fmt.Errorf("inputs for style '%s' must have a subject", s.Name())
+	}
+	return nil
+}
+
+func (s *ghostStyle) Description(inputs genreq.ImageInputs) string {
+	return inputs.Ghost.Subject
+}
+
+func (s *ghostStyle) Prompt(inputs genreq.ImageInputs) string {
+	return fmt.Sprintf("a ghostly image of %s, with glitchy VHS artifacts, dark background", inputs.Ghost.Subject)
+}
+
+func (s *ghostStyle) TextPrompt(inputs genreq.ImageInputs) string {
+	return ""
+}
+
+func (s *ghostStyle) Pipeline() []Step {
+	return []Step{ConvertToJpeg}
+}
+
+func (s *ghostStyle) OnscreenImage(viewer core.Viewer, alert Alert) (*eonscreen.PayloadImage, error) {
+	return &eonscreen.PayloadImage{
+		Type:   eonscreen.ImageTypeGhost,
+		Viewer: viewer,
+		Details: eonscreen.ImageDetails{
+			Ghost: &eonscreen.ImageDetailsGhost{
+				ImageUrl:    alert.ImageUrl,
+				Description: alert.Description,
+			},
+		},
+	}, nil
+}
+
+func (s *ghostStyle) DiscordChannel() string {
+	return "ghosts"
+}
+
+func (s *ghostStyle) DiscordPreview() []Step {
+	return nil
+}
+
+func (s *ghostStyle) PostToDiscord(webhookUrl string, viewer core.Viewer, alert Alert, preview *Image) error {
+	return discord.PostGhostAlert(webhookUrl, viewer.TwitchDisplayName, alert.Description, alert.ImageUrl)
+}
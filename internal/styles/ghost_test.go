@@ -0,0 +1,41 @@
+package styles
+
+import (
+	"testing"
+
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ghostStyle(t *testing.T) {
+	s := &ghostStyle{}
+	inputs := genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "a seal"}}
+
+	assert.NoError(t, s.Validate(&inputs))
+	assert.EqualError(t, s.Validate(&genreq.ImageInputs{Ghost: &genreq.ImageInputsGhost{Subject: "  "}}), "inputs for style 'ghost' must have a subject")
+	assert.Equal(t, "a seal", s.Description(inputs))
+	assert.Equal(t, "a ghostly image of a seal, with glitchy VHS artifacts, dark background", s.Prompt(inputs))
+	assert.Equal(t, "", s.TextPrompt(inputs))
+	assert.Len(t, s.Pipeline(), 1)
+	assert.Equal(t, "ghosts", s.DiscordChannel())
+	assert.Nil(t, s.DiscordPreview())
+
+	payload, err := s.OnscreenImage(core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"}, Alert{
+		Description:     "a seal",
+		ImageUrl:        "https://example.com/seal.jpg",
+		BackgroundColor: "#000000",
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, &eonscreen.PayloadImage{
+		Type:   eonscreen.ImageTypeGhost,
+		Viewer: core.Viewer{TwitchUserId: "1001", TwitchDisplayName: "BigJoe"},
+		Details: eonscreen.ImageDetails{
+			Ghost: &eonscreen.ImageDetailsGhost{
+				ImageUrl:    "https://example.com/seal.jpg",
+				Description: "a seal",
+			},
+		},
+	}, payload)
+}
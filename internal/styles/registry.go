@@ -0,0 +1,39 @@
+package styles
+
+import (
+	"fmt"
+	"sort"
+
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+)
+
+var registry = make(map[genreq.ImageStyle]Style)
+
+// Register makes a style available by name. It's intended to be called from the init
+// function of the file that implements the style, and it panics if a style with the
+// same name has already been registered.
+func Register(s Style) {
+	name := s.Name()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("styles: style '%s' is already registered", name))
+	}
+	registry[name] = s
+}
+
+// Get returns the registered style with the given name
+func Get(name genreq.ImageStyle) (Style, bool) {
+	s, ok := registry[name]
+	return s, ok
+}
+
+// Names returns the names of all registered styles, in sorted order
+func Names() []genreq.ImageStyle {
+	names := make([]genreq.ImageStyle, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return names[i] < names[j]
+	})
+	return names
+}
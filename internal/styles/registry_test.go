@@ -0,0 +1,23 @@
+package styles
+
+import (
+	"testing"
+
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Registry(t *testing.T) {
+	assert.Equal(t, []genreq.ImageStyle{genreq.ImageStyleFriend, genreq.ImageStyleGhost}, Names())
+
+	s, ok := Get(genreq.ImageStyleGhost)
+	assert.True(t, ok)
+	assert.Equal(t, genreq.ImageStyleGhost, s.Name())
+
+	_, ok = Get("nonexistent")
+	assert.False(t, ok)
+
+	assert.Panics(t, func() {
+		Register(&ghostStyle{})
+	})
+}
@@ -0,0 +1,70 @@
+package styles
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image/jpeg"
+	"image/png"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ConvertToJpeg is a Step that converts a PNG image to a compressed JPEG, in-memory
+func ConvertToJpeg(ctx context.Context, tools *Tools, image *Image) error {
+	bmpData, err := png.Decode(bytes.NewReader(image.Data))
+	if err != nil {
+		return fmt.Errorf("failed to decode PNG data for generated image: %w", err)
+	}
+
+	// Preallocate a buffer that's roughly as large as the largest 1024x1024 JPEG we can
+	// reasonably expect to produce, then write our compressed JPEG data into it
+	jpegBuffer := bytes.NewBuffer(make([]byte, 0, 512*1024))
+	if err := jpeg.Encode(jpegBuffer, bmpData, &jpeg.Options{Quality: 80}); err != nil {
+		return fmt.Errorf("failed to encode JPEG image from decoded PNG image: %w", err)
+	}
+
+	// Replace the image with our compressed JPEG version
+	image.ContentType = "image/jpeg"
+	image.Data = jpegBuffer.Bytes()
+	return nil
+}
+
+// RemoveBackground is a Step that uses the remove-background routine from the
+// image-filters library to detect the background color of a PNG image and key it out,
+// producing a compressed WEBP image with a transparent background
+func RemoveBackground(ctx context.Context, tools *Tools, image *Image) error {
+	// Write the PNG to disk temporarily so it can be processed by another program
+	infile, err := os.CreateTemp("", "imf_*.png")
+	if err != nil {
+		return err
+	}
+	defer infile.Close()
+	defer os.Remove(infile.Name())
+	if _, err := infile.Write(image.Data); err != nil {
+		return err
+	}
+	infile.Close()
+
+	// Build the path to our processed WEBP file
+	outfileName := strings.TrimSuffix(infile.Name(), filepath.Ext(infile.Name())) + ".webp"
+	defer os.Remove(outfileName)
+
+	// Invoke 'imf remove-background -i <infile> -o <outfile>' to write a new image,
+	// capturing the detected background color
+	color, err := tools.FilterRunner.RemoveBackground(ctx, infile.Name(), outfileName)
+	if err != nil {
+		return err
+	}
+
+	// Read the newly-written WEBP file from disk to get our final image data
+	webpData, err := os.ReadFile(outfileName)
+	if err != nil {
+		return err
+	}
+	image.ContentType = "image/webp"
+	image.Data = webpData
+	image.BackgroundColor = color
+	return nil
+}
@@ -0,0 +1,68 @@
+package styles
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_ConvertToJpeg(t *testing.T) {
+	img := &Image{ContentType: "image/png", Data: encodeTestPng(t), BackgroundColor: "#000000"}
+	err := ConvertToJpeg(context.Background(), nil, img)
+	assert.NoError(t, err)
+	assert.Equal(t, "image/jpeg", img.ContentType)
+	assert.Equal(t, []byte{0xff, 0xd8}, img.Data[:2])
+	assert.Equal(t, "#000000", img.BackgroundColor)
+
+	err = ConvertToJpeg(context.Background(), nil, &Image{ContentType: "image/png", Data: []byte("not a png")})
+	assert.ErrorContains(t, err, "failed to decode PNG data")
+}
+
+func Test_RemoveBackground(t *testing.T) {
+	runner := &mockRunner{color: "#ff00ff", output: []byte("webp data")}
+	img := &Image{ContentType: "image/png", Data: []byte("png data"), BackgroundColor: "#000000"}
+	err := RemoveBackground(context.Background(), &Tools{FilterRunner: runner}, img)
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png data"), runner.input)
+	assert.Equal(t, "image/webp", img.ContentType)
+	assert.Equal(t, []byte("webp data"), img.Data)
+	assert.Equal(t, "#ff00ff", img.BackgroundColor)
+}
+
+type mockRunner struct {
+	color  string
+	output []byte
+	input  []byte
+}
+
+func (m *mockRunner) RemoveBackground(ctx context.Context, infile string, outfile string) (string, error) {
+	input, err := os.ReadFile(infile)
+	if err != nil {
+		return "", err
+	}
+	m.input = input
+	if err := os.WriteFile(outfile, m.output, 0o644); err != nil {
+		return "", err
+	}
+	return m.color, nil
+}
+
+func encodeTestPng(t *testing.T) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	for x := 0; x < 4; x++ {
+		for y := 0; y < 4; y++ {
+			img.Set(x, y, color.RGBA{B: 255, A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
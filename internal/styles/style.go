@@ -0,0 +1,83 @@
+package styles
+
+import (
+	"context"
+
+	"github.com/golden-vcr/dynamo/internal/filters"
+	"github.com/golden-vcr/schemas/core"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	eonscreen "github.com/golden-vcr/schemas/onscreen-events"
+)
+
+// Style defines how image requests of a particular style are turned into alerts
+type Style interface {
+	// Name identifies the style, matching the style value in generation requests
+	Name() genreq.ImageStyle
+
+	// Validate returns an error if the given inputs are not sufficient to generate an
+	// image in this style
+	Validate(inputs *genreq.ImageInputs) error
+
+	// Description returns a brief description of the requested image, suitable for
+	// display alongside it, e.g. "a seal"
+	Description(inputs genreq.ImageInputs) string
+
+	// Prompt returns the prompt that should be submitted in order to generate an image
+	Prompt(inputs genreq.ImageInputs) string
+
+	// TextPrompt returns a prompt that should be submitted to generate text to
+	// accompany the image (e.g. a name for a character), or an empty string if this
+	// style does not require any text
+	TextPrompt(inputs genreq.ImageInputs) string
+
+	// Pipeline returns the sequence of post-processing steps that should be applied,
+	// in order, to turn a generated PNG image into the final image for an alert
+	Pipeline() []Step
+
+	// OnscreenImage builds the payload for an onscreen event that will display the
+	// final image as an alert
+	OnscreenImage(viewer core.Viewer, alert Alert) (*eonscreen.PayloadImage, error)
+
+	// DiscordChannel returns the name of the Discord channel to which alerts in this
+	// style are posted (e.g. "ghosts"), which selects the webhook that's used, or an
+	// empty string if alerts in this style are not posted to Discord
+	DiscordChannel() string
+
+	// DiscordPreview returns the sequence of post-processing steps that should be
+	// applied to a generated PNG image to produce the image that's attached to the
+	// alert's Discord post, or nil if the post should link to the final image instead
+	DiscordPreview() []Step
+
+	// PostToDiscord posts the alert to the Discord webhook with the given URL: preview
+	// is the image produced by DiscordPreview, if the style requires one
+	PostToDiscord(webhookUrl string, viewer core.Viewer, alert Alert, preview *Image) error
+}
+
+// Alert describes the final assets that were produced for an image request
+type Alert struct {
+	// Description is the description of the requested image, as returned by the style
+	Description string
+	// ImageUrl is the URL at which the final image is stored
+	ImageUrl string
+	// Text is the text that was generated from the style's TextPrompt, if any
+	Text string
+	// BackgroundColor is the background color of the final image, as determined during
+	// post-processing
+	BackgroundColor string
+}
+
+// Image is an image being post-processed by a style's pipeline
+type Image struct {
+	ContentType     string
+	Data            []byte
+	BackgroundColor string
+}
+
+// Tools provides access to the external resources that post-processing steps may
+// require
+type Tools struct {
+	FilterRunner filters.Runner
+}
+
+// Step is a single post-processing operation, which modifies image in place
+type Step func(ctx context.Context, tools *Tools, image *Image) error
@@ -0,0 +1,3 @@
+// Package submission implements API routes that allow authorized users to submit
+// generation requests directly, bypassing the Twitch event pipeline
+package submission
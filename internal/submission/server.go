@@ -0,0 +1,80 @@
+package submission
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/golden-vcr/auth"
+	"github.com/golden-vcr/dynamo/internal/processing"
+	genreq "github.com/golden-vcr/schemas/generation-requests"
+	"github.com/golden-vcr/server-common/entry"
+	"github.com/golden-vcr/server-common/rmq"
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+type Server struct {
+	generationRequestsProducer rmq.Producer
+	generateId                 func() uuid.UUID
+}
+
+func NewServer(generationRequestsProducer rmq.Producer) *Server {
+	return &Server{
+		generationRequestsProducer: generationRequestsProducer,
+		generateId:                 uuid.New,
+	}
+}
+
+func (s *Server) RegisterRoutes(c auth.Client, r *mux.Router) {
+	r.Path("/requests").Methods("POST").Handler(
+		auth.RequireAccess(c, auth.RoleBroadcaster,
+			http.HandlerFunc(s.handlePostRequest),
+		),
+	)
+}
+
+func (s *Server) handlePostRequest(res http.ResponseWriter, req *http.Request) {
+	// The request's Content-Type must indicate JSON if set
+	contentType := req.Header.Get("content-type")
+	if contentType != "" && !strings.HasPrefix(contentType, "application/json") {
+		http.Error(res, "content-type not supported", http.StatusBadRequest)
+		return
+	}
+
+	// Parse the generation request from the request body, and make sure it's something
+	// the consumer will be able to handle
+	var r genreq.Request
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err := processing.ValidateRequest(&r); err != nil {
+		http.Error(res, fmt.Sprintf("invalid request payload: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Assign an ID to the request up front, so that the caller can use it to look up
+	// the results once the consumer has processed the request
+	m := processing.Message{
+		Id:      s.generateId(),
+		Request: r,
+	}
+	data, err := json.Marshal(m)
+	if err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if err := s.generationRequestsProducer.Send(req.Context(), data); err != nil {
+		http.Error(res, fmt.Sprintf("failed to enqueue generation request: %v", err), http.StatusInternalServerError)
+		return
+	}
+	entry.Log(req).Info("Produced to generation-requests", "imageRequestId", m.Id, "generationRequest", m.Request)
+
+	// Return a JSON-serialized SubmissionResult struct to the user
+	result := &SubmissionResult{ImageRequestId: m.Id}
+	if err := json.NewEncoder(res).Encode(result); err != nil {
+		http.Error(res, err.Error(), http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,120 @@
+package submission
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golden-vcr/auth"
+	authmock "github.com/golden-vcr/auth/mock"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_Server_handlePostRequest(t *testing.T) {
+	tests := []struct {
+		name          string
+		producer      *mockProducer
+		authorization string
+		body          string
+		wantStatus    int
+		wantBody      string
+		wantMessages  []string
+	}{
+		{
+			"broadcaster can submit a valid request",
+			&mockProducer{},
+			"broadcaster-token",
+			`{"type":"image","viewer":{"twitch_user_id":"1234","twitch_display_name":"SomeViewer"},"state":{"broadcast_id":0,"screening_id":"00000000-0000-0000-0000-000000000000","tape_id":0},"payload":{"style":"ghost","inputs":{"subject":"a seal"}}}`,
+			http.StatusOK,
+			`{"imageRequestId":"f5b8c6a9-2c1e-4d0b-9a7f-3e6d5c4b3a21"}`,
+			[]string{
+				`{"id":"f5b8c6a9-2c1e-4d0b-9a7f-3e6d5c4b3a21","type":"image","viewer":{"twitch_user_id":"1234","twitch_display_name":"SomeViewer"},"state":{"broadcast_id":0,"screening_id":"00000000-0000-0000-0000-000000000000","tape_id":0},"payload":{"style":"ghost","inputs":{"subject":"a seal"}}}`,
+			},
+		},
+		{
+			"viewer may not submit requests",
+			&mockProducer{},
+			"viewer-token",
+			`{"type":"image","viewer":{"twitch_user_id":"1234","twitch_display_name":"SomeViewer"},"state":{},"payload":{"style":"ghost","inputs":{"subject":"a seal"}}}`,
+			http.StatusForbidden,
+			"insufficient access: requires broadcaster; you are viewer",
+			nil,
+		},
+		{
+			"invalid request is a 400",
+			&mockProducer{},
+			"broadcaster-token",
+			`{"type":"image","viewer":{"twitch_user_id":"1234","twitch_display_name":"SomeViewer"},"state":{},"payload":{"style":"friend","inputs":{"color":"plaid","subject":"a seal"}}}`,
+			http.StatusBadRequest,
+			"invalid request payload: inputs for style 'friend' must have a valid color",
+			nil,
+		},
+		{
+			"malformed JSON is a 400",
+			&mockProducer{},
+			"broadcaster-token",
+			`{"type":`,
+			http.StatusBadRequest,
+			"invalid request payload: unexpected EOF",
+			nil,
+		},
+		{
+			"failure to produce is a 500",
+			&mockProducer{err: fmt.Errorf("mock error")},
+			"broadcaster-token",
+			`{"type":"image","viewer":{"twitch_user_id":"1234","twitch_display_name":"SomeViewer"},"state":{},"payload":{"style":"ghost","inputs":{"subject":"a seal"}}}`,
+			http.StatusInternalServerError,
+			"failed to enqueue generation request: mock error",
+			nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authClient := authmock.NewClient().AllowTwitchUserAccessToken("broadcaster-token", auth.RoleBroadcaster, auth.UserDetails{
+				Id:          "1000",
+				Login:       "broadcaster",
+				DisplayName: "Broadcaster",
+			}).AllowTwitchUserAccessToken("viewer-token", auth.RoleViewer, auth.UserDetails{
+				Id:          "1234",
+				Login:       "someviewer",
+				DisplayName: "SomeViewer",
+			})
+			s := &Server{
+				generationRequestsProducer: tt.producer,
+				generateId: func() uuid.UUID {
+					return uuid.MustParse("f5b8c6a9-2c1e-4d0b-9a7f-3e6d5c4b3a21")
+				},
+			}
+			handler := auth.RequireAccess(authClient, auth.RoleBroadcaster, http.HandlerFunc(s.handlePostRequest))
+			req := httptest.NewRequest(http.MethodPost, "/requests", strings.NewReader(tt.body))
+			req.Header.Set("authorization", tt.authorization)
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			b, err := io.ReadAll(res.Body)
+			assert.NoError(t, err)
+			body := strings.TrimSuffix(string(b), "\n")
+			assert.Equal(t, tt.wantStatus, res.Code)
+			assert.Equal(t, tt.wantBody, body)
+			assert.Equal(t, tt.wantMessages, tt.producer.messages)
+		})
+	}
+}
+
+type mockProducer struct {
+	err      error
+	messages []string
+}
+
+func (m *mockProducer) Send(ctx context.Context, jsonData []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.messages = append(m.messages, string(jsonData))
+	return nil
+}
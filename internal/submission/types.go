@@ -0,0 +1,9 @@
+package submission
+
+import "github.com/google/uuid"
+
+// SubmissionResult is returned in response to a POST /requests call, identifying the
+// image request that will be created once the request is consumed from the queue
+type SubmissionResult struct {
+	ImageRequestId uuid.UUID `json:"imageRequestId"`
+}
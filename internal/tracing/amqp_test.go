@@ -0,0 +1,49 @@
+package tracing
+
+import (
+	"context"
+	"testing"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func Test_Inject_Extract(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	// Simulate a producer that publishes a message from within a span
+	ctx, producerSpan := Start(context.Background(), "produce")
+	headers := amqp.Table{"x-delivery-count": int32(2)}
+	Inject(ctx, headers)
+	producerSpan.End()
+	assert.Contains(t, headers, "traceparent")
+	assert.Equal(t, int32(2), headers["x-delivery-count"])
+
+	// Simulate a consumer that receives the message: header values may arrive as
+	// []byte, and the consumer's span should continue the producer's trace
+	headers["traceparent"] = []byte(headers["traceparent"].(string))
+	_, consumerSpan := StartConsumer(Extract(context.Background(), headers), "consume")
+	consumerSpan.End()
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 2)
+	assert.Equal(t, spans[0].SpanContext().TraceID(), spans[1].SpanContext().TraceID())
+	assert.Equal(t, spans[0].SpanContext().SpanID(), spans[1].Parent().SpanID())
+	assert.Equal(t, trace.SpanKindConsumer, spans[1].SpanKind())
+}
+
+func Test_Extract_no_headers(t *testing.T) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	ctx := Extract(context.Background(), nil)
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+
+	ctx = Extract(context.Background(), amqp.Table{"x-delivery-count": int32(1)})
+	assert.False(t, trace.SpanContextFromContext(ctx).IsValid())
+}
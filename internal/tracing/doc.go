@@ -0,0 +1,5 @@
+// Package tracing configures OpenTelemetry tracing for the consumer, so that the time
+// spent handling each generation request can be broken down by the external calls it
+// made: trace context is propagated through AMQP message headers, so a request's
+// trace can be followed from the producer that submitted it to the alert we produce
+package tracing
@@ -0,0 +1,136 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Names of the exporters that may be used to send spans somewhere
+const (
+	// ExporterNone discards all spans
+	ExporterNone = "none"
+	// ExporterStdout writes spans to stdout as JSON, for local development
+	ExporterStdout = "stdout"
+	// ExporterFile appends spans to a local file as JSON, for local development
+	ExporterFile = "file"
+	// ExporterOtlp sends spans to an OpenTelemetry collector via OTLP over HTTP, as
+	// configured by the standard OTEL_EXPORTER_OTLP_* environment variables
+	ExporterOtlp = "otlp"
+)
+
+// tracerName identifies the instrumentation that produces our spans
+const tracerName = "github.com/golden-vcr/dynamo"
+
+// Options configures how spans are exported
+type Options struct {
+	// ServiceName identifies the process that produced each span
+	ServiceName string
+	// Exporter is one of the Exporter* constants; ExporterNone if empty
+	Exporter string
+	// FilePath is the file to which spans are written when using ExporterFile
+	FilePath string
+}
+
+// Init installs a global TracerProvider that exports spans as configured, along with a
+// propagator that carries W3C trace context between processes. The returned function
+// flushes any buffered spans and must be called before the process exits.
+func Init(ctx context.Context, opts Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var closer io.Closer
+	switch opts.Exporter {
+	case "", ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterStdout:
+		e, err := stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	case ExporterFile:
+		f, err := os.OpenFile(opts.FilePath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trace file: %w", err)
+		}
+		e, err := stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		exporter = e
+		closer = f
+	case ExporterOtlp:
+		e, err := otlptracehttp.New(ctx)
+		if err != nil {
+			return nil, err
+		}
+		exporter = e
+	default:
+		return nil, fmt.Errorf("unsupported trace exporter '%s'", opts.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(opts.ServiceName)))
+	if err != nil {
+		return nil, err
+	}
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if closer != nil {
+			if closeErr := closer.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}, nil
+}
+
+// Start begins a new span as a child of any span in ctx, returning a context that
+// carries the new span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartConsumer begins a new span representing the receipt of a message from a queue
+func StartConsumer(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+}
+
+// End ends a span, first marking it as failed if err is non-nil
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// Attribute keys identifying the generation request that a span pertains to
+const (
+	AttributeImageRequestId = "dynamo.image_request_id"
+	AttributeStyle          = "dynamo.style"
+)
+
+// Attribute keys describing the parameters of a generation call
+const (
+	AttributeModel     = "dynamo.model"
+	AttributeImageSize = "dynamo.image_size"
+)